@@ -1,26 +1,72 @@
 import BaseService from "./baseService";
+import storage from "../util/localStorage";
+
+// Ratings are kept under an account the server hands out once and this browser keeps
+async function accountHeaders() {
+  let token = storage.get('accountToken');
+  if (!token) {
+    let res = await BaseService.post(`/api/accounts`);
+    token = res.data.token;
+    storage.set('accountToken', token);
+  }
+  return { 'X-Account-Token': token };
+}
 
 export default {
-  async getAllGames() {
-    return BaseService.get(`/api/games`);
+  async getAllGames(query) {
+    return BaseService.get(`/api/games`, { params: query });
   },
   
   async getGameSummary(gameId) {
     return BaseService.get(`/api/games/summary/${gameId}`);
   },
 
-  async newGame(gameName, creatorName) {
-    return BaseService.post(`/api/games`, {name: gameName, creator: creatorName});
+  async newGame(gameName, creatorName, deck, teams, shareHands, unrated, undoApproval) {
+    return BaseService.post(`/api/games`, {name: gameName, creator: creatorName, deck: deck, teams: teams, share_hands: shareHands,
+      unrated: unrated, undo_approval: undoApproval}, { headers: await accountHeaders() });
+  },
+
+  // Lists the decks a game can be dealt with
+  async getDecks() {
+    return BaseService.get(`/api/decks`);
   },
 
   async joinGame(gameId, playerName) {
-    return BaseService.post(`/api/games/${gameId}/join`, { playerName: playerName });
+    return BaseService.post(`/api/games/${gameId}/join`, { playerName: playerName }, { headers: await accountHeaders() });
+  },
+
+  // Joins the open game whose players are rated closest to the player
+  async matchGame(playerName) {
+    return BaseService.post(`/api/games/match`, { playerName: playerName }, { headers: await accountHeaders() });
+  },
+
+  // Queues the player until the matcher finds enough players with the same preferences
+  async enqueue(playerName, preferences) {
+    return BaseService.post(`/api/matchmaking/enqueue`, { playerName: playerName, ...preferences }, { headers: await accountHeaders() });
+  },
+
+  async getTicket(ticketId) {
+    return BaseService.get(`/api/matchmaking/${ticketId}`);
+  },
+
+  async cancelTicket(ticketId) {
+    return BaseService.delete(`/api/matchmaking/${ticketId}`);
+  },
+
+  ticketEvents(ticketId) {
+    return new EventSource(`${BaseService.defaults.baseURL}/api/matchmaking/${ticketId}/events`);
   },
 
   async getGameState(gameId) {
     return BaseService.get(`/api/games/${gameId}`);
   },
 
+  // EventSource cannot send the Authorization header, so the token goes in the query
+  gameEvents(gameId) {
+    const token = encodeURIComponent(storage.get('token'));
+    return new EventSource(`${BaseService.defaults.baseURL}/api/games/${gameId}/events?token=${token}`);
+  },
+
   async getPlayerNameFromToken() {
     let token = localStorage.getItem('token')
     if (token) {
@@ -42,12 +88,35 @@ export default {
     return BaseService.post(`/api/games/${gameId}/start`);
   },
 
+  // Asks to take back the last move in an unrated game, or to make the last undone move again
+  async requestUndo(gameId, redo) {
+    return BaseService.post(`/api/games/${gameId}/${redo ? 'redo' : 'undo'}`);
+  },
+
+  // Agrees to, or turns down, the undo waiting for an answer
+  async answerUndo(gameId, approve) {
+    return BaseService.post(`/api/games/${gameId}/undo/${approve ? 'approve' : 'reject'}`);
+  },
+
+  // Moves the player to another team before a team game starts
+  async pickTeam(gameId, team) {
+    return BaseService.post(`/api/games/${gameId}/team`, {team: team});
+  },
+
   async gotoHelp(tag) {
     return BaseService.post(`/help${tag}`)
   },
   
-  async sendMessage(gameId, playerId, message) {
-    return BaseService.post(`/api/chat/${gameId}/add`, { player: playerId, message: message});
+  async sendMessage(gameId, playerId, message, channel, recipient) {
+    return BaseService.post(`/api/chat/${gameId}/add`, { message: message, channel: channel, recipient: recipient});
+  },
+
+  async getMessages(gameId, afterId) {
+    return BaseService.get(`/api/chat/${gameId}`, { params: afterId ? { after: afterId } : {} });
+  },
+
+  async deleteMessage(gameId, messageId) {
+    return BaseService.delete(`/api/chat/${gameId}/${messageId}`);
   },
 
   async callUno(gameId, calledOnPlayerId) {
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// Ratings are kept per account. There are no logins: an account is a random ID the server signs into a token,
+// which the client keeps and sends in accountHeader whenever it sits down at a game.
+// Only whoever holds the token plays under its rating, picking the same name is not enough.
+// Players who sit down without one are guests, and games with a guest at the table are not rated.
+const accountHeader = "X-Account-Token"
+
+var errBadAccountToken = errors.New("The account token is not valid")
+
+// generateAccountToken signs an account ID. Accounts are kept for good, so the token does not expire.
+func generateAccountToken(account string) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["account"] = account
+
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// parseAccountToken returns the account a token was signed for
+func parseAccountToken(encoded string) (string, error) {
+	token, err := jwt.Parse(encoded, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errBadAccountToken
+		}
+		return []byte(tokenSecret), nil
+	})
+
+	if err != nil || !token.Valid {
+		return "", errBadAccountToken
+	}
+
+	// Player and admin tokens are signed with the same secret but carry no account
+	account, _ := token.Claims.(jwt.MapClaims)["account"].(string)
+	if account == "" {
+		return "", errBadAccountToken
+	}
+
+	return account, nil
+}
+
+// accountFromRequest is the account the request was sent from, or "" for a guest
+func accountFromRequest(c echo.Context) (string, error) {
+	encoded := c.Request().Header.Get(accountHeader)
+	if encoded == "" {
+		return "", nil
+	}
+
+	return parseAccountToken(encoded)
+}
+
+// newAccount hands out a new account for the client to keep
+func (s *server) newAccount(c echo.Context) error {
+	account := uuid.New().String()
+
+	token, err := generateAccountToken(account)
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not create account")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"account": account, "token": token})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountTokens(t *testing.T) {
+	token, err := generateAccountToken("account")
+	require.Nil(t, err)
+
+	account, err := parseAccountToken(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "account", account)
+
+	// Player tokens are signed with the same secret, but they do not name an account
+	_, err = parseAccountToken(generateToken(&model.Player{ID: "player", Name: "Pro"}))
+	assert.Equal(t, errBadAccountToken, err)
+
+	_, err = parseAccountToken(token + "x")
+	assert.Equal(t, errBadAccountToken, err)
+}
+
+func TestPlayersSitDownUnderTheirAccount(t *testing.T) {
+	games := newTestService()
+	e := limitedServer(games)
+
+	rec := call(e, http.MethodPost, "/api/accounts", "10.0.7.1", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var account struct {
+		Account string `json:"account"`
+		Token   string `json:"token"`
+	}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &account))
+
+	create := func(ip string, accountToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/games", strings.NewReader(`{"name": "Rated", "creator": "Pro"}`))
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(accountHeader, accountToken)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	creator := func(rec *httptest.ResponseRecorder) model.Player {
+		var created struct {
+			Game struct {
+				ID string `json:"game_id"`
+			} `json:"game"`
+		}
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		game, err := games.database.LookupGameByID(created.Game.ID)
+		require.Nil(t, err)
+		return game.Players[0]
+	}
+
+	rec = create("10.0.7.2", account.Token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, account.Account, creator(rec).Account)
+
+	// Taking the same name without the token sits down as a guest
+	rec = create("10.0.7.3", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", creator(rec).Account)
+
+	rec = create("10.0.7.4", generateToken(&model.Player{ID: "player", Name: "Pro"}))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/jak103/uno/model"
+)
+
+// Everything that happens to a game once it is dealt goes through applyAction, which makes the change
+// and adds it to the game's actions. A transcript is the deal and those actions, so replaying it goes
+// through applyAction too and cannot come out any different from the game that was played.
+
+var (
+	errUnknownAction  = errors.New("That is not something that can happen in a game")
+	errRandomRanOut   = errors.New("The action needed more random numbers than were recorded")
+	errRandomInvalid  = errors.New("A recorded random number is out of range")
+	errRandomLeftOver = errors.New("The action did not use all of its recorded random numbers")
+)
+
+// applyAction does what an action says to a game and records it. The random numbers the action
+// uses come from rng and are kept with it, so the action can be replayed exactly.
+func applyAction(rng random, game *model.Game, action model.Action) error {
+	recorder := &recordingRand{rng: rng}
+
+	var err error
+	switch action.Kind {
+	case model.ActionPlay, model.ActionDraw, model.ActionCallUno:
+		turn := turnAction{playerID: action.PlayerID, targetID: action.TargetID}
+		switch action.Kind {
+		case model.ActionPlay:
+			if action.Card == nil {
+				return errCardNotInHand
+			}
+			turn.kind, turn.card = actionPlay, *action.Card
+		case model.ActionDraw:
+			turn.kind = actionDraw
+		default:
+			turn.kind = actionCallUno
+		}
+
+		snapshotGame(game, action)
+		err = resolveTurn(recorder, game, turn)
+	case model.ActionUndo, model.ActionRedo:
+		err = takeBack(game, action)
+	case model.ActionRemovePlayer:
+		err = removeFromTable(game, action.TargetID)
+	case model.ActionEnd:
+		err = endWithoutWinner(game)
+	default:
+		err = errUnknownAction
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Games dealt before transcripts were kept have nothing to add the action to.
+	// A game that runs past model.MaxActions stops keeping its transcript rather than growing without end.
+	if game.Opening != nil && len(game.Actions) >= model.MaxActions {
+		game.Opening = nil
+		game.Actions = nil
+	}
+	if game.Opening != nil {
+		action.Random = recorder.values
+		game.Actions = append(game.Actions, action)
+	}
+
+	return nil
+}
+
+// takeBack undoes or redoes a move. Players can only take moves back in unrated games,
+// an operator, who has no player ID, can take them back in any game.
+func takeBack(game *model.Game, action model.Action) error {
+	if action.PlayerID != "" {
+		if findPlayer(game, action.PlayerID) < 0 {
+			return errNoSuchPlayer
+		}
+		if !game.Rules.Unrated {
+			return errRatedUndo
+		}
+	}
+
+	var err error
+	if action.Kind == model.ActionRedo {
+		_, err = redoLastUndo(game)
+	} else {
+		_, err = undoLastMove(game)
+	}
+	return err
+}
+
+// recordingRand remembers every random number it hands out
+type recordingRand struct {
+	rng    random
+	values []int
+}
+
+func (r *recordingRand) Intn(n int) int {
+	value := r.rng.Intn(n)
+	r.values = append(r.values, value)
+	return value
+}
+
+// Shuffle is a Fisher-Yates shuffle over Intn, so the shuffle is replayed from the numbers recorded
+func (r *recordingRand) Shuffle(n int, swap func(i, j int)) {
+	fisherYates(r, n, swap)
+}
+
+// replayRand hands out the random numbers an action recorded, in order.
+// random cannot fail, so a replay that goes wrong is reported by err afterwards.
+type replayRand struct {
+	values []int
+	err    error
+}
+
+func (r *replayRand) Intn(n int) int {
+	if r.err != nil {
+		return 0
+	}
+
+	if len(r.values) == 0 {
+		r.err = errRandomRanOut
+		return 0
+	}
+
+	value := r.values[0]
+	r.values = r.values[1:]
+	if value < 0 || value >= n {
+		r.err = errRandomInvalid
+		return 0
+	}
+	return value
+}
+
+func (r *replayRand) Shuffle(n int, swap func(i, j int)) {
+	fisherYates(r, n, swap)
+}
+
+// finish says whether the replay used exactly the numbers it was given
+func (r *replayRand) finish() error {
+	if r.err == nil && len(r.values) > 0 {
+		return errRandomLeftOver
+	}
+	return r.err
+}
+
+func fisherYates(rng random, n int, swap func(i, j int)) {
+	for i := n - 1; i > 0; i-- {
+		swap(i, rng.Intn(i+1))
+	}
+}
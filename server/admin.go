@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/jak103/uno/events"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+)
+
+var (
+	errNotAdmin      = errors.New("Only operators can do that")
+	errWrongAdminKey = errors.New("That is not the admin key")
+)
+
+// adminRole is the role claim of the tokens that can use the admin API
+const adminRole = "admin"
+
+// adminTokenTTL is how long an admin token lasts, operators trade the admin key for a new one after that
+const adminTokenTTL = time.Hour
+
+// systemName is who broadcast messages are from
+const systemName = "System"
+
+// setupAdminRoutes adds the routes operators use to inspect and repair live games.
+// They are only served when the server has an admin key.
+func (s *server) setupAdminRoutes(e *echo.Echo) {
+	if s.adminKey == "" {
+		return
+	}
+
+	e.POST("/api/admin/token", s.adminToken, s.limitByIP(limitCreate))
+
+	admin := e.Group("/api/admin")
+
+	admin.Use(s.limitByIP(limitPlayer), middleware.JWTWithConfig(middleware.JWTConfig{
+		SigningKey: []byte(tokenSecret),
+		AuthScheme: "Token",
+	}), requireAdmin)
+
+	admin.GET("/games", s.adminListGames)
+	admin.GET("/games/:id", s.adminGetGame)
+	admin.GET("/games/:id/export", s.adminExportGame)
+	admin.POST("/games/:id/end", s.adminEndGame)
+	admin.DELETE("/games/:id", s.adminDeleteGame)
+	admin.DELETE("/games/:id/players/:playerId", s.adminRemovePlayer)
+	admin.POST("/games/:id/creator", s.adminChangeCreator)
+	admin.POST("/games/:id/undo", s.adminUndo)
+	admin.POST("/games/:id/broadcast", s.adminBroadcast)
+
+	// GET shows the log level, PUT {"level": "debug"} changes it
+	admin.Any("/log-level", echo.WrapHandler(logLevel))
+}
+
+// generateAdminToken makes a token for the admin API, signed like every player's token
+func generateAdminToken(now time.Time) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["role"] = adminRole
+	claims["exp"] = now.Add(adminTokenTTL).Unix()
+
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// requireAdmin turns away every token but an admin token. It goes after the JWT middleware.
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token, ok := c.Get("user").(*jwt.Token)
+		if !ok {
+			return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
+		}
+
+		if claims, ok := token.Claims.(jwt.MapClaims); !ok || claims["role"] != adminRole {
+			return c.JSON(http.StatusForbidden, errNotAdmin.Error())
+		}
+
+		return next(c)
+	}
+}
+
+// forceEndGame finishes a game without a winner, so nobody's results or rating are recorded
+func (s *GameService) forceEndGame(ctx context.Context, gameID string) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "forceEndGame", gameID, "")
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	action := model.Action{Kind: model.ActionEnd, At: s.now()}
+	game, err := updateGame(database, gameID, func(game *model.Game) error {
+		return applyAction(nil, game, action)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyGame(ctx, game.ID, events.GameUpdated)
+
+	return game, nil
+}
+
+// removeGame deletes a game outright, without archiving it
+func (s *GameService) removeGame(ctx context.Context, gameID string) error {
+	ctx, span := startSpan(ctx, "removeGame", gameID, "")
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	if _, err := database.LookupGameByID(gameID); err != nil {
+		return errGameNotFound
+	}
+
+	if err := database.DeleteGame(gameID); err != nil {
+		return err
+	}
+
+	s.notifyGame(ctx, gameID, events.GameUpdated)
+
+	return nil
+}
+
+// removePlayer takes a player out of a game. Their cards go to the bottom of the draw pile and play carries on
+// with whoever would have been next. A game being played ends once fewer than two players are left in it.
+func (s *GameService) removePlayer(ctx context.Context, gameID string, playerID string) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "removePlayer", gameID, playerID)
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	action := model.Action{Kind: model.ActionRemovePlayer, TargetID: playerID, At: s.now()}
+	game, err := updateGame(database, gameID, func(game *model.Game) error {
+		return applyAction(nil, game, action)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyGame(ctx, game.ID, events.GameUpdated)
+
+	return game, nil
+}
+
+// endWithoutWinner finishes a game nobody won
+func endWithoutWinner(game *model.Game) error {
+	if game.Status == model.Finished {
+		return errGameFinished
+	}
+
+	game.Status = model.Finished
+	forgetHistory(game)
+
+	return nil
+}
+
+// removeFromTable takes a player and their cards out of a game
+func removeFromTable(game *model.Game, playerID string) error {
+	seat := findPlayer(game, playerID)
+	if seat < 0 {
+		return errNoSuchPlayer
+	}
+
+	removed := game.Players[seat]
+	game.Players = append(game.Players[:seat:seat], game.Players[seat+1:]...)
+	game.DrawPile = append(copyCards(removed.Cards), game.DrawPile...)
+
+	// The seats after the removed one move down. When it was their turn, the turn passes
+	// to the next player in the direction of play.
+	if seat < game.CurrentPlayer || (seat == game.CurrentPlayer && !game.Direction) {
+		game.CurrentPlayer--
+	}
+	if players := len(game.Players); players > 0 {
+		game.CurrentPlayer = (game.CurrentPlayer%players + players) % players
+	} else {
+		game.CurrentPlayer = 0
+	}
+
+	if game.Status == model.Playing && len(game.Players) < 2 {
+		game.Status = model.Finished
+	}
+
+	// Someone still at the table has to be able to start the game
+	if game.Creator.ID == playerID && len(game.Players) > 0 {
+		game.Creator = creatorFrom(game.Players[0])
+	}
+
+	// Every snapshot has the removed player in it
+	forgetHistory(game)
+
+	return nil
+}
+
+// changeCreator hands the game over to another player at the table
+func (s *GameService) changeCreator(ctx context.Context, gameID string, playerID string) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "changeCreator", gameID, playerID)
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	game, err := updateGame(database, gameID, func(game *model.Game) error {
+		seat := findPlayer(game, playerID)
+		if seat < 0 {
+			return errNoSuchPlayer
+		}
+
+		game.Creator = creatorFrom(game.Players[seat])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyGame(ctx, game.ID, events.GameUpdated)
+
+	return game, nil
+}
+
+// creatorFrom is a seated player as the game's creator, which has no cards or standing in the game
+func creatorFrom(player model.Player) model.Player {
+	return model.Player{ID: player.ID, Name: player.Name, CreatedAt: player.CreatedAt}
+}
+
+// undoMove puts a game back the way it was before its last move
+func (s *GameService) undoMove(ctx context.Context, gameID string) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "undoMove", gameID, "")
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	action := model.Action{Kind: model.ActionUndo, At: s.now()}
+	game, err := updateGame(database, gameID, func(game *model.Game) error {
+		return applyAction(nil, game, action)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyGame(ctx, game.ID, events.GameUpdated)
+
+	return game, nil
+}
+
+// broadcastMessage posts a message from the system to everyone watching the game
+func (s *GameService) broadcastMessage(ctx context.Context, gameID string, text string) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "broadcastMessage", gameID, "")
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errEmptyMessage
+	}
+
+	message := model.Message{
+		ID:        uuid.New().String(),
+		Player:    model.Player{Name: systemName},
+		Value:     text,
+		Timestamp: s.clock.Now().Format(time.RFC3339),
+		Channel:   model.SystemChannel,
+	}
+
+	game, err := updateGame(database, gameID, func(game *model.Game) error {
+		game.Messages = append(game.Messages, message)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyGame(ctx, game.ID, events.ChatUpdated)
+
+	return game, nil
+}
+
+// adminToken trades the admin key for an admin token
+func (s *server) adminToken(c echo.Context) error {
+	var request struct {
+		Key string `json:"key"`
+	}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, "Could not bind to input")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(request.Key), []byte(s.adminKey)) != 1 {
+		logFrom(c.Request().Context()).Warn("Refused an admin token", zap.String("ip", c.RealIP()))
+		return c.JSON(http.StatusUnauthorized, errWrongAdminKey.Error())
+	}
+
+	token, err := generateAdminToken(s.games.clock.Now())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not create a token")
+	}
+
+	logFrom(c.Request().Context()).Info("Gave out an admin token", zap.String("ip", c.RealIP()))
+
+	return c.JSON(http.StatusOK, makeJWTPayload(token))
+}
+
+// adminListGames lists games like the lobby does, with everything in them
+func (s *server) adminListGames(c echo.Context) error {
+	query, err := parseGameQuery(c.QueryParams())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	page, err := s.games.databaseFor(c.Request().Context()).QueryGames(query)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not find games")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"games": page.Games,
+		"total": page.Total,
+		"page":  query.Page,
+		"limit": query.Limit,
+	})
+}
+
+// adminGetGame is a game with every hand and pile showing
+func (s *server) adminGetGame(c echo.Context) error {
+	game, err := s.games.databaseFor(c.Request().Context()).LookupGameByID(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errGameNotFound.Error())
+	}
+
+	return c.JSON(http.StatusOK, game)
+}
+
+func (s *server) adminEndGame(c echo.Context) error {
+	game, err := s.games.forceEndGame(c.Request().Context(), c.Param("id"))
+	return s.adminResult(c, "Ended a game", game, err)
+}
+
+func (s *server) adminDeleteGame(c echo.Context) error {
+	err := s.games.removeGame(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	logFrom(c.Request().Context()).Info("Deleted a game")
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *server) adminRemovePlayer(c echo.Context) error {
+	game, err := s.games.removePlayer(c.Request().Context(), c.Param("id"), c.Param("playerId"))
+	return s.adminResult(c, "Removed a player", game, err, zap.String("removed_id", c.Param("playerId")))
+}
+
+func (s *server) adminChangeCreator(c echo.Context) error {
+	var request struct {
+		PlayerID string `json:"player_id"`
+	}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, "Could not bind to input")
+	}
+
+	game, err := s.games.changeCreator(c.Request().Context(), c.Param("id"), request.PlayerID)
+	return s.adminResult(c, "Changed a game's creator", game, err, zap.String("creator_id", request.PlayerID))
+}
+
+func (s *server) adminUndo(c echo.Context) error {
+	game, err := s.games.undoMove(c.Request().Context(), c.Param("id"))
+	return s.adminResult(c, "Undid a move", game, err)
+}
+
+func (s *server) adminBroadcast(c echo.Context) error {
+	var request struct {
+		Message string `json:"message"`
+	}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, "Could not bind to input")
+	}
+
+	game, err := s.games.broadcastMessage(c.Request().Context(), c.Param("id"), request.Message)
+	return s.adminResult(c, "Broadcast a message", game, err)
+}
+
+// adminResult logs what an operator did to a game and responds with the whole game, or with why it could not be done
+func (s *server) adminResult(c echo.Context, done string, game *model.Game, err error, fields ...zap.Field) error {
+	if err != nil {
+		return adminError(c, err)
+	}
+
+	logFrom(c.Request().Context()).Info(done, fields...)
+	return c.JSON(http.StatusOK, game)
+}
+
+func adminError(c echo.Context, err error) error {
+	switch err {
+	case errGameNotFound, errNoSuchPlayer:
+		return c.JSON(http.StatusNotFound, err.Error())
+	case errGameFinished, errNothingToUndo:
+		return c.JSON(http.StatusConflict, err.Error())
+	case errEmptyMessage:
+		return c.JSON(http.StatusBadRequest, err.Error())
+	default:
+		logFrom(c.Request().Context()).Error("Admin request failed", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "Could not change the game")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAdminKey = "let-me-in"
+
+// adminServer serves the admin API, and returns the token for it
+func adminServer(t *testing.T, games *GameService) (*echo.Echo, string) {
+	api := newServer(games)
+	api.adminKey = testAdminKey
+
+	e := echo.New()
+	api.setupRoutes(e)
+
+	rec := call(e, http.MethodPost, "/api/admin/token", "192.0.2.1", "", `{"key": "`+testAdminKey+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var payload map[string]string
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &payload))
+	return e, payload["JWT"]
+}
+
+// adminGame reads the game an admin request responded with
+func adminGame(t *testing.T, body []byte) model.Game {
+	var game model.Game
+	require.Nil(t, json.Unmarshal(body, &game))
+	return game
+}
+
+func TestAdminAPIIsOffWithoutAKey(t *testing.T) {
+	e := echo.New()
+	newServer(newTestService()).setupRoutes(e)
+
+	rec := call(e, http.MethodPost, "/api/admin/token", "192.0.2.1", "", `{"key": ""}`)
+	assert.NotEqual(t, http.StatusOK, rec.Code)
+}
+
+func TestOnlyAdminsCanUseTheAdminAPI(t *testing.T) {
+	games := newTestService()
+	e, token := adminServer(t, games)
+	_, player := setupGameWithPlayer(games.database)
+
+	rec := call(e, http.MethodPost, "/api/admin/token", "192.0.2.1", "", `{"key": "guess"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Echo answers a request with no token as a bad request
+	assert.Equal(t, http.StatusBadRequest, call(e, http.MethodGet, "/api/admin/games", "192.0.2.1", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, call(e, http.MethodGet, "/api/admin/games", "192.0.2.1", generateToken(player), "").Code)
+	assert.Equal(t, http.StatusOK, call(e, http.MethodGet, "/api/admin/games", "192.0.2.1", token, "").Code)
+}
+
+func TestAdminsSeeEveryHand(t *testing.T) {
+	games := newTestService()
+	e, token := adminServer(t, games)
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+
+	rec := call(e, http.MethodGet, "/api/admin/games?status=playing", "192.0.2.1", token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Games []model.Game `json:"games"`
+		Total int          `json:"total"`
+	}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Equal(t, 1, page.Total)
+	assert.Equal(t, game.Players[1].Cards, page.Games[0].Players[1].Cards)
+	assert.Equal(t, game.DrawPile, page.Games[0].DrawPile)
+
+	rec = call(e, http.MethodGet, "/api/admin/games/"+game.ID, "192.0.2.1", token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, game.Players[0].Cards, adminGame(t, rec.Body.Bytes()).Players[0].Cards)
+
+	assert.Equal(t, http.StatusNotFound, call(e, http.MethodGet, "/api/admin/games/missing", "192.0.2.1", token, "").Code)
+}
+
+func TestAdminsCanEndAndDeleteGames(t *testing.T) {
+	games := newTestService()
+	e, token := adminServer(t, games)
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+
+	rec := call(e, http.MethodPost, "/api/admin/games/"+game.ID+"/end", "192.0.2.1", token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	ended := adminGame(t, rec.Body.Bytes())
+	assert.Equal(t, model.Finished, ended.Status)
+	assert.Equal(t, "", ended.GameOver)
+
+	// Nobody won, so nothing was recorded
+	results, err := games.database.GetGameResults(games.clock.Now().Add(-time.Hour))
+	require.Nil(t, err)
+	assert.Empty(t, *results)
+
+	assert.Equal(t, http.StatusConflict, call(e, http.MethodPost, "/api/admin/games/"+game.ID+"/end", "192.0.2.1", token, "").Code)
+
+	assert.Equal(t, http.StatusNoContent, call(e, http.MethodDelete, "/api/admin/games/"+game.ID, "192.0.2.1", token, "").Code)
+	assert.False(t, games.database.HasGameByID(game.ID))
+	assert.Equal(t, http.StatusNotFound, call(e, http.MethodDelete, "/api/admin/games/"+game.ID, "192.0.2.1", token, "").Code)
+}
+
+func TestAdminsCanRemovePlayers(t *testing.T) {
+	games := newTestService()
+	e, token := adminServer(t, games)
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob", "Carol")
+
+	game.CurrentPlayer = 1
+	game.Direction = true
+	require.Nil(t, games.database.SaveGame(*game))
+
+	bob := game.Players[1]
+	rec := call(e, http.MethodDelete, "/api/admin/games/"+game.ID+"/players/"+bob.ID, "192.0.2.1", token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	removed := adminGame(t, rec.Body.Bytes())
+
+	// Carol was next after Bob, and Bob's cards went under the draw pile
+	require.Len(t, removed.Players, 2)
+	assert.Equal(t, "Carol", removed.Players[removed.CurrentPlayer].Name)
+	assert.Equal(t, bob.Cards, removed.DrawPile[:len(bob.Cards)])
+	assert.Len(t, removed.DrawPile, len(game.DrawPile)+len(bob.Cards))
+	assert.Equal(t, model.Playing, removed.Status)
+
+	assert.Equal(t, http.StatusNotFound, call(e, http.MethodDelete, "/api/admin/games/"+game.ID+"/players/"+bob.ID, "192.0.2.1", token, "").Code)
+
+	// The creator leaving hands the game to whoever is left, and one player cannot go on alone
+	rec = call(e, http.MethodDelete, "/api/admin/games/"+game.ID+"/players/"+game.Players[0].ID, "192.0.2.1", token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	removed = adminGame(t, rec.Body.Bytes())
+	assert.Equal(t, "Carol", removed.Creator.Name)
+	assert.Equal(t, model.Finished, removed.Status)
+}
+
+func TestAdminsCanChangeTheCreator(t *testing.T) {
+	games := newTestService()
+	e, token := adminServer(t, games)
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+
+	rec := call(e, http.MethodPost, "/api/admin/games/"+game.ID+"/creator", "192.0.2.1", token, `{"player_id": "`+game.Players[1].ID+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	saved, err := games.database.LookupGameByID(game.ID)
+	require.Nil(t, err)
+	assert.Equal(t, game.Players[1].ID, saved.Creator.ID)
+	assert.Equal(t, "Bob", saved.Creator.Name)
+
+	rec = call(e, http.MethodPost, "/api/admin/games/"+game.ID+"/creator", "192.0.2.1", token, `{"player_id": "stranger"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminsCanUndoTheLastMove(t *testing.T) {
+	games := newTestService()
+	e, token := adminServer(t, games)
+	before := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+
+	_, err := games.drawCard(context.Background(), before.ID, before.Players[before.CurrentPlayer].ID)
+	require.Nil(t, err)
+
+	rec := call(e, http.MethodPost, "/api/admin/games/"+before.ID+"/undo", "192.0.2.1", token, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	saved, err := games.database.LookupGameByID(before.ID)
+	require.Nil(t, err)
+	assert.Equal(t, before.DrawPile, saved.DrawPile)
+	assert.Equal(t, before.Players, saved.Players)
+	assert.Equal(t, before.CurrentPlayer, saved.CurrentPlayer)
+
+	assert.Equal(t, http.StatusConflict, call(e, http.MethodPost, "/api/admin/games/"+before.ID+"/undo", "192.0.2.1", token, "").Code)
+}
+
+func TestAdminsCanBroadcast(t *testing.T) {
+	games := newTestService()
+	e, token := adminServer(t, games)
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+
+	rec := call(e, http.MethodPost, "/api/admin/games/"+game.ID+"/broadcast", "192.0.2.1", token, `{"message": "  Restarting in 5 minutes  "}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Every player sees it, and no player can send one
+	messages, err := games.getMessages(context.Background(), game.ID, game.Players[1].ID, "")
+	require.Nil(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Restarting in 5 minutes", messages[0].Value)
+	assert.Equal(t, model.SystemChannel, messages[0].Channel)
+	assert.Equal(t, systemName, messages[0].Player.Name)
+
+	_, err = games.addMessage(context.Background(), game.ID, game.Players[0].ID, model.Message{Value: "Hi", Channel: model.SystemChannel})
+	assert.Equal(t, errUnknownChannel, err)
+
+	rec = call(e, http.MethodPost, "/api/admin/games/"+game.ID+"/broadcast", "192.0.2.1", token, `{"message": " "}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
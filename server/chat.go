@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jak103/uno/model"
+	"github.com/mattwhite180/go-away"
+)
+
+// Limits applied to every chat message
+const (
+	maxMessageLength  = 280
+	messageRateLimit  = 5
+	messageRateWindow = 10 * time.Second
+)
+
+// Errors returned by the chat functions so handlers can pick a response code
+var (
+	errEmptyMessage    = errors.New("Message is empty")
+	errMessageTooLong  = errors.New("Message is too long")
+	errProfaneMessage  = errors.New("Profane message")
+	errChatRateLimited = errors.New("Too many messages, slow down")
+	errMessageNotFound = errors.New("Message not found")
+	errNotGameCreator  = errors.New("Only the player who created the game can do that")
+	errGameNotFound    = errors.New("Game not found")
+	errUnknownChannel  = errors.New("Unknown chat channel")
+	errBadRecipient    = errors.New("Whispers must be sent to another player in the game")
+	errNotSpectator    = errors.New("Only spectators can use the spectator channel")
+	errNotSeated       = errors.New("Only players in the game can talk to the table or whisper")
+)
+
+// chatRateLimiter allows each player a fixed number of messages per sliding window
+type chatRateLimiter struct {
+	mutex  sync.Mutex
+	limit  int
+	window time.Duration
+	sent   map[string][]time.Time
+	pruned time.Time
+}
+
+func newChatRateLimiter(limit int, window time.Duration) *chatRateLimiter {
+	return &chatRateLimiter{
+		limit:  limit,
+		window: window,
+		sent:   make(map[string][]time.Time),
+	}
+}
+
+// allow records a message for the player at the given time if they are under their limit
+func (l *chatRateLimiter) allow(playerID string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	// Only keep the messages that are still inside the window
+	recent := l.sent[playerID][:0]
+	for _, sentAt := range l.sent[playerID] {
+		if now.Sub(sentAt) < l.window {
+			recent = append(recent, sentAt)
+		}
+	}
+
+	if len(recent) >= l.limit {
+		l.sent[playerID] = recent
+		return false
+	}
+
+	l.sent[playerID] = append(recent, now)
+	return true
+}
+
+// prune forgets the players whose messages are all outside the window, they are the same as a player who sent none.
+// The messages are in the order they were sent, so the last one is the newest.
+func (l *chatRateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.window {
+		return
+	}
+	l.pruned = now
+
+	for playerID, sent := range l.sent {
+		if len(sent) == 0 || now.Sub(sent[len(sent)-1]) >= l.window {
+			delete(l.sent, playerID)
+		}
+	}
+}
+
+// validateMessage trims the message and checks it against the length and profanity rules
+func validateMessage(message model.Message) (model.Message, error) {
+	message.Value = strings.TrimSpace(message.Value)
+
+	if message.Value == "" {
+		return message, errEmptyMessage
+	}
+
+	if utf8.RuneCountInString(message.Value) > maxMessageLength {
+		return message, errMessageTooLong
+	}
+
+	if goaway.IsProfane(message.Value) {
+		return message, errProfaneMessage
+	}
+
+	return message, nil
+}
+
+// findMessage returns the index of the message with the given ID, or -1 if there is none
+func findMessage(messages []model.Message, messageID string) int {
+	for i, message := range messages {
+		if message.ID == messageID {
+			return i
+		}
+	}
+	return -1
+}
+
+// messagesAfter returns the messages that were added after the message with the given ID.
+// An empty ID returns every message.
+func messagesAfter(messages []model.Message, afterID string) ([]model.Message, error) {
+	if afterID == "" {
+		return messages, nil
+	}
+
+	index := findMessage(messages, afterID)
+	if index < 0 {
+		return nil, errMessageNotFound
+	}
+
+	return messages[index+1:], nil
+}
+
+// isPlayerInGame returns true if the player is seated in the game rather than watching it
+func isPlayerInGame(game *model.Game, playerID string) bool {
+	for _, player := range game.Players {
+		if player.ID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// validateChannel checks that the sender is allowed to post the message to its channel.
+// Messages without a channel are sent to the whole table, or to the other spectators when a spectator sends them.
+// Only players seated in the game can talk to the table or whisper.
+func validateChannel(game *model.Game, playerID string, message model.Message) (model.Message, error) {
+	seated := isPlayerInGame(game, playerID)
+	if message.Channel == "" && !seated {
+		message.Channel = model.SpectatorChannel
+	}
+
+	switch message.Channel {
+	case "", model.TableChannel:
+		if !seated {
+			return message, errNotSeated
+		}
+		message.Channel = model.TableChannel
+		message.Recipient = ""
+	case model.WhisperChannel:
+		if !seated {
+			return message, errNotSeated
+		}
+		if message.Recipient == playerID || !isPlayerInGame(game, message.Recipient) {
+			return message, errBadRecipient
+		}
+	case model.SpectatorChannel:
+		if seated {
+			return message, errNotSpectator
+		}
+		message.Recipient = ""
+	default:
+		return message, errUnknownChannel
+	}
+
+	return message, nil
+}
+
+// canSeeMessage decides if the viewer is allowed to see the message based on its channel
+func canSeeMessage(game *model.Game, viewerID string, message model.Message) bool {
+	switch message.Channel {
+	case "", model.TableChannel, model.SystemChannel:
+		return true
+	case model.WhisperChannel:
+		return message.Player.ID == viewerID || message.Recipient == viewerID
+	case model.SpectatorChannel:
+		return !isPlayerInGame(game, viewerID)
+	default:
+		return false
+	}
+}
+
+// visibleMessages returns only the messages the viewer is allowed to see
+func visibleMessages(game *model.Game, viewerID string, messages []model.Message) []model.Message {
+	visible := make([]model.Message, 0, len(messages))
+	for _, message := range messages {
+		if canSeeMessage(game, viewerID, message) {
+			visible = append(visible, message)
+		}
+	}
+	return visible
+}
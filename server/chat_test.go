@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatRateLimiter(t *testing.T) {
+	limiter := newChatRateLimiter(2, time.Second)
+	now := time.Now()
+
+	// Two messages fit inside the window, the third does not
+	assert.True(t, limiter.allow("player", now))
+	assert.True(t, limiter.allow("player", now.Add(100*time.Millisecond)))
+	assert.False(t, limiter.allow("player", now.Add(200*time.Millisecond)))
+
+	// Other players have their own limit
+	assert.True(t, limiter.allow("other player", now))
+
+	// Once the first message leaves the window another one is allowed
+	assert.True(t, limiter.allow("player", now.Add(1050*time.Millisecond)))
+	assert.False(t, limiter.allow("player", now.Add(1060*time.Millisecond)))
+}
+
+func TestChatRateLimiterForgetsQuietPlayers(t *testing.T) {
+	limiter := newChatRateLimiter(2, time.Second)
+	now := time.Now()
+
+	limiter.allow("quiet player", now)
+	limiter.allow("player", now.Add(500*time.Millisecond))
+	assert.Len(t, limiter.sent, 2)
+
+	// Once every message a player sent is outside the window they are forgotten
+	limiter.allow("player", now.Add(1200*time.Millisecond))
+	assert.Len(t, limiter.sent, 1)
+	assert.Len(t, limiter.sent["player"], 2)
+}
+
+func TestValidateMessage(t *testing.T) {
+	message, err := validateMessage(model.Message{Value: "  good game  "})
+	assert.Nil(t, err)
+	assert.Equal(t, "good game", message.Value)
+
+	_, err = validateMessage(model.Message{Value: "   "})
+	assert.Equal(t, errEmptyMessage, err)
+
+	_, err = validateMessage(model.Message{Value: strings.Repeat("a", maxMessageLength+1)})
+	assert.Equal(t, errMessageTooLong, err)
+
+	_, err = validateMessage(model.Message{Value: "fuck"})
+	assert.Equal(t, errProfaneMessage, err)
+}
+
+func TestMessagesAfter(t *testing.T) {
+	messages := []model.Message{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+
+	all, err := messagesAfter(messages, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(all))
+
+	newer, err := messagesAfter(messages, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Message{{ID: "3"}}, newer)
+
+	none, err := messagesAfter(messages, "3")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(none))
+
+	_, err = messagesAfter(messages, "unknown")
+	assert.Equal(t, errMessageNotFound, err)
+}
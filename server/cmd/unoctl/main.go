@@ -0,0 +1,361 @@
+// unoctl operates a running uno server through its admin API.
+//
+//	unoctl [-server URL] [-key KEY] <command> [arguments]
+//
+// The server and admin key can also be given in UNO_SERVER and UNO_ADMIN_KEY.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jak103/uno/model"
+)
+
+const usage = `Commands:
+  games [-status waiting|playing|finished] [-q search] [-page n] [-limit n]
+                                list games
+  show GAME                     print everything in a game, every hand included
+  end GAME                      finish a game without a winner
+  delete GAME                   delete a game
+  remove-player GAME PLAYER     take a player out of a game
+  set-creator GAME PLAYER       hand a game to another of its players
+  undo GAME                     put a game back the way it was before its last move
+  broadcast GAME MESSAGE...     post a system message in a game's chat
+  export GAME                   print a game's transcript, to save it or import it elsewhere
+  import [-resume] FILE         load a saved transcript as a new game, finished to replay it
+                                or unrated to carry on with it, and print the creator's token
+`
+
+func main() {
+	err := run(os.Args[1:], os.Getenv, os.Stdout)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unoctl:", err)
+		os.Exit(1)
+	}
+}
+
+// command is one of unoctl's commands. args are what follows its name.
+type command struct {
+	args int
+	run  func(c *client, args []string, out io.Writer) error
+}
+
+var commands = map[string]command{
+	"games":         {0, listGames},
+	"show":          {1, showGame},
+	"end":           {1, gameAction(http.MethodPost, "/end", "Ended")},
+	"delete":        {1, deleteGame},
+	"remove-player": {2, removePlayer},
+	"set-creator":   {2, setCreator},
+	"undo":          {1, gameAction(http.MethodPost, "/undo", "Undid the last move in")},
+	"broadcast":     {2, broadcast},
+	"export":        {1, exportGame},
+	"import":        {1, importGame},
+}
+
+// run runs the command in args against the server
+func run(args []string, getenv func(string) string, out io.Writer) error {
+	flags := flag.NewFlagSet("unoctl", flag.ContinueOnError)
+	flags.SetOutput(out)
+	server := flags.String("server", or(getenv("UNO_SERVER"), "http://localhost:8080"), "the server's address, or UNO_SERVER")
+	key := flags.String("key", getenv("UNO_ADMIN_KEY"), "the server's admin key, or UNO_ADMIN_KEY")
+	flags.Usage = func() {
+		fmt.Fprintln(out, "Usage: unoctl [-server URL] [-key KEY] <command> [arguments]")
+		flags.PrintDefaults()
+		fmt.Fprint(out, usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no command given")
+	}
+
+	name, rest := flags.Arg(0), flags.Args()[1:]
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("%q is not a command, see unoctl -help", name)
+	}
+
+	if len(rest) < cmd.args {
+		return fmt.Errorf("%s needs %d arguments, see unoctl -help", name, cmd.args)
+	}
+
+	if *key == "" {
+		return errors.New("the admin key must be given with -key or UNO_ADMIN_KEY")
+	}
+
+	c := &client{server: strings.TrimRight(*server, "/"), http: &http.Client{Timeout: 30 * time.Second}}
+	if err := c.login(*key); err != nil {
+		return err
+	}
+
+	return cmd.run(c, rest, out)
+}
+
+func or(value string, otherwise string) string {
+	if value == "" {
+		return otherwise
+	}
+	return value
+}
+
+// client calls the admin API with an admin token
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// login trades the admin key for an admin token
+func (c *client) login(key string) error {
+	var payload struct {
+		JWT string `json:"JWT"`
+	}
+
+	if err := c.do(http.MethodPost, "/api/admin/token", map[string]string{"key": key}, &payload); err != nil {
+		return err
+	}
+
+	c.token = payload.JWT
+	return nil
+}
+
+// do sends body as JSON and reads the response into out, unless either is nil.
+// A response that is not a success is returned as an error with the server's message.
+func (c *client) do(method string, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var message interface{}
+		if json.NewDecoder(res.Body).Decode(&message) != nil {
+			message = http.StatusText(res.StatusCode)
+		}
+		return fmt.Errorf("%s %s: %d %v", method, path, res.StatusCode, message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func gamePath(gameID string) string {
+	return "/api/admin/games/" + url.PathEscape(gameID)
+}
+
+func listGames(c *client, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("games", flag.ContinueOnError)
+	flags.SetOutput(out)
+	status := flags.String("status", "", "only games that are waiting, playing or finished")
+	search := flags.String("q", "", "only games whose name or players match")
+	page := flags.Int("page", 1, "the page to list")
+	limit := flags.Int("limit", 0, "games per page, the server's default when 0")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if *status != "" {
+		query.Set("status", *status)
+	}
+	if *search != "" {
+		query.Set("q", *search)
+	}
+	query.Set("page", strconv.Itoa(*page))
+	if *limit > 0 {
+		query.Set("limit", strconv.Itoa(*limit))
+	}
+
+	var listing struct {
+		Games []model.Game `json:"games"`
+		Total int          `json:"total"`
+	}
+	if err := c.do(http.MethodGet, "/api/admin/games?"+query.Encode(), nil, &listing); err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tNAME\tSTATUS\tCREATOR\tPLAYERS\tUNDOABLE")
+	for _, game := range listing.Games {
+		names := make([]string, len(game.Players))
+		for i, player := range game.Players {
+			names[i] = player.Name
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%d\n", game.ID, game.Name, game.Status, game.Creator.Name, strings.Join(names, ", "), len(game.History))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%d of %d games\n", len(listing.Games), listing.Total)
+	return nil
+}
+
+func showGame(c *client, args []string, out io.Writer) error {
+	return printJSON(c, gamePath(args[0]), out)
+}
+
+func exportGame(c *client, args []string, out io.Writer) error {
+	return printJSON(c, gamePath(args[0])+"/export", out)
+}
+
+func importGame(c *client, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(out)
+	resume := flags.Bool("resume", false, "carry on with the game instead of replaying it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("import needs the transcript's file, see unoctl -help")
+	}
+
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	mode := "replay"
+	if *resume {
+		mode = "resume"
+	}
+
+	var imported struct {
+		Game struct {
+			ID     string `json:"game_id"`
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		} `json:"game"`
+		Token string `json:"token"`
+	}
+	body := map[string]interface{}{"transcript": json.RawMessage(data), "mode": mode}
+	if err := c.do(http.MethodPost, "/api/games/import", body, &imported); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Imported %s (%s), %s\n", imported.Game.ID, imported.Game.Name, imported.Game.Status)
+	fmt.Fprintf(out, "Your token, for the creator's seat: %s\n", imported.Token)
+	if *resume {
+		fmt.Fprintln(out, "The other players claim their seats by joining the game")
+	}
+	return nil
+}
+
+// printJSON prints what the server answers at path, indented
+func printJSON(c *client, path string, out io.Writer) error {
+	var game json.RawMessage
+	if err := c.do(http.MethodGet, path, nil, &game); err != nil {
+		return err
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, game, "", "  "); err != nil {
+		return err
+	}
+
+	indented.WriteString("\n")
+	_, err := indented.WriteTo(out)
+	return err
+}
+
+// gameAction is a command that posts to one of a game's admin routes and reports what became of the game
+func gameAction(method string, route string, done string) func(c *client, args []string, out io.Writer) error {
+	return func(c *client, args []string, out io.Writer) error {
+		var game model.Game
+		if err := c.do(method, gamePath(args[0])+route, nil, &game); err != nil {
+			return err
+		}
+
+		printGame(out, done, game)
+		return nil
+	}
+}
+
+func deleteGame(c *client, args []string, out io.Writer) error {
+	if err := c.do(http.MethodDelete, gamePath(args[0]), nil, nil); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Deleted %s\n", args[0])
+	return nil
+}
+
+func removePlayer(c *client, args []string, out io.Writer) error {
+	var game model.Game
+	if err := c.do(http.MethodDelete, gamePath(args[0])+"/players/"+url.PathEscape(args[1]), nil, &game); err != nil {
+		return err
+	}
+
+	printGame(out, "Removed "+args[1]+" from", game)
+	return nil
+}
+
+func setCreator(c *client, args []string, out io.Writer) error {
+	var game model.Game
+	if err := c.do(http.MethodPost, gamePath(args[0])+"/creator", map[string]string{"player_id": args[1]}, &game); err != nil {
+		return err
+	}
+
+	printGame(out, "Handed to "+game.Creator.Name+":", game)
+	return nil
+}
+
+func broadcast(c *client, args []string, out io.Writer) error {
+	var game model.Game
+	message := strings.Join(args[1:], " ")
+	if err := c.do(http.MethodPost, gamePath(args[0])+"/broadcast", map[string]string{"message": message}, &game); err != nil {
+		return err
+	}
+
+	printGame(out, "Broadcast to", game)
+	return nil
+}
+
+// printGame reports what was done to a game, and where the game stands now
+func printGame(out io.Writer, done string, game model.Game) {
+	fmt.Fprintf(out, "%s %s (%s), %s, %d players, %d moves can be undone\n",
+		done, game.ID, game.Name, game.Status, len(game.Players), len(game.History))
+}
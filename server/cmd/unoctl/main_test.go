@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// request is what the fake server was sent
+type request struct {
+	method string
+	path   string
+	token  string
+	body   string
+}
+
+// fakeServer answers the admin API with game, and records every request it is sent after the token
+func fakeServer(t *testing.T, game model.Game) (*httptest.Server, *[]request) {
+	var requests []request
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if r.URL.Path == "/api/admin/token" {
+			if string(body) != `{"key":"secret"}` {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode("That is not the admin key")
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"JWT": "admin-token"})
+			return
+		}
+
+		requests = append(requests, request{r.Method, r.URL.RequestURI(), r.Header.Get("Authorization"), string(body)})
+
+		switch {
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/admin/games/g1":
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/api/admin/games":
+			json.NewEncoder(w).Encode(map[string]interface{}{"games": []model.Game{game}, "total": 1})
+		case r.URL.Path == "/api/games/import":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"game":  map[string]string{"game_id": "g2", "name": game.Name, "status": "Playing"},
+				"token": "alice-token",
+			})
+		case r.URL.Path == "/api/admin/games/missing":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode("Game not found")
+		default:
+			json.NewEncoder(w).Encode(game)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestCommands(t *testing.T) {
+	game := model.Game{ID: "g1", Name: "Friday", Status: model.Playing, Creator: model.Player{Name: "Alice"},
+		Players: []model.Player{{ID: "p1", Name: "Alice"}, {ID: "p2", Name: "Bob"}}}
+	server, requests := fakeServer(t, game)
+
+	transcript, err := ioutil.TempFile("", "transcript")
+	require.Nil(t, err)
+	t.Cleanup(func() { os.Remove(transcript.Name()) })
+	_, err = transcript.WriteString(`{"format": "uno-transcript"}`)
+	require.Nil(t, err)
+	require.Nil(t, transcript.Close())
+
+	tests := []struct {
+		args []string
+		sent request
+		says string
+	}{
+		{[]string{"games", "-status", "playing", "-q", "fri"}, request{method: "GET", path: "/api/admin/games?page=1&q=fri&status=playing"}, "Alice, Bob"},
+		{[]string{"show", "g1"}, request{method: "GET", path: "/api/admin/games/g1"}, `"name": "Friday"`},
+		{[]string{"end", "g1"}, request{method: "POST", path: "/api/admin/games/g1/end"}, "Ended g1 (Friday)"},
+		{[]string{"delete", "g1"}, request{method: "DELETE", path: "/api/admin/games/g1"}, "Deleted g1"},
+		{[]string{"remove-player", "g1", "p2"}, request{method: "DELETE", path: "/api/admin/games/g1/players/p2"}, "Removed p2 from g1"},
+		{[]string{"set-creator", "g1", "p2"}, request{method: "POST", path: "/api/admin/games/g1/creator", body: `{"player_id":"p2"}`}, "Handed to Alice"},
+		{[]string{"undo", "g1"}, request{method: "POST", path: "/api/admin/games/g1/undo"}, "Undid the last move in g1"},
+		{[]string{"broadcast", "g1", "Back", "soon"}, request{method: "POST", path: "/api/admin/games/g1/broadcast", body: `{"message":"Back soon"}`}, "Broadcast to g1"},
+		{[]string{"export", "g1"}, request{method: "GET", path: "/api/admin/games/g1/export"}, `"name": "Friday"`},
+		{[]string{"import", "-resume", transcript.Name()}, request{method: "POST", path: "/api/games/import", body: `{"mode":"resume","transcript":{"format":"uno-transcript"}}`}, "alice-token"},
+	}
+
+	for _, test := range tests {
+		*requests = nil
+		var out bytes.Buffer
+
+		args := append([]string{"-server", server.URL, "-key", "secret"}, test.args...)
+		require.Nil(t, run(args, noEnv, &out), test.args[0])
+
+		test.sent.token = "Token admin-token"
+		require.Len(t, *requests, 1, test.args[0])
+		assert.Equal(t, test.sent, (*requests)[0], test.args[0])
+		assert.Contains(t, out.String(), test.says, test.args[0])
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server, _ := fakeServer(t, model.Game{})
+	env := func(name string) string {
+		return map[string]string{"UNO_SERVER": server.URL, "UNO_ADMIN_KEY": "secret"}[name]
+	}
+	var out bytes.Buffer
+
+	err := run([]string{"show", "missing"}, env, &out)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "404 Game not found")
+
+	err = run([]string{"-key", "guess", "show", "g1"}, env, &out)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "not the admin key")
+
+	assert.NotNil(t, run([]string{"show"}, env, &out))
+	assert.NotNil(t, run([]string{"explode", "g1"}, env, &out))
+	assert.NotNil(t, run([]string{"show", "g1"}, noEnv, &out))
+}
+
+func noEnv(string) string {
+	return ""
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jak103/uno/ratelimit"
+	"github.com/labstack/gommon/bytes"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
+)
+
+// config is how the server is run. Every setting has a flag and an environment variable,
+// and can also be given in a JSON or YAML file named by -config or UNO_CONFIG, keyed by its flag name.
+// Flags win over the environment, which wins over the file.
+type config struct {
+	// The address to listen on, such as :8080
+	Listen string
+	// The server speaks HTTPS when both of these are set
+	TLSCert string
+	TLSKey  string
+	// The origins browsers may call the API from, * for any
+	CORSOrigins []string
+	// Where the built client is served from
+	StaticDir string
+	// Where custom decks are loaded from
+	DecksDir string
+	// Signs the tokens players are given
+	TokenSecret string
+	// How long requests in flight get to finish when the server stops
+	ShutdownTimeout time.Duration
+	// The least severe level logged at startup, it can be changed at /api/admin/log-level
+	LogLevel zapcore.Level
+	// Where spans are sent: none, stdout or otlp
+	TraceExporter string
+	// The OpenTelemetry collector spans are sent to over gRPC
+	OTLPEndpoint string
+	// How often each address can read, and create players, and how often each player can call the API
+	PublicRate ratelimit.Rate
+	CreateRate ratelimit.Rate
+	PlayerRate ratelimit.Rate
+	// Where the rate limit buckets are kept: memory, or redis to share them between replicas
+	RateLimitStore string
+	// Read each client's address from X-Forwarded-For, past any proxies on private networks,
+	// for when the server is behind a load balancer. Otherwise clients could dodge the rate limits
+	// by picking their own address.
+	ProxyHeaders bool
+	// The largest request body accepted, such as 64K
+	BodyLimit string
+	// How many unfinished games one creator can have, 0 for any number
+	MaxOpenGames int
+	// Operators trade this for a token to the admin API at /api/admin/token. The admin API is off when it is empty.
+	AdminKey string
+	// The database and event bus settings, by environment variable.
+	// The db and events packages read them from the environment when they connect.
+	Backend map[string]string
+}
+
+func defaultConfig() config {
+	return config{
+		Listen:          ":8080",
+		CORSOrigins:     []string{"*"},
+		StaticDir:       "/client/dist/",
+		DecksDir:        "decks",
+		TokenSecret:     "usudevops",
+		ShutdownTimeout: 10 * time.Second,
+		LogLevel:        zapcore.InfoLevel,
+		TraceExporter:   traceToNowhere,
+		OTLPEndpoint:    defaultOTLPHost,
+		PublicRate:      ratelimit.Rate{Limit: 600, Per: time.Minute},
+		CreateRate:      ratelimit.Rate{Limit: 20, Per: time.Minute},
+		PlayerRate:      ratelimit.Rate{Limit: 300, Per: time.Minute},
+		RateLimitStore:  limitInMemory,
+		BodyLimit:       "64K",
+		MaxOpenGames:    3,
+		Backend:         map[string]string{},
+	}
+}
+
+// setting is one setting's flag, its environment variable and how to set it from text
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *config, value string) error
+}
+
+func textSetting(flag string, env string, usage string, field func(c *config) *string) setting {
+	return setting{flag, env, usage, func(c *config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func rateSetting(flag string, env string, usage string, field func(c *config) *ratelimit.Rate) setting {
+	return setting{flag, env, usage, func(c *config, value string) error {
+		rate, err := ratelimit.ParseRate(value)
+		*field(c) = rate
+		return err
+	}}
+}
+
+// backendSetting is a setting the db or events package reads from env
+func backendSetting(flag string, env string, usage string) setting {
+	return setting{flag, env, usage, func(c *config, value string) error {
+		c.Backend[env] = value
+		return nil
+	}}
+}
+
+var settings = []setting{
+	textSetting("listen", "LISTEN_ADDR", "address to listen on", func(c *config) *string { return &c.Listen }),
+	textSetting("tls-cert", "TLS_CERT", "TLS certificate file, HTTPS is served when it and the key are set", func(c *config) *string { return &c.TLSCert }),
+	textSetting("tls-key", "TLS_KEY", "TLS private key file", func(c *config) *string { return &c.TLSKey }),
+	{"cors-origins", "CORS_ORIGINS", "comma separated origins allowed to call the API, * for any", func(c *config, value string) error {
+		c.CORSOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.CORSOrigins = append(c.CORSOrigins, origin)
+			}
+		}
+		return nil
+	}},
+	textSetting("static-dir", "STATIC_DIR", "directory the client is served from", func(c *config) *string { return &c.StaticDir }),
+	textSetting("decks-dir", "DECKS_DIR", "directory custom decks are loaded from", func(c *config) *string { return &c.DecksDir }),
+	textSetting("token-secret", "TOKEN_SECRET", "secret player tokens are signed with", func(c *config) *string { return &c.TokenSecret }),
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long requests in flight get to finish when stopping", func(c *config, value string) error {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("must be a duration like 10s, got %q", value)
+		}
+		c.ShutdownTimeout = timeout
+		return nil
+	}},
+	{"log-level", "LOG_LEVEL", "least severe level logged: debug, info, warn or error", func(c *config, value string) error {
+		return c.LogLevel.UnmarshalText([]byte(value))
+	}},
+	textSetting("trace-exporter", "TRACE_EXPORTER", "where spans are sent: none, stdout or otlp", func(c *config) *string { return &c.TraceExporter }),
+	textSetting("otlp-endpoint", "OTLP_ENDPOINT", "host:port of the OpenTelemetry collector", func(c *config) *string { return &c.OTLPEndpoint }),
+	rateSetting("rate-public", "RATE_LIMIT_PUBLIC", "requests each address can make, like 600/1m, or off", func(c *config) *ratelimit.Rate { return &c.PublicRate }),
+	rateSetting("rate-create", "RATE_LIMIT_CREATE", "games each address can create, join or queue for, like 20/1m, or off", func(c *config) *ratelimit.Rate { return &c.CreateRate }),
+	rateSetting("rate-player", "RATE_LIMIT_PLAYER", "requests each player can make, like 300/1m, or off", func(c *config) *ratelimit.Rate { return &c.PlayerRate }),
+	textSetting("rate-limit-store", "RATE_LIMIT_STORE", "where rate limits are kept: memory, or redis to share them between replicas", func(c *config) *string { return &c.RateLimitStore }),
+	{"proxy-headers", "PROXY_HEADERS", "read client addresses from X-Forwarded-For, only behind a load balancer that sets it", func(c *config, value string) error {
+		trust, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false, got %q", value)
+		}
+		c.ProxyHeaders = trust
+		return nil
+	}},
+	textSetting("body-limit", "BODY_LIMIT", "largest request body accepted, like 64K", func(c *config) *string { return &c.BodyLimit }),
+	{"max-open-games", "MAX_OPEN_GAMES", "unfinished games one creator can have, 0 for any number", func(c *config, value string) error {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 0 {
+			return fmt.Errorf("must be a number from 0, got %q", value)
+		}
+		c.MaxOpenGames = max
+		return nil
+	}},
+	textSetting("admin-key", "ADMIN_KEY", "key operators trade for an admin API token, the admin API is off when empty", func(c *config) *string { return &c.AdminKey }),
+	backendSetting("db-type", "DB_TYPE", "database: mock, mongo, sqlite, postgres, redis or firestore"),
+	backendSetting("mongo-uri", "MONGO_URI", "MongoDB connection string"),
+	backendSetting("mongo-database", "MONGO_DATABASE", "MongoDB database name"),
+	backendSetting("postgres-uri", "POSTGRES_URI", "Postgres connection string"),
+	backendSetting("sqlite-path", "SQLITE_PATH", "SQLite database file"),
+	backendSetting("redis-url", "REDIS_URL", "Redis URL for the redis database and event bus"),
+	backendSetting("firestore-project", "FIRESTORE_PROJECT_ID", "Google Cloud project for Firestore"),
+	backendSetting("event-bus", "EVENT_BUS", "event bus: local or redis"),
+}
+
+// loadConfig reads the config from the defaults, the config file, the environment and then the flags in args
+func loadConfig(args []string, getenv func(string) string) (config, error) {
+	cfg := defaultConfig()
+
+	flags := flag.NewFlagSet("uno", flag.ContinueOnError)
+	file := flags.String("config", getenv("UNO_CONFIG"), "JSON or YAML config file (UNO_CONFIG)")
+	values := map[string]*string{}
+	for _, s := range settings {
+		values[s.flag] = flags.String(s.flag, "", fmt.Sprintf("%s (%s)", s.usage, s.env))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *file != "" {
+		if err := readConfigFile(*file, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(&cfg, value); err != nil {
+				return cfg, fmt.Errorf("%s %v", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		set := false
+		flags.Visit(func(f *flag.Flag) { set = set || f.Name == s.flag })
+
+		if set {
+			if err := s.set(&cfg, *values[s.flag]); err != nil {
+				return cfg, fmt.Errorf("-%s %v", s.flag, err)
+			}
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+// readConfigFile reads settings keyed by their flag names from JSON,
+// or from YAML when the file name ends in .yaml or .yml
+func readConfigFile(path string, cfg *config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	file := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	default:
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Go through the keys in order so the same bad file always gives the same error
+	keys := make([]string, 0, len(file))
+	for key := range file {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s, ok := findSetting(key)
+		if !ok {
+			return fmt.Errorf("%s: %q is not a setting", path, key)
+		}
+
+		if err := s.set(cfg, settingText(file[key])); err != nil {
+			return fmt.Errorf("%s: %s %v", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+// settingText is a value from a config file as it would be written in a flag, lists are comma separated
+func settingText(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+func findSetting(flag string) (setting, bool) {
+	for _, s := range settings {
+		if s.flag == flag {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+func (c config) validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("the listen address must be set")
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("TLS needs both a certificate and a key")
+	}
+
+	if c.TokenSecret == "" {
+		return fmt.Errorf("the token secret must be set")
+	}
+
+	// Anyone could sign an admin token with the default secret, it is in the source
+	if c.AdminKey != "" && c.TokenSecret == defaultConfig().TokenSecret {
+		return fmt.Errorf("the admin API needs a token secret of its own, set TOKEN_SECRET")
+	}
+
+	switch c.TraceExporter {
+	case traceToNowhere, traceToStdout, traceToOTLP:
+	default:
+		return fmt.Errorf("the trace exporter must be none, stdout or otlp, got %q", c.TraceExporter)
+	}
+
+	if _, err := bytes.Parse(c.BodyLimit); err != nil {
+		return fmt.Errorf("the body limit must be a size like 64K, got %q", c.BodyLimit)
+	}
+
+	if c.RateLimitStore != limitInMemory && c.RateLimitStore != limitInRedis {
+		return fmt.Errorf("the rate limit store must be memory or redis, got %q", c.RateLimitStore)
+	}
+
+	if len(c.CORSOrigins) == 0 {
+		return fmt.Errorf("at least one CORS origin must be allowed, * for any")
+	}
+
+	return nil
+}
+
+// applyBackend puts the database and event bus settings where the db and events packages read them
+func (c config) applyBackend() error {
+	for env, value := range c.Backend {
+		if err := os.Setenv(env, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
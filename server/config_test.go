@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func environment(values map[string]string) func(string) string {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil, environment(nil))
+	require.Nil(t, err)
+	assert.Equal(t, defaultConfig(), cfg)
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uno-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "uno.yaml")
+	require.Nil(t, ioutil.WriteFile(file, []byte(`
+listen: ":9000"
+static-dir: /srv/uno
+cors-origins: [https://uno.example.com, https://www.uno.example.com]
+shutdown-timeout: 30s
+db-type: mongo
+mongo-uri: mongodb://file
+`), 0644))
+
+	cfg, err := loadConfig(
+		[]string{"-listen", ":9443", "-tls-cert", "cert.pem", "-tls-key", "key.pem"},
+		environment(map[string]string{"UNO_CONFIG": file, "MONGO_URI": "mongodb://env", "TOKEN_SECRET": "shh"}),
+	)
+	require.Nil(t, err)
+
+	// Flags win over the environment, which wins over the file
+	assert.Equal(t, ":9443", cfg.Listen)
+	assert.Equal(t, "cert.pem", cfg.TLSCert)
+	assert.Equal(t, "shh", cfg.TokenSecret)
+	assert.Equal(t, "/srv/uno", cfg.StaticDir)
+	assert.Equal(t, []string{"https://uno.example.com", "https://www.uno.example.com"}, cfg.CORSOrigins)
+	assert.Equal(t, 30*time.Second, cfg.ShutdownTimeout)
+	assert.Equal(t, map[string]string{"DB_TYPE": "mongo", "MONGO_URI": "mongodb://env"}, cfg.Backend)
+
+	// JSON files work the same way
+	file = filepath.Join(dir, "uno.json")
+	require.Nil(t, ioutil.WriteFile(file, []byte(`{"cors-origins": "https://a.example.com, https://b.example.com", "event-bus": "redis"}`), 0644))
+
+	cfg, err = loadConfig([]string{"-config", file}, environment(nil))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.CORSOrigins)
+	assert.Equal(t, "redis", cfg.Backend["EVENT_BUS"])
+}
+
+func TestAdminKeyNeedsItsOwnSecret(t *testing.T) {
+	cfg, err := loadConfig(nil, environment(map[string]string{"ADMIN_KEY": "let-me-in", "TOKEN_SECRET": "shh"}))
+	require.Nil(t, err)
+	assert.Equal(t, "let-me-in", cfg.AdminKey)
+}
+
+func TestBadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uno-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	unknown := filepath.Join(dir, "unknown.json")
+	require.Nil(t, ioutil.WriteFile(unknown, []byte(`{"port": 8080}`), 0644))
+
+	for name, load := range map[string]func() (config, error){
+		"half of TLS": func() (config, error) {
+			return loadConfig([]string{"-tls-cert", "cert.pem"}, environment(nil))
+		},
+		"bad timeout": func() (config, error) {
+			return loadConfig(nil, environment(map[string]string{"SHUTDOWN_TIMEOUT": "soon"}))
+		},
+		"unknown flag": func() (config, error) {
+			return loadConfig([]string{"-port", "8080"}, environment(nil))
+		},
+		"unknown setting": func() (config, error) {
+			return loadConfig([]string{"-config", unknown}, environment(nil))
+		},
+		"missing file": func() (config, error) {
+			return loadConfig([]string{"-config", filepath.Join(dir, "missing.yaml")}, environment(nil))
+		},
+		"no origins": func() (config, error) {
+			return loadConfig([]string{"-cors-origins", " , "}, environment(nil))
+		},
+		"unknown log level": func() (config, error) {
+			return loadConfig(nil, environment(map[string]string{"LOG_LEVEL": "chatty"}))
+		},
+		"bad rate": func() (config, error) {
+			return loadConfig([]string{"-rate-create", "lots"}, environment(nil))
+		},
+		"admin API with the default secret": func() (config, error) {
+			return loadConfig(nil, environment(map[string]string{"ADMIN_KEY": "let-me-in"}))
+		},
+		"unknown rate limit store": func() (config, error) {
+			return loadConfig(nil, environment(map[string]string{"RATE_LIMIT_STORE": "disk"}))
+		},
+		"bad body limit": func() (config, error) {
+			return loadConfig([]string{"-body-limit", "big"}, environment(nil))
+		},
+		"unknown trace exporter": func() (config, error) {
+			return loadConfig([]string{"-trace-exporter", "jaeger"}, environment(nil))
+		},
+	} {
+		_, err := load()
+		assert.NotNil(t, err, name)
+	}
+}
@@ -3,35 +3,55 @@ package db
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/google/uuid"
 	"github.com/jak103/uno/model"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type firestoreDB struct {
-	client  *firestore.Client
-	games   *firestore.CollectionRef
-	players *firestore.CollectionRef
+	client   *firestore.Client
+	games    *firestore.CollectionRef
+	players  *firestore.CollectionRef
+	archives *firestore.CollectionRef
+	results  *firestore.CollectionRef
+	ratings  *firestore.CollectionRef
+	queue    *firestore.CollectionRef
+}
+
+// firestoreNotFound turns Firestore's missing document error into the shared not found error
+func firestoreNotFound(err error, notFoundErr error) error {
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("firestore: %w", notFoundErr)
+	}
+	return err
 }
 
 func (db *firestoreDB) GetAllGames() (*[]model.Game, error) {
 	games := make([]model.Game, 0)
 
-	documents := db.games.DocumentRefs(context.Background())
+	documents := db.games.Documents(context.Background())
+	defer documents.Stop()
 	for {
-		docRef, docRefErr := documents.Next()
+		docSnapshot, err := documents.Next()
 
-		if docRefErr == iterator.Done {
+		if err == iterator.Done {
 			break
 		}
 
-		var game model.Game
+		if err != nil {
+			return nil, err
+		}
 
-		if docSnapshot, _ := docRef.Get(context.Background()); docSnapshot != nil {
-			_ = docSnapshot.DataTo(&game)
+		var game model.Game
+		if err = docSnapshot.DataTo(&game); err != nil {
+			return nil, err
 		}
 
 		games = append(games, game)
@@ -40,6 +60,41 @@ func (db *firestoreDB) GetAllGames() (*[]model.Game, error) {
 	return &games, nil
 }
 
+// QueryGames returns one page of the games matching the query.
+// Firestore filters on status, but it cannot search inside names or sort by the number of players,
+// so the rest of the query is applied here.
+func (db *firestoreDB) QueryGames(query GameQuery) (*GamePage, error) {
+	q := db.games.Query
+	if query.Status != "" {
+		q = q.Where("Status", "==", query.Status)
+	}
+
+	games := make([]model.Game, 0)
+
+	documents := q.Documents(context.Background())
+	defer documents.Stop()
+	for {
+		docSnapshot, err := documents.Next()
+
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var game model.Game
+		if err = docSnapshot.DataTo(&game); err != nil {
+			return nil, err
+		}
+
+		games = append(games, game)
+	}
+
+	return queryGames(games, query), nil
+}
+
 // HasGame checks to see if a game with the given ID exists in the database.
 func (db *firestoreDB) HasGameByPassword(password string) bool {
 	game, err := db.LookupGameByPassword(password)
@@ -61,11 +116,12 @@ func (db *firestoreDB) CreateGame(gameName string, creatorID string) (*model.Gam
 
 	myGame := model.Game{
 		ID:        uuid.New().String(),
-		Password:  "12234",
+		Password:  newGamePassword(),
 		Creator:   *player,
 		Name:      gameName,
 		Status:    model.WaitingForPlayers,
-		Direction: true}
+		Direction: true,
+		CreatedAt: timestamp()}
 	myGame.Players = append(myGame.Players, *player)
 	gameDoc := db.games.Doc(myGame.ID)
 
@@ -78,7 +134,7 @@ func (db *firestoreDB) CreateGame(gameName string, creatorID string) (*model.Gam
 
 // CreatePlayer creates the player in the database
 func (db *firestoreDB) CreatePlayer(name string) (*model.Player, error) {
-	player := model.Player{ID: uuid.New().String(), Name: name}
+	player := model.Player{ID: uuid.New().String(), Name: name, CreatedAt: timestamp()}
 	playerDoc := db.players.Doc(player.ID)
 
 	if _, err := playerDoc.Create(context.Background(), player); err != nil {
@@ -116,11 +172,7 @@ func (db *firestoreDB) LookupGameByID(id string) (*model.Game, error) {
 	docSnapshot, err := gameDoc.Get(context.Background())
 
 	if err != nil {
-		return nil, err
-	}
-
-	if docSnapshot == nil {
-		return nil, fmt.Errorf("%s: game not found", id)
+		return nil, firestoreNotFound(err, ErrGameNotFound)
 	}
 
 	var game model.Game
@@ -133,36 +185,25 @@ func (db *firestoreDB) LookupGameByID(id string) (*model.Game, error) {
 
 // LookupGameByPassword looks up an existing game in the database.
 func (db *firestoreDB) LookupGameByPassword(password string) (*model.Game, error) {
-	q := db.games.Where("Password", "==", password)
+	q := db.games.Where("Password", "==", password).Limit(1)
 	documents := q.Documents(context.Background())
-
-	var game model.Game
-	var err error
-	var docSnapshot *firestore.DocumentSnapshot
 	defer documents.Stop()
-	for {
-		docSnapshot, err = documents.Next()
-
-		if err == iterator.Done {
-			err = fmt.Errorf("%s: no game found for password", password)
-			break
-		}
-
-		if err != nil {
-			break
-		}
 
-		if err = docSnapshot.DataTo(&game); err != nil {
-			break
-		}
+	docSnapshot, err := documents.Next()
 
-		break
+	if err == iterator.Done {
+		return nil, fmt.Errorf("firestore: %w", ErrGameNotFound)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
+	var game model.Game
+	if err = docSnapshot.DataTo(&game); err != nil {
+		return nil, err
+	}
+
 	return &game, nil
 }
 
@@ -172,11 +213,7 @@ func (db *firestoreDB) LookupPlayer(id string) (*model.Player, error) {
 	docSnapshot, err := playerDoc.Get(context.Background())
 
 	if err != nil {
-		return nil, err
-	}
-
-	if docSnapshot == nil {
-		return nil, fmt.Errorf("%s: player not found", id)
+		return nil, firestoreNotFound(err, ErrPlayerNotFound)
 	}
 
 	var player model.Player
@@ -187,77 +224,422 @@ func (db *firestoreDB) LookupPlayer(id string) (*model.Player, error) {
 	return &player, nil
 }
 
-// JoinGame join a player to a game.
-func (db *firestoreDB) JoinGame(id string, username string) (*model.Game, error) {
-	game, gameErr := db.LookupGameByID(id)
+// UpdateGame reads a game, changes it with update and writes it back in one transaction,
+// so concurrent updates to the same game are never lost.
+func (db *firestoreDB) UpdateGame(id string, update func(game *model.Game) error) (*model.Game, error) {
+	var game model.Game
+	gameDoc := db.games.Doc(id)
+
+	err := db.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnapshot, err := tx.Get(gameDoc)
+		if err != nil {
+			return firestoreNotFound(err, ErrGameNotFound)
+		}
+
+		game = model.Game{}
+		if err = docSnapshot.DataTo(&game); err != nil {
+			return err
+		}
+
+		if err = update(&game); err != nil {
+			return err
+		}
+
+		return tx.Set(gameDoc, game)
+	})
 
-	if gameErr != nil {
-		return nil, gameErr
+	if err != nil {
+		return nil, err
 	}
 
+	return &game, nil
+}
+
+// JoinGame join a player to a game.
+func (db *firestoreDB) JoinGame(id string, username string) (*model.Game, error) {
 	player, playerErr := db.LookupPlayer(username)
 
 	if playerErr != nil {
 		return nil, playerErr
 	}
 
-	game.Players = append(game.Players, *player)
+	return db.UpdateGame(id, func(game *model.Game) error {
+		if !hasPlayer(game, player.ID) {
+			game.Players = append(game.Players, *player)
+		}
+		return nil
+	})
+}
+
+// SaveGame saves the game
+func (db *firestoreDB) SaveGame(game model.Game) error {
+	_, err := db.UpdateGame(game.ID, func(saved *model.Game) error {
+		*saved = game
+		return nil
+	})
+
+	return err
+}
+
+// SavePlayer saves the player data
+func (db *firestoreDB) SavePlayer(player model.Player) error {
+	playerDoc := db.players.Doc(player.ID)
+
+	return db.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(playerDoc); err != nil {
+			return firestoreNotFound(err, ErrPlayerNotFound)
+		}
+
+		return tx.Set(playerDoc, player)
+	})
+}
 
-	err := db.SaveGame(*game)
+// SendMessage add a Message to a game chat.
+func (db *firestoreDB) AddMessage(gameID string, playerID string, message model.Message) (*model.Game, error) {
+	player, err := db.LookupPlayer(playerID)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return game, nil
+	message.Player = *player
+
+	return db.UpdateGame(gameID, func(game *model.Game) error {
+		game.Messages = append(game.Messages, message)
+		return nil
+	})
+}
+
+// DeleteMessage removes a Message from a game chat.
+func (db *firestoreDB) DeleteMessage(gameID string, messageID string) (*model.Game, error) {
+	return db.UpdateGame(gameID, func(game *model.Game) error {
+		messages, found := removeMessage(game.Messages, messageID)
+		if !found {
+			return fmt.Errorf("firestore: %w", ErrMessageNotFound)
+		}
+
+		game.Messages = messages
+		return nil
+	})
 }
 
-// SaveGame saves the game
-func (db *firestoreDB) SaveGame(game model.Game) error {
-	gameDoc := db.games.Doc(game.ID)
+// GetAllPlayers returns all players in the database
+func (db *firestoreDB) GetAllPlayers() (*[]model.Player, error) {
+	players := make([]model.Player, 0)
 
-	if _, err := gameDoc.Set(context.Background(), game); err != nil {
-		return err
+	documents := db.players.Documents(context.Background())
+	defer documents.Stop()
+	for {
+		docSnapshot, err := documents.Next()
+
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var player model.Player
+		if err = docSnapshot.DataTo(&player); err != nil {
+			return nil, err
+		}
+
+		players = append(players, player)
 	}
 
-	return nil
+	return &players, nil
 }
 
-// SavePlayer saves the player data
-func (db *firestoreDB) SavePlayer(player model.Player) error {
-	playerDoc := db.players.Doc(player.ID)
+// ArchiveGame stores the archive and deletes the game it summarizes in one transaction
+func (db *firestoreDB) ArchiveGame(archive model.GameArchive) error {
+	gameDoc := db.games.Doc(archive.ID)
 
-	if _, err := playerDoc.Set(context.Background(), player); err != nil {
-		return err
+	return db.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(gameDoc); err != nil {
+			return firestoreNotFound(err, ErrGameNotFound)
+		}
+
+		if err := tx.Set(db.archives.Doc(archive.ID), archive); err != nil {
+			return err
+		}
+
+		return tx.Delete(gameDoc)
+	})
+}
+
+// LookupArchive looks up the archive of a game that has been cleaned up
+func (db *firestoreDB) LookupArchive(id string) (*model.GameArchive, error) {
+	docSnapshot, err := db.archives.Doc(id).Get(context.Background())
+
+	if err != nil {
+		return nil, firestoreNotFound(err, ErrArchiveNotFound)
+	}
+
+	var archive model.GameArchive
+	if err = docSnapshot.DataTo(&archive); err != nil {
+		return nil, err
+	}
+
+	return &archive, nil
+}
+
+// AddGameResults records how each player did in a finished game.
+// Each result is created under its game and player, so recording the same game twice keeps the first results.
+func (db *firestoreDB) AddGameResults(results []model.PlayerResult) error {
+	for _, result := range results {
+		_, err := db.results.Doc(result.GameID+"_"+result.PlayerID).Create(context.Background(), result)
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// SendMessage add a Message to a game chat.
-func (db *firestoreDB) AddMessage(gameID string, playerID string, message model.Message) (*model.Game, error) {
-	game, err := db.LookupGameByID(gameID)
+// GetGameResults returns the results of every game that finished at or after since
+func (db *firestoreDB) GetGameResults(since time.Time) (*[]model.PlayerResult, error) {
+	results := make([]model.PlayerResult, 0)
+
+	documents := db.results.Where("FinishedAt", ">=", formatTime(since)).Documents(context.Background())
+	defer documents.Stop()
+	for {
+		docSnapshot, err := documents.Next()
+
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var result model.PlayerResult
+		if err = docSnapshot.DataTo(&result); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return &results, nil
+}
+
+// ratingDoc is where an account's rating is stored. Account keys are names, which may contain a slash.
+func (db *firestoreDB) ratingDoc(account string) *firestore.DocumentRef {
+	return db.ratings.Doc(url.PathEscape(account))
+}
+
+// LookupRating looks up the rating of an account
+func (db *firestoreDB) LookupRating(account string) (*model.Rating, error) {
+	docSnapshot, err := db.ratingDoc(account).Get(context.Background())
 
 	if err != nil {
+		return nil, firestoreNotFound(err, ErrRatingNotFound)
+	}
+
+	var rating model.Rating
+	if err = docSnapshot.DataTo(&rating); err != nil {
 		return nil, err
 	}
 
-	player, err := db.LookupPlayer(playerID)
-	message.Player = *player
+	return &rating, nil
+}
+
+// LookupRatings looks up the ratings of several accounts in one round trip
+func (db *firestoreDB) LookupRatings(accounts []string) (map[string]model.Rating, error) {
+	ratings := make(map[string]model.Rating, len(accounts))
+	if len(accounts) == 0 {
+		return ratings, nil
+	}
+
+	docs := make([]*firestore.DocumentRef, len(accounts))
+	for i, account := range accounts {
+		docs[i] = db.ratingDoc(account)
+	}
 
+	docSnapshots, err := db.client.GetAll(context.Background(), docs)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("Adding a new Message")
-	game.Messages = append(game.Messages, message)
-	err = db.SaveGame(*game)
+	// The snapshots come back in the order they were asked for
+	for i, docSnapshot := range docSnapshots {
+		if !docSnapshot.Exists() {
+			continue
+		}
+
+		var rating model.Rating
+		if err := docSnapshot.DataTo(&rating); err != nil {
+			return nil, err
+		}
+		ratings[accounts[i]] = rating
+	}
+
+	return ratings, nil
+}
+
+// ApplyRatingChanges moves each account's rating by its change and records the change in its history,
+// which is kept under the rating document, one document per game
+func (db *firestoreDB) ApplyRatingChanges(changes []model.RatingChange) error {
+	for _, change := range changes {
+		change := change
+		ratingDoc := db.ratingDoc(change.Account)
+		historyDoc := ratingDoc.Collection("history").Doc(change.GameID)
+
+		err := db.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+			if _, err := tx.Get(historyDoc); status.Code(err) != codes.NotFound {
+				// Either the change was already applied or the read failed
+				return err
+			}
+
+			rating := model.Rating{Account: change.Account, Rating: model.DefaultRating}
+			docSnapshot, err := tx.Get(ratingDoc)
+			if err == nil {
+				err = docSnapshot.DataTo(&rating)
+			}
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+
+			rating.Name = change.Name
+			rating.Rating += change.Delta
+			rating.GamesRated++
+			rating.UpdatedAt = change.At
+			change.Rating = rating.Rating
+
+			if err := tx.Set(ratingDoc, rating); err != nil {
+				return err
+			}
+			return tx.Set(historyDoc, change)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetRatingHistory returns how an account's rating changed, oldest first
+func (db *firestoreDB) GetRatingHistory(account string) (*[]model.RatingChange, error) {
+	history := make([]model.RatingChange, 0)
+
+	documents := db.ratingDoc(account).Collection("history").Documents(context.Background())
+	defer documents.Stop()
+	for {
+		docSnapshot, err := documents.Next()
+
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var change model.RatingChange
+		if err = docSnapshot.DataTo(&change); err != nil {
+			return nil, err
+		}
+
+		history = append(history, change)
+	}
+
+	sortRatingHistory(history)
+	return &history, nil
+}
+
+// EnqueuePlayer adds a player to the matchmaking queue
+func (db *firestoreDB) EnqueuePlayer(entry model.QueueEntry) (*model.QueueEntry, error) {
+	entry = newQueueEntry(entry)
+
+	if _, err := db.queue.Doc(entry.ID).Create(context.Background(), entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// LookupQueueEntry looks up a matchmaking ticket
+func (db *firestoreDB) LookupQueueEntry(id string) (*model.QueueEntry, error) {
+	docSnapshot, err := db.queue.Doc(id).Get(context.Background())
 
 	if err != nil {
+		return nil, firestoreNotFound(err, ErrQueueEntryNotFound)
+	}
+
+	var entry model.QueueEntry
+	if err = docSnapshot.DataTo(&entry); err != nil {
 		return nil, err
 	}
 
-	return game, nil
+	return &entry, nil
+}
+
+// GetQueueEntries returns every entry in the matchmaking queue, oldest first
+func (db *firestoreDB) GetQueueEntries() (*[]model.QueueEntry, error) {
+	entries := make([]model.QueueEntry, 0)
+
+	documents := db.queue.Documents(context.Background())
+	defer documents.Stop()
+	for {
+		docSnapshot, err := documents.Next()
+
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var entry model.QueueEntry
+		if err = docSnapshot.DataTo(&entry); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sortQueue(entries)
+	return &entries, nil
+}
+
+// UpdateQueueEntry saves the entry if its status is still from
+func (db *firestoreDB) UpdateQueueEntry(entry model.QueueEntry, from model.QueueStatus) error {
+	doc := db.queue.Doc(entry.ID)
+
+	return db.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnapshot, err := tx.Get(doc)
+		if err != nil {
+			return firestoreNotFound(err, ErrQueueEntryNotFound)
+		}
+
+		var stored model.QueueEntry
+		if err = docSnapshot.DataTo(&stored); err != nil {
+			return err
+		}
+
+		if stored.Status != from {
+			return fmt.Errorf("firestore: %w", ErrQueueEntryChanged)
+		}
+
+		return tx.Set(doc, entry)
+	})
+}
+
+// DeleteQueueEntry removes an entry from the matchmaking queue
+func (db *firestoreDB) DeleteQueueEntry(id string) error {
+	doc := db.queue.Doc(id)
+
+	return db.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(doc); err != nil {
+			return firestoreNotFound(err, ErrQueueEntryNotFound)
+		}
+		return tx.Delete(doc)
+	})
 }
 
 // Disconnect disconnects from the remote database
@@ -268,20 +650,39 @@ func (db *firestoreDB) disconnect() {
 	}
 }
 
-// Connect allows the user to connect to the database
-func (db *firestoreDB) connect() {
+// Connect allows the user to connect to the database.
+// When FIRESTORE_EMULATOR_HOST is set the client talks to a local emulator instead.
+func (db *firestoreDB) connect() error {
 	// Sets your Google Cloud Platform project ID.
 	projectID := os.Getenv("FIRESTORE_PROJECT_ID")
 
 	client, err := firestore.NewClient(context.Background(), projectID)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Etablish Database Collection object
 	db.client = client
 	db.games = db.client.Collection("games")
 	db.players = db.client.Collection("players")
+	db.archives = db.client.Collection("archives")
+	db.results = db.client.Collection("results")
+	db.ratings = db.client.Collection("ratings")
+	db.queue = db.client.Collection("matchmaking_queue")
+
+	return db.Ping()
+}
+
+// Ping reads a game, Firestore has no call that only checks the connection
+func (db *firestoreDB) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.games.Limit(1).Documents(ctx).Next()
+	if err == iterator.Done {
+		return nil
+	}
+	return err
 }
 
 func init() {
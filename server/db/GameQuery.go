@@ -0,0 +1,134 @@
+package db
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jak103/uno/model"
+)
+
+// GameSort orders the games returned by QueryGames
+type GameSort string
+
+// Ways the lobby can be sorted. Ties are always broken by game ID so pages never overlap.
+const (
+	SortNewest  GameSort = "newest"
+	SortOldest  GameSort = "oldest"
+	SortName    GameSort = "name"
+	SortPlayers GameSort = "players"
+)
+
+// Page sizes for QueryGames
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// GameQuery selects one page of games for the lobby
+type GameQuery struct {
+	// Only games with this status, or every game when empty
+	Status model.GameStatus
+	// Only games whose name contains this, ignoring case
+	Search string
+	// Defaults to SortNewest
+	Sort GameSort
+	// Pages start at 1
+	Page int
+	// Games per page, up to MaxPageSize
+	Limit int
+}
+
+// GamePage is one page of games and how many games matched the query in total
+type GamePage struct {
+	Games []model.Game
+	Total int
+}
+
+// ParseGameSort checks that s is one of the supported sorts
+func ParseGameSort(s string) (GameSort, bool) {
+	switch gameSort := GameSort(strings.ToLower(s)); gameSort {
+	case SortNewest, SortOldest, SortName, SortPlayers:
+		return gameSort, true
+	}
+	return "", false
+}
+
+// normalize fills in the defaults and keeps the page inside the allowed range
+func (q GameQuery) normalize() GameQuery {
+	if q.Sort == "" {
+		q.Sort = SortNewest
+	}
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Limit < 1 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+	return q
+}
+
+func (q GameQuery) offset() int {
+	return (q.Page - 1) * q.Limit
+}
+
+// matches reports whether a game passes the query's filters
+func (q GameQuery) matches(game model.Game) bool {
+	if q.Status != "" && game.Status != q.Status {
+		return false
+	}
+	return strings.Contains(strings.ToLower(game.Name), strings.ToLower(q.Search))
+}
+
+// less orders two games the way the query asks
+func (q GameQuery) less(a model.Game, b model.Game) bool {
+	switch q.Sort {
+	case SortOldest:
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+	case SortName:
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+	case SortPlayers:
+		if len(a.Players) != len(b.Players) {
+			return len(a.Players) > len(b.Players)
+		}
+	default:
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt > b.CreatedAt
+		}
+	}
+	return a.ID < b.ID
+}
+
+// queryGames filters, sorts and pages games in memory,
+// for the backends that have no query engine of their own
+func queryGames(games []model.Game, query GameQuery) *GamePage {
+	query = query.normalize()
+
+	matched := make([]model.Game, 0)
+	for _, game := range games {
+		if query.matches(game) {
+			matched = append(matched, game)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return query.less(matched[i], matched[j])
+	})
+
+	page := &GamePage{Games: make([]model.Game, 0), Total: len(matched)}
+	if query.offset() < len(matched) {
+		end := query.offset() + query.Limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page.Games = append(page.Games, matched[query.offset():end]...)
+	}
+
+	return page
+}
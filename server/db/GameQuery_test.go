@@ -0,0 +1,26 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGameSort(t *testing.T) {
+	sort, ok := ParseGameSort("Players")
+	assert.True(t, ok)
+	assert.Equal(t, SortPlayers, sort)
+
+	_, ok = ParseGameSort("password")
+	assert.False(t, ok)
+}
+
+func TestGameQueryNormalize(t *testing.T) {
+	query := GameQuery{}.normalize()
+	assert.Equal(t, GameQuery{Sort: SortNewest, Page: 1, Limit: DefaultPageSize}, query)
+	assert.Equal(t, 0, query.offset())
+
+	query = GameQuery{Sort: SortName, Page: 3, Limit: MaxPageSize + 1}.normalize()
+	assert.Equal(t, MaxPageSize, query.Limit)
+	assert.Equal(t, 2*MaxPageSize, query.offset())
+}
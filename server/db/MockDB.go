@@ -1,8 +1,9 @@
 package db
 
 import (
-	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jak103/uno/model"
@@ -10,12 +11,40 @@ import (
 
 // MockDB is an implemenation declaring the unit test db
 type mockDB struct {
+	mutex         sync.RWMutex
 	games         map[string]model.Game
 	gamePasswords map[string]model.Game
 	players       map[string]model.Player
+	archives      map[string]model.GameArchive
+	results       map[string]model.PlayerResult
+	ratings       map[string]model.Rating
+	ratingHistory map[string][]model.RatingChange
+	queue         map[string]model.QueueEntry
+}
+
+// newMockDB creates an empty in-memory database
+func newMockDB() *mockDB {
+	return &mockDB{
+		games:         make(map[string]model.Game),
+		gamePasswords: make(map[string]model.Game),
+		players:       make(map[string]model.Player),
+		archives:      make(map[string]model.GameArchive),
+		results:       make(map[string]model.PlayerResult),
+		ratings:       make(map[string]model.Rating),
+		ratingHistory: make(map[string][]model.RatingChange),
+		queue:         make(map[string]model.QueueEntry),
+	}
+}
+
+// NewMockDB creates an empty in-memory database for tests that need one to themselves
+func NewMockDB() UnoDB {
+	return newMockDB()
 }
 
 func (db *mockDB) GetAllGames() (*[]model.Game, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
 	games := make([]model.Game, 0)
 
 	for _, game := range db.games {
@@ -25,52 +54,90 @@ func (db *mockDB) GetAllGames() (*[]model.Game, error) {
 	return &games, nil
 }
 
+// QueryGames returns one page of the games matching the query
+func (db *mockDB) QueryGames(query GameQuery) (*GamePage, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	games := make([]model.Game, 0, len(db.games))
+	for _, game := range db.games {
+		games = append(games, *copyGame(game))
+	}
+
+	return queryGames(games, query), nil
+}
+
 // HasGame checks to see if a game with the given ID exists in the database.
 func (db *mockDB) HasGameByPassword(password string) bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
 	_, ok := db.gamePasswords[password]
 	return ok
 }
 
 // HasGameByID checks to see if a game with the given ID exists in the database.
 func (db *mockDB) HasGameByID(id string) bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
 	_, ok := db.games[id]
 	return ok
 }
 
 // CreateGame a game with the given ID. Perhaps this should instead just return an id?
 func (db *mockDB) CreateGame(gameName string, creatorID string) (*model.Game, error) {
-	player, _ := db.LookupPlayer(creatorID)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	player, err := db.lookupPlayer(creatorID)
+	if err != nil {
+		return nil, err
+	}
+
 	myGame := model.Game{
 		ID:        uuid.New().String(),
-		Password:  "12234",
+		Password:  newGamePassword(),
 		Creator:   *player,
 		Name:      gameName,
 		Status:    model.WaitingForPlayers,
-		Direction: true}
+		Direction: true,
+		CreatedAt: timestamp()}
+	myGame.Players = append(myGame.Players, *player)
+
 	db.games[myGame.ID] = myGame
 	db.gamePasswords[myGame.Password] = myGame
 
-	db.JoinGame(myGame.ID, player.ID)
 	return &myGame, nil
 }
 
 // CreatePlayer creates the player in the database
 func (db *mockDB) CreatePlayer(name string) (*model.Player, error) {
-	player := model.Player{ID: uuid.New().String(), Name: name}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	player := model.Player{ID: uuid.New().String(), Name: name, CreatedAt: timestamp()}
 	db.players[player.ID] = player
 	return &player, nil
 }
 
 // DeleteGame deletes a game
 func (db *mockDB) DeleteGame(id string) error {
-	if _, ok := db.games[id]; ok {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if game, ok := db.games[id]; ok {
 		delete(db.games, id)
+		delete(db.gamePasswords, game.Password)
 	}
 	return nil
 }
 
 // DeletePlayer deletes a player from the database
 func (db *mockDB) DeletePlayer(id string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
 	if _, ok := db.players[id]; ok {
 		delete(db.players, id)
 	}
@@ -79,82 +146,438 @@ func (db *mockDB) DeletePlayer(id string) error {
 
 // LookupGameByID looks up an existing game in the database.
 func (db *mockDB) LookupGameByID(id string) (*model.Game, error) {
-	if id != "" {
-		if game, ok := db.games[id]; ok {
-			return &game, nil
-		}
-	}
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
 
-	return nil, errors.New("mockdb: game not found")
+	return db.lookupGame(id)
 }
 
 // LookupGameByPassword looks up an existing game in the database.
 func (db *mockDB) LookupGameByPassword(password string) (*model.Game, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
 	if game, ok := db.gamePasswords[password]; ok {
-		return &game, nil
+		return copyGame(game), nil
 	}
-	return nil, errors.New("mockdb: game not found")
+	return nil, fmt.Errorf("mockdb: %w", ErrGameNotFound)
 }
 
 // LookupPlayer checks to see if a player is in the database
 func (db *mockDB) LookupPlayer(id string) (*model.Player, error) {
-	if player, ok := db.players[id]; ok {
-		return &player, nil
-	}
-	return nil, errors.New("mockdb: player not found")
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	return db.lookupPlayer(id)
 }
 
 // JoinGame join a player to a game.
 func (db *mockDB) JoinGame(id string, username string) (*model.Game, error) {
-	if game, ok := db.games[id]; ok {
-		if player, err := db.LookupPlayer(username); err != nil {
-			return nil, err
-		} else {
-			game.Players = append(game.Players, *player)
-			return &game, nil
-		}
-	} else {
-		return nil, errors.New("mockdb: game not found")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	game, err := db.lookupGame(id)
+	if err != nil {
+		return nil, err
+	}
+
+	player, err := db.lookupPlayer(username)
+	if err != nil {
+		return nil, err
 	}
+
+	if !hasPlayer(game, player.ID) {
+		game.Players = append(game.Players, *player)
+		db.saveGame(*game)
+	}
+
+	return game, nil
 }
 
 // SaveGame saves the game
 func (db *mockDB) SaveGame(game model.Game) error {
-	db.games[game.ID] = game
-	db.gamePasswords[game.Password] = game
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.games[game.ID]; !ok {
+		return fmt.Errorf("mockdb: %w", ErrGameNotFound)
+	}
+
+	db.saveGame(game)
 	return nil
 }
 
+// UpdateGame changes a game while holding the lock, so no other change can come in between
+func (db *mockDB) UpdateGame(id string, update func(game *model.Game) error) (*model.Game, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	game, err := db.lookupGame(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := update(game); err != nil {
+		return nil, err
+	}
+
+	db.saveGame(*game)
+	return game, nil
+}
+
 // SavePlayer saves the player data
 func (db *mockDB) SavePlayer(player model.Player) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.players[player.ID]; !ok {
+		return fmt.Errorf("mockdb: %w", ErrPlayerNotFound)
+	}
+
 	db.players[player.ID] = player
 	return nil
 }
 
 // SendMessage add a Message to a game chat.
 func (db *mockDB) AddMessage(gameID string, playerID string, message model.Message) (*model.Game, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	game, err := db.lookupGame(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	player, err := db.lookupPlayer(playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	message.Player = *player
+	game.Messages = append(game.Messages, message)
+	db.saveGame(*game)
+
+	return game, nil
+}
+
+// DeleteMessage removes a Message from a game chat.
+func (db *mockDB) DeleteMessage(gameID string, messageID string) (*model.Game, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	game, err := db.lookupGame(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, found := removeMessage(game.Messages, messageID)
+	if !found {
+		return nil, fmt.Errorf("mockdb: %w", ErrMessageNotFound)
+	}
+
+	game.Messages = messages
+	db.saveGame(*game)
+
+	return game, nil
+}
+
+// GetAllPlayers returns all players in the database
+func (db *mockDB) GetAllPlayers() (*[]model.Player, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	players := make([]model.Player, 0)
+
+	for id := range db.players {
+		player, _ := db.lookupPlayer(id)
+		players = append(players, *player)
+	}
+
+	return &players, nil
+}
+
+// ArchiveGame stores the archive and deletes the game it summarizes
+func (db *mockDB) ArchiveGame(archive model.GameArchive) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	game, ok := db.games[archive.ID]
+	if !ok {
+		return fmt.Errorf("mockdb: %w", ErrGameNotFound)
+	}
+
+	archive.Players = append([]model.ArchivedPlayer(nil), archive.Players...)
+	archive.Transcript = append([]byte(nil), archive.Transcript...)
+	db.archives[archive.ID] = archive
+	delete(db.games, game.ID)
+	delete(db.gamePasswords, game.Password)
+
+	return nil
+}
+
+// LookupArchive looks up the archive of a game that has been cleaned up
+func (db *mockDB) LookupArchive(id string) (*model.GameArchive, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	archive, ok := db.archives[id]
+	if !ok {
+		return nil, fmt.Errorf("mockdb: %w", ErrArchiveNotFound)
+	}
+
+	archive.Players = append([]model.ArchivedPlayer(nil), archive.Players...)
+	archive.Transcript = append([]byte(nil), archive.Transcript...)
+	return &archive, nil
+}
+
+// AddGameResults records how each player did in a finished game
+func (db *mockDB) AddGameResults(results []model.PlayerResult) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for _, result := range results {
+		key := result.GameID + "/" + result.PlayerID
+		if _, ok := db.results[key]; !ok {
+			db.results[key] = result
+		}
+	}
+
+	return nil
+}
+
+// GetGameResults returns the results of every game that finished at or after since
+func (db *mockDB) GetGameResults(since time.Time) (*[]model.PlayerResult, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	results := make([]model.PlayerResult, 0)
+	for _, result := range db.results {
+		if result.FinishedAt >= formatTime(since) {
+			results = append(results, result)
+		}
+	}
 
-	if game, ok := db.games[gameID]; ok {
+	return &results, nil
+}
+
+// LookupRating looks up the rating of an account
+func (db *mockDB) LookupRating(account string) (*model.Rating, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	rating, ok := db.ratings[account]
+	if !ok {
+		return nil, fmt.Errorf("mockdb: %w", ErrRatingNotFound)
+	}
+
+	return &rating, nil
+}
 
-		player, err := db.LookupPlayer(playerID)
-		message.Player = *player
+// LookupRatings looks up the ratings of several accounts
+func (db *mockDB) LookupRatings(accounts []string) (map[string]model.Rating, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
 
-		if err != nil {
-			return nil, errors.New("mockdb: player not found")
+	ratings := make(map[string]model.Rating, len(accounts))
+	for _, account := range accounts {
+		if rating, ok := db.ratings[account]; ok {
+			ratings[account] = rating
 		}
+	}
+
+	return ratings, nil
+}
+
+// ApplyRatingChanges moves each account's rating by its change and records the change in its history
+func (db *mockDB) ApplyRatingChanges(changes []model.RatingChange) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for _, change := range changes {
+		if hasRatingChange(db.ratingHistory[change.Account], change.GameID) {
+			continue
+		}
+
+		rating, ok := db.ratings[change.Account]
+		if !ok {
+			rating = model.Rating{Account: change.Account, Rating: model.DefaultRating}
+		}
+		rating.Name = change.Name
+		rating.Rating += change.Delta
+		rating.GamesRated++
+		rating.UpdatedAt = change.At
+		db.ratings[change.Account] = rating
+
+		change.Rating = rating.Rating
+		db.ratingHistory[change.Account] = append(db.ratingHistory[change.Account], change)
+	}
+
+	return nil
+}
+
+// GetRatingHistory returns how an account's rating changed, oldest first
+func (db *mockDB) GetRatingHistory(account string) (*[]model.RatingChange, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	history := append(make([]model.RatingChange, 0), db.ratingHistory[account]...)
+	sortRatingHistory(history)
+	return &history, nil
+}
+
+// EnqueuePlayer adds a player to the matchmaking queue
+func (db *mockDB) EnqueuePlayer(entry model.QueueEntry) (*model.QueueEntry, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	entry = newQueueEntry(entry)
+	db.queue[entry.ID] = entry
+
+	return &entry, nil
+}
+
+// LookupQueueEntry looks up a matchmaking ticket
+func (db *mockDB) LookupQueueEntry(id string) (*model.QueueEntry, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	entry, ok := db.queue[id]
+	if !ok {
+		return nil, fmt.Errorf("mockdb: %w", ErrQueueEntryNotFound)
+	}
+
+	return &entry, nil
+}
+
+// GetQueueEntries returns every entry in the matchmaking queue, oldest first
+func (db *mockDB) GetQueueEntries() (*[]model.QueueEntry, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	entries := make([]model.QueueEntry, 0, len(db.queue))
+	for _, entry := range db.queue {
+		entries = append(entries, entry)
+	}
+
+	sortQueue(entries)
+	return &entries, nil
+}
+
+// UpdateQueueEntry saves the entry if its status is still from
+func (db *mockDB) UpdateQueueEntry(entry model.QueueEntry, from model.QueueStatus) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	stored, ok := db.queue[entry.ID]
+	if !ok {
+		return fmt.Errorf("mockdb: %w", ErrQueueEntryNotFound)
+	}
 
-		fmt.Println("Adding a new Message")
-		game.Messages = append(game.Messages, message)
+	if stored.Status != from {
+		return fmt.Errorf("mockdb: %w", ErrQueueEntryChanged)
+	}
+
+	db.queue[entry.ID] = entry
+	return nil
+}
+
+// DeleteQueueEntry removes an entry from the matchmaking queue
+func (db *mockDB) DeleteQueueEntry(id string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.queue[id]; !ok {
+		return fmt.Errorf("mockdb: %w", ErrQueueEntryNotFound)
+	}
+
+	delete(db.queue, id)
+	return nil
+}
+
+// lookupGame must be called while holding the mutex
+func (db *mockDB) lookupGame(id string) (*model.Game, error) {
+	if game, ok := db.games[id]; ok {
+		return copyGame(game), nil
+	}
+	return nil, fmt.Errorf("mockdb: %w", ErrGameNotFound)
+}
+
+// lookupPlayer must be called while holding the mutex
+func (db *mockDB) lookupPlayer(id string) (*model.Player, error) {
+	if player, ok := db.players[id]; ok {
+		player.Cards = append([]model.Card(nil), player.Cards...)
+		return &player, nil
+	}
+	return nil, fmt.Errorf("mockdb: %w", ErrPlayerNotFound)
+}
+
+// saveGame must be called while holding the write lock
+func (db *mockDB) saveGame(game model.Game) {
+	game = *copyGame(game)
+	db.games[game.ID] = game
+	db.gamePasswords[game.Password] = game
+}
 
-		if err != nil {
-			return nil, errors.New("mockdb: game not Saved")
+// copyGame copies the slices of a game so that callers never share memory with the stored game,
+// just like a game read from a real database.
+func copyGame(game model.Game) *model.Game {
+	game.DrawPile = copyCards(game.DrawPile)
+	game.DiscardPile = copyCards(game.DiscardPile)
+
+	if game.Players != nil {
+		players := make([]model.Player, len(game.Players))
+		for i, player := range game.Players {
+			player.Cards = copyCards(player.Cards)
+			players[i] = player
 		}
+		game.Players = players
+	}
+
+	if game.Messages != nil {
+		game.Messages = append([]model.Message{}, game.Messages...)
+	}
+
+	// Snapshots are never changed once they are taken, so only the lists are copied
+	if game.History != nil {
+		game.History = append([]model.GameSnapshot{}, game.History...)
+	}
+	if game.Redo != nil {
+		game.Redo = append([]model.GameSnapshot{}, game.Redo...)
+	}
+
+	// Neither is changed once it is stored, only added to
+	if game.Opening != nil {
+		opening := *game.Opening
+		game.Opening = &opening
+	}
+	if game.Actions != nil {
+		game.Actions = append([]model.Action{}, game.Actions...)
+	}
+
+	if game.UnclaimedSeats != nil {
+		game.UnclaimedSeats = append([]string{}, game.UnclaimedSeats...)
+	}
+
+	if game.UndoRequest != nil {
+		request := *game.UndoRequest
+		request.Approvals = append([]string{}, request.Approvals...)
+		game.UndoRequest = &request
+	}
 
-		return &game, nil
+	// Decks are never changed once they are dealt, so their card types can be shared
+	if game.Deck != nil {
+		deck := *game.Deck
+		game.Deck = &deck
+	}
+
+	return &game
+}
 
-	} else {
-		return nil, errors.New("mockdb: game not found")
+func copyCards(cards []model.Card) []model.Card {
+	if cards == nil {
+		return nil
 	}
+	return append([]model.Card{}, cards...)
 }
 
 // Disconnect disconnects from the remote database
@@ -163,18 +586,19 @@ func (db *mockDB) disconnect() {
 }
 
 // connect allows the user to connect to the database
-func (db *mockDB) connect() {
-	return
+func (db *mockDB) connect() error {
+	return nil
+}
+
+// Ping always succeeds, the mock database is in memory
+func (db *mockDB) Ping() error {
+	return nil
 }
 
 func init() {
 	registerDB(&DB{
 		name:        "MOCK",
 		description: "Mock database connection for Unit Tests",
-		UnoDB: &mockDB{
-			games:         make(map[string]model.Game),
-			gamePasswords: make(map[string]model.Game),
-			players:       make(map[string]model.Player),
-		},
+		UnoDB:       newMockDB(),
 	})
 }
@@ -4,21 +4,64 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
+	"regexp"
 	"time"
 
 	"github.com/jak103/uno/model"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonoptions"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+	// mongoGameVersion is the field of a game every write to it increments
+	mongoGameVersion = "version"
+	// mongoMessages is the field a game's chat is stored in
+	mongoMessages = "messeges"
+	// mongoMaxRetries is how often UpdateGame starts over when the game changed before it could write it back
+	mongoMaxRetries = 50
+)
+
+// mongoCodecs is how games and players are encoded, both by the client and by UpdateGame
+var mongoCodecs = mongoRegistry()
+
+// Every update below touches a single game or player document, which Mongo applies atomically,
+// so a move never needs a multi-document transaction. Every write to a game also increments its
+// version, and UpdateGame only writes back while the version is still the one it read.
 type mongoDB struct {
 	client   *mongo.Client
 	uri      string
 	database *mongo.Database
 	games    *mongo.Collection
 	players  *mongo.Collection
+	archives *mongo.Collection
+	results  *mongo.Collection
+	ratings  *mongo.Collection
+	history  *mongo.Collection
+	queue    *mongo.Collection
+}
+
+// objectID converts a hex ID from the application into a Mongo ObjectID.
+// An ID that is not valid hex can never match a document, so it is reported as notFoundErr.
+func objectID(id string, notFoundErr error) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return oid, fmt.Errorf("mongodb: %s is not a valid id: %w", id, notFoundErr)
+	}
+	return oid, nil
+}
+
+// notFound turns Mongo's missing document error into the shared not found error
+func notFound(err error, notFoundErr error) error {
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("mongodb: %w", notFoundErr)
+	}
+	return err
 }
 
 func (db *mongoDB) GetAllGames() (*[]model.Game, error) {
@@ -28,19 +71,83 @@ func (db *mongoDB) GetAllGames() (*[]model.Game, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer cursor.Close(context.Background())
 
 	for cursor.Next(context.Background()) {
 		g := model.Game{}
 		err := cursor.Decode(&g)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		games = append(games, g)
 	}
 
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
 	return &games, nil
 }
 
+// QueryGames returns one page of the games matching the query
+func (db *mongoDB) QueryGames(query GameQuery) (*GamePage, error) {
+	query = query.normalize()
+
+	match := bson.M{}
+	if query.Status != "" {
+		match["status"] = query.Status
+	}
+	if query.Search != "" {
+		match["name"] = primitive.Regex{Pattern: regexp.QuoteMeta(query.Search), Options: "i"}
+	}
+
+	total, err := db.games.CountDocuments(context.Background(), match)
+	if err != nil {
+		return nil, err
+	}
+
+	var order bson.D
+	switch query.Sort {
+	case SortOldest:
+		order = bson.D{{Key: "created_at", Value: 1}}
+	case SortName:
+		order = bson.D{{Key: "name", Value: 1}}
+	case SortPlayers:
+		order = bson.D{{Key: "player_count", Value: -1}}
+	default:
+		order = bson.D{{Key: "created_at", Value: -1}}
+	}
+	order = append(order, bson.E{Key: "_id", Value: 1})
+
+	cursor, err := db.games.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: bson.M{"player_count": bson.M{"$size": bson.M{"$ifNull": bson.A{"$players", bson.A{}}}}}}},
+		{{Key: "$sort", Value: order}},
+		{{Key: "$skip", Value: query.offset()}},
+		{{Key: "$limit", Value: query.Limit}},
+		{{Key: "$project", Value: bson.M{"player_count": 0}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	page := &GamePage{Games: make([]model.Game, 0), Total: int(total)}
+	for cursor.Next(context.Background()) {
+		var game model.Game
+		if err := cursor.Decode(&game); err != nil {
+			return nil, err
+		}
+		page.Games = append(page.Games, game)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
 // HasGame checks to see if a game with the given ID exists in the database.
 func (db *mongoDB) HasGameByPassword(password string) bool {
 	game, err := db.LookupGameByPassword(password)
@@ -61,11 +168,12 @@ func (db *mongoDB) CreateGame(gameName string, creatorID string) (*model.Game, e
 	}
 
 	myGame := model.Game{
-		Password:  "12234",
+		Password:  newGamePassword(),
 		Creator:   *player,
 		Name:      gameName,
 		Status:    model.WaitingForPlayers,
-		Direction: true}
+		Direction: true,
+		CreatedAt: timestamp()}
 	myGame.Players = append(myGame.Players, *player)
 
 	res, err := db.games.InsertOne(context.Background(), myGame)
@@ -79,7 +187,7 @@ func (db *mongoDB) CreateGame(gameName string, creatorID string) (*model.Game, e
 
 // CreatePlayer creates the player in the database
 func (db *mongoDB) CreatePlayer(name string) (*model.Player, error) {
-	player := model.Player{Name: name}
+	player := model.Player{Name: name, CreatedAt: timestamp()}
 	res, err := db.players.InsertOne(context.Background(), player)
 	if err != nil {
 		return nil, err
@@ -90,9 +198,9 @@ func (db *mongoDB) CreatePlayer(name string) (*model.Player, error) {
 
 // DeleteGame deletes a game
 func (db *mongoDB) DeleteGame(id string) error {
-	oid, err := primitive.ObjectIDFromHex(id)
+	oid, err := objectID(id, ErrGameNotFound)
 	if err != nil {
-		return err
+		return nil
 	}
 
 	_, err = db.games.DeleteOne(context.Background(), bson.M{"_id": oid})
@@ -105,9 +213,9 @@ func (db *mongoDB) DeleteGame(id string) error {
 
 // DeletePlayer deletes a player from the database
 func (db *mongoDB) DeletePlayer(id string) error {
-	oid, err := primitive.ObjectIDFromHex(id)
+	oid, err := objectID(id, ErrPlayerNotFound)
 	if err != nil {
-		return err
+		return nil
 	}
 
 	_, err = db.players.DeleteOne(context.Background(), bson.M{"_id": oid})
@@ -121,10 +229,13 @@ func (db *mongoDB) DeletePlayer(id string) error {
 // LookupGameByID looks up an existing game in the database.
 func (db *mongoDB) LookupGameByID(id string) (*model.Game, error) {
 	var res model.Game
-	oid, _ := primitive.ObjectIDFromHex(id)
-	if err := db.games.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&res); err != nil {
+	oid, err := objectID(id, ErrGameNotFound)
+	if err != nil {
 		return nil, err
 	}
+	if err := db.games.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&res); err != nil {
+		return nil, notFound(err, ErrGameNotFound)
+	}
 	return &res, nil
 }
 
@@ -133,7 +244,7 @@ func (db *mongoDB) LookupGameByPassword(password string) (*model.Game, error) {
 	var game model.Game
 	err := db.games.FindOne(context.Background(), bson.M{"password": password}).Decode(&game)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, ErrGameNotFound)
 	}
 	return &game, nil
 }
@@ -141,19 +252,33 @@ func (db *mongoDB) LookupGameByPassword(password string) (*model.Game, error) {
 // LookupPlayer checks to see if a player is in the database
 func (db *mongoDB) LookupPlayer(id string) (*model.Player, error) {
 	var res model.Player
-	oid, _ := primitive.ObjectIDFromHex(id)
-	if err := db.players.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&res); err != nil {
+	oid, err := objectID(id, ErrPlayerNotFound)
+	if err != nil {
 		return nil, err
 	}
+	if err := db.players.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&res); err != nil {
+		return nil, notFound(err, ErrPlayerNotFound)
+	}
 	return &res, nil
 }
 
+// updateGame applies an update to a single game and returns the game as it is after the update
+func (db *mongoDB) updateGame(filter bson.M, update bson.M) (*model.Game, error) {
+	update["$inc"] = bson.M{mongoGameVersion: int64(1)}
+
+	var game model.Game
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := db.games.FindOneAndUpdate(context.Background(), filter, update, after).Decode(&game); err != nil {
+		return nil, err
+	}
+	return &game, nil
+}
+
 // JoinGame join a player to a game.
 func (db *mongoDB) JoinGame(id string, username string) (*model.Game, error) {
-	game, gameErr := db.LookupGameByID(id)
-
-	if gameErr != nil {
-		return nil, gameErr
+	oid, err := objectID(id, ErrGameNotFound)
+	if err != nil {
+		return nil, err
 	}
 
 	player, playerErr := db.LookupPlayer(username)
@@ -162,66 +287,569 @@ func (db *mongoDB) JoinGame(id string, username string) (*model.Game, error) {
 		return nil, playerErr
 	}
 
-	game.Players = append(game.Players, *player)
-
-	err := db.SaveGame(*game)
+	// Only push the player if they are not seated yet, so joining twice is harmless
+	game, err := db.updateGame(
+		bson.M{"_id": oid, "players._id": bson.M{"$ne": player.ID}},
+		bson.M{"$push": bson.M{"players": *player}})
 
-	if err != nil {
-		return nil, err
+	if err == mongo.ErrNoDocuments {
+		return db.LookupGameByID(id)
 	}
 
-	return game, nil
+	return game, err
 }
 
-// SaveGame saves the game
+// SaveGame saves the game. Its chat is left alone, messages are only ever added and removed one at a time.
 func (db *mongoDB) SaveGame(game model.Game) error {
-	savedID := game.ID
-	defer func() { game.ID = savedID }()
-	id, _ := primitive.ObjectIDFromHex(game.ID)
-	game.ID = "" // Prevent Mongo from trying to change the ID.
-	_, err := db.games.ReplaceOne(
-		context.Background(),
-		bson.M{"_id": id},
-		game)
+	_, err := db.UpdateGame(game.ID, func(saved *model.Game) error {
+		messages := saved.Messages
+		*saved = game
+		saved.Messages = messages
+		return nil
+	})
+
 	return err
 }
 
+// UpdateGame reads a game, changes it with update and writes back only the fields that changed.
+// The write only matches while the game still has the version it was read at, so when anything
+// else changed the game in between, update runs again on the game as it is now.
+func (db *mongoDB) UpdateGame(id string, update func(game *model.Game) error) (*model.Game, error) {
+	oid, err := objectID(id, ErrGameNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < mongoMaxRetries; i++ {
+		stored, err := db.games.FindOne(context.Background(), bson.M{"_id": oid}).DecodeBytes()
+		if err != nil {
+			return nil, notFound(err, ErrGameNotFound)
+		}
+
+		var game model.Game
+		if err := bson.UnmarshalWithRegistry(mongoCodecs, stored, &game); err != nil {
+			return nil, err
+		}
+
+		before, err := marshalGame(game)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := update(&game); err != nil {
+			return nil, err
+		}
+
+		after, err := marshalGame(game)
+		if err != nil {
+			return nil, err
+		}
+
+		changes, err := gameChanges(before, after)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := db.games.UpdateOne(context.Background(), versionFilter(oid, gameVersion(stored)), changes)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.MatchedCount == 1 {
+			game.ID = id
+			return &game, nil
+		}
+	}
+
+	return nil, fmt.Errorf("mongodb: gave up on game %s after %d conflicting updates", id, mongoMaxRetries)
+}
+
 // SavePlayer saves the player data
 func (db *mongoDB) SavePlayer(player model.Player) error {
-	savedID := player.ID
-	defer func() { player.ID = savedID }()
-	id, _ := primitive.ObjectIDFromHex(player.ID)
+	id, err := objectID(player.ID, ErrPlayerNotFound)
+	if err != nil {
+		return err
+	}
 	player.ID = "" // Prevent mongo from trying to change the ID.
-	_, err := db.players.ReplaceOne(
+
+	res, err := db.players.UpdateOne(
 		context.Background(),
 		bson.M{"_id": id},
-		player)
-	return err
+		bson.M{"$set": player})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("mongodb: %w", ErrPlayerNotFound)
+	}
+
+	return nil
 }
 
 func (db *mongoDB) AddMessage(gameID string, playerID string, message model.Message) (*model.Game, error) {
-	game, err := db.LookupGameByID(gameID)
-
+	oid, err := objectID(gameID, ErrGameNotFound)
 	if err != nil {
 		return nil, err
 	}
 
 	player, err := db.LookupPlayer(playerID)
+
+	if err != nil {
+		return nil, err
+	}
+
 	message.Player = *player
 
+	game, err := db.updateGame(bson.M{"_id": oid}, bson.M{"$push": bson.M{"messeges": message}})
+	return game, notFound(err, ErrGameNotFound)
+}
+
+// DeleteMessage removes a Message from a game chat.
+func (db *mongoDB) DeleteMessage(gameID string, messageID string) (*model.Game, error) {
+	oid, err := objectID(gameID, ErrGameNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	game, err := db.updateGame(
+		bson.M{"_id": oid, "messeges.id": messageID},
+		bson.M{"$pull": bson.M{"messeges": bson.M{"id": messageID}}})
+
+	if err == mongo.ErrNoDocuments {
+		if _, lookupErr := db.LookupGameByID(gameID); lookupErr != nil {
+			return nil, lookupErr
+		}
+		return nil, fmt.Errorf("mongodb: %w", ErrMessageNotFound)
+	}
+
+	return game, err
+}
+
+// GetAllPlayers returns all players in the database
+func (db *mongoDB) GetAllPlayers() (*[]model.Player, error) {
+	players := make([]model.Player, 0)
+
+	cursor, err := db.players.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var player model.Player
+		if err := cursor.Decode(&player); err != nil {
+			return nil, err
+		}
+		players = append(players, player)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return &players, nil
+}
+
+// ArchiveGame stores the archive and deletes the game it summarizes.
+// The archive is upserted under the game's ID, so two replicas archiving the same game store it once.
+func (db *mongoDB) ArchiveGame(archive model.GameArchive) error {
+	oid, err := objectID(archive.ID, ErrGameNotFound)
+	if err != nil {
+		return err
+	}
+	archive.ID = "" // The ID comes from the filter
+
+	if !db.HasGameByID(oid.Hex()) {
+		return fmt.Errorf("mongodb: %w", ErrGameNotFound)
+	}
+
+	_, err = db.archives.ReplaceOne(context.Background(), bson.M{"_id": oid}, archive, options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+
+	res, err := db.games.DeleteOne(context.Background(), bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("mongodb: %w", ErrGameNotFound)
+	}
+
+	return nil
+}
+
+// LookupArchive looks up the archive of a game that has been cleaned up
+func (db *mongoDB) LookupArchive(id string) (*model.GameArchive, error) {
+	var archive model.GameArchive
+	oid, err := objectID(id, ErrArchiveNotFound)
 	if err != nil {
 		return nil, err
 	}
+	if err := db.archives.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&archive); err != nil {
+		return nil, notFound(err, ErrArchiveNotFound)
+	}
+	return &archive, nil
+}
+
+// AddGameResults records how each player did in a finished game.
+// Results are only inserted, so recording the same game twice keeps the first results.
+func (db *mongoDB) AddGameResults(results []model.PlayerResult) error {
+	for _, result := range results {
+		_, err := db.results.UpdateOne(
+			context.Background(),
+			bson.M{"game_id": result.GameID, "player_id": result.PlayerID},
+			bson.M{"$setOnInsert": result},
+			options.Update().SetUpsert(true))
+
+		// Two replicas upserting the same result at once collide on the unique index, which is fine
+		if err != nil && !isDuplicateKey(err) {
+			return err
+		}
+	}
+
+	return nil
+}
 
-	fmt.Println("Adding a new Message")
-	game.Messages = append(game.Messages, message)
-	err = db.SaveGame(*game)
+// GetGameResults returns the results of every game that finished at or after since
+func (db *mongoDB) GetGameResults(since time.Time) (*[]model.PlayerResult, error) {
+	results := make([]model.PlayerResult, 0)
 
+	cursor, err := db.results.Find(context.Background(), bson.M{"finished_at": bson.M{"$gte": formatTime(since)}})
 	if err != nil {
 		return nil, err
 	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var result model.PlayerResult
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
 
-	return game, nil
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// LookupRating looks up the rating of an account
+func (db *mongoDB) LookupRating(account string) (*model.Rating, error) {
+	var rating model.Rating
+	err := db.ratings.FindOne(context.Background(), bson.M{"_id": account}).Decode(&rating)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("mongodb: %w", ErrRatingNotFound)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &rating, nil
+}
+
+// LookupRatings looks up the ratings of several accounts in one query
+func (db *mongoDB) LookupRatings(accounts []string) (map[string]model.Rating, error) {
+	ratings := make(map[string]model.Rating, len(accounts))
+	if len(accounts) == 0 {
+		return ratings, nil
+	}
+
+	cursor, err := db.ratings.Find(context.Background(), bson.M{"_id": bson.M{"$in": accounts}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var rating model.Rating
+		if err := cursor.Decode(&rating); err != nil {
+			return nil, err
+		}
+		ratings[rating.Account] = rating
+	}
+
+	return ratings, cursor.Err()
+}
+
+// ApplyRatingChanges moves each account's rating by its change and records the change in its history.
+// The unique index on the history claims each game for an account before the rating moves,
+// so a change is applied once even when two replicas apply it at the same time.
+func (db *mongoDB) ApplyRatingChanges(changes []model.RatingChange) error {
+	ctx := context.Background()
+
+	for _, change := range changes {
+		_, err := db.history.InsertOne(ctx, change)
+		if isDuplicateKey(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = db.ratings.UpdateOne(ctx,
+			bson.M{"_id": change.Account},
+			bson.M{"$setOnInsert": bson.M{"rating": model.DefaultRating, "games_rated": 0}},
+			options.Update().SetUpsert(true))
+		if err != nil && !isDuplicateKey(err) {
+			return err
+		}
+
+		var rating model.Rating
+		err = db.ratings.FindOneAndUpdate(ctx,
+			bson.M{"_id": change.Account},
+			bson.M{
+				"$inc": bson.M{"rating": change.Delta, "games_rated": 1},
+				"$set": bson.M{"name": change.Name, "updated_at": change.At},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&rating)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.history.UpdateOne(ctx,
+			bson.M{"account": change.Account, "game_id": change.GameID},
+			bson.M{"$set": bson.M{"rating": rating.Rating}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetRatingHistory returns how an account's rating changed, oldest first
+func (db *mongoDB) GetRatingHistory(account string) (*[]model.RatingChange, error) {
+	history := make([]model.RatingChange, 0)
+
+	cursor, err := db.history.Find(context.Background(), bson.M{"account": account},
+		options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "game_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var change model.RatingChange
+		if err := cursor.Decode(&change); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return &history, nil
+}
+
+// EnqueuePlayer adds a player to the matchmaking queue
+func (db *mongoDB) EnqueuePlayer(entry model.QueueEntry) (*model.QueueEntry, error) {
+	entry = newQueueEntry(entry)
+
+	if _, err := db.queue.InsertOne(context.Background(), entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// LookupQueueEntry looks up a matchmaking ticket
+func (db *mongoDB) LookupQueueEntry(id string) (*model.QueueEntry, error) {
+	var entry model.QueueEntry
+	err := db.queue.FindOne(context.Background(), bson.M{"_id": id}).Decode(&entry)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("mongodb: %w", ErrQueueEntryNotFound)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// GetQueueEntries returns every entry in the matchmaking queue, oldest first
+func (db *mongoDB) GetQueueEntries() (*[]model.QueueEntry, error) {
+	entries := make([]model.QueueEntry, 0)
+
+	cursor, err := db.queue.Find(context.Background(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "enqueued_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var entry model.QueueEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return &entries, nil
+}
+
+// UpdateQueueEntry saves the entry if its status is still from
+func (db *mongoDB) UpdateQueueEntry(entry model.QueueEntry, from model.QueueStatus) error {
+	res, err := db.queue.ReplaceOne(context.Background(), bson.M{"_id": entry.ID, "status": from}, entry)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	// Nothing matched, either because the entry is gone or because its status moved on
+	if _, err := db.LookupQueueEntry(entry.ID); err != nil {
+		return err
+	}
+	return fmt.Errorf("mongodb: %w", ErrQueueEntryChanged)
+}
+
+// DeleteQueueEntry removes an entry from the matchmaking queue
+func (db *mongoDB) DeleteQueueEntry(id string) error {
+	res, err := db.queue.DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("mongodb: %w", ErrQueueEntryNotFound)
+	}
+
+	return nil
+}
+
+// marshalGame encodes a game the way it is stored, without its ID
+func marshalGame(game model.Game) (bson.Raw, error) {
+	game.ID = ""
+	return bson.MarshalWithRegistry(mongoCodecs, game)
+}
+
+// gameChanges is the update that turns the stored game before into after. Only the fields that changed are set,
+// and messages added after the last one are pushed, so an update never writes over more of the game than it changed.
+func gameChanges(before bson.Raw, after bson.Raw) (bson.M, error) {
+	set := bson.M{}
+	unset := bson.M{}
+	changes := bson.M{"$inc": bson.M{mongoGameVersion: int64(1)}}
+
+	elements, err := after.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, element := range elements {
+		key, value := element.Key(), element.Value()
+		old, err := before.LookupErr(key)
+		if err == nil && old.Equal(value) {
+			continue
+		}
+
+		if key == mongoMessages && err == nil {
+			if added, ok := appended(old, value); ok {
+				changes["$push"] = bson.M{key: bson.M{"$each": added}}
+				continue
+			}
+		}
+
+		set[key] = value
+	}
+
+	// Empty fields that are left out when they are not set have to be removed
+	elements, err = before.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, element := range elements {
+		if _, err := after.LookupErr(element.Key()); err != nil {
+			unset[element.Key()] = ""
+		}
+	}
+
+	if len(set) > 0 {
+		changes["$set"] = set
+	}
+	if len(unset) > 0 {
+		changes["$unset"] = unset
+	}
+
+	return changes, nil
+}
+
+// appended returns what was added to the end of the array before to make after,
+// and false when after is not before with more added to it
+func appended(before bson.RawValue, after bson.RawValue) ([]bson.RawValue, bool) {
+	if before.Type != bsontype.Array || after.Type != bsontype.Array {
+		return nil, false
+	}
+
+	old, err := before.Array().Values()
+	if err != nil {
+		return nil, false
+	}
+	values, err := after.Array().Values()
+	if err != nil || len(values) <= len(old) {
+		return nil, false
+	}
+
+	for i := range old {
+		if !old[i].Equal(values[i]) {
+			return nil, false
+		}
+	}
+
+	return values[len(old):], true
+}
+
+// gameVersion is how many times a stored game has been written, games stored before versions were kept have none
+func gameVersion(game bson.Raw) int64 {
+	value, err := game.LookupErr(mongoGameVersion)
+	if err != nil {
+		return 0
+	}
+	if version, ok := value.Int64OK(); ok {
+		return version
+	}
+	if version, ok := value.Int32OK(); ok {
+		return int64(version)
+	}
+	return 0
+}
+
+// versionFilter matches a game only while it still has the version it was read at
+func versionFilter(oid primitive.ObjectID, version int64) bson.M {
+	if version == 0 {
+		return bson.M{"_id": oid, mongoGameVersion: bson.M{"$in": bson.A{int64(0), nil}}}
+	}
+	return bson.M{"_id": oid, mongoGameVersion: version}
+}
+
+// isDuplicateKey reports whether a write failed because of a unique index
+func isDuplicateKey(err error) bool {
+	if writeErr, ok := err.(mongo.WriteException); ok {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // disconnect disconnects from the remote database
@@ -234,21 +862,84 @@ func (db *mongoDB) disconnect() {
 	defer cancel()
 }
 
+// mongoRegistry encodes nil slices as empty arrays so that $push always has an array to push onto
+func mongoRegistry() *bsoncodec.Registry {
+	sliceCodec := bsoncodec.NewSliceCodec(bsonoptions.SliceCodec().SetEncodeNilAsEmpty(true))
+	return bson.NewRegistryBuilder().
+		RegisterDefaultEncoder(reflect.Slice, sliceCodec).
+		Build()
+}
+
+// createIndexes makes sure the fields the lobby and the leaderboard query on are indexed
+func (db *mongoDB) createIndexes(ctx context.Context) error {
+	_, err := db.games.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"password": 1}},
+		{Keys: bson.M{"status": 1}},
+		{Keys: bson.M{"created_at": -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.results.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "game_id", Value: 1}, {Key: "player_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"finished_at": 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.history.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "account", Value: 1}, {Key: "game_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
 // connect allows the user to connect to the database
-func (db *mongoDB) connect() {
-	client, err := mongo.NewClient(options.Client().ApplyURI(os.Getenv("MONGO_URI")))
+func (db *mongoDB) connect() error {
+	db.uri = os.Getenv("MONGO_URI")
+	client, err := mongo.NewClient(options.Client().ApplyURI(db.uri).SetRegistry(mongoCodecs))
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	err = client.Connect(ctx)
+	if err = client.Connect(ctx); err != nil {
+		return err
+	}
+
+	// Connect does not wait for the server, so make sure it is there
+	if err = client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return err
+	}
+
+	databaseName := os.Getenv("MONGO_DATABASE")
+	if databaseName == "" {
+		databaseName = "uno"
+	}
+
 	db.client = client
-	database := client.Database("uno")
+	database := client.Database(databaseName)
 	db.database = database
 	db.games = database.Collection("games")
 	db.players = database.Collection("players")
+	db.archives = database.Collection("archives")
+	db.results = database.Collection("results")
+	db.ratings = database.Collection("ratings")
+	db.history = database.Collection("rating_history")
+	db.queue = database.Collection("matchmaking_queue")
+
+	return db.createIndexes(ctx)
+}
+
+// Ping checks the server answers
+func (db *mongoDB) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return db.client.Ping(ctx, nil)
 }
 
 func init() {
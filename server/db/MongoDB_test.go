@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// These tests run against a real mongod, for example:
+//
+//	docker run -d -p 27017:27017 mongo
+//	MONGO_URI=mongodb://localhost:27017 go test ./db/
+//
+// They are skipped when MONGO_URI is not set.
+func setupMongoDB(t *testing.T) *mongoDB {
+	if os.Getenv("MONGO_URI") == "" {
+		t.Skip("MONGO_URI is not set, skipping Mongo integration tests")
+	}
+
+	if os.Getenv("MONGO_DATABASE") == "" {
+		os.Setenv("MONGO_DATABASE", "uno_test")
+		defer os.Unsetenv("MONGO_DATABASE")
+	}
+
+	database := new(mongoDB)
+	if err := database.connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		database.database.Drop(context.Background())
+		database.disconnect()
+	})
+
+	return database
+}
+
+func TestMongoInvalidIDs(t *testing.T) {
+	database := setupMongoDB(t)
+
+	// IDs that are not ObjectIDs can never match a document
+	_, err := database.LookupGameByID("not an object id")
+	assert.True(t, errors.Is(err, ErrGameNotFound), "got %v", err)
+	_, err = database.LookupPlayer("not an object id")
+	assert.True(t, errors.Is(err, ErrPlayerNotFound), "got %v", err)
+	err = database.SaveGame(model.Game{ID: "not an object id"})
+	assert.True(t, errors.Is(err, ErrGameNotFound), "got %v", err)
+
+	// Well formed IDs that are not in the database are not found either
+	err = database.SaveGame(model.Game{ID: "5f0c5b2e8e0b8a1e4c8b4567"})
+	assert.True(t, errors.Is(err, ErrGameNotFound), "got %v", err)
+	_, err = database.JoinGame("5f0c5b2e8e0b8a1e4c8b4567", "5f0c5b2e8e0b8a1e4c8b4567")
+	assert.True(t, errors.Is(err, ErrPlayerNotFound), "got %v", err)
+}
+
+func TestMongoIndexes(t *testing.T) {
+	database := setupMongoDB(t)
+
+	cursor, err := database.games.Indexes().List(context.Background())
+	assert.Nil(t, err)
+
+	var indexes []struct {
+		Key map[string]interface{} `bson:"key"`
+	}
+	assert.Nil(t, cursor.All(context.Background(), &indexes))
+
+	keys := map[string]bool{}
+	for _, index := range indexes {
+		for key := range index.Key {
+			keys[key] = true
+		}
+	}
+	assert.True(t, keys["password"])
+	assert.True(t, keys["status"])
+}
+
+func TestMongoGameChangesOnlyTouchWhatChanged(t *testing.T) {
+	game := model.Game{
+		Name:     "Game 1",
+		Status:   model.Playing,
+		Players:  []model.Player{{ID: "1", Name: "Creator"}},
+		Messages: []model.Message{{ID: "1", Value: "hi"}},
+		History:  []model.GameSnapshot{{Move: "play"}},
+	}
+	before, err := marshalGame(game)
+	assert.Nil(t, err)
+
+	game.CurrentPlayer = 1
+	game.Messages = append(game.Messages, model.Message{ID: "2", Value: "hello"})
+	game.History = nil
+	after, err := marshalGame(game)
+	assert.Nil(t, err)
+
+	changes, err := gameChanges(before, after)
+	assert.Nil(t, err)
+
+	// Players were not touched, so a join at the same time is not written over
+	assert.Equal(t, []string{"current_player"}, keysOf(changes["$set"]))
+	assert.Equal(t, []string{"history"}, keysOf(changes["$unset"]))
+	assert.Equal(t, []string{mongoMessages}, keysOf(changes["$push"]))
+	assert.Equal(t, bson.M{mongoGameVersion: int64(1)}, changes["$inc"])
+
+	added := changes["$push"].(bson.M)[mongoMessages].(bson.M)["$each"].([]bson.RawValue)
+	assert.Equal(t, 1, len(added))
+
+	// Removing a message sets the whole chat, the version filter keeps that from losing a message added meanwhile
+	game.Messages = game.Messages[1:]
+	removed, _ := marshalGame(game)
+	changes, err = gameChanges(after, removed)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{mongoMessages}, keysOf(changes["$set"]))
+	assert.Nil(t, changes["$push"])
+}
+
+func keysOf(fields interface{}) []string {
+	keys := []string{}
+	if fields, ok := fields.(bson.M); ok {
+		for key := range fields {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,761 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+	"github.com/jak103/uno/model"
+)
+
+// Games and players are stored as JSON strings. UpdateGame WATCHes a game's key and writes it back
+// inside MULTI/EXEC, starting over when the key changed in between, so moves made on two replicas at
+// once are applied one after the other. SaveGame writes the game it is given over whatever is stored.
+//
+//	uno:games                  set of every game ID
+//	uno:game:<id>              the game
+//	uno:game-password:<code>   the ID of the game with that password
+//	uno:players                set of every player ID
+//	uno:player:<id>            the player
+//	uno:archive:<id>           the archive of a finished game
+//	uno:results                sorted set of every result, scored by when the game finished
+//	uno:result:<game>:<player> how the player did in the game
+//	uno:rating:<account>       the account's rating
+//	uno:rating-history:<acct>  hash of the account's rating changes by game ID
+//	uno:queue                  set of every matchmaking ticket ID
+//	uno:queue:<id>             the matchmaking ticket
+const (
+	redisGamesKey   = "uno:games"
+	redisPlayersKey = "uno:players"
+	redisResultsKey = "uno:results"
+	redisQueueKey   = "uno:queue"
+
+	// redisMaxRetries is how often an update is retried when another client changed the same key first
+	redisMaxRetries = 50
+)
+
+type redisDB struct {
+	client *redis.Client
+}
+
+func redisGameKey(id string) string {
+	return "uno:game:" + id
+}
+
+func redisPasswordKey(password string) string {
+	return "uno:game-password:" + password
+}
+
+func redisPlayerKey(id string) string {
+	return "uno:player:" + id
+}
+
+func redisArchiveKey(id string) string {
+	return "uno:archive:" + id
+}
+
+func redisResultMember(gameID string, playerID string) string {
+	return gameID + ":" + playerID
+}
+
+func redisResultKey(member string) string {
+	return "uno:result:" + member
+}
+
+func redisRatingKey(account string) string {
+	return "uno:rating:" + account
+}
+
+func redisRatingHistoryKey(account string) string {
+	return "uno:rating-history:" + account
+}
+
+func redisQueueEntryKey(id string) string {
+	return "uno:queue:" + id
+}
+
+// getJSON reads the JSON value stored at key into value.
+// A missing key is reported as notFoundErr.
+func getJSON(client redis.Cmdable, key string, value interface{}, notFoundErr error) error {
+	data, err := client.Get(key).Bytes()
+	if err == redis.Nil {
+		return fmt.Errorf("redisdb: %w", notFoundErr)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// setJSON queues a write of value as JSON at key
+func setJSON(pipe redis.Pipeliner, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	pipe.Set(key, data, 0)
+	return nil
+}
+
+// watch runs fn inside WATCH on keys and retries it when one of the keys changed before EXEC
+func (db *redisDB) watch(fn func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < redisMaxRetries; i++ {
+		err := db.client.Watch(fn, keys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("redisdb: gave up on %v after %d conflicting updates", keys, redisMaxRetries)
+}
+
+func (db *redisDB) GetAllGames() (*[]model.Game, error) {
+	games := make([]model.Game, 0)
+
+	ids, err := db.client.SMembers(redisGamesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return &games, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisGameKey(id)
+	}
+
+	values, err := db.client.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		// The game was deleted between SMEMBERS and MGET
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var game model.Game
+		if err := json.Unmarshal([]byte(data), &game); err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+
+	return &games, nil
+}
+
+// QueryGames returns one page of the games matching the query.
+// Redis has no secondary indexes, so the games are filtered and sorted here;
+// the janitor keeps the number of games small enough for that.
+func (db *redisDB) QueryGames(query GameQuery) (*GamePage, error) {
+	games, err := db.GetAllGames()
+	if err != nil {
+		return nil, err
+	}
+
+	return queryGames(*games, query), nil
+}
+
+// HasGame checks to see if a game with the given ID exists in the database.
+func (db *redisDB) HasGameByPassword(password string) bool {
+	game, err := db.LookupGameByPassword(password)
+	return err == nil && game != nil
+}
+
+// HasGameByID checks to see if a game with the given ID exists in the database.
+func (db *redisDB) HasGameByID(id string) bool {
+	count, err := db.client.Exists(redisGameKey(id)).Result()
+	return err == nil && count > 0
+}
+
+// CreateGame a game with the given ID. Perhaps this should instead just return an id?
+func (db *redisDB) CreateGame(gameName string, creatorID string) (*model.Game, error) {
+	player, err := db.LookupPlayer(creatorID)
+	if err != nil {
+		return nil, err
+	}
+
+	myGame := model.Game{
+		ID:        uuid.New().String(),
+		Password:  newGamePassword(),
+		Creator:   *player,
+		Name:      gameName,
+		Status:    model.WaitingForPlayers,
+		Direction: true,
+		CreatedAt: timestamp()}
+	myGame.Players = append(myGame.Players, *player)
+
+	_, err = db.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		if err := setJSON(pipe, redisGameKey(myGame.ID), myGame); err != nil {
+			return err
+		}
+		pipe.Set(redisPasswordKey(myGame.Password), myGame.ID, 0)
+		pipe.SAdd(redisGamesKey, myGame.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &myGame, nil
+}
+
+// CreatePlayer creates the player in the database
+func (db *redisDB) CreatePlayer(name string) (*model.Player, error) {
+	player := model.Player{ID: uuid.New().String(), Name: name, CreatedAt: timestamp()}
+
+	_, err := db.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		if err := setJSON(pipe, redisPlayerKey(player.ID), player); err != nil {
+			return err
+		}
+		pipe.SAdd(redisPlayersKey, player.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &player, nil
+}
+
+// DeleteGame deletes a game
+func (db *redisDB) DeleteGame(id string) error {
+	key := redisGameKey(id)
+
+	return db.watch(func(tx *redis.Tx) error {
+		var game model.Game
+		err := getJSON(tx, key, &game, ErrGameNotFound)
+		if err != nil {
+			if errors.Is(err, ErrGameNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.Del(key, redisPasswordKey(game.Password))
+			pipe.SRem(redisGamesKey, id)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// DeletePlayer deletes a player from the database
+func (db *redisDB) DeletePlayer(id string) error {
+	_, err := db.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(redisPlayerKey(id))
+		pipe.SRem(redisPlayersKey, id)
+		return nil
+	})
+	return err
+}
+
+// LookupGameByID looks up an existing game in the database.
+func (db *redisDB) LookupGameByID(id string) (*model.Game, error) {
+	var game model.Game
+	if err := getJSON(db.client, redisGameKey(id), &game, ErrGameNotFound); err != nil {
+		return nil, err
+	}
+	return &game, nil
+}
+
+// LookupGameByPassword looks up an existing game in the database.
+func (db *redisDB) LookupGameByPassword(password string) (*model.Game, error) {
+	id, err := db.client.Get(redisPasswordKey(password)).Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("redisdb: %w", ErrGameNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return db.LookupGameByID(id)
+}
+
+// LookupPlayer checks to see if a player is in the database
+func (db *redisDB) LookupPlayer(id string) (*model.Player, error) {
+	var player model.Player
+	if err := getJSON(db.client, redisPlayerKey(id), &player, ErrPlayerNotFound); err != nil {
+		return nil, err
+	}
+	return &player, nil
+}
+
+// UpdateGame reads a game, changes it with update and writes it back with WATCH and MULTI/EXEC,
+// so concurrent updates to the same game are never lost.
+func (db *redisDB) UpdateGame(id string, update func(game *model.Game) error) (*model.Game, error) {
+	var game model.Game
+	key := redisGameKey(id)
+
+	err := db.watch(func(tx *redis.Tx) error {
+		game = model.Game{}
+		if err := getJSON(tx, key, &game, ErrGameNotFound); err != nil {
+			return err
+		}
+
+		if err := update(&game); err != nil {
+			return err
+		}
+
+		_, err := tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			if err := setJSON(pipe, key, game); err != nil {
+				return err
+			}
+			pipe.Set(redisPasswordKey(game.Password), game.ID, 0)
+			return nil
+		})
+		return err
+	}, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &game, nil
+}
+
+// JoinGame join a player to a game.
+func (db *redisDB) JoinGame(id string, username string) (*model.Game, error) {
+	player, playerErr := db.LookupPlayer(username)
+
+	if playerErr != nil {
+		return nil, playerErr
+	}
+
+	return db.UpdateGame(id, func(game *model.Game) error {
+		if !hasPlayer(game, player.ID) {
+			game.Players = append(game.Players, *player)
+		}
+		return nil
+	})
+}
+
+// SaveGame saves the game
+func (db *redisDB) SaveGame(game model.Game) error {
+	_, err := db.UpdateGame(game.ID, func(saved *model.Game) error {
+		*saved = game
+		return nil
+	})
+
+	return err
+}
+
+// SavePlayer saves the player data
+func (db *redisDB) SavePlayer(player model.Player) error {
+	key := redisPlayerKey(player.ID)
+
+	return db.watch(func(tx *redis.Tx) error {
+		count, err := tx.Exists(key).Result()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("redisdb: %w", ErrPlayerNotFound)
+		}
+
+		_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			return setJSON(pipe, key, player)
+		})
+		return err
+	}, key)
+}
+
+// SendMessage add a Message to a game chat.
+func (db *redisDB) AddMessage(gameID string, playerID string, message model.Message) (*model.Game, error) {
+	player, err := db.LookupPlayer(playerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	message.Player = *player
+
+	return db.UpdateGame(gameID, func(game *model.Game) error {
+		game.Messages = append(game.Messages, message)
+		return nil
+	})
+}
+
+// DeleteMessage removes a Message from a game chat.
+func (db *redisDB) DeleteMessage(gameID string, messageID string) (*model.Game, error) {
+	return db.UpdateGame(gameID, func(game *model.Game) error {
+		messages, found := removeMessage(game.Messages, messageID)
+		if !found {
+			return fmt.Errorf("redisdb: %w", ErrMessageNotFound)
+		}
+
+		game.Messages = messages
+		return nil
+	})
+}
+
+// GetAllPlayers returns all players in the database
+func (db *redisDB) GetAllPlayers() (*[]model.Player, error) {
+	players := make([]model.Player, 0)
+
+	ids, err := db.client.SMembers(redisPlayersKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		player, err := db.LookupPlayer(id)
+		if errors.Is(err, ErrPlayerNotFound) {
+			// The player was deleted after SMEMBERS
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		players = append(players, *player)
+	}
+
+	return &players, nil
+}
+
+// ArchiveGame stores the archive and deletes the game it summarizes in one MULTI/EXEC
+func (db *redisDB) ArchiveGame(archive model.GameArchive) error {
+	key := redisGameKey(archive.ID)
+
+	return db.watch(func(tx *redis.Tx) error {
+		var game model.Game
+		if err := getJSON(tx, key, &game, ErrGameNotFound); err != nil {
+			return err
+		}
+
+		_, err := tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			if err := setJSON(pipe, redisArchiveKey(archive.ID), archive); err != nil {
+				return err
+			}
+			pipe.Del(key, redisPasswordKey(game.Password))
+			pipe.SRem(redisGamesKey, game.ID)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// LookupArchive looks up the archive of a game that has been cleaned up
+func (db *redisDB) LookupArchive(id string) (*model.GameArchive, error) {
+	var archive model.GameArchive
+	if err := getJSON(db.client, redisArchiveKey(id), &archive, ErrArchiveNotFound); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// AddGameResults records how each player did in a finished game.
+// SETNX and ZADD NX keep the results already recorded for the same game and player.
+func (db *redisDB) AddGameResults(results []model.PlayerResult) error {
+	_, err := db.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		for _, result := range results {
+			finishedAt, err := time.Parse(time.RFC3339, result.FinishedAt)
+			if err != nil {
+				return fmt.Errorf("redisdb: result of %s finished at %q: %w", result.PlayerID, result.FinishedAt, err)
+			}
+
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+
+			member := redisResultMember(result.GameID, result.PlayerID)
+			pipe.SetNX(redisResultKey(member), data, 0)
+			pipe.ZAddNX(redisResultsKey, &redis.Z{Score: float64(finishedAt.Unix()), Member: member})
+		}
+		return nil
+	})
+	return err
+}
+
+// GetGameResults returns the results of every game that finished at or after since
+func (db *redisDB) GetGameResults(since time.Time) (*[]model.PlayerResult, error) {
+	results := make([]model.PlayerResult, 0)
+
+	members, err := db.client.ZRangeByScore(redisResultsKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) == 0 {
+		return &results, nil
+	}
+
+	keys := make([]string, len(members))
+	for i, member := range members {
+		keys[i] = redisResultKey(member)
+	}
+
+	values, err := db.client.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var result model.PlayerResult
+		if err := json.Unmarshal([]byte(data), &result); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return &results, nil
+}
+
+// LookupRating looks up the rating of an account
+func (db *redisDB) LookupRating(account string) (*model.Rating, error) {
+	var rating model.Rating
+	if err := getJSON(db.client, redisRatingKey(account), &rating, ErrRatingNotFound); err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+// LookupRatings looks up the ratings of several accounts with one MGET
+func (db *redisDB) LookupRatings(accounts []string) (map[string]model.Rating, error) {
+	ratings := make(map[string]model.Rating, len(accounts))
+	if len(accounts) == 0 {
+		return ratings, nil
+	}
+
+	keys := make([]string, len(accounts))
+	for i, account := range accounts {
+		keys[i] = redisRatingKey(account)
+	}
+
+	values, err := db.client.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys that are not set come back as nil
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var rating model.Rating
+		if err := json.Unmarshal([]byte(data), &rating); err != nil {
+			return nil, err
+		}
+		ratings[accounts[i]] = rating
+	}
+
+	return ratings, nil
+}
+
+// ApplyRatingChanges moves each account's rating by its change and records the change in its history.
+// Each account is updated in its own WATCH, so concurrent games finishing never lose an update.
+func (db *redisDB) ApplyRatingChanges(changes []model.RatingChange) error {
+	for _, change := range changes {
+		change := change
+		ratingKey := redisRatingKey(change.Account)
+		historyKey := redisRatingHistoryKey(change.Account)
+
+		err := db.watch(func(tx *redis.Tx) error {
+			applied, err := tx.HExists(historyKey, change.GameID).Result()
+			if err != nil || applied {
+				return err
+			}
+
+			rating := model.Rating{Account: change.Account, Rating: model.DefaultRating}
+			err = getJSON(tx, ratingKey, &rating, ErrRatingNotFound)
+			if err != nil && !errors.Is(err, ErrRatingNotFound) {
+				return err
+			}
+
+			rating.Name = change.Name
+			rating.Rating += change.Delta
+			rating.GamesRated++
+			rating.UpdatedAt = change.At
+			change.Rating = rating.Rating
+
+			data, err := json.Marshal(change)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+				pipe.HSet(historyKey, change.GameID, data)
+				return setJSON(pipe, ratingKey, rating)
+			})
+			return err
+		}, ratingKey, historyKey)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetRatingHistory returns how an account's rating changed, oldest first
+func (db *redisDB) GetRatingHistory(account string) (*[]model.RatingChange, error) {
+	history := make([]model.RatingChange, 0)
+
+	values, err := db.client.HVals(redisRatingHistoryKey(account)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		var change model.RatingChange
+		if err := json.Unmarshal([]byte(value), &change); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	sortRatingHistory(history)
+	return &history, nil
+}
+
+// EnqueuePlayer adds a player to the matchmaking queue
+func (db *redisDB) EnqueuePlayer(entry model.QueueEntry) (*model.QueueEntry, error) {
+	entry = newQueueEntry(entry)
+
+	_, err := db.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		if err := setJSON(pipe, redisQueueEntryKey(entry.ID), entry); err != nil {
+			return err
+		}
+		pipe.SAdd(redisQueueKey, entry.ID)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// LookupQueueEntry looks up a matchmaking ticket
+func (db *redisDB) LookupQueueEntry(id string) (*model.QueueEntry, error) {
+	var entry model.QueueEntry
+	if err := getJSON(db.client, redisQueueEntryKey(id), &entry, ErrQueueEntryNotFound); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// GetQueueEntries returns every entry in the matchmaking queue, oldest first
+func (db *redisDB) GetQueueEntries() (*[]model.QueueEntry, error) {
+	entries := make([]model.QueueEntry, 0)
+
+	ids, err := db.client.SMembers(redisQueueKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		entry, err := db.LookupQueueEntry(id)
+		if errors.Is(err, ErrQueueEntryNotFound) {
+			// The entry was deleted after SMEMBERS
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	sortQueue(entries)
+	return &entries, nil
+}
+
+// UpdateQueueEntry saves the entry if its status is still from
+func (db *redisDB) UpdateQueueEntry(entry model.QueueEntry, from model.QueueStatus) error {
+	key := redisQueueEntryKey(entry.ID)
+
+	return db.watch(func(tx *redis.Tx) error {
+		var stored model.QueueEntry
+		if err := getJSON(tx, key, &stored, ErrQueueEntryNotFound); err != nil {
+			return err
+		}
+
+		if stored.Status != from {
+			return fmt.Errorf("redisdb: %w", ErrQueueEntryChanged)
+		}
+
+		_, err := tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			return setJSON(pipe, key, entry)
+		})
+		return err
+	}, key)
+}
+
+// DeleteQueueEntry removes an entry from the matchmaking queue
+func (db *redisDB) DeleteQueueEntry(id string) error {
+	var deleted *redis.IntCmd
+	_, err := db.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(redisQueueEntryKey(id))
+		pipe.SRem(redisQueueKey, id)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if deleted.Val() == 0 {
+		return fmt.Errorf("redisdb: %w", ErrQueueEntryNotFound)
+	}
+
+	return nil
+}
+
+// disconnect disconnects from the remote database
+func (db *redisDB) disconnect() {
+	if db.client != nil {
+		db.client.Close()
+	}
+}
+
+// connect allows the user to connect to the database.
+// REDIS_URL looks like redis://:password@localhost:6379/0
+func (db *redisDB) connect() error {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		url = "redis://localhost:6379/0"
+	}
+
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return err
+	}
+
+	db.client = redis.NewClient(options)
+	return db.Ping()
+}
+
+// Ping checks the server answers
+func (db *redisDB) Ping() error {
+	return db.client.Ping().Err()
+}
+
+func init() {
+	registerDB(&DB{
+		name:        "REDIS",
+		description: "Redis database shared by several server replicas",
+		UnoDB:       new(redisDB),
+	})
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupRedisDB connects to the redis-server at REDIS_TEST_URL, which is wiped,
+// or to an in-process miniredis when it is not set
+func setupRedisDB(t *testing.T) *redisDB {
+	url := os.Getenv("REDIS_TEST_URL")
+	if url == "" {
+		server, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(server.Close)
+		url = "redis://" + server.Addr()
+	}
+
+	os.Setenv("REDIS_URL", url)
+	defer os.Unsetenv("REDIS_URL")
+
+	database := new(redisDB)
+	if err := database.connect(); err != nil {
+		t.Fatal(err)
+	}
+	database.client.FlushDB()
+
+	t.Cleanup(func() {
+		database.client.FlushDB()
+		database.disconnect()
+	})
+
+	return database
+}
+
+func TestRedisDeleteGameRemovesIndexes(t *testing.T) {
+	database := setupRedisDB(t)
+
+	creator, _ := database.CreatePlayer("Creator")
+	game, err := database.CreateGame("Game 1", creator.ID)
+	assert.Nil(t, err)
+
+	assert.Nil(t, database.DeleteGame(game.ID))
+
+	_, err = database.client.Get(redisPasswordKey(game.Password)).Result()
+	assert.Equal(t, redis.Nil, err)
+
+	isMember, err := database.client.SIsMember(redisGamesKey, game.ID).Result()
+	assert.Nil(t, err)
+	assert.False(t, isMember)
+}
+
+func TestRedisUpdateRetriesAfterConflict(t *testing.T) {
+	database := setupRedisDB(t)
+
+	creator, _ := database.CreatePlayer("Creator")
+	joiner, _ := database.CreatePlayer("Joiner")
+	game, _ := database.CreateGame("Game 1", creator.ID)
+
+	// Another replica joins a player after this update read the game but before it wrote it back.
+	// EXEC must fail and the update must run again on top of the join.
+	attempts := 0
+	updated, err := database.UpdateGame(game.ID, func(g *model.Game) error {
+		attempts++
+		if attempts == 1 {
+			other := &redisDB{client: redis.NewClient(database.client.Options())}
+			defer other.disconnect()
+			if _, err := other.JoinGame(game.ID, joiner.ID); err != nil {
+				return err
+			}
+		}
+		g.Name = "Renamed"
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, "Renamed", updated.Name)
+	assert.Equal(t, 2, len(updated.Players))
+}
@@ -0,0 +1,1315 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jak103/uno/model"
+
+	// Drivers for the relational backends
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Names of the rows in the piles table
+const (
+	drawPile    = "draw"
+	discardPile = "discard"
+)
+
+var (
+	errSQLGameNotFound    = fmt.Errorf("sqldb: %w", ErrGameNotFound)
+	errSQLPlayerNotFound  = fmt.Errorf("sqldb: %w", ErrPlayerNotFound)
+	errSQLMessageNotFound = fmt.Errorf("sqldb: %w", ErrMessageNotFound)
+	errSQLArchiveNotFound = fmt.Errorf("sqldb: %w", ErrArchiveNotFound)
+	errSQLRatingNotFound  = fmt.Errorf("sqldb: %w", ErrRatingNotFound)
+	errSQLQueueNotFound   = fmt.Errorf("sqldb: %w", ErrQueueEntryNotFound)
+)
+
+// sqlDB stores games in a normalized relational schema. Every change to a game is made in a
+// single transaction so a move is never half saved.
+type sqlDB struct {
+	driver     string
+	dsnEnv     string
+	defaultDSN string
+	conn       *sql.DB
+}
+
+// sqlQueryer is satisfied by both *sql.DB and *sql.Tx
+type sqlQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// rebind rewrites ? placeholders into the $1, $2... form Postgres expects
+func (db *sqlDB) rebind(query string) string {
+	if db.driver != "postgres" {
+		return query
+	}
+
+	var rebound strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+		} else {
+			rebound.WriteRune(r)
+		}
+	}
+	return rebound.String()
+}
+
+func (db *sqlDB) exec(q sqlQueryer, query string, args ...interface{}) (sql.Result, error) {
+	return q.Exec(db.rebind(query), args...)
+}
+
+func (db *sqlDB) query(q sqlQueryer, query string, args ...interface{}) (*sql.Rows, error) {
+	return q.Query(db.rebind(query), args...)
+}
+
+func (db *sqlDB) queryRow(q sqlQueryer, query string, args ...interface{}) *sql.Row {
+	return q.QueryRow(db.rebind(query), args...)
+}
+
+// inTransaction runs fn in a transaction, committing if it succeeds and rolling back if it fails
+func (db *sqlDB) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *sqlDB) GetAllGames() (*[]model.Game, error) {
+	games := make([]model.Game, 0)
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		ids, err := db.queryIDs(tx, `SELECT id FROM games ORDER BY id`)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			game, err := db.loadGame(tx, id)
+			if err != nil {
+				return err
+			}
+			games = append(games, *game)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &games, nil
+}
+
+// QueryGames returns one page of the games matching the query
+func (db *sqlDB) QueryGames(query GameQuery) (*GamePage, error) {
+	query = query.normalize()
+
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, string(query.Status))
+	}
+	if query.Search != "" {
+		conditions = append(conditions, `LOWER(name) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(strings.ToLower(query.Search))+"%")
+	}
+	where := ` WHERE ` + strings.Join(conditions, " AND ")
+
+	var order string
+	switch query.Sort {
+	case SortOldest:
+		order = `created_at, id`
+	case SortName:
+		order = `name, id`
+	case SortPlayers:
+		order = `(SELECT COUNT(*) FROM game_players gp WHERE gp.game_id = games.id) DESC, id`
+	default:
+		order = `created_at DESC, id`
+	}
+
+	page := &GamePage{Games: make([]model.Game, 0)}
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		if err := db.queryRow(tx, `SELECT COUNT(*) FROM games`+where, args...).Scan(&page.Total); err != nil {
+			return err
+		}
+
+		ids, err := db.queryIDs(tx, `SELECT id FROM games`+where+` ORDER BY `+order+` LIMIT ? OFFSET ?`,
+			append(args, query.Limit, query.offset())...)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			game, err := db.loadGame(tx, id)
+			if err != nil {
+				return err
+			}
+			page.Games = append(page.Games, *game)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// escapeLike stops % and _ in a search from acting as LIKE wildcards
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// HasGame checks to see if a game with the given ID exists in the database.
+func (db *sqlDB) HasGameByPassword(password string) bool {
+	game, err := db.LookupGameByPassword(password)
+	return err == nil && game != nil
+}
+
+// HasGameByID checks to see if a game with the given ID exists in the database.
+func (db *sqlDB) HasGameByID(id string) bool {
+	game, err := db.LookupGameByID(id)
+	return err == nil && game != nil
+}
+
+// CreateGame a game with the given ID. Perhaps this should instead just return an id?
+func (db *sqlDB) CreateGame(gameName string, creatorID string) (*model.Game, error) {
+	var game *model.Game
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		player, err := db.lookupPlayer(tx, creatorID)
+		if err != nil {
+			return err
+		}
+
+		myGame := model.Game{
+			ID:        uuid.New().String(),
+			Password:  newGamePassword(),
+			Creator:   *player,
+			Name:      gameName,
+			Status:    model.WaitingForPlayers,
+			Direction: true,
+			CreatedAt: timestamp()}
+		myGame.Players = append(myGame.Players, *player)
+
+		_, err = db.exec(tx,
+			`INSERT INTO games (id, name, password, creator_id, current_player, status, direction, winner, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			myGame.ID, myGame.Name, myGame.Password, myGame.Creator.ID, myGame.CurrentPlayer, string(myGame.Status), myGame.Direction, myGame.GameOver, myGame.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		if err = db.insertGameChildren(tx, myGame); err != nil {
+			return err
+		}
+
+		game = &myGame
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return game, nil
+}
+
+// CreatePlayer creates the player in the database
+func (db *sqlDB) CreatePlayer(name string) (*model.Player, error) {
+	player := model.Player{ID: uuid.New().String(), Name: name, CreatedAt: timestamp()}
+
+	_, err := db.exec(db.conn,
+		`INSERT INTO players (id, name, last_updated, is_active, protection, created_at, account) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		player.ID, player.Name, player.LastUpdated, player.IsActive, player.Protection, player.CreatedAt, player.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &player, nil
+}
+
+// DeleteGame deletes a game
+func (db *sqlDB) DeleteGame(id string) error {
+	return db.inTransaction(func(tx *sql.Tx) error {
+		if err := db.deleteGameChildren(tx, id); err != nil {
+			return err
+		}
+		_, err := db.exec(tx, `DELETE FROM games WHERE id = ?`, id)
+		return err
+	})
+}
+
+// DeletePlayer deletes a player from the database
+func (db *sqlDB) DeletePlayer(id string) error {
+	_, err := db.exec(db.conn, `DELETE FROM players WHERE id = ?`, id)
+	return err
+}
+
+// LookupGameByID looks up an existing game in the database.
+func (db *sqlDB) LookupGameByID(id string) (*model.Game, error) {
+	var game *model.Game
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		var err error
+		game, err = db.loadGame(tx, id)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return game, nil
+}
+
+// LookupGameByPassword looks up an existing game in the database.
+func (db *sqlDB) LookupGameByPassword(password string) (*model.Game, error) {
+	var game *model.Game
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		ids, err := db.queryIDs(tx, `SELECT id FROM games WHERE password = ? ORDER BY id`, password)
+		if err != nil {
+			return err
+		}
+
+		if len(ids) == 0 {
+			return errSQLGameNotFound
+		}
+
+		game, err = db.loadGame(tx, ids[0])
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return game, nil
+}
+
+// LookupPlayer checks to see if a player is in the database
+func (db *sqlDB) LookupPlayer(id string) (*model.Player, error) {
+	return db.lookupPlayer(db.conn, id)
+}
+
+// JoinGame join a player to a game.
+func (db *sqlDB) JoinGame(id string, username string) (*model.Game, error) {
+	var game *model.Game
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		if err := db.lockGame(tx, id); err != nil {
+			return err
+		}
+
+		player, err := db.lookupPlayer(tx, username)
+		if err != nil {
+			return err
+		}
+
+		var seated, seats int
+		err = db.queryRow(tx,
+			`SELECT COUNT(CASE WHEN player_id = ? THEN 1 END), COUNT(*) FROM game_players WHERE game_id = ?`, player.ID, id).
+			Scan(&seated, &seats)
+		if err != nil {
+			return err
+		}
+
+		// Joining a game the player is already in leaves the game as it is
+		if seated == 0 {
+			if err = db.insertGamePlayer(tx, id, seats, *player); err != nil {
+				return err
+			}
+		}
+
+		game, err = db.loadGame(tx, id)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return game, nil
+}
+
+// SaveGame saves the game
+func (db *sqlDB) SaveGame(game model.Game) error {
+	return db.inTransaction(func(tx *sql.Tx) error {
+		if err := db.lockGame(tx, game.ID); err != nil {
+			return err
+		}
+		return db.writeGame(tx, game)
+	})
+}
+
+// UpdateGame reads a game, changes it with update and writes it back in one transaction. The game is locked
+// while it is read, so any other change to it waits until this one is written.
+func (db *sqlDB) UpdateGame(id string, update func(game *model.Game) error) (*model.Game, error) {
+	var game *model.Game
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		if err := db.lockGame(tx, id); err != nil {
+			return err
+		}
+
+		var err error
+		if game, err = db.loadGame(tx, id); err != nil {
+			return err
+		}
+
+		if err = update(game); err != nil {
+			return err
+		}
+
+		return db.writeGame(tx, *game)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return game, nil
+}
+
+// writeGame writes a game and everything that belongs to it over what is stored
+func (db *sqlDB) writeGame(tx *sql.Tx, game model.Game) error {
+	rules, err := json.Marshal(game.Rules)
+	if err != nil {
+		return err
+	}
+
+	// Games that have not been dealt have no deck
+	deck := ""
+	if game.Deck != nil {
+		encoded, err := json.Marshal(game.Deck)
+		if err != nil {
+			return err
+		}
+		deck = string(encoded)
+	}
+
+	// Games no move has been made in have no history, and most have nothing to redo or agree to
+	history, err := encodeIfSet(len(game.History) > 0, game.History)
+	if err != nil {
+		return err
+	}
+	redo, err := encodeIfSet(len(game.Redo) > 0, game.Redo)
+	if err != nil {
+		return err
+	}
+	undoRequest, err := encodeIfSet(game.UndoRequest != nil, game.UndoRequest)
+	if err != nil {
+		return err
+	}
+	opening, err := encodeIfSet(game.Opening != nil, game.Opening)
+	if err != nil {
+		return err
+	}
+	actions, err := encodeIfSet(len(game.Actions) > 0, game.Actions)
+	if err != nil {
+		return err
+	}
+	unclaimedSeats, err := encodeIfSet(len(game.UnclaimedSeats) > 0, game.UnclaimedSeats)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.exec(tx,
+		`UPDATE games SET name = ?, password = ?, creator_id = ?, current_player = ?, status = ?, direction = ?, winner = ?, created_at = ?, rules = ?,
+		deck = ?, dark_side = ?, history = ?, redo = ?, undo_request = ?, opening = ?, actions = ?, unclaimed_seats = ? WHERE id = ?`,
+		game.Name, game.Password, game.Creator.ID, game.CurrentPlayer, string(game.Status), game.Direction, game.GameOver, game.CreatedAt, string(rules),
+		deck, game.DarkSide, history, redo, undoRequest, opening, actions, unclaimedSeats, game.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = db.deleteGameChildren(tx, game.ID); err != nil {
+		return err
+	}
+
+	return db.insertGameChildren(tx, game)
+}
+
+// encodeIfSet is value as JSON, or empty when it is not set
+func encodeIfSet(set bool, value interface{}) (string, error) {
+	if !set {
+		return "", nil
+	}
+
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// SavePlayer saves the player data.
+// A player's cards are only stored as part of the games they are seated in.
+func (db *sqlDB) SavePlayer(player model.Player) error {
+	res, err := db.exec(db.conn,
+		`UPDATE players SET name = ?, last_updated = ?, is_active = ?, protection = ?, created_at = ?, account = ? WHERE id = ?`,
+		player.Name, player.LastUpdated, player.IsActive, player.Protection, player.CreatedAt, player.Account, player.ID)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := res.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return errSQLPlayerNotFound
+	}
+
+	return nil
+}
+
+// AddMessage add a Message to a game chat.
+func (db *sqlDB) AddMessage(gameID string, playerID string, message model.Message) (*model.Game, error) {
+	var game *model.Game
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		if err := db.lockGame(tx, gameID); err != nil {
+			return err
+		}
+
+		player, err := db.lookupPlayer(tx, playerID)
+		if err != nil {
+			return err
+		}
+		message.Player = *player
+
+		var ordinal int
+		err = db.queryRow(tx, `SELECT COALESCE(MAX(ordinal) + 1, 0) FROM messages WHERE game_id = ?`, gameID).Scan(&ordinal)
+		if err != nil {
+			return err
+		}
+
+		if err = db.insertMessage(tx, gameID, ordinal, message); err != nil {
+			return err
+		}
+
+		game, err = db.loadGame(tx, gameID)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return game, nil
+}
+
+// DeleteMessage removes a Message from a game chat.
+func (db *sqlDB) DeleteMessage(gameID string, messageID string) (*model.Game, error) {
+	var game *model.Game
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		if err := db.lockGame(tx, gameID); err != nil {
+			return err
+		}
+
+		res, err := db.exec(tx, `DELETE FROM messages WHERE game_id = ? AND id = ?`, gameID, messageID)
+		if err != nil {
+			return err
+		}
+
+		if deleted, err := res.RowsAffected(); err != nil {
+			return err
+		} else if deleted == 0 {
+			return errSQLMessageNotFound
+		}
+
+		game, err = db.loadGame(tx, gameID)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return game, nil
+}
+
+// GetAllPlayers returns all players in the database
+func (db *sqlDB) GetAllPlayers() (*[]model.Player, error) {
+	players := make([]model.Player, 0)
+
+	rows, err := db.query(db.conn, `SELECT id, name, last_updated, is_active, protection, created_at, account FROM players ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var player model.Player
+		if err := rows.Scan(&player.ID, &player.Name, &player.LastUpdated, &player.IsActive, &player.Protection, &player.CreatedAt, &player.Account); err != nil {
+			return nil, err
+		}
+		players = append(players, player)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &players, nil
+}
+
+// ArchiveGame stores the archive and deletes the game it summarizes in one transaction
+func (db *sqlDB) ArchiveGame(archive model.GameArchive) error {
+	return db.inTransaction(func(tx *sql.Tx) error {
+		if err := db.lockGame(tx, archive.ID); err != nil {
+			return err
+		}
+
+		_, err := db.exec(tx,
+			`INSERT INTO game_archives (id, name, creator, winner, created_at, archived_at, transcript) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			archive.ID, archive.Name, archive.Creator, archive.Winner, archive.CreatedAt, archive.ArchivedAt, string(archive.Transcript))
+		if err != nil {
+			return err
+		}
+
+		for seat, player := range archive.Players {
+			_, err = db.exec(tx,
+				`INSERT INTO archive_players (archive_id, seat, player_id, name, cards_left) VALUES (?, ?, ?, ?, ?)`,
+				archive.ID, seat, player.ID, player.Name, player.CardsLeft)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err = db.deleteGameChildren(tx, archive.ID); err != nil {
+			return err
+		}
+		_, err = db.exec(tx, `DELETE FROM games WHERE id = ?`, archive.ID)
+		return err
+	})
+}
+
+// LookupArchive looks up the archive of a game that has been cleaned up
+func (db *sqlDB) LookupArchive(id string) (*model.GameArchive, error) {
+	archive := model.GameArchive{ID: id}
+	var transcript string
+
+	err := db.queryRow(db.conn, `SELECT name, creator, winner, created_at, archived_at, transcript FROM game_archives WHERE id = ?`, id).
+		Scan(&archive.Name, &archive.Creator, &archive.Winner, &archive.CreatedAt, &archive.ArchivedAt, &transcript)
+
+	if err == sql.ErrNoRows {
+		return nil, errSQLArchiveNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if transcript != "" {
+		archive.Transcript = []byte(transcript)
+	}
+
+	rows, err := db.query(db.conn, `SELECT player_id, name, cards_left FROM archive_players WHERE archive_id = ? ORDER BY seat`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var player model.ArchivedPlayer
+		if err := rows.Scan(&player.ID, &player.Name, &player.CardsLeft); err != nil {
+			return nil, err
+		}
+		archive.Players = append(archive.Players, player)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &archive, nil
+}
+
+// AddGameResults records how each player did in a finished game.
+// Results already recorded for the same game and player are kept.
+func (db *sqlDB) AddGameResults(results []model.PlayerResult) error {
+	return db.inTransaction(func(tx *sql.Tx) error {
+		for _, result := range results {
+			_, err := db.exec(tx,
+				`INSERT INTO game_results (game_id, player_id, account, name, finished_at, won, final_hand_size,
+					cards_played, draws_taken, uno_calls, penalties)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+				result.GameID, result.PlayerID, result.Account, result.Name, result.FinishedAt, result.Won, result.FinalHandSize,
+				result.Tally.CardsPlayed, result.Tally.DrawsTaken, result.Tally.UnoCalls, result.Tally.Penalties)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetGameResults returns the results of every game that finished at or after since
+func (db *sqlDB) GetGameResults(since time.Time) (*[]model.PlayerResult, error) {
+	results := make([]model.PlayerResult, 0)
+
+	rows, err := db.query(db.conn,
+		`SELECT game_id, player_id, account, name, finished_at, won, final_hand_size,
+			cards_played, draws_taken, uno_calls, penalties
+		FROM game_results WHERE finished_at >= ? ORDER BY finished_at, game_id, player_id`, formatTime(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result model.PlayerResult
+		err := rows.Scan(&result.GameID, &result.PlayerID, &result.Account, &result.Name, &result.FinishedAt, &result.Won, &result.FinalHandSize,
+			&result.Tally.CardsPlayed, &result.Tally.DrawsTaken, &result.Tally.UnoCalls, &result.Tally.Penalties)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// LookupRating looks up the rating of an account
+func (db *sqlDB) LookupRating(account string) (*model.Rating, error) {
+	rating := model.Rating{Account: account}
+
+	err := db.queryRow(db.conn, `SELECT name, rating, games_rated, updated_at FROM ratings WHERE account = ?`, account).
+		Scan(&rating.Name, &rating.Rating, &rating.GamesRated, &rating.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, errSQLRatingNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &rating, nil
+}
+
+// LookupRatings looks up the ratings of several accounts in one query
+func (db *sqlDB) LookupRatings(accounts []string) (map[string]model.Rating, error) {
+	ratings := make(map[string]model.Rating, len(accounts))
+	if len(accounts) == 0 {
+		return ratings, nil
+	}
+
+	args := make([]interface{}, len(accounts))
+	for i, account := range accounts {
+		args[i] = account
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(accounts)), ", ")
+
+	rows, err := db.query(db.conn,
+		`SELECT account, name, rating, games_rated, updated_at FROM ratings WHERE account IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rating model.Rating
+		if err := rows.Scan(&rating.Account, &rating.Name, &rating.Rating, &rating.GamesRated, &rating.UpdatedAt); err != nil {
+			return nil, err
+		}
+		ratings[rating.Account] = rating
+	}
+
+	return ratings, rows.Err()
+}
+
+// ApplyRatingChanges moves each account's rating by its change and records the change in its history.
+// The history row is inserted first, so a change that was already applied inserts nothing and is skipped.
+func (db *sqlDB) ApplyRatingChanges(changes []model.RatingChange) error {
+	return db.inTransaction(func(tx *sql.Tx) error {
+		for _, change := range changes {
+			inserted, err := db.exec(tx,
+				`INSERT INTO rating_history (account, game_id, name, rank, delta, rating, at) VALUES (?, ?, ?, ?, ?, 0, ?)
+				ON CONFLICT DO NOTHING`,
+				change.Account, change.GameID, change.Name, change.Rank, change.Delta, change.At)
+			if err != nil {
+				return err
+			}
+
+			applied, err := inserted.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if applied == 0 {
+				continue
+			}
+
+			_, err = db.exec(tx,
+				`INSERT INTO ratings (account, name, rating, games_rated, updated_at) VALUES (?, ?, ?, 1, ?)
+				ON CONFLICT (account) DO UPDATE SET name = excluded.name, rating = ratings.rating + ?,
+					games_rated = ratings.games_rated + 1, updated_at = excluded.updated_at`,
+				change.Account, change.Name, model.DefaultRating+change.Delta, change.At, change.Delta)
+			if err != nil {
+				return err
+			}
+
+			_, err = db.exec(tx,
+				`UPDATE rating_history SET rating = (SELECT rating FROM ratings WHERE account = ?) WHERE account = ? AND game_id = ?`,
+				change.Account, change.Account, change.GameID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetRatingHistory returns how an account's rating changed, oldest first
+func (db *sqlDB) GetRatingHistory(account string) (*[]model.RatingChange, error) {
+	history := make([]model.RatingChange, 0)
+
+	rows, err := db.query(db.conn,
+		`SELECT account, game_id, name, rank, delta, rating, at FROM rating_history WHERE account = ? ORDER BY at, game_id`, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change model.RatingChange
+		if err := rows.Scan(&change.Account, &change.GameID, &change.Name, &change.Rank, &change.Delta, &change.Rating, &change.At); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &history, nil
+}
+
+// EnqueuePlayer adds a player to the matchmaking queue
+func (db *sqlDB) EnqueuePlayer(entry model.QueueEntry) (*model.QueueEntry, error) {
+	entry = newQueueEntry(entry)
+
+	rules, err := json.Marshal(entry.Preferences.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.exec(db.conn,
+		`INSERT INTO matchmaking_queue (id, player_name, player_count, rules, status, enqueued_at, updated_at, account) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ID, entry.PlayerName, entry.Preferences.PlayerCount, string(rules), string(entry.Status), entry.EnqueuedAt, entry.UpdatedAt, entry.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// LookupQueueEntry looks up a matchmaking ticket
+func (db *sqlDB) LookupQueueEntry(id string) (*model.QueueEntry, error) {
+	entries, err := db.queryQueue(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, errSQLQueueNotFound
+	}
+
+	return &entries[0], nil
+}
+
+// GetQueueEntries returns every entry in the matchmaking queue, oldest first
+func (db *sqlDB) GetQueueEntries() (*[]model.QueueEntry, error) {
+	entries, err := db.queryQueue(`ORDER BY enqueued_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	return &entries, nil
+}
+
+// UpdateQueueEntry saves the entry if its status is still from
+func (db *sqlDB) UpdateQueueEntry(entry model.QueueEntry, from model.QueueStatus) error {
+	rules, err := json.Marshal(entry.Preferences.Rules)
+	if err != nil {
+		return err
+	}
+
+	return db.inTransaction(func(tx *sql.Tx) error {
+		res, err := db.exec(tx,
+			`UPDATE matchmaking_queue SET player_name = ?, player_count = ?, rules = ?, status = ?, enqueued_at = ?, updated_at = ?,
+				game_id = ?, player_id = ?
+			WHERE id = ? AND status = ?`,
+			entry.PlayerName, entry.Preferences.PlayerCount, string(rules), string(entry.Status), entry.EnqueuedAt, entry.UpdatedAt,
+			entry.GameID, entry.PlayerID, entry.ID, string(from))
+		if err != nil {
+			return err
+		}
+
+		if updated, err := res.RowsAffected(); err != nil {
+			return err
+		} else if updated > 0 {
+			return nil
+		}
+
+		// Nothing matched, either because the entry is gone or because its status moved on
+		var count int
+		if err := db.queryRow(tx, `SELECT COUNT(*) FROM matchmaking_queue WHERE id = ?`, entry.ID).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			return errSQLQueueNotFound
+		}
+		return fmt.Errorf("sqldb: %w", ErrQueueEntryChanged)
+	})
+}
+
+// DeleteQueueEntry removes an entry from the matchmaking queue
+func (db *sqlDB) DeleteQueueEntry(id string) error {
+	res, err := db.exec(db.conn, `DELETE FROM matchmaking_queue WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := res.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return errSQLQueueNotFound
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////
+// Row helpers shared by the methods above
+////////////////////////////////////////////////////////////
+
+func (db *sqlDB) queryIDs(q sqlQueryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.query(q, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// lockGame makes sure the game exists and, on Postgres, holds its row until the transaction ends
+// so that concurrent changes to the game are applied one after another. SQLite shares a single
+// connection, so its transactions already run one at a time.
+func (db *sqlDB) lockGame(tx *sql.Tx, id string) error {
+	query := `SELECT id FROM games WHERE id = ?`
+	if db.driver == "postgres" {
+		query += ` FOR UPDATE`
+	}
+
+	var found string
+	err := db.queryRow(tx, query, id).Scan(&found)
+	if err == sql.ErrNoRows {
+		return errSQLGameNotFound
+	}
+	return err
+}
+
+func (db *sqlDB) lookupPlayer(q sqlQueryer, id string) (*model.Player, error) {
+	player := model.Player{ID: id}
+	err := db.queryRow(q, `SELECT name, last_updated, is_active, protection, created_at, account FROM players WHERE id = ?`, id).
+		Scan(&player.Name, &player.LastUpdated, &player.IsActive, &player.Protection, &player.CreatedAt, &player.Account)
+
+	if err == sql.ErrNoRows {
+		return nil, errSQLPlayerNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &player, nil
+}
+
+// playerName looks up a player's name, which is stored once in the players table
+func (db *sqlDB) playerName(q sqlQueryer, id string) (string, error) {
+	var name string
+	err := db.queryRow(q, `SELECT name FROM players WHERE id = ?`, id).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return name, err
+}
+
+// loadGame reads a game and everything that belongs to it
+func (db *sqlDB) loadGame(q sqlQueryer, id string) (*model.Game, error) {
+	game := model.Game{ID: id}
+	var status, rules, deck, history, redo, undoRequest, opening, actions, unclaimedSeats string
+
+	err := db.queryRow(q,
+		`SELECT name, password, creator_id, current_player, status, direction, winner, created_at, rules, deck, dark_side, history, redo, undo_request,
+		opening, actions, unclaimed_seats FROM games WHERE id = ?`, id).
+		Scan(&game.Name, &game.Password, &game.Creator.ID, &game.CurrentPlayer, &status, &game.Direction, &game.GameOver, &game.CreatedAt, &rules, &deck,
+			&game.DarkSide, &history, &redo, &undoRequest, &opening, &actions, &unclaimedSeats)
+
+	if err == sql.ErrNoRows {
+		return nil, errSQLGameNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	game.Status = model.GameStatus(status)
+
+	if err = json.Unmarshal([]byte(rules), &game.Rules); err != nil {
+		return nil, err
+	}
+
+	if deck != "" {
+		game.Deck = &model.DeckDefinition{}
+		if err = json.Unmarshal([]byte(deck), game.Deck); err != nil {
+			return nil, err
+		}
+	}
+
+	if history != "" {
+		if err = json.Unmarshal([]byte(history), &game.History); err != nil {
+			return nil, err
+		}
+	}
+
+	if redo != "" {
+		if err = json.Unmarshal([]byte(redo), &game.Redo); err != nil {
+			return nil, err
+		}
+	}
+
+	if undoRequest != "" {
+		if err = json.Unmarshal([]byte(undoRequest), &game.UndoRequest); err != nil {
+			return nil, err
+		}
+	}
+
+	if opening != "" {
+		if err = json.Unmarshal([]byte(opening), &game.Opening); err != nil {
+			return nil, err
+		}
+	}
+
+	if actions != "" {
+		if err = json.Unmarshal([]byte(actions), &game.Actions); err != nil {
+			return nil, err
+		}
+	}
+
+	if unclaimedSeats != "" {
+		if err = json.Unmarshal([]byte(unclaimedSeats), &game.UnclaimedSeats); err != nil {
+			return nil, err
+		}
+	}
+
+	if game.Creator.Name, err = db.playerName(q, game.Creator.ID); err != nil {
+		return nil, err
+	}
+
+	if err = db.loadGamePlayers(q, &game); err != nil {
+		return nil, err
+	}
+
+	if err = db.loadPiles(q, &game); err != nil {
+		return nil, err
+	}
+
+	if err = db.loadMessages(q, &game); err != nil {
+		return nil, err
+	}
+
+	return &game, nil
+}
+
+func (db *sqlDB) loadGamePlayers(q sqlQueryer, game *model.Game) error {
+	rows, err := db.query(q,
+		`SELECT gp.player_id, COALESCE(p.name, ''), gp.last_updated, gp.is_active, gp.protection, COALESCE(p.created_at, ''),
+			gp.cards_played, gp.draws_taken, gp.uno_calls, gp.penalties, gp.rating, gp.rank, gp.rating_delta, gp.team, COALESCE(p.account, '')
+		FROM game_players gp LEFT JOIN players p ON p.id = gp.player_id
+		WHERE gp.game_id = ? ORDER BY gp.seat`, game.ID)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var player model.Player
+		err := rows.Scan(&player.ID, &player.Name, &player.LastUpdated, &player.IsActive, &player.Protection, &player.CreatedAt,
+			&player.Tally.CardsPlayed, &player.Tally.DrawsTaken, &player.Tally.UnoCalls, &player.Tally.Penalties,
+			&player.Rating, &player.Rank, &player.RatingDelta, &player.Team, &player.Account)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		game.Players = append(game.Players, player)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.query(q, `SELECT seat, card_color, card_value, card_dark_color, card_dark_value FROM hands WHERE game_id = ? ORDER BY seat, ordinal`, game.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var seat int
+		var card model.Card
+		if err := rows.Scan(&seat, &card.Color, &card.Value, &card.DarkColor, &card.DarkValue); err != nil {
+			return err
+		}
+		if seat < len(game.Players) {
+			game.Players[seat].Cards = append(game.Players[seat].Cards, card)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (db *sqlDB) loadPiles(q sqlQueryer, game *model.Game) error {
+	rows, err := db.query(q, `SELECT pile, card_color, card_value, card_dark_color, card_dark_value FROM piles WHERE game_id = ? ORDER BY pile, ordinal`, game.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pile string
+		var card model.Card
+		if err := rows.Scan(&pile, &card.Color, &card.Value, &card.DarkColor, &card.DarkValue); err != nil {
+			return err
+		}
+
+		switch pile {
+		case drawPile:
+			game.DrawPile = append(game.DrawPile, card)
+		case discardPile:
+			game.DiscardPile = append(game.DiscardPile, card)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (db *sqlDB) loadMessages(q sqlQueryer, game *model.Game) error {
+	rows, err := db.query(q,
+		`SELECT m.id, m.player_id, COALESCE(p.name, ''), m.body, m.sent_at, m.channel, m.recipient
+		FROM messages m LEFT JOIN players p ON p.id = m.player_id
+		WHERE m.game_id = ? ORDER BY m.ordinal`, game.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message model.Message
+		var channel string
+		if err := rows.Scan(&message.ID, &message.Player.ID, &message.Player.Name, &message.Value, &message.Timestamp, &channel, &message.Recipient); err != nil {
+			return err
+		}
+		message.Channel = model.MessageChannel(channel)
+		game.Messages = append(game.Messages, message)
+	}
+
+	return rows.Err()
+}
+
+func (db *sqlDB) deleteGameChildren(tx *sql.Tx, gameID string) error {
+	for _, table := range []string{"game_players", "hands", "piles", "messages"} {
+		if _, err := db.exec(tx, `DELETE FROM `+table+` WHERE game_id = ?`, gameID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *sqlDB) insertGameChildren(tx *sql.Tx, game model.Game) error {
+	for seat, player := range game.Players {
+		if err := db.insertGamePlayer(tx, game.ID, seat, player); err != nil {
+			return err
+		}
+	}
+
+	if err := db.insertPile(tx, game.ID, drawPile, game.DrawPile); err != nil {
+		return err
+	}
+
+	if err := db.insertPile(tx, game.ID, discardPile, game.DiscardPile); err != nil {
+		return err
+	}
+
+	for ordinal, message := range game.Messages {
+		if err := db.insertMessage(tx, game.ID, ordinal, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *sqlDB) insertGamePlayer(tx *sql.Tx, gameID string, seat int, player model.Player) error {
+	_, err := db.exec(tx,
+		`INSERT INTO game_players (game_id, seat, player_id, last_updated, is_active, protection,
+			cards_played, draws_taken, uno_calls, penalties, rating, rank, rating_delta, team)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		gameID, seat, player.ID, player.LastUpdated, player.IsActive, player.Protection,
+		player.Tally.CardsPlayed, player.Tally.DrawsTaken, player.Tally.UnoCalls, player.Tally.Penalties,
+		player.Rating, player.Rank, player.RatingDelta, player.Team)
+	if err != nil {
+		return err
+	}
+
+	for ordinal, card := range player.Cards {
+		_, err = db.exec(tx,
+			`INSERT INTO hands (game_id, seat, ordinal, card_color, card_value, card_dark_color, card_dark_value) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			gameID, seat, ordinal, card.Color, card.Value, card.DarkColor, card.DarkValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *sqlDB) insertPile(tx *sql.Tx, gameID string, pile string, cards []model.Card) error {
+	for ordinal, card := range cards {
+		_, err := db.exec(tx,
+			`INSERT INTO piles (game_id, pile, ordinal, card_color, card_value, card_dark_color, card_dark_value) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			gameID, pile, ordinal, card.Color, card.Value, card.DarkColor, card.DarkValue)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *sqlDB) insertMessage(tx *sql.Tx, gameID string, ordinal int, message model.Message) error {
+	_, err := db.exec(tx,
+		`INSERT INTO messages (game_id, ordinal, id, player_id, body, sent_at, channel, recipient) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		gameID, ordinal, message.ID, message.Player.ID, message.Value, message.Timestamp, string(message.Channel), message.Recipient)
+	return err
+}
+
+func (db *sqlDB) queryQueue(clause string, args ...interface{}) ([]model.QueueEntry, error) {
+	rows, err := db.query(db.conn,
+		`SELECT id, player_name, player_count, rules, status, enqueued_at, updated_at, game_id, player_id, account
+		FROM matchmaking_queue `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]model.QueueEntry, 0)
+	for rows.Next() {
+		var entry model.QueueEntry
+		var rules, status string
+		err := rows.Scan(&entry.ID, &entry.PlayerName, &entry.Preferences.PlayerCount, &rules, &status, &entry.EnqueuedAt, &entry.UpdatedAt,
+			&entry.GameID, &entry.PlayerID, &entry.Account)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(rules), &entry.Preferences.Rules); err != nil {
+			return nil, err
+		}
+		entry.Status = model.QueueStatus(status)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// disconnect closes the connection pool
+func (db *sqlDB) disconnect() {
+	if db.conn != nil {
+		db.conn.Close()
+	}
+}
+
+// connect opens the database named by the backend's environment variable and migrates it
+func (db *sqlDB) connect() error {
+	dsn := os.Getenv(db.dsnEnv)
+	if dsn == "" {
+		dsn = db.defaultDSN
+	}
+
+	conn, err := sql.Open(db.driver, dsn)
+	if err != nil {
+		return err
+	}
+
+	// SQLite only allows one writer at a time, so share a single connection
+	if db.driver == "sqlite3" {
+		conn.SetMaxOpenConns(1)
+	}
+
+	if err = conn.Ping(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	db.conn = conn
+
+	return db.migrate()
+}
+
+// Ping checks the database answers
+func (db *sqlDB) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return db.conn.PingContext(ctx)
+}
+
+func init() {
+	registerDB(&DB{
+		name:        "SQLITE",
+		description: "Embedded SQLite database stored in the file named by SQLITE_PATH",
+		UnoDB:       &sqlDB{driver: "sqlite3", dsnEnv: "SQLITE_PATH", defaultDSN: "uno.db"},
+	})
+
+	registerDB(&DB{
+		name:        "POSTGRES",
+		description: "Postgres database at the connection string in POSTGRES_URI",
+		UnoDB:       &sqlDB{driver: "postgres", dsnEnv: "POSTGRES_URI"},
+	})
+}
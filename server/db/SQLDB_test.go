@@ -0,0 +1,106 @@
+package db
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupSQLiteDB connects a fresh SQLite database in a temporary directory
+func setupSQLiteDB(t *testing.T) *sqlDB {
+	dir, err := ioutil.TempDir("", "uno-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("SQLITE_PATH", filepath.Join(dir, "uno.db"))
+	defer os.Unsetenv("SQLITE_PATH")
+
+	database := &sqlDB{driver: "sqlite3", dsnEnv: "SQLITE_PATH"}
+	if err := database.connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		database.disconnect()
+		os.RemoveAll(dir)
+	})
+
+	return database
+}
+
+func TestSQLRebind(t *testing.T) {
+	sqlite := &sqlDB{driver: "sqlite3"}
+	postgres := &sqlDB{driver: "postgres"}
+
+	query := `SELECT id FROM games WHERE id = ? AND status = ?`
+	assert.Equal(t, query, sqlite.rebind(query))
+	assert.Equal(t, `SELECT id FROM games WHERE id = $1 AND status = $2`, postgres.rebind(query))
+}
+
+func TestSQLMigrationsAreApplied(t *testing.T) {
+	database := setupSQLiteDB(t)
+
+	var version int
+	assert.Nil(t, database.conn.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+	assert.Equal(t, sqlMigrations[len(sqlMigrations)-1].version, version)
+
+	// Running the migrations again must not try to recreate anything
+	assert.Nil(t, database.migrate())
+}
+
+func TestSQLGameRoundTrip(t *testing.T) {
+	database := setupSQLiteDB(t)
+
+	creator, err := database.CreatePlayer("Creator")
+	assert.Nil(t, err)
+	joiner, err := database.CreatePlayer("Joiner")
+	assert.Nil(t, err)
+
+	game, err := database.CreateGame("Game 1", creator.ID)
+	assert.Nil(t, err)
+	game, err = database.JoinGame(game.ID, joiner.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(game.Players))
+
+	// Save a game in the middle of play and read it back exactly
+	game.Status = model.Playing
+	game.CurrentPlayer = 1
+	game.Direction = false
+	game.DrawPile = []model.Card{{Color: "red", Value: "1"}, {Color: "black", Value: "W4"}}
+	game.DiscardPile = []model.Card{{Color: "blue", Value: "5"}}
+	game.Players[0].Cards = []model.Card{{Color: "green", Value: "S"}}
+	game.Players[1].Cards = []model.Card{{Color: "yellow", Value: "R"}, {Color: "yellow", Value: "0"}}
+	game.Players[1].Protection = true
+	assert.Nil(t, database.SaveGame(*game))
+
+	saved, err := database.LookupGameByID(game.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, game, saved)
+
+	// Messages are ordered and can be removed
+	saved, err = database.AddMessage(game.ID, joiner.ID, model.Message{ID: "1", Value: "hello", Channel: model.TableChannel})
+	assert.Nil(t, err)
+	saved, err = database.AddMessage(game.ID, creator.ID, model.Message{ID: "2", Value: "hi", Channel: model.TableChannel})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2"}, []string{saved.Messages[0].ID, saved.Messages[1].ID})
+	assert.Equal(t, "Joiner", saved.Messages[0].Player.Name)
+
+	saved, err = database.DeleteMessage(game.ID, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(saved.Messages))
+
+	// Deleting the game removes everything that belonged to it
+	assert.Nil(t, database.DeleteGame(game.ID))
+	_, err = database.LookupGameByID(game.ID)
+	assert.True(t, errors.Is(err, ErrGameNotFound))
+
+	var hands int
+	assert.Nil(t, database.conn.QueryRow(`SELECT COUNT(*) FROM hands`).Scan(&hands))
+	assert.Equal(t, 0, hands)
+}
@@ -0,0 +1,283 @@
+package db
+
+import "database/sql"
+
+// sqlMigration is one versioned change to the relational schema
+type sqlMigration struct {
+	version    int
+	statements []string
+}
+
+// sqlMigrations are applied in order and each exactly once.
+// Add new migrations to the end of the list, never edit one that has shipped.
+var sqlMigrations = []sqlMigration{
+	{
+		version: 1,
+		statements: []string{
+			`CREATE TABLE players (
+				id           TEXT PRIMARY KEY,
+				name         TEXT NOT NULL,
+				last_updated TEXT NOT NULL DEFAULT '',
+				is_active    BOOLEAN NOT NULL DEFAULT FALSE,
+				protection   BOOLEAN NOT NULL DEFAULT FALSE
+			)`,
+			`CREATE TABLE games (
+				id             TEXT PRIMARY KEY,
+				name           TEXT NOT NULL,
+				password       TEXT NOT NULL,
+				creator_id     TEXT NOT NULL,
+				current_player INTEGER NOT NULL DEFAULT 0,
+				status         TEXT NOT NULL,
+				direction      BOOLEAN NOT NULL,
+				winner         TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX games_password ON games (password)`,
+			`CREATE INDEX games_status ON games (status)`,
+			`CREATE TABLE game_players (
+				game_id      TEXT NOT NULL,
+				seat         INTEGER NOT NULL,
+				player_id    TEXT NOT NULL,
+				last_updated TEXT NOT NULL DEFAULT '',
+				is_active    BOOLEAN NOT NULL DEFAULT FALSE,
+				protection   BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (game_id, seat)
+			)`,
+			`CREATE TABLE hands (
+				game_id    TEXT NOT NULL,
+				seat       INTEGER NOT NULL,
+				ordinal    INTEGER NOT NULL,
+				card_color TEXT NOT NULL,
+				card_value TEXT NOT NULL,
+				PRIMARY KEY (game_id, seat, ordinal)
+			)`,
+			`CREATE TABLE piles (
+				game_id    TEXT NOT NULL,
+				pile       TEXT NOT NULL,
+				ordinal    INTEGER NOT NULL,
+				card_color TEXT NOT NULL,
+				card_value TEXT NOT NULL,
+				PRIMARY KEY (game_id, pile, ordinal)
+			)`,
+			`CREATE TABLE messages (
+				game_id   TEXT NOT NULL,
+				ordinal   INTEGER NOT NULL,
+				id        TEXT NOT NULL,
+				player_id TEXT NOT NULL,
+				body      TEXT NOT NULL,
+				sent_at   TEXT NOT NULL DEFAULT '',
+				channel   TEXT NOT NULL DEFAULT '',
+				recipient TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (game_id, ordinal)
+			)`,
+			`CREATE INDEX messages_id ON messages (game_id, id)`,
+		},
+	},
+	{
+		version: 2,
+		statements: []string{
+			`ALTER TABLE games ADD COLUMN created_at TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE players ADD COLUMN created_at TEXT NOT NULL DEFAULT ''`,
+			`CREATE TABLE game_archives (
+				id          TEXT PRIMARY KEY,
+				name        TEXT NOT NULL,
+				creator     TEXT NOT NULL,
+				winner      TEXT NOT NULL DEFAULT '',
+				created_at  TEXT NOT NULL DEFAULT '',
+				archived_at TEXT NOT NULL
+			)`,
+			`CREATE TABLE archive_players (
+				archive_id TEXT NOT NULL,
+				seat       INTEGER NOT NULL,
+				player_id  TEXT NOT NULL,
+				name       TEXT NOT NULL,
+				cards_left INTEGER NOT NULL,
+				PRIMARY KEY (archive_id, seat)
+			)`,
+		},
+	},
+	{
+		version: 3,
+		statements: []string{
+			`CREATE INDEX games_created_at ON games (created_at)`,
+		},
+	},
+	{
+		version: 4,
+		statements: []string{
+			`ALTER TABLE game_players ADD COLUMN cards_played INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE game_players ADD COLUMN draws_taken INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE game_players ADD COLUMN uno_calls INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE game_players ADD COLUMN penalties INTEGER NOT NULL DEFAULT 0`,
+			`CREATE TABLE game_results (
+				game_id         TEXT NOT NULL,
+				player_id       TEXT NOT NULL,
+				account         TEXT NOT NULL,
+				name            TEXT NOT NULL,
+				finished_at     TEXT NOT NULL,
+				won             BOOLEAN NOT NULL,
+				final_hand_size INTEGER NOT NULL,
+				cards_played    INTEGER NOT NULL,
+				draws_taken     INTEGER NOT NULL,
+				uno_calls       INTEGER NOT NULL,
+				penalties       INTEGER NOT NULL,
+				PRIMARY KEY (game_id, player_id)
+			)`,
+			`CREATE INDEX game_results_finished_at ON game_results (finished_at)`,
+		},
+	},
+	{
+		version: 5,
+		statements: []string{
+			`ALTER TABLE game_players ADD COLUMN rating REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE game_players ADD COLUMN rank INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE game_players ADD COLUMN rating_delta REAL NOT NULL DEFAULT 0`,
+			`CREATE TABLE ratings (
+				account     TEXT PRIMARY KEY,
+				name        TEXT NOT NULL,
+				rating      REAL NOT NULL,
+				games_rated INTEGER NOT NULL,
+				updated_at  TEXT NOT NULL
+			)`,
+			`CREATE TABLE rating_history (
+				account TEXT NOT NULL,
+				game_id TEXT NOT NULL,
+				name    TEXT NOT NULL,
+				rank    INTEGER NOT NULL,
+				delta   REAL NOT NULL,
+				rating  REAL NOT NULL,
+				at      TEXT NOT NULL,
+				PRIMARY KEY (account, game_id)
+			)`,
+		},
+	},
+	{
+		version: 6,
+		statements: []string{
+			// Rules are stored as JSON so new options do not each need a migration
+			`ALTER TABLE games ADD COLUMN rules TEXT NOT NULL DEFAULT '{}'`,
+			`CREATE TABLE matchmaking_queue (
+				id           TEXT PRIMARY KEY,
+				player_name  TEXT NOT NULL,
+				player_count INTEGER NOT NULL,
+				rules        TEXT NOT NULL,
+				status       TEXT NOT NULL,
+				enqueued_at  TEXT NOT NULL,
+				updated_at   TEXT NOT NULL,
+				game_id      TEXT NOT NULL DEFAULT '',
+				player_id    TEXT NOT NULL DEFAULT '',
+				token        TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+	},
+	{
+		version: 7,
+		statements: []string{
+			// The deck a game was dealt with, as JSON. Empty until the game is dealt.
+			`ALTER TABLE games ADD COLUMN deck TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 8,
+		statements: []string{
+			// The other face of two-sided Flip cards, and which side a Flip game is on
+			`ALTER TABLE hands ADD COLUMN card_dark_color TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE hands ADD COLUMN card_dark_value TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE piles ADD COLUMN card_dark_color TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE piles ADD COLUMN card_dark_value TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE games ADD COLUMN dark_side BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+	{
+		version: 9,
+		statements: []string{
+			// The team each player is on in team games, 0 otherwise
+			`ALTER TABLE game_players ADD COLUMN team INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 10,
+		statements: []string{
+			// The game before each of its last few moves, as JSON, so moves can be undone
+			`ALTER TABLE games ADD COLUMN history TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 11,
+		statements: []string{
+			// Undone moves that can be made again, and an undo waiting for players to agree, as JSON
+			`ALTER TABLE games ADD COLUMN redo TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE games ADD COLUMN undo_request TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 12,
+		statements: []string{
+			// The game as it was dealt and every action since, as JSON, for its transcript
+			`ALTER TABLE games ADD COLUMN opening TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE games ADD COLUMN actions TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 13,
+		statements: []string{
+			// The account a player is rated under, empty for guests
+			`ALTER TABLE players ADD COLUMN account TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE matchmaking_queue ADD COLUMN account TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 14,
+		statements: []string{
+			// Tokens are made when a ticket is picked up, the ones stored before that are wiped
+			`UPDATE matchmaking_queue SET token = ''`,
+		},
+	},
+	{
+		version: 15,
+		statements: []string{
+			// The seats of a resumed import nobody has claimed yet, as JSON
+			`ALTER TABLE games ADD COLUMN unclaimed_seats TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 16,
+		statements: []string{
+			// A finished game's transcript as JSON, so it can be exported once it is archived
+			`ALTER TABLE game_archives ADD COLUMN transcript TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+}
+
+// migrate brings the schema up to the latest version, one transaction per migration
+func (db *sqlDB) migrate() error {
+	if _, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for _, migration := range sqlMigrations {
+		if migration.version <= current {
+			continue
+		}
+
+		err := db.inTransaction(func(tx *sql.Tx) error {
+			for _, statement := range migration.statements {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			_, err := db.exec(tx, `INSERT INTO schema_migrations (version) VALUES (?)`, migration.version)
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
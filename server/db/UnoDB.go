@@ -1,13 +1,34 @@
 package db
 
 import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/jak103/uno/model"
 )
 
+// Errors every backend wraps when something cannot be found. Check for them with errors.Is.
+var (
+	ErrGameNotFound       = errors.New("game not found")
+	ErrPlayerNotFound     = errors.New("player not found")
+	ErrMessageNotFound    = errors.New("message not found")
+	ErrArchiveNotFound    = errors.New("archived game not found")
+	ErrRatingNotFound     = errors.New("rating not found")
+	ErrQueueEntryNotFound = errors.New("queue entry not found")
+)
+
+// ErrQueueEntryChanged is wrapped by UpdateQueueEntry when someone else changed the entry first
+var ErrQueueEntryChanged = errors.New("queue entry changed")
+
 // UnoDB declares the database types for the applicaiton
 type UnoDB interface {
 	// Returns all games in the database
 	GetAllGames() (*[]model.Game, error)
+	// Returns one page of the games matching the query, and how many matched in total
+	QueryGames(query GameQuery) (*GamePage, error)
 	// Check if a game with the given password exists in the database.
 	HasGameByPassword(password string) bool
 	// Check if a game with the given ID exists in the database.
@@ -26,16 +47,136 @@ type UnoDB interface {
 	LookupGameByPassword(password string) (*model.Game, error)
 	// Looks up an existing player in the database.
 	LookupPlayer(id string) (*model.Player, error)
-	// Joins a player to a game.
+	// Joins a player to a game. Joining a game the player is already in does nothing.
 	JoinGame(gameID string, playerID string) (*model.Game, error)
-	// Saves a game to the database.
+	// Saves a game that already exists in the database.
 	SaveGame(model.Game) error
-	// Saves a player to the database.
+	// Reads a game, changes it with update and saves it in one step, so two updates to the same game at once
+	// are applied one after the other and neither is lost. update may be called again with a fresh copy of
+	// the game when another change got in first, and nothing is saved when it returns an error.
+	UpdateGame(id string, update func(game *model.Game) error) (*model.Game, error)
+	// Saves a player that already exists in the database.
 	SavePlayer(model.Player) error
 	// Adds a Players message to the db
 	AddMessage(gameID string, playerID string, message model.Message) (*model.Game, error)
+	// Removes a message from a game's chat
+	DeleteMessage(gameID string, messageID string) (*model.Game, error)
+	// Returns all players in the database
+	GetAllPlayers() (*[]model.Player, error)
+	// Stores the archive of a game and deletes the game with the same ID in one step.
+	// Fails with ErrGameNotFound when the game is already gone.
+	ArchiveGame(archive model.GameArchive) error
+	// Looks up the archive of a game that has been cleaned up.
+	LookupArchive(id string) (*model.GameArchive, error)
+	// Records how each player did in a finished game. A result already recorded for the same game and player is kept.
+	AddGameResults(results []model.PlayerResult) error
+	// Returns the results of every game that finished at or after since.
+	GetGameResults(since time.Time) (*[]model.PlayerResult, error)
+	// Looks up the rating of an account. Accounts that never finished a rated game have none.
+	LookupRating(account string) (*model.Rating, error)
+	// Looks up the ratings of several accounts at once, by account. Accounts that were never rated are left out.
+	LookupRatings(accounts []string) (map[string]model.Rating, error)
+	// Adds each change's delta to the rating of its account, starting from model.DefaultRating, and records
+	// the change with the new rating in the account's history. A change already applied for the same game is skipped.
+	ApplyRatingChanges(changes []model.RatingChange) error
+	// Returns how an account's rating changed, oldest first.
+	GetRatingHistory(account string) (*[]model.RatingChange, error)
+	// Adds a player to the matchmaking queue. The entry is given an ID and starts out waiting.
+	EnqueuePlayer(entry model.QueueEntry) (*model.QueueEntry, error)
+	// Looks up a matchmaking ticket.
+	LookupQueueEntry(id string) (*model.QueueEntry, error)
+	// Returns every entry in the matchmaking queue, oldest first.
+	GetQueueEntries() (*[]model.QueueEntry, error)
+	// Saves a queue entry only while its stored status is still from, and fails with ErrQueueEntryChanged
+	// otherwise, so two matchers can never both claim the same player.
+	UpdateQueueEntry(entry model.QueueEntry, from model.QueueStatus) error
+	// Removes an entry from the matchmaking queue.
+	DeleteQueueEntry(id string) error
+	// Checks the database is reachable and answering.
+	Ping() error
 	// disconnects from the database.
 	disconnect()
 	// connect to the database
-	connect()
+	connect() error
+}
+
+// removeMessage returns the messages without the one matching messageID
+// and whether that message was found.
+func removeMessage(messages []model.Message, messageID string) ([]model.Message, bool) {
+	for i, message := range messages {
+		if message.ID == messageID {
+			remaining := make([]model.Message, 0, len(messages)-1)
+			remaining = append(remaining, messages[:i]...)
+			return append(remaining, messages[i+1:]...), true
+		}
+	}
+	return messages, false
+}
+
+// hasPlayer returns true if the player is already seated in the game
+func hasPlayer(game *model.Game, playerID string) bool {
+	for _, player := range game.Players {
+		if player.ID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRatingChange checks if a history already has the change from a game
+func hasRatingChange(history []model.RatingChange, gameID string) bool {
+	for _, change := range history {
+		if change.GameID == gameID {
+			return true
+		}
+	}
+	return false
+}
+
+// sortRatingHistory puts a history in time order, for the backends that cannot sort it themselves
+func sortRatingHistory(history []model.RatingChange) {
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].At != history[j].At {
+			return history[i].At < history[j].At
+		}
+		return history[i].GameID < history[j].GameID
+	})
+}
+
+// queueTimeFormat is RFC3339 with a fixed number of fractional digits, so tickets queued
+// in the same second still sort in the order they joined
+const queueTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// newQueueEntry fills in what every backend sets on a new queue entry
+func newQueueEntry(entry model.QueueEntry) model.QueueEntry {
+	entry.ID = uuid.New().String()
+	entry.Status = model.QueueWaiting
+	entry.EnqueuedAt = time.Now().UTC().Format(queueTimeFormat)
+	entry.UpdatedAt = entry.EnqueuedAt
+	return entry
+}
+
+// sortQueue puts queue entries in the order they joined, for the backends that cannot sort them themselves
+func sortQueue(entries []model.QueueEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].EnqueuedAt != entries[j].EnqueuedAt {
+			return entries[i].EnqueuedAt < entries[j].EnqueuedAt
+		}
+		return entries[i].ID < entries[j].ID
+	})
+}
+
+// timestamp is the time backends record as CreatedAt
+func timestamp() string {
+	return formatTime(time.Now())
+}
+
+// formatTime is how backends store and compare times, it sorts in time order as a string
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// newGamePassword makes a short code that is unique enough to look a game up by
+func newGamePassword() string {
+	return strings.ToUpper(strings.Replace(uuid.New().String(), "-", "", -1)[:8])
 }
@@ -33,7 +33,9 @@ func GetDb() (*DB, error) {
 		}
 
 		if db, ok := dbRegistry[environment]; ok {
-			db.connect()
+			if err := db.connect(); err != nil {
+				return nil, fmt.Errorf("could not connect to the %s database: %w", environment, err)
+			}
 			connectedDB = db
 		} else {
 			return nil, fmt.Errorf("%s is not a registered DB_TYPE", environment)
@@ -43,6 +45,11 @@ func GetDb() (*DB, error) {
 	return connectedDB, nil
 }
 
+// Name is the DB_TYPE the database was registered as
+func (d *DB) Name() string {
+	return d.name
+}
+
 // Disconnect disconnects the database
 func Disconnect() {
 	if connectedDB != nil {
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jak103/uno/model"
+)
+
+// Observer is told when each call to a database starts, and returns what to call with its error when it is done
+type Observer func(method string) (done func(err error))
+
+// Observe wraps a database so every call to it is reported to observer, such as for timing or tracing each method
+func Observe(database UnoDB, observer Observer) UnoDB {
+	return &observedDB{database: database, observer: observer}
+}
+
+// observedDB is a database that reports every call made to it
+type observedDB struct {
+	database UnoDB
+	observer Observer
+}
+
+func (db *observedDB) GetAllGames() (*[]model.Game, error) {
+	done := db.observer("GetAllGames")
+	result, err := db.database.GetAllGames()
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) QueryGames(query GameQuery) (*GamePage, error) {
+	done := db.observer("QueryGames")
+	result, err := db.database.QueryGames(query)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) HasGameByPassword(password string) bool {
+	done := db.observer("HasGameByPassword")
+	result := db.database.HasGameByPassword(password)
+	done(nil)
+	return result
+}
+
+func (db *observedDB) HasGameByID(game string) bool {
+	done := db.observer("HasGameByID")
+	result := db.database.HasGameByID(game)
+	done(nil)
+	return result
+}
+
+func (db *observedDB) CreateGame(gameName string, creatorID string) (*model.Game, error) {
+	done := db.observer("CreateGame")
+	result, err := db.database.CreateGame(gameName, creatorID)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) CreatePlayer(name string) (*model.Player, error) {
+	done := db.observer("CreatePlayer")
+	result, err := db.database.CreatePlayer(name)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) DeleteGame(id string) error {
+	done := db.observer("DeleteGame")
+	err := db.database.DeleteGame(id)
+	done(err)
+	return err
+}
+
+func (db *observedDB) DeletePlayer(id string) error {
+	done := db.observer("DeletePlayer")
+	err := db.database.DeletePlayer(id)
+	done(err)
+	return err
+}
+
+func (db *observedDB) LookupGameByID(id string) (*model.Game, error) {
+	done := db.observer("LookupGameByID")
+	result, err := db.database.LookupGameByID(id)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) LookupGameByPassword(password string) (*model.Game, error) {
+	done := db.observer("LookupGameByPassword")
+	result, err := db.database.LookupGameByPassword(password)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) LookupPlayer(id string) (*model.Player, error) {
+	done := db.observer("LookupPlayer")
+	result, err := db.database.LookupPlayer(id)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) JoinGame(gameID string, playerID string) (*model.Game, error) {
+	done := db.observer("JoinGame")
+	result, err := db.database.JoinGame(gameID, playerID)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) SaveGame(game model.Game) error {
+	done := db.observer("SaveGame")
+	err := db.database.SaveGame(game)
+	done(err)
+	return err
+}
+
+func (db *observedDB) UpdateGame(id string, update func(game *model.Game) error) (*model.Game, error) {
+	done := db.observer("UpdateGame")
+	result, err := db.database.UpdateGame(id, update)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) SavePlayer(player model.Player) error {
+	done := db.observer("SavePlayer")
+	err := db.database.SavePlayer(player)
+	done(err)
+	return err
+}
+
+func (db *observedDB) AddMessage(gameID string, playerID string, message model.Message) (*model.Game, error) {
+	done := db.observer("AddMessage")
+	result, err := db.database.AddMessage(gameID, playerID, message)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) DeleteMessage(gameID string, messageID string) (*model.Game, error) {
+	done := db.observer("DeleteMessage")
+	result, err := db.database.DeleteMessage(gameID, messageID)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) GetAllPlayers() (*[]model.Player, error) {
+	done := db.observer("GetAllPlayers")
+	result, err := db.database.GetAllPlayers()
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) ArchiveGame(archive model.GameArchive) error {
+	done := db.observer("ArchiveGame")
+	err := db.database.ArchiveGame(archive)
+	done(err)
+	return err
+}
+
+func (db *observedDB) LookupArchive(id string) (*model.GameArchive, error) {
+	done := db.observer("LookupArchive")
+	result, err := db.database.LookupArchive(id)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) AddGameResults(results []model.PlayerResult) error {
+	done := db.observer("AddGameResults")
+	err := db.database.AddGameResults(results)
+	done(err)
+	return err
+}
+
+func (db *observedDB) GetGameResults(since time.Time) (*[]model.PlayerResult, error) {
+	done := db.observer("GetGameResults")
+	result, err := db.database.GetGameResults(since)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) LookupRating(account string) (*model.Rating, error) {
+	done := db.observer("LookupRating")
+	result, err := db.database.LookupRating(account)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) LookupRatings(accounts []string) (map[string]model.Rating, error) {
+	done := db.observer("LookupRatings")
+	result, err := db.database.LookupRatings(accounts)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) ApplyRatingChanges(changes []model.RatingChange) error {
+	done := db.observer("ApplyRatingChanges")
+	err := db.database.ApplyRatingChanges(changes)
+	done(err)
+	return err
+}
+
+func (db *observedDB) GetRatingHistory(account string) (*[]model.RatingChange, error) {
+	done := db.observer("GetRatingHistory")
+	result, err := db.database.GetRatingHistory(account)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) EnqueuePlayer(entry model.QueueEntry) (*model.QueueEntry, error) {
+	done := db.observer("EnqueuePlayer")
+	result, err := db.database.EnqueuePlayer(entry)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) LookupQueueEntry(id string) (*model.QueueEntry, error) {
+	done := db.observer("LookupQueueEntry")
+	result, err := db.database.LookupQueueEntry(id)
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) GetQueueEntries() (*[]model.QueueEntry, error) {
+	done := db.observer("GetQueueEntries")
+	result, err := db.database.GetQueueEntries()
+	done(err)
+	return result, err
+}
+
+func (db *observedDB) UpdateQueueEntry(entry model.QueueEntry, from model.QueueStatus) error {
+	done := db.observer("UpdateQueueEntry")
+	err := db.database.UpdateQueueEntry(entry, from)
+	done(err)
+	return err
+}
+
+func (db *observedDB) DeleteQueueEntry(id string) error {
+	done := db.observer("DeleteQueueEntry")
+	err := db.database.DeleteQueueEntry(id)
+	done(err)
+	return err
+}
+
+func (db *observedDB) Ping() error {
+	done := db.observer("Ping")
+	err := db.database.Ping()
+	done(err)
+	return err
+}
+
+func (db *observedDB) disconnect() {
+	db.database.disconnect()
+}
+
+func (db *observedDB) connect() error {
+	return db.database.connect()
+}
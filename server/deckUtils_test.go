@@ -15,7 +15,7 @@ import (
 )
 
 func TestGenerateShuffledDeck(t *testing.T) {
-	deck := generateShuffledDeck(1)
+	deck := generateShuffledDeck(testRand(), model.StandardDeck(), 1)
 
 	//Check that the deck has the right number of each color
 	colorCounts := map[string]int{
@@ -41,24 +41,25 @@ func TestGenerateShuffledDeck(t *testing.T) {
 }
 
 func TestNumDecksToUse(t *testing.T) {
-	assert.Equal(t, 1, numDecksToUse(3))
-	assert.Equal(t, 2, numDecksToUse(6))
-	assert.Equal(t, 3, numDecksToUse(12))
-	assert.Equal(t, 4, numDecksToUse(16))
-	assert.Equal(t, 5, numDecksToUse(21))
-	assert.Equal(t, 6, numDecksToUse(26))
-	assert.Equal(t, 7, numDecksToUse(32))
-	assert.Equal(t, 8, numDecksToUse(37))
-	assert.Equal(t, 9, numDecksToUse(41))
+	standard := model.StandardDeck()
+	assert.Equal(t, 1, standard.Copies(3))
+	assert.Equal(t, 2, standard.Copies(6))
+	assert.Equal(t, 3, standard.Copies(12))
+	assert.Equal(t, 4, standard.Copies(16))
+	assert.Equal(t, 5, standard.Copies(21))
+	assert.Equal(t, 6, standard.Copies(26))
+	assert.Equal(t, 7, standard.Copies(32))
+	assert.Equal(t, 8, standard.Copies(37))
+	assert.Equal(t, 9, standard.Copies(41))
 }
 
 func TestShuffleCards(t *testing.T) {
-	deck := shuffleCards([]model.Card{model.Card{"red", "1"}, model.Card{"blue", "2"}, model.Card{"green", "3"}}) //Shuffling test deck
-	assert.NotEqual(t, deck[:0], model.Card{"red", "1"})
+	deck := shuffleCards(testRand(), []model.Card{model.Card{Color: "red", Value: "1"}, model.Card{Color: "blue", Value: "2"}, model.Card{Color: "green", Value: "3"}}) //Shuffling test deck
+	assert.NotEqual(t, deck[:0], model.Card{Color: "red", Value: "1"})
 }
 
 func TestPrintCard(t *testing.T) {
-	card := model.Card{"red", "1"} //Card to print
+	card := model.Card{Color: "red", Value: "1"} //Card to print
 	re := captureOutput(func() {   //Capturing the output from printcard
 		printCard(card)
 	})
@@ -66,7 +67,7 @@ func TestPrintCard(t *testing.T) {
 }
 
 func TestPrintCards(t *testing.T) {
-	deck := []model.Card{model.Card{"red", "1"}, model.Card{"blue", "2"}, model.Card{"green", "3"}} //Building test deck
+	deck := []model.Card{model.Card{Color: "red", Value: "1"}, model.Card{Color: "blue", Value: "2"}, model.Card{Color: "green", Value: "3"}} //Building test deck
 	re := captureOutput(func() {                                                                    //Capturing output from printcards
 		printCards(deck)
 	})
@@ -74,10 +75,10 @@ func TestPrintCards(t *testing.T) {
 }
 
 func TestNumberCard(t *testing.T) {
-	numCard := model.Card{"red", "1"} //Card to to test for IS number card
-	notNumCard := model.Card{"red", "W"} //Card to to test for IS NOT number card
-	assert.Equal(t, true, isNumberCard(numCard))
-	assert.Equal(t, false, isNumberCard(notNumCard))
+	numCard := model.Card{Color: "red", Value: "1"} //Card to to test for IS number card
+	notNumCard := model.Card{Color: "red", Value: "W"} //Card to to test for IS NOT number card
+	assert.Equal(t, true, isNumberCard(model.StandardDeck(), numCard))
+	assert.Equal(t, false, isNumberCard(model.StandardDeck(), notNumCard))
 }
 
 func captureOutput(f func()) string { //Function to capture output source: https://medium.com/@hau12a1/golang-capturing-log-println-and-fmt-println-output-770209c791b4
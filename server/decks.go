@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v2"
+)
+
+var errUnknownDeck = errors.New("There is no deck with that name")
+
+// deckRegistry holds the decks games can be dealt with, by name
+type deckRegistry struct {
+	mutex sync.RWMutex
+	decks map[string]model.DeckDefinition
+}
+
+// newDeckRegistry starts out with only the standard deck, loadDecks adds the ones in the configured decks directory
+func newDeckRegistry() *deckRegistry {
+	standard := model.StandardDeck()
+	return &deckRegistry{decks: map[string]model.DeckDefinition{standard.Name: standard}}
+}
+
+// add registers a deck once it is valid, replacing any deck with the same name
+func (r *deckRegistry) add(deck model.DeckDefinition) error {
+	if err := deck.Validate(); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.decks[deck.Name] = deck
+	return nil
+}
+
+// lookup finds a deck by name. No name is the standard deck.
+func (r *deckRegistry) lookup(name string) (model.DeckDefinition, error) {
+	if name == "" {
+		name = model.StandardDeckName
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	deck, ok := r.decks[name]
+	if !ok {
+		return model.DeckDefinition{}, errUnknownDeck
+	}
+	return deck, nil
+}
+
+// all is every deck, sorted by name
+func (r *deckRegistry) all() []model.DeckDefinition {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	all := make([]model.DeckDefinition, 0, len(r.decks))
+	for _, deck := range r.decks {
+		all = append(all, deck)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// parseDeck reads a deck definition from JSON, or from YAML when the file name ends in .yaml or .yml
+func parseDeck(fileName string, data []byte) (model.DeckDefinition, error) {
+	var deck model.DeckDefinition
+	var err error
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &deck)
+	default:
+		err = json.Unmarshal(data, &deck)
+	}
+
+	if err != nil {
+		return deck, fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return deck, nil
+}
+
+// loadDir registers every .json, .yaml and .yml deck in dir.
+// A missing directory is not an error, games just have the standard deck.
+func (r *deckRegistry) loadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		switch strings.ToLower(filepath.Ext(file.Name())) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		deck, err := parseDeck(path, data)
+		if err != nil {
+			return err
+		}
+
+		if err := r.add(deck); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// loadDecks registers the decks in dir
+func (s *GameService) loadDecks(dir string) error {
+	return s.decks.loadDir(dir)
+}
+
+// ruleDeck checks that a deck a game asks for exists, and gives the name the game's rules keep.
+// The standard deck is kept as no name, so games that pick it match games that pick nothing.
+func (s *GameService) ruleDeck(name string) (string, error) {
+	deck, err := s.decks.lookup(name)
+	if err != nil {
+		return "", err
+	}
+
+	if deck.Name == model.StandardDeckName {
+		return "", nil
+	}
+	return deck.Name, nil
+}
+
+// dealtDeck is the deck a game's rules pick, copied onto the game so later changes to the deck files do not change it
+func (s *GameService) dealtDeck(game *model.Game) (*model.DeckDefinition, error) {
+	deck, err := s.decks.lookup(game.Rules.Deck)
+	if err != nil {
+		return nil, err
+	}
+	return &deck, nil
+}
+
+// getDecks lists the decks a game can be created with
+func (s *server) getDecks(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.games.decks.all())
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShippedDecksLoad(t *testing.T) {
+	registry := newDeckRegistry()
+	assert.Nil(t, registry.loadDir("decks"))
+
+	modern, err := registry.lookup("modern")
+	assert.Nil(t, err)
+	assert.Len(t, modern.Build(2), 112)
+
+	party, err := registry.lookup("party")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, party.Copies(4))
+
+	standard, err := registry.lookup("")
+	assert.Nil(t, err)
+	assert.Equal(t, model.StandardDeckName, standard.Name)
+
+	_, err = registry.lookup("missing")
+	assert.Equal(t, errUnknownDeck, err)
+}
+
+func TestParseDeck(t *testing.T) {
+	yamlDeck := []byte(`
+name: tiny
+colors: [red, blue]
+cards:
+  - {value: "1", count: 3}
+  - {value: WS, count: 1, wild: true, effects: [{kind: shuffle-hands}]}
+`)
+	deck, err := parseDeck("tiny.yml", yamlDeck)
+	assert.Nil(t, err)
+	assert.Nil(t, deck.Validate())
+	assert.Len(t, deck.Build(2), 7)
+	assert.True(t, deck.IsWild("WS"))
+
+	jsonDeck := []byte(`{"name": "tiny", "colors": ["red"], "cards": [{"value": "1", "count": 1}]}`)
+	deck, err = parseDeck("tiny.json", jsonDeck)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Card{{Color: "red", Value: "1"}}, deck.Build(1))
+
+	// Misspelled YAML fields are caught rather than ignored
+	_, err = parseDeck("tiny.yaml", []byte("name: tiny\ncolours: [red]\n"))
+	assert.NotNil(t, err)
+}
+
+func TestValidateDeck(t *testing.T) {
+	valid := model.DeckDefinition{Name: "ok", Colors: []string{"red"}, Cards: []model.CardType{{Value: "1", Count: 1}}}
+	assert.Nil(t, valid.Validate())
+	assert.Nil(t, model.StandardDeck().Validate())
+
+	broken := map[string]func(deck *model.DeckDefinition){
+		"no name":        func(deck *model.DeckDefinition) { deck.Name = "" },
+		"no colors":      func(deck *model.DeckDefinition) { deck.Colors = nil },
+		"no number card": func(deck *model.DeckDefinition) { deck.Cards[0].Wild = true },
+		"duplicate value": func(deck *model.DeckDefinition) {
+			deck.Cards = append(deck.Cards, model.CardType{Value: "1", Count: 1})
+		},
+		"reserved value": func(deck *model.DeckDefinition) {
+			deck.Cards = append(deck.Cards, model.CardType{Value: "Blank", Count: 1})
+		},
+		"unknown effect": func(deck *model.DeckDefinition) {
+			deck.Cards = append(deck.Cards, model.CardType{Value: "X", Count: 1, Effects: []model.Effect{{Kind: "explode"}}})
+		},
+		"too many of a card": func(deck *model.DeckDefinition) {
+			deck.Cards = append(deck.Cards, model.CardType{Value: "2", Count: model.MaxCardCount + 1})
+		},
+		"too many cards": func(deck *model.DeckDefinition) {
+			deck.Colors = make([]string, 100)
+			for i := range deck.Colors {
+				deck.Colors[i] = fmt.Sprint("color ", i)
+			}
+			deck.Cards[0].Count = model.MaxCardCount
+		},
+		"draw nothing": func(deck *model.DeckDefinition) {
+			deck.Cards = append(deck.Cards, model.CardType{Value: "D0", Count: 1, Effects: []model.Effect{{Kind: model.EffectDraw}}})
+		},
+	}
+
+	for name, breakDeck := range broken {
+		deck := valid
+		deck.Cards = append([]model.CardType{}, valid.Cards...)
+		breakDeck(&deck)
+		assert.NotNil(t, deck.Validate(), name)
+	}
+}
+
+func TestLoadDirRejectsInvalidDecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "decks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("not a deck"), 0644)
+	assert.Nil(t, newDeckRegistry().loadDir(dir))
+
+	ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"name": "bad", "colors": ["red"], "cards": []}`), 0644)
+	assert.NotNil(t, newDeckRegistry().loadDir(dir))
+
+	// No directory just leaves the standard deck
+	registry := newDeckRegistry()
+	assert.Nil(t, registry.loadDir(filepath.Join(dir, "missing")))
+	assert.Len(t, registry.all(), 1)
+}
+
+// dealtGame deals a two or three player game with the deck, then gives the first player the hand
+func dealtGame(t *testing.T, games *GameService, deck model.DeckDefinition, players int, hand []model.Card) *model.Game {
+	database := games.database
+	assert.Nil(t, games.decks.add(deck))
+
+	game := dealGame(t, games, model.GameRules{Deck: deck.Name}, playerNames(players)...)
+	assert.Equal(t, deck.Name, game.Deck.Name)
+
+	game.CurrentPlayer = 0
+	game.Direction = true
+	game.DiscardPile = []model.Card{{Color: "red", Value: "1"}}
+	game.Players[0].Cards = hand
+	database.SaveGame(*game)
+	return game
+}
+
+func testDeck() model.DeckDefinition {
+	return model.DeckDefinition{
+		Name:   "effects-" + uuid.New().String(),
+		Colors: []string{"red", "blue"},
+		Cards: []model.CardType{
+			{Value: "1", Count: 10},
+			{Value: "SA", Count: 2, Effects: []model.Effect{{Kind: model.EffectSkipAll}}},
+			{Value: "W6", Count: 2, Wild: true, Points: 60, Effects: []model.Effect{{Kind: model.EffectDraw, Amount: 6}}},
+			{Value: "WS", Count: 2, Wild: true, Effects: []model.Effect{{Kind: model.EffectShuffleHands}}},
+		},
+	}
+}
+
+func TestCustomCardEffects(t *testing.T) {
+	games := newTestService()
+
+	red1 := model.Card{Color: "red", Value: "1"}
+
+	// Skip everyone: the player goes again
+	game := dealtGame(t, games, testDeck(), 3, []model.Card{{Color: "red", Value: "SA"}, red1})
+	game, err := games.playCard(context.Background(), game.ID, game.Players[0].ID, model.Card{Color: "red", Value: "SA"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, game.CurrentPlayer)
+
+	// A wild draw 6 can be played on anything as any color, and the next player draws 6 and is skipped
+	game = dealtGame(t, games, testDeck(), 3, []model.Card{{Color: "black", Value: "W6"}, red1})
+	game.DiscardPile = []model.Card{{Color: "blue", Value: "SA"}}
+	database := games.database
+	database.SaveGame(*game)
+
+	game, err = games.playCard(context.Background(), game.ID, game.Players[0].ID, model.Card{Color: "red", Value: "W6"})
+	assert.Nil(t, err)
+	assert.Len(t, game.Players[1].Cards, 7+6)
+	assert.Equal(t, 2, game.CurrentPlayer)
+	assert.Equal(t, 60, game.CardDeck().HandPoints([]model.Card{{Color: "black", Value: "W6"}}))
+
+	// Shuffling hands deals every card back out, starting with the next player
+	game = dealtGame(t, games, testDeck(), 3, []model.Card{{Color: "black", Value: "WS"}, red1, red1, red1})
+	game, err = games.playCard(context.Background(), game.ID, game.Players[0].ID, model.Card{Color: "blue", Value: "WS"})
+	assert.Nil(t, err)
+	assert.Len(t, game.Players[1].Cards, 6)
+	assert.Len(t, game.Players[2].Cards, 6)
+	assert.Len(t, game.Players[0].Cards, 5)
+	assert.Equal(t, 1, game.CurrentPlayer)
+}
+
+func TestDeckTooSmallToDeal(t *testing.T) {
+	games := newTestService()
+
+	tiny := model.DeckDefinition{Name: "tiny-" + uuid.New().String(), Colors: []string{"red"}, Cards: []model.CardType{{Value: "1", Count: 5}}}
+	assert.Nil(t, games.decks.add(tiny))
+
+	game, _, err := games.createNewGame(context.Background(), "Tiny", "Player 0", "", model.GameRules{Deck: tiny.Name})
+	assert.Nil(t, err)
+	player, _ := games.createPlayer(context.Background(), "Player 1", "")
+	game, _ = games.joinGame(context.Background(), game.ID, player)
+
+	_, err = games.dealCards(context.Background(), game)
+	assert.Equal(t, errDeckTooSmall, err)
+}
+
+func TestDealGivesUpWhenNoNumberCardIsLeft(t *testing.T) {
+	games := newTestService()
+
+	// The only number card is usually dealt into someone's hand
+	skips := model.DeckDefinition{Name: "skips-" + uuid.New().String(), Colors: []string{"red"}, Cards: []model.CardType{
+		{Value: "1", Count: 1},
+		{Value: "S", Count: 20, Effects: []model.Effect{{Kind: model.EffectSkip}}},
+	}}
+	require.Nil(t, games.decks.add(skips))
+
+	stuck := 0
+	for i := 0; i < 20; i++ {
+		game, err := games.dealCards(context.Background(), seatPlayers(t, games, model.GameRules{Deck: skips.Name}, "Player 0", "Player 1"))
+		if err == errNoStartingCard {
+			stuck++
+			continue
+		}
+
+		require.Nil(t, err)
+		assert.Equal(t, "1", game.DiscardPile[0].Value)
+	}
+	assert.NotZero(t, stuck)
+}
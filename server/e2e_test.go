@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The scenarios in testdata/scenarios are games played through the HTTP API by players holding
+// real tokens, against a server with a mock database of its own. The first player creates the game
+// and the others join in order, then the steps run one after another:
+//
+//	{"as": "Ann", "do": "play", "card": "red 5", "expect": {"status": 200, "turn": "Bob"}}
+//
+// Cards are written as "color value", a wild card is played as the color it is given.
+// Deals are random, so a scenario that needs particular cards arranges the table first,
+// which is the one step that goes straight to the database instead of through the API.
+
+// scenario is one scripted game
+type scenario struct {
+	Name string `json:"name"`
+	// Anything else the game is created with, such as its deck or teams
+	Game    map[string]interface{} `json:"game"`
+	Players []string               `json:"players"`
+	Steps   []scenarioStep         `json:"steps"`
+}
+
+// scenarioStep is something a player does and what should come of it
+type scenarioStep struct {
+	// The player doing it
+	As string `json:"as"`
+	// start, arrange, play, draw, call, chat, messages, state or play out
+	Do string `json:"do"`
+	// "none" sends no token, which is a bad request, and "forged" a token signed with the wrong secret
+	Token   string `json:"token"`
+	Card    string `json:"card"`
+	Target  string `json:"target"`
+	Message string `json:"message"`
+	// What arrange puts on the table
+	Hands   map[string][]string `json:"hands"`
+	Discard string              `json:"discard"`
+	Turn    string              `json:"turn"`
+	// How many moves play out may take before the game is over
+	Moves  int            `json:"moves"`
+	Expect scenarioExpect `json:"expect"`
+}
+
+// scenarioExpect is checked against the response and then against the game as the player sees it.
+// Anything left out is not checked.
+type scenarioExpect struct {
+	// The response code, 200 unless set
+	Status   int            `json:"status"`
+	Turn     string         `json:"turn"`
+	Top      string         `json:"top"`
+	Hands    map[string]int `json:"hands"`
+	Game     string         `json:"game"`
+	Winner   string         `json:"winner"`
+	Messages *int           `json:"messages"`
+}
+
+// gameView is the game state the API sends a player
+type gameView struct {
+	ID            string               `json:"game_id"`
+	PlayerID      string               `json:"player_id"`
+	Status        model.GameStatus     `json:"status"`
+	GameOver      string               `json:"gameOver"`
+	CurrentCard   model.Card           `json:"current_card"`
+	CurrentPlayer model.Player         `json:"current_player"`
+	Players       []model.Player       `json:"all_players"`
+	Hand          []model.Card         `json:"player_cards"`
+	Deck          model.DeckDefinition `json:"deck"`
+}
+
+// table is a game being played through the API
+type table struct {
+	t      *testing.T
+	games  *GameService
+	server *httptest.Server
+	gameID string
+	// The player who created the game
+	creator string
+	// Each player's token and ID, by name
+	tokens map[string]string
+	ids    map[string]string
+}
+
+// newTable starts a server with the API's routes and seats the players, the first one creates the game
+func newTable(t *testing.T, options map[string]interface{}, players ...string) *table {
+	games := newTestService()
+	require.Nil(t, games.decks.loadDir("decks"))
+
+	e := echo.New()
+	newServer(games).setupRoutes(e)
+
+	tb := &table{t: t, games: games, server: httptest.NewServer(e), tokens: map[string]string{}, ids: map[string]string{}}
+	t.Cleanup(tb.server.Close)
+
+	body := map[string]interface{}{"name": t.Name(), "creator": players[0]}
+	for key, value := range options {
+		body[key] = value
+	}
+
+	var created struct {
+		Token string   `json:"token"`
+		Game  gameView `json:"game"`
+	}
+	require.Equal(t, http.StatusOK, tb.send(http.MethodPost, "/api/games", "", body, &created))
+	tb.gameID = created.Game.ID
+	tb.creator = players[0]
+	tb.seat(players[0], created.Token, created.Game)
+
+	for _, name := range players[1:] {
+		var joined struct {
+			Token string   `json:"token"`
+			Game  gameView `json:"game"`
+		}
+		status := tb.send(http.MethodPost, "/api/games/"+tb.gameID+"/join", "", map[string]string{"playerName": name}, &joined)
+		require.Equal(t, http.StatusOK, status, "%s joining", name)
+		tb.seat(name, joined.Token, joined.Game)
+	}
+
+	return tb
+}
+
+func (tb *table) seat(name string, token string, game gameView) {
+	require.NotEqual(tb.t, "", token, "%s has no token", name)
+	tb.tokens[name] = token
+	tb.ids[name] = game.PlayerID
+}
+
+// send makes a request with token as the Authorization header, unless it is empty,
+// and reads the response into out. It returns the response code.
+func (tb *table) send(method string, path string, token string, body interface{}, out interface{}) int {
+	var reader *bytes.Reader
+	if body == nil {
+		reader = bytes.NewReader(nil)
+	} else {
+		data, err := json.Marshal(body)
+		require.Nil(tb.t, err)
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, tb.server.URL+path, reader)
+	require.Nil(tb.t, err)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Token "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.Nil(tb.t, err)
+	defer res.Body.Close()
+
+	if out != nil && res.StatusCode == http.StatusOK {
+		require.Nil(tb.t, json.NewDecoder(res.Body).Decode(out), "%s %s", method, path)
+	}
+
+	return res.StatusCode
+}
+
+// view is the game as a player sees it
+func (tb *table) view(name string) gameView {
+	var view gameView
+	require.Equal(tb.t, http.StatusOK, tb.send(http.MethodGet, "/api/games/"+tb.gameID, tb.tokens[name], nil, &view))
+	return view
+}
+
+// token is the token a step is sent with
+func (tb *table) token(step scenarioStep) string {
+	switch step.Token {
+	case "none":
+		return ""
+	case "forged":
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"playerId": tb.ids[step.As]})
+		forged, err := token.SignedString([]byte("not the secret"))
+		require.Nil(tb.t, err)
+		return forged
+	default:
+		return tb.tokens[step.As]
+	}
+}
+
+// run does a step and checks what came of it
+func (tb *table) run(step scenarioStep) {
+	t := tb.t
+	path := "/api/games/" + tb.gameID
+	token := tb.token(step)
+	status := http.StatusOK
+	var messages []model.Message
+
+	switch step.Do {
+	case "start":
+		status = tb.send(http.MethodPost, path+"/start", token, nil, nil)
+	case "arrange":
+		tb.arrange(step)
+	case "play":
+		status = tb.send(http.MethodPost, path+"/play", token, parseCard(step.Card), nil)
+	case "draw":
+		status = tb.send(http.MethodPost, path+"/draw", token, nil, nil)
+	case "call":
+		status = tb.send(http.MethodPost, path+"/call", token, map[string]string{"id": tb.ids[step.Target]}, nil)
+	case "chat":
+		status = tb.send(http.MethodPost, "/api/chat/"+tb.gameID+"/add", token, map[string]string{"message": step.Message}, nil)
+	case "messages":
+		status = tb.send(http.MethodGet, "/api/chat/"+tb.gameID, token, nil, &messages)
+	case "state":
+		status = tb.send(http.MethodGet, path, token, nil, nil)
+	case "play out":
+		tb.playOut(step.Moves)
+	default:
+		t.Fatalf("unknown step %q", step.Do)
+	}
+
+	expect := step.Expect
+	if expect.Status == 0 {
+		expect.Status = http.StatusOK
+	}
+	require.Equal(t, expect.Status, status, "%s %s", step.As, step.Do)
+
+	if expect.Messages != nil {
+		assert.Equal(t, *expect.Messages, len(messages), "messages")
+	}
+
+	// The rest is checked as the player sees the game, or as its creator for steps nobody takes
+	viewer := step.As
+	if _, ok := tb.tokens[viewer]; !ok {
+		viewer = tb.creator
+	}
+	view := tb.view(viewer)
+
+	if expect.Turn != "" {
+		assert.Equal(t, expect.Turn, view.CurrentPlayer.Name, "whose turn")
+	}
+	if expect.Top != "" {
+		assert.Equal(t, parseCard(expect.Top), view.CurrentCard, "top card")
+	}
+	for name, count := range expect.Hands {
+		assert.Equal(t, count, len(view.Players[tb.seatOf(view, name)].Cards), "cards in %s's hand", name)
+	}
+	if expect.Game != "" {
+		assert.Equal(t, model.GameStatus(expect.Game), view.Status, "game status")
+	}
+	if expect.Winner != "" {
+		assert.Equal(t, expect.Winner, view.GameOver, "winner")
+	}
+}
+
+// arrange deals the hands and the discard pile a step asks for and hands the turn to a player
+func (tb *table) arrange(step scenarioStep) {
+	database := tb.games.database
+	game, err := database.LookupGameByID(tb.gameID)
+	require.Nil(tb.t, err)
+
+	for name, hand := range step.Hands {
+		seat := findPlayer(game, tb.ids[name])
+		require.True(tb.t, seat >= 0, "%s is not at the table", name)
+
+		game.Players[seat].Cards = []model.Card{}
+		for _, card := range hand {
+			game.Players[seat].Cards = append(game.Players[seat].Cards, parseCard(card))
+		}
+	}
+
+	if step.Discard != "" {
+		game.DiscardPile = append(game.DiscardPile, parseCard(step.Discard))
+	}
+
+	if step.Turn != "" {
+		game.CurrentPlayer = findPlayer(game, tb.ids[step.Turn])
+	}
+
+	require.Nil(tb.t, database.SaveGame(*game))
+}
+
+// playOut plays until the game is over. Whoever's turn it is plays the first card they can,
+// wild cards as the color they hold most of, and draws when they have nothing to play.
+// A player left with one card calls Uno on themselves.
+func (tb *table) playOut(moves int) {
+	if moves == 0 {
+		moves = 2000
+	}
+
+	path := "/api/games/" + tb.gameID
+	for move := 0; move < moves; move++ {
+		game, err := tb.games.database.LookupGameByID(tb.gameID)
+		require.Nil(tb.t, err)
+		if game.Status == model.Finished {
+			return
+		}
+
+		name := game.Players[game.CurrentPlayer].Name
+		token := tb.tokens[name]
+
+		var view gameView
+		card, ok := pickCard(game)
+		if !ok {
+			require.Equal(tb.t, http.StatusOK, tb.send(http.MethodPost, path+"/draw", token, nil, nil), "%s drawing", name)
+			continue
+		}
+
+		require.Equal(tb.t, http.StatusOK, tb.send(http.MethodPost, path+"/play", token, card, &view), "%s playing %v", name, card)
+
+		if view.Status == model.Playing && len(view.Hand) == 1 {
+			require.Equal(tb.t, http.StatusOK, tb.send(http.MethodPost, path+"/call", token, map[string]string{"id": view.PlayerID}, nil))
+		}
+	}
+
+	tb.t.Fatalf("the game was not over after %d moves", moves)
+}
+
+func (tb *table) seatOf(view gameView, name string) int {
+	for i, player := range view.Players {
+		if player.ID == tb.ids[name] {
+			return i
+		}
+	}
+	tb.t.Fatalf("%s is not at the table", name)
+	return -1
+}
+
+// parseCard reads a card written as "color value"
+func parseCard(card string) model.Card {
+	fields := strings.Fields(card)
+	if len(fields) != 2 {
+		return model.Card{}
+	}
+	return model.Card{Color: fields[0], Value: fields[1]}
+}
+
+func TestScenarios(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "scenarios", "*.json"))
+	require.Nil(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		require.Nil(t, err)
+
+		var s scenario
+		require.Nil(t, json.Unmarshal(data, &s), file)
+
+		t.Run(s.Name, func(t *testing.T) {
+			tb := newTable(t, s.Game, s.Players...)
+			for _, step := range s.Steps {
+				tb.run(step)
+			}
+		})
+	}
+}
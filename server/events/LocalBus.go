@@ -0,0 +1,114 @@
+package events
+
+import "sync"
+
+// subscriptionBuffer is how many events a slow subscriber may fall behind before events are dropped.
+// Dropping is fine because every event only means "read the game again".
+const subscriptionBuffer = 16
+
+// Subscription receives the events of one game on C
+type Subscription struct {
+	C      <-chan Event
+	events chan Event
+	gameID string
+	hub    *hub
+}
+
+// Close stops the subscription and closes C
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// hub fans events out to the subscribers in this process
+type hub struct {
+	mutex         sync.Mutex
+	subscriptions map[string]map[*Subscription]struct{}
+}
+
+func newHub() *hub {
+	return &hub{subscriptions: make(map[string]map[*Subscription]struct{})}
+}
+
+func (h *hub) subscribe(gameID string) *Subscription {
+	events := make(chan Event, subscriptionBuffer)
+	subscription := &Subscription{C: events, events: events, gameID: gameID, hub: h}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.subscriptions[gameID] == nil {
+		h.subscriptions[gameID] = make(map[*Subscription]struct{})
+	}
+	h.subscriptions[gameID][subscription] = struct{}{}
+
+	return subscription
+}
+
+func (h *hub) unsubscribe(subscription *Subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	subscriptions := h.subscriptions[subscription.gameID]
+	if _, ok := subscriptions[subscription]; !ok {
+		return
+	}
+
+	delete(subscriptions, subscription)
+	if len(subscriptions) == 0 {
+		delete(h.subscriptions, subscription.gameID)
+	}
+	close(subscription.events)
+}
+
+// deliver hands the event to every subscriber of its game without ever blocking the publisher
+func (h *hub) deliver(event Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for subscription := range h.subscriptions[event.GameID] {
+		select {
+		case subscription.events <- event:
+		default:
+		}
+	}
+}
+
+// closeAll ends every subscription
+func (h *hub) closeAll() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for gameID, subscriptions := range h.subscriptions {
+		for subscription := range subscriptions {
+			close(subscription.events)
+		}
+		delete(h.subscriptions, gameID)
+	}
+}
+
+// LocalBus delivers events to subscribers in the same process
+type LocalBus struct {
+	hub *hub
+}
+
+// NewLocalBus creates a bus for a single server
+func NewLocalBus() *LocalBus {
+	return &LocalBus{hub: newHub()}
+}
+
+// Publish sends an event to every subscriber of event.GameID
+func (b *LocalBus) Publish(event Event) error {
+	b.hub.deliver(event)
+	return nil
+}
+
+// Subscribe listens for the events of one game
+func (b *LocalBus) Subscribe(gameID string) *Subscription {
+	return b.hub.subscribe(gameID)
+}
+
+// Close ends every subscription
+func (b *LocalBus) Close() error {
+	b.hub.closeAll()
+	return nil
+}
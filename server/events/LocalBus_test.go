@@ -0,0 +1,76 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receive waits a short while for the next event on a subscription
+func receive(t *testing.T, subscription *Subscription) (Event, bool) {
+	select {
+	case event, ok := <-subscription.C:
+		return event, ok
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+		return Event{}, false
+	}
+}
+
+// assertNoEvent checks that nothing arrives on a subscription
+func assertNoEvent(t *testing.T, subscription *Subscription) {
+	select {
+	case event := <-subscription.C:
+		t.Errorf("unexpected event %v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLocalBusDeliversToGameSubscribers(t *testing.T) {
+	bus := NewLocalBus()
+	defer bus.Close()
+
+	first := bus.Subscribe("game-1")
+	second := bus.Subscribe("game-1")
+	other := bus.Subscribe("game-2")
+
+	assert.Nil(t, bus.Publish(Event{GameID: "game-1", Kind: GameUpdated}))
+
+	event, _ := receive(t, first)
+	assert.Equal(t, Event{GameID: "game-1", Kind: GameUpdated}, event)
+	event, _ = receive(t, second)
+	assert.Equal(t, Event{GameID: "game-1", Kind: GameUpdated}, event)
+	assertNoEvent(t, other)
+}
+
+func TestLocalBusClose(t *testing.T) {
+	bus := NewLocalBus()
+
+	subscription := bus.Subscribe("game-1")
+	subscription.Close()
+	_, ok := receive(t, subscription)
+	assert.False(t, ok)
+
+	// Closing twice is harmless, and the bus still works for everyone else
+	subscription.Close()
+	remaining := bus.Subscribe("game-1")
+	assert.Nil(t, bus.Publish(Event{GameID: "game-1", Kind: ChatUpdated}))
+	event, _ := receive(t, remaining)
+	assert.Equal(t, ChatUpdated, event.Kind)
+
+	bus.Close()
+	_, ok = receive(t, remaining)
+	assert.False(t, ok)
+}
+
+func TestLocalBusNeverBlocksPublisher(t *testing.T) {
+	bus := NewLocalBus()
+	defer bus.Close()
+
+	// Nobody reads this subscription, publishing must still return
+	bus.Subscribe("game-1")
+	for i := 0; i < subscriptionBuffer*2; i++ {
+		assert.Nil(t, bus.Publish(Event{GameID: "game-1", Kind: GameUpdated}))
+	}
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// redisChannel is the Redis pub/sub channel every replica publishes to and listens on
+const redisChannel = "uno:events"
+
+// RedisBus fans events out between server replicas. A move handled on one replica is published
+// to Redis, and every replica, including the one that published it, hands it to its own subscribers.
+type RedisBus struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	hub    *hub
+	done   chan struct{}
+}
+
+// NewRedisBus connects to the Redis server at url, which looks like redis://localhost:6379/0
+func NewRedisBus(url string) (*RedisBus, error) {
+	if url == "" {
+		url = "redis://localhost:6379/0"
+	}
+
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+
+	// Receive waits for the subscription to be confirmed, so no event published after this returns is missed
+	pubsub := client.Subscribe(redisChannel)
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		client.Close()
+		return nil, err
+	}
+
+	bus := &RedisBus{client: client, pubsub: pubsub, hub: newHub(), done: make(chan struct{})}
+	go bus.listen(pubsub.Channel())
+
+	return bus, nil
+}
+
+// listen delivers everything published on the channel until the bus is closed
+func (b *RedisBus) listen(messages <-chan *redis.Message) {
+	defer close(b.done)
+
+	for message := range messages {
+		var event Event
+		if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+			log.Println("Ignoring malformed event:", err)
+			continue
+		}
+		b.hub.deliver(event)
+	}
+}
+
+// Publish sends an event to the subscribers of event.GameID on every replica
+func (b *RedisBus) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(redisChannel, data).Err()
+}
+
+// Subscribe listens for the events of one game
+func (b *RedisBus) Subscribe(gameID string) *Subscription {
+	return b.hub.subscribe(gameID)
+}
+
+// Close stops listening to Redis and ends every subscription
+func (b *RedisBus) Close() error {
+	err := b.pubsub.Close()
+	<-b.done
+	b.hub.closeAll()
+	b.client.Close()
+	return err
+}
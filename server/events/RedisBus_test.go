@@ -0,0 +1,65 @@
+package events
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// redisTestURL returns REDIS_TEST_URL for a local redis-server, or starts a miniredis
+func redisTestURL(t *testing.T) string {
+	if url := os.Getenv("REDIS_TEST_URL"); url != "" {
+		return url
+	}
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	return "redis://" + server.Addr()
+}
+
+func TestRedisBusFansOutBetweenReplicas(t *testing.T) {
+	url := redisTestURL(t)
+
+	// Two buses on the same Redis stand in for two server replicas
+	replica1, err := NewRedisBus(url)
+	assert.Nil(t, err)
+	defer replica1.Close()
+	replica2, err := NewRedisBus(url)
+	assert.Nil(t, err)
+	defer replica2.Close()
+
+	local := replica1.Subscribe("game-1")
+	remote := replica2.Subscribe("game-1")
+	other := replica2.Subscribe("game-2")
+
+	// A move handled on replica 1 reaches clients connected to either replica
+	assert.Nil(t, replica1.Publish(Event{GameID: "game-1", Kind: GameUpdated}))
+
+	event, _ := receive(t, remote)
+	assert.Equal(t, Event{GameID: "game-1", Kind: GameUpdated}, event)
+	event, _ = receive(t, local)
+	assert.Equal(t, Event{GameID: "game-1", Kind: GameUpdated}, event)
+	assertNoEvent(t, other)
+}
+
+func TestRedisBusClose(t *testing.T) {
+	bus, err := NewRedisBus(redisTestURL(t))
+	assert.Nil(t, err)
+
+	subscription := bus.Subscribe("game-1")
+	assert.Nil(t, bus.Close())
+
+	_, ok := receive(t, subscription)
+	assert.False(t, ok)
+}
+
+func TestRedisBusBadURL(t *testing.T) {
+	_, err := NewRedisBus("not a redis url")
+	assert.NotNil(t, err)
+}
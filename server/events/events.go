@@ -0,0 +1,78 @@
+// Package events tells clients that a game changed, no matter which server replica made the change.
+package events
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Kinds of game event
+const (
+	GameUpdated = "game"
+	ChatUpdated = "chat"
+	// A chat message was deleted. The event says which one, so clients can take it off their screen.
+	MessageDeleted = "chat-delete"
+	// A matchmaking ticket changed. These events are published under the ticket ID instead of a game ID.
+	MatchUpdated = "match"
+)
+
+// Event tells listeners that something in a game changed. It carries no game state,
+// every listener reads the game again so players only ever see their own hand.
+type Event struct {
+	GameID string `json:"game_id"`
+	Kind   string `json:"kind"`
+	// The message a MessageDeleted event is about
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// Bus publishes events to every subscriber of a game
+type Bus interface {
+	// Publish sends an event to every subscriber of event.GameID
+	Publish(event Event) error
+	// Subscribe listens for the events of one game until the subscription is closed
+	Subscribe(gameID string) *Subscription
+	// Close stops delivering events
+	Close() error
+}
+
+var (
+	busMutex     sync.Mutex
+	connectedBus Bus
+)
+
+// GetBus returns the event bus chosen by EVENT_BUS.
+// LOCAL (the default) only reaches clients of this process, REDIS reaches every replica sharing REDIS_URL.
+func GetBus() (Bus, error) {
+	busMutex.Lock()
+	defer busMutex.Unlock()
+
+	if connectedBus == nil {
+		switch strings.ToUpper(os.Getenv("EVENT_BUS")) {
+		case "", "LOCAL":
+			connectedBus = NewLocalBus()
+		case "REDIS":
+			bus, err := NewRedisBus(os.Getenv("REDIS_URL"))
+			if err != nil {
+				return nil, err
+			}
+			connectedBus = bus
+		default:
+			return nil, fmt.Errorf("%s is not a known EVENT_BUS", os.Getenv("EVENT_BUS"))
+		}
+	}
+
+	return connectedBus, nil
+}
+
+// Close closes the bus returned by GetBus
+func Close() {
+	busMutex.Lock()
+	defer busMutex.Unlock()
+
+	if connectedBus != nil {
+		connectedBus.Close()
+		connectedBus = nil
+	}
+}
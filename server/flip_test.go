@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func flipDeck(t *testing.T) model.DeckDefinition {
+	data, err := ioutil.ReadFile("decks/flip.yaml")
+	assert.Nil(t, err)
+
+	deck, err := parseDeck("flip.yaml", data)
+	assert.Nil(t, err)
+	assert.Nil(t, deck.Validate())
+	return deck
+}
+
+// flipGame deals a flip game, then sets up the first player's hand and the discard pile on the given side
+func flipGame(t *testing.T, games *GameService, players int, dark bool, top model.Card, hand []model.Card) *model.Game {
+	game := dealtGame(t, games, flipDeck(t), players, hand)
+	game.DarkSide = dark
+	game.DiscardPile = []model.Card{top}
+
+	database := games.database
+	database.SaveGame(*game)
+	return game
+}
+
+func TestFlipDeckHasTwoFaces(t *testing.T) {
+	deck := flipDeck(t)
+	cards := generateShuffledDeck(testRand(), deck, 2)
+	assert.Len(t, cards, 112)
+
+	darkColors := map[string]bool{"pink": true, "teal": true, "orange": true, "purple": true, "black": true}
+	darkValues := map[string]int{}
+	for _, card := range cards {
+		assert.NotEmpty(t, card.DarkValue)
+		assert.True(t, darkColors[card.DarkColor], card.DarkColor)
+		darkValues[card.DarkValue]++
+	}
+	assert.Equal(t, 8, darkValues["D5"])
+	assert.Equal(t, 4, darkValues["WDC"])
+
+	// Both sides have to have the same number of cards
+	deck.Dark.Cards = deck.Dark.Cards[1:]
+	assert.NotNil(t, deck.Validate())
+
+	// Only two-sided decks can flip
+	standard := model.StandardDeck()
+	standard.Cards = append(standard.Cards, model.CardType{Value: "F", Count: 1, Effects: []model.Effect{{Kind: model.EffectFlip}}})
+	assert.NotNil(t, standard.Validate())
+}
+
+func TestFlipCardTurnsTheTable(t *testing.T) {
+	games := newTestService()
+
+	flip := model.Card{Color: "red", Value: "F", DarkColor: "teal", DarkValue: "3"}
+	top := model.Card{Color: "red", Value: "1", DarkColor: "pink", DarkValue: "SA"}
+	game := flipGame(t, games, 3, false, top, []model.Card{flip, {Color: "blue", Value: "2", DarkColor: "teal", DarkValue: "D5"}})
+
+	game, err := games.playCard(context.Background(), game.ID, game.Players[0].ID, model.Card{Color: "red", Value: "F"})
+	assert.Nil(t, err)
+	assert.True(t, game.DarkSide)
+	assert.Equal(t, 1, game.CurrentPlayer)
+
+	// The played card keeps both faces and now shows its dark side
+	assert.Equal(t, flip, game.DiscardPile[len(game.DiscardPile)-1])
+	assert.True(t, isCardPlayable(game, model.Card{Color: "teal", Value: "8"}))
+	assert.True(t, isCardPlayable(game, model.Card{Color: "orange", Value: "3"}))
+	assert.False(t, isCardPlayable(game, model.Card{Color: "red", Value: "8"}))
+	assert.True(t, isCardPlayable(game, model.Card{Color: "black", Value: "WDC"}))
+
+	// Light side wild values are not wild on the dark side
+	assert.False(t, isCardPlayable(game, model.Card{Color: "black", Value: "W2"}))
+}
+
+func TestDarkSideEffects(t *testing.T) {
+	games := newTestService()
+
+	top := model.Card{Color: "red", Value: "1", DarkColor: "pink", DarkValue: "4"}
+
+	// Draw five
+	game := flipGame(t, games, 3, true, top, []model.Card{{Color: "blue", Value: "7", DarkColor: "pink", DarkValue: "D5"}, top})
+	game, err := games.playCard(context.Background(), game.ID, game.Players[0].ID, model.Card{Color: "pink", Value: "D5"})
+	assert.Nil(t, err)
+	assert.Len(t, game.Players[1].Cards, 7+5)
+	assert.Equal(t, 2, game.CurrentPlayer)
+
+	// Skip everyone comes back to the player
+	game = flipGame(t, games, 3, true, top, []model.Card{{Color: "blue", Value: "7", DarkColor: "pink", DarkValue: "SA"}, top})
+	game, err = games.playCard(context.Background(), game.ID, game.Players[0].ID, model.Card{Color: "pink", Value: "SA"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, game.CurrentPlayer)
+
+	// Wild draw color: the next player draws until they turn up the color, then loses their turn
+	game = flipGame(t, games, 3, true, top, []model.Card{{Color: "black", Value: "W2", DarkColor: "black", DarkValue: "WDC"}, top})
+	game.DrawPile = []model.Card{
+		{Color: "red", Value: "5", DarkColor: "orange", DarkValue: "1"},
+		{Color: "red", Value: "6", DarkColor: "teal", DarkValue: "2"},
+		{Color: "red", Value: "7", DarkColor: "pink", DarkValue: "3"},
+	}
+	database := games.database
+	database.SaveGame(*game)
+
+	game, err = games.playCard(context.Background(), game.ID, game.Players[0].ID, model.Card{Color: "teal", Value: "WDC"})
+	assert.Nil(t, err)
+	assert.Len(t, game.Players[1].Cards, 7+2)
+	assert.Equal(t, 2, game.CurrentPlayer)
+
+	// The wild shows the color it was played as and keeps its light face
+	played := game.DiscardPile[len(game.DiscardPile)-1]
+	assert.Equal(t, model.Card{Color: "black", Value: "W2", DarkColor: "teal", DarkValue: "WDC"}, played)
+}
+
+func TestFlipGameStateShowsSides(t *testing.T) {
+	games := newTestService()
+
+	mine := model.Card{Color: "red", Value: "5", DarkColor: "pink", DarkValue: "D5"}
+	game := flipGame(t, games, 2, true, model.Card{Color: "red", Value: "1", DarkColor: "pink", DarkValue: "4"}, []model.Card{mine})
+	game.Players[1].Cards = []model.Card{{Color: "blue", Value: "S", DarkColor: "teal", DarkValue: "9"}}
+
+	state := buildGameState(game, game.Players[0].ID)
+	assert.Equal(t, []model.Card{{Color: "pink", Value: "D5"}}, state["player_cards"])
+	assert.Equal(t, model.Card{Color: "pink", Value: "4"}, state["current_card"])
+	assert.Equal(t, true, state["dark_side"])
+
+	// Opponents see the backs of your cards, which is their other side
+	players := state["all_players"].([]model.Player)
+	assert.Equal(t, []model.Card{{Color: "blue", Value: "S"}}, players[1].Cards)
+
+	// Building the state does not change the game
+	assert.Equal(t, mine, game.Players[0].Cards[0])
+}
+
+func TestFlipGamesScoreTheShowingSide(t *testing.T) {
+	deck := flipDeck(t)
+	game := &model.Game{CurrentPlayer: 0, DarkSide: true, Deck: &deck, Players: []model.Player{
+		{},
+		{Cards: []model.Card{{Color: "red", Value: "1", DarkColor: "black", DarkValue: "WDC"}}},
+		{Cards: []model.Card{{Color: "black", Value: "W2", DarkColor: "teal", DarkValue: "2"}}},
+	}}
+
+	rankPlayers(game)
+	assert.Equal(t, 3, game.Players[1].Rank)
+	assert.Equal(t, 2, game.Players[2].Rank)
+	assert.Equal(t, 60, deck.Dark.HandPoints(model.Faces(game.Players[1].Cards, true)))
+}
@@ -4,13 +4,26 @@ go 1.14
 
 require (
 	cloud.google.com/go/firestore v1.2.0
+	github.com/alicebob/miniredis/v2 v2.14.1
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/google/uuid v1.1.1
+	github.com/go-redis/redis/v7 v7.4.0
+	github.com/google/uuid v1.1.2
 	github.com/labstack/echo v3.3.10+incompatible
 	github.com/labstack/echo/v4 v4.1.16
+	github.com/labstack/gommon v0.3.0
+	github.com/lib/pq v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mattwhite180/go-away v1.0.0
-	github.com/stretchr/testify v1.6.1
+	github.com/prometheus/client_golang v1.8.0
+	github.com/stretchr/testify v1.7.0
 	go.mongodb.org/mongo-driver v1.3.5
-	golang.org/x/crypto v0.0.0-20200510223506-06a226fb4e37 // indirect
+	go.opentelemetry.io/otel v0.19.0
+	go.opentelemetry.io/otel/exporters/otlp v0.19.0
+	go.opentelemetry.io/otel/exporters/stdout v0.19.0
+	go.opentelemetry.io/otel/sdk v0.19.0
+	go.opentelemetry.io/otel/trace v0.19.0
+	go.uber.org/zap v1.16.0
 	google.golang.org/api v0.20.0
+	google.golang.org/grpc v1.36.0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/jak103/uno/model"
+)
+
+var (
+	errNothingToUndo = errors.New("There is no move to undo")
+	errNothingToRedo = errors.New("There is no undone move to redo")
+	errGameFinished  = errors.New("The game is over, its result has been recorded")
+)
+
+// dealMove is the move of a game's opening snapshot, the game as it was dealt
+const dealMove = "deal"
+
+// snapshotGame records the game as it is before a move, keeping only the last model.MaxHistory moves.
+// The move replaces anything that was undone, and any undo still waiting for an answer.
+func snapshotGame(game *model.Game, action model.Action) {
+	game.History = pushSnapshot(game.History, takeSnapshot(game, action.Kind, action.PlayerID, action.At))
+	game.Redo = nil
+	game.UndoRequest = nil
+}
+
+// undoLastMove puts the game back the way it was before its last move and returns that snapshot.
+// The piles, hands and Protection flags all come back, so the same cards are drawn next.
+// Chat and anything else a move cannot change are left as they are. The move can be redone until the next move.
+func undoLastMove(game *model.Game) (model.GameSnapshot, error) {
+	if game.Status == model.Finished {
+		return model.GameSnapshot{}, errGameFinished
+	}
+
+	if len(game.History) == 0 {
+		return model.GameSnapshot{}, errNothingToUndo
+	}
+
+	last := len(game.History) - 1
+	snapshot := game.History[last]
+	game.History = game.History[:last]
+
+	game.Redo = pushSnapshot(game.Redo, takeSnapshot(game, snapshot.Move, snapshot.PlayerID, snapshot.At))
+	restoreSnapshot(game, snapshot)
+
+	return snapshot, nil
+}
+
+// redoLastUndo makes the last undone move again, leaving the game as it was right after the move
+func redoLastUndo(game *model.Game) (model.GameSnapshot, error) {
+	if game.Status == model.Finished {
+		return model.GameSnapshot{}, errGameFinished
+	}
+
+	if len(game.Redo) == 0 {
+		return model.GameSnapshot{}, errNothingToRedo
+	}
+
+	last := len(game.Redo) - 1
+	snapshot := game.Redo[last]
+	game.Redo = game.Redo[:last]
+
+	game.History = pushSnapshot(game.History, takeSnapshot(game, snapshot.Move, snapshot.PlayerID, snapshot.At))
+	restoreSnapshot(game, snapshot)
+
+	return snapshot, nil
+}
+
+// forgetHistory leaves the game with nothing to undo or redo
+func forgetHistory(game *model.Game) {
+	game.History = nil
+	game.Redo = nil
+	game.UndoRequest = nil
+}
+
+// takeSnapshot copies everything a move can change in the game
+func takeSnapshot(game *model.Game, move string, playerID string, at string) model.GameSnapshot {
+	return model.GameSnapshot{
+		Move:          move,
+		PlayerID:      playerID,
+		At:            at,
+		DrawPile:      copyCards(game.DrawPile),
+		DiscardPile:   copyCards(game.DiscardPile),
+		Players:       copyPlayers(game.Players),
+		CurrentPlayer: game.CurrentPlayer,
+		Status:        game.Status,
+		Direction:     game.Direction,
+		DarkSide:      game.DarkSide,
+		GameOver:      game.GameOver,
+	}
+}
+
+func restoreSnapshot(game *model.Game, snapshot model.GameSnapshot) {
+	game.DrawPile = copyCards(snapshot.DrawPile)
+	game.DiscardPile = copyCards(snapshot.DiscardPile)
+	game.Players = copyPlayers(snapshot.Players)
+	game.CurrentPlayer = snapshot.CurrentPlayer
+	game.Status = snapshot.Status
+	game.Direction = snapshot.Direction
+	game.DarkSide = snapshot.DarkSide
+	game.GameOver = snapshot.GameOver
+	game.UndoRequest = nil
+}
+
+// pushSnapshot adds a snapshot to the end of a list, dropping the oldest past model.MaxHistory
+func pushSnapshot(snapshots []model.GameSnapshot, snapshot model.GameSnapshot) []model.GameSnapshot {
+	snapshots = append(snapshots, snapshot)
+	if len(snapshots) > model.MaxHistory {
+		snapshots = append([]model.GameSnapshot{}, snapshots[len(snapshots)-model.MaxHistory:]...)
+	}
+	return snapshots
+}
+
+// copyPlayers copies players and their hands, so a snapshot never shares cards with the game
+func copyPlayers(players []model.Player) []model.Player {
+	if players == nil {
+		return nil
+	}
+
+	copied := make([]model.Player, len(players))
+	for i, player := range players {
+		player.Cards = copyCards(player.Cards)
+		copied[i] = player
+	}
+	return copied
+}
+
+func copyCards(cards []model.Card) []model.Card {
+	if cards == nil {
+		return nil
+	}
+	return append([]model.Card{}, cards...)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoPutsBackTheWholeGame(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	before := dealGame(t, games, model.GameRules{}, "Alice", "Bob", "Carol")
+
+	drawer := before.Players[before.CurrentPlayer].ID
+	after, err := games.drawCard(ctx, before.ID, drawer)
+	require.Nil(t, err)
+	require.Len(t, after.History, 1)
+	assert.Equal(t, moveDraw, after.History[0].Move)
+	assert.Equal(t, drawer, after.History[0].PlayerID)
+
+	after.Players[0].Protection = true
+	_, err = undoLastMove(after)
+	require.Nil(t, err)
+
+	// The drawn card is back on top of the draw pile, so the same card is drawn again
+	assert.Equal(t, before.DrawPile, after.DrawPile)
+	assert.Equal(t, before.DiscardPile, after.DiscardPile)
+	assert.Equal(t, before.Players, after.Players)
+	assert.Equal(t, before.CurrentPlayer, after.CurrentPlayer)
+	assert.Empty(t, after.History)
+
+	_, err = undoLastMove(after)
+	assert.Equal(t, errNothingToUndo, err)
+}
+
+func TestSnapshotsDoNotShareCards(t *testing.T) {
+	game := &model.Game{
+		DrawPile: []model.Card{{Color: "red", Value: "1"}},
+		Players:  []model.Player{{ID: "1", Cards: []model.Card{{Color: "blue", Value: "2"}}}},
+	}
+	snapshotGame(game, model.Action{Kind: model.ActionPlay, PlayerID: "1"})
+
+	game.DrawPile[0].Value = "9"
+	game.Players[0].Cards[0].Value = "9"
+
+	assert.Equal(t, "1", game.History[0].DrawPile[0].Value)
+	assert.Equal(t, "2", game.History[0].Players[0].Cards[0].Value)
+}
+
+func TestHistoryIsCapped(t *testing.T) {
+	game := &model.Game{}
+	for i := 0; i < model.MaxHistory+5; i++ {
+		game.CurrentPlayer = i
+		snapshotGame(game, model.Action{Kind: model.ActionDraw, PlayerID: "1"})
+	}
+
+	require.Len(t, game.History, model.MaxHistory)
+	assert.Equal(t, 5, game.History[0].CurrentPlayer)
+	assert.Equal(t, model.MaxHistory+4, game.History[model.MaxHistory-1].CurrentPlayer)
+}
+
+func TestFinishedGamesCannotBeUndone(t *testing.T) {
+	game := &model.Game{Status: model.Finished}
+	snapshotGame(game, model.Action{Kind: model.ActionPlay, PlayerID: "1"})
+
+	_, err := undoLastMove(game)
+	assert.Equal(t, errGameFinished, err)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests deal random games and throw random moves at them, legal and not,
+// checking after every move that the engine keeps the table in order:
+// every card dealt is still on the table exactly once, play stays with a seated player,
+// and a finished game turns every move down. Run the fuzzer with
+//
+//	go test -run XXX -fuzz FuzzEngine
+
+// invariantDecks are the decks random games are dealt with
+var invariantDecks = []string{"", "modern", "party", "flip"}
+
+// tableSetup is how a random game is set up, read from its first bytes
+type tableSetup struct {
+	players int
+	rules   model.GameRules
+}
+
+func readSetup(setup []byte) tableSetup {
+	for len(setup) < 3 {
+		setup = append(setup, 0)
+	}
+
+	players := 2 + int(setup[0])%(model.MaxPlayers-1)
+	rules := model.GameRules{
+		Deck:              invariantDecks[int(setup[1])%len(invariantDecks)],
+		DrawUntilPlayable: setup[2]&1 != 0,
+		Unrated:           setup[2]&2 != 0,
+	}
+
+	if setup[2]&4 != 0 && players >= 4 && players%2 == 0 {
+		rules.Teams = 2
+	}
+
+	return tableSetup{players: players, rules: rules}
+}
+
+// randomGame deals a game and makes the moves, checking the invariants after each one
+func randomGame(t *testing.T, seed int64, setup tableSetup, moves []byte) {
+	games := newGameService(db.NewMockDB(), &fakeClock{now: time.Now()}, rand.New(rand.NewSource(seed)), events.NewLocalBus())
+	require.Nil(t, games.decks.loadDir("decks"))
+
+	game := dealGame(t, games, setup.rules, playerNames(setup.players)...)
+
+	deck := game.CardDeck()
+	dealt := countCards(deck, deck.Build(setup.players))
+	checkInvariants(t, deck, dealt, game)
+
+	for len(moves) >= 3 && game.Status != model.Finished {
+		move, who, pick := moves[0], int(moves[1])%setup.players, int(moves[2])
+		moves = moves[3:]
+
+		randomMove(games, game, move, who, pick)
+
+		updated, err := games.database.LookupGameByID(game.ID)
+		require.Nil(t, err)
+		game = updated
+		checkInvariants(t, deck, dealt, game)
+	}
+
+	if game.Status == model.Finished {
+		checkFinished(t, games, game)
+	}
+}
+
+// randomMove makes one move. Most are the move the current player would make, the rest are
+// whatever a random player tries, which is usually turned down.
+func randomMove(games *GameService, game *model.Game, move byte, who int, pick int) {
+	current := game.Players[game.CurrentPlayer]
+	player := game.Players[who]
+	deck := game.ActiveDeck()
+
+	switch move % 6 {
+	case 0, 1, 2:
+		for _, card := range model.Faces(current.Cards, game.DarkSide) {
+			if deck.IsWild(card.Value) {
+				card.Color = deck.Colors[pick%len(deck.Colors)]
+			}
+			if isCardPlayable(game, card) {
+				games.playCard(context.Background(), game.ID, current.ID, card)
+				return
+			}
+		}
+		games.drawCard(context.Background(), game.ID, current.ID)
+	case 3:
+		games.drawCard(context.Background(), game.ID, player.ID)
+	case 4:
+		card := model.Card{Color: "purple", Value: "9"}
+		if len(player.Cards) > 0 {
+			card = player.Cards[pick%len(player.Cards)].Face(game.DarkSide)
+		}
+		if pick&1 != 0 {
+			card.Color = deck.Colors[pick%len(deck.Colors)]
+		}
+		games.playCard(context.Background(), game.ID, player.ID, card)
+	case 5:
+		target := game.Players[pick%len(game.Players)]
+		games.logicCallUno(context.Background(), game.ID, player.ID, target.ID)
+	}
+}
+
+// countCards counts the faces of the cards, with wild cards counted in the color they are dealt in
+// whatever color they were played as
+func countCards(deck model.DeckDefinition, cards []model.Card) map[model.Card]int {
+	counts := map[model.Card]int{}
+	for _, card := range cards {
+		light := card.Face(false)
+		if deck.IsWild(light.Value) {
+			light.Color = deck.Wilds()
+		}
+		counts[light]++
+
+		if deck.Dark != nil {
+			dark := card.Face(true)
+			if deck.Dark.IsWild(dark.Value) {
+				dark.Color = deck.Dark.Wilds()
+			}
+			dark.Value = "dark " + dark.Value
+			counts[dark]++
+		}
+	}
+	return counts
+}
+
+func checkInvariants(t *testing.T, deck model.DeckDefinition, dealt map[model.Card]int, game *model.Game) {
+	cards := append(append([]model.Card{}, game.DrawPile...), game.DiscardPile...)
+	for _, player := range game.Players {
+		cards = append(cards, player.Cards...)
+	}
+
+	// No card is on the table more often than it was dealt, and none are missing
+	total := 0
+	for card, count := range countCards(deck, cards) {
+		if count > dealt[card] {
+			t.Fatalf("%d of %v on the table but the deck has %d", count, card, dealt[card])
+		}
+		total += count
+	}
+	for _, count := range dealt {
+		total -= count
+	}
+	if total != 0 {
+		t.Fatalf("%d cards appeared on the table", total)
+	}
+
+	if game.CurrentPlayer < 0 || game.CurrentPlayer >= len(game.Players) {
+		t.Fatalf("current player %d of %d", game.CurrentPlayer, len(game.Players))
+	}
+}
+
+// checkFinished tries every move on a finished game and checks none of them change it
+func checkFinished(t *testing.T, games *GameService, game *model.Game) {
+	before, err := games.database.LookupGameByID(game.ID)
+	require.Nil(t, err)
+
+	for _, player := range game.Players {
+		_, err = games.drawCard(context.Background(), game.ID, player.ID)
+		require.Equal(t, errGameNotPlaying, err)
+
+		for _, card := range model.Faces(player.Cards, game.DarkSide) {
+			_, err = games.playCard(context.Background(), game.ID, player.ID, card)
+			require.Equal(t, errGameNotPlaying, err)
+		}
+
+		_, err = games.logicCallUno(context.Background(), game.ID, player.ID, player.ID)
+		require.Equal(t, errGameNotPlaying, err)
+	}
+
+	after, err := games.database.LookupGameByID(game.ID)
+	require.Nil(t, err)
+	require.Equal(t, before, after)
+}
+
+func TestEngineInvariants(t *testing.T) {
+	seeds := int64(50)
+	if testing.Short() {
+		seeds = 5
+	}
+
+	for seed := int64(1); seed <= seeds; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+
+		setup := make([]byte, 3)
+		rng.Read(setup)
+		moves := make([]byte, 3*1000)
+		rng.Read(moves)
+
+		t.Run(fmt.Sprint("seed ", seed), func(t *testing.T) {
+			randomGame(t, seed, readSetup(setup), moves)
+		})
+	}
+}
+
+func TestEmptyPilesDoNotPanic(t *testing.T) {
+	game := &model.Game{Status: model.Playing, Players: []model.Player{{ID: "a", Cards: []model.Card{red3}}, {ID: "b", Cards: []model.Card{red3}}}}
+
+	_, card := drawTopCard(game)
+	require.Equal(t, model.Card{}, card)
+
+	_, ok := drawFromPile(testRand(), game)
+	require.False(t, ok)
+	require.Nil(t, reshuffleDiscardPile(testRand(), game).DrawPile)
+	require.True(t, isCardPlayable(game, red5))
+
+	// Nothing to draw passes the turn
+	require.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionDraw, playerID: "a"}))
+	require.Equal(t, 1, game.CurrentPlayer)
+	require.Len(t, game.Players[0].Cards, 1)
+}
+
+func FuzzEngine(f *testing.F) {
+	f.Add(int64(1), []byte{0, 0, 0}, []byte{0, 0, 0})
+	f.Add(int64(2), []byte{8, 3, 5}, []byte{3, 1, 7, 4, 2, 9, 5, 0, 1, 0, 0, 0})
+	f.Add(int64(3), []byte{2, 1, 6}, []byte{5, 1, 1, 5, 0, 0, 1, 1, 1, 4, 3, 2})
+
+	f.Fuzz(func(t *testing.T, seed int64, setup []byte, moves []byte) {
+		randomGame(t, seed, readSetup(setup), moves)
+	})
+}
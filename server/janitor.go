@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/model"
+	"go.uber.org/zap"
+)
+
+// clock tells the janitor what time it is, so tests can move time forward instead of waiting
+type clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// The kinds of cleanup counted by janitorCleanups
+const (
+	cleanupExpiredLobby  = "expired_lobby"
+	cleanupArchivedGame  = "archived_game"
+	cleanupDeletedPlayer = "deleted_player"
+)
+
+// janitorPolicy says how long things may sit idle before the janitor cleans them up.
+// A TTL of zero turns that cleanup off.
+type janitorPolicy struct {
+	// How often the janitor sweeps
+	Interval time.Duration
+	// Lobbies still waiting for players are deleted after this long without activity
+	LobbyTTL time.Duration
+	// Finished games are archived this long after the last activity, so players can still see the result
+	FinishedTTL time.Duration
+	// Players that are in no game are deleted this long after they were created
+	OrphanTTL time.Duration
+}
+
+var defaultJanitorPolicy = janitorPolicy{
+	Interval:    time.Minute,
+	LobbyTTL:    30 * time.Minute,
+	FinishedTTL: 10 * time.Minute,
+	OrphanTTL:   time.Hour,
+}
+
+// janitorPolicyFromEnv reads the policy from JANITOR_INTERVAL, JANITOR_LOBBY_TTL, JANITOR_FINISHED_TTL
+// and JANITOR_ORPHAN_TTL. Each is a duration such as 30m, anything unset keeps its default.
+func janitorPolicyFromEnv() (janitorPolicy, error) {
+	policy := defaultJanitorPolicy
+
+	settings := map[string]*time.Duration{
+		"JANITOR_INTERVAL":     &policy.Interval,
+		"JANITOR_LOBBY_TTL":    &policy.LobbyTTL,
+		"JANITOR_FINISHED_TTL": &policy.FinishedTTL,
+		"JANITOR_ORPHAN_TTL":   &policy.OrphanTTL,
+	}
+
+	if err := durationsFromEnv(settings); err != nil {
+		return policy, err
+	}
+
+	if policy.Interval == 0 {
+		return policy, fmt.Errorf("JANITOR_INTERVAL must be more than 0")
+	}
+
+	return policy, nil
+}
+
+// durationsFromEnv reads each environment variable that is set into its duration
+func durationsFromEnv(settings map[string]*time.Duration) error {
+	for name, setting := range settings {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("%s must be a duration like 30m, got %q", name, value)
+		}
+		*setting = duration
+	}
+
+	return nil
+}
+
+// janitorStats counts what one sweep cleaned up
+type janitorStats struct {
+	ExpiredLobbies int
+	ArchivedGames  int
+	DeletedPlayers int
+}
+
+// janitor cleans up abandoned lobbies, finished games and players that never made it into a game.
+// It only uses UnoDB, so it works on every backend, and every step is safe to repeat,
+// so each replica can run its own janitor against a shared database.
+type janitor struct {
+	database db.UnoDB
+	clock    clock
+	policy   janitorPolicy
+}
+
+func newJanitor(database db.UnoDB, clock clock, policy janitorPolicy) *janitor {
+	return &janitor{database: database, clock: clock, policy: policy}
+}
+
+// run sweeps every policy interval until stop is closed
+func (j *janitor) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(j.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			stats, err := j.sweep()
+			if err != nil {
+				logger.Error("Janitor sweep failed", zap.Error(err))
+			}
+			if stats != (janitorStats{}) {
+				logger.Info("Janitor swept",
+					zap.Int("expired_lobbies", stats.ExpiredLobbies),
+					zap.Int("archived_games", stats.ArchivedGames),
+					zap.Int("deleted_players", stats.DeletedPlayers))
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sweep cleans up everything the policy says is stale. It keeps going after an error
+// so one bad record cannot stop the rest from being cleaned up, and returns the first error.
+func (j *janitor) sweep() (janitorStats, error) {
+	var stats janitorStats
+	var firstErr error
+	fail := func(err error) {
+		janitorErrors.Inc()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	janitorSweeps.Inc()
+	now := j.clock.Now()
+
+	games, err := j.database.GetAllGames()
+	if err != nil {
+		fail(err)
+		return stats, firstErr
+	}
+
+	// Players still in a game that is kept are not orphans
+	seated := make(map[string]bool)
+
+	for _, game := range *games {
+		idle := now.Sub(lastActivity(game))
+
+		switch {
+		case game.Status == model.WaitingForPlayers && j.policy.LobbyTTL > 0 && idle > j.policy.LobbyTTL:
+			if err := j.database.DeleteGame(game.ID); err != nil {
+				fail(err)
+				break
+			}
+			stats.ExpiredLobbies++
+			janitorCleanups.WithLabelValues(cleanupExpiredLobby).Inc()
+			continue
+
+		case game.Status == model.Finished && j.policy.FinishedTTL > 0 && idle > j.policy.FinishedTTL:
+			archive := model.GameToArchive(game, now.UTC().Format(time.RFC3339))
+			if transcript, err := exportGame(&game, archive.ArchivedAt); err == nil {
+				archive.Transcript, _ = json.Marshal(transcript)
+			}
+
+			err := j.database.ArchiveGame(archive)
+			if errors.Is(err, db.ErrGameNotFound) {
+				// Another replica archived it first
+				continue
+			}
+			if err != nil {
+				fail(err)
+				break
+			}
+			stats.ArchivedGames++
+			janitorCleanups.WithLabelValues(cleanupArchivedGame).Inc()
+			continue
+		}
+
+		seated[game.Creator.ID] = true
+		for _, player := range game.Players {
+			seated[player.ID] = true
+		}
+	}
+
+	if j.policy.OrphanTTL == 0 {
+		return stats, firstErr
+	}
+
+	players, err := j.database.GetAllPlayers()
+	if err != nil {
+		fail(err)
+		return stats, firstErr
+	}
+
+	for _, player := range *players {
+		if seated[player.ID] || now.Sub(parseTimestamp(player.CreatedAt)) <= j.policy.OrphanTTL {
+			continue
+		}
+
+		if err := j.database.DeletePlayer(player.ID); err != nil {
+			fail(err)
+			continue
+		}
+		stats.DeletedPlayers++
+		janitorCleanups.WithLabelValues(cleanupDeletedPlayer).Inc()
+	}
+
+	return stats, firstErr
+}
+
+// lastActivity is the latest of when the game was created and when any of its players last checked in
+func lastActivity(game model.Game) time.Time {
+	latest := parseTimestamp(game.CreatedAt)
+
+	for _, player := range game.Players {
+		if updated := parseTimestamp(player.LastUpdated); updated.After(latest) {
+			latest = updated
+		}
+	}
+
+	return latest
+}
+
+// parseTimestamp reads an RFC3339 timestamp. Records from before timestamps were kept count as very old.
+func parseTimestamp(value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return parsed
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock that only moves when the test moves it
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func setupJanitor(policy janitorPolicy) (*janitor, db.UnoDB, *fakeClock) {
+	database := db.NewMockDB()
+	clock := &fakeClock{now: time.Now()}
+	return newJanitor(database, clock, policy), database, clock
+}
+
+func TestJanitorExpiresIdleLobbies(t *testing.T) {
+	j, database, clock := setupJanitor(janitorPolicy{Interval: time.Minute, LobbyTTL: 30 * time.Minute})
+
+	creator, _ := database.CreatePlayer("Creator")
+	abandoned, _ := database.CreateGame("Abandoned", creator.ID)
+	waiting, _ := database.CreateGame("Waiting", creator.ID)
+	playing, _ := database.CreateGame("Playing", creator.ID)
+	playing.Status = model.Playing
+	database.SaveGame(*playing)
+
+	clock.Advance(20 * time.Minute)
+
+	// Someone is still sitting in this lobby
+	waiting.Players[0].LastUpdated = clock.Now().Format(time.RFC3339)
+	database.SaveGame(*waiting)
+
+	stats, err := j.sweep()
+	assert.Nil(t, err)
+	assert.Equal(t, janitorStats{}, stats)
+
+	clock.Advance(15 * time.Minute)
+
+	stats, err = j.sweep()
+	assert.Nil(t, err)
+	assert.Equal(t, janitorStats{ExpiredLobbies: 1}, stats)
+	assert.False(t, database.HasGameByID(abandoned.ID))
+	assert.True(t, database.HasGameByID(waiting.ID))
+
+	// Games in play are never expired, no matter how quiet they are
+	assert.True(t, database.HasGameByID(playing.ID))
+}
+
+func TestJanitorArchivesFinishedGames(t *testing.T) {
+	j, database, clock := setupJanitor(janitorPolicy{Interval: time.Minute, FinishedTTL: 10 * time.Minute})
+
+	creator, _ := database.CreatePlayer("Creator")
+	joiner, _ := database.CreatePlayer("Joiner")
+	game, _ := database.CreateGame("Game 1", creator.ID)
+	game, _ = database.JoinGame(game.ID, joiner.ID)
+	game.Status = model.Finished
+	game.GameOver = "Joiner"
+	game.Players[0].Cards = []model.Card{{Color: "red", Value: "1"}}
+	database.SaveGame(*game)
+
+	clock.Advance(11 * time.Minute)
+
+	stats, err := j.sweep()
+	assert.Nil(t, err)
+	assert.Equal(t, janitorStats{ArchivedGames: 1}, stats)
+
+	_, err = database.LookupGameByID(game.ID)
+	assert.True(t, errors.Is(err, db.ErrGameNotFound))
+
+	archive, err := database.LookupArchive(game.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Joiner", archive.Winner)
+	assert.Equal(t, clock.Now().UTC().Format(time.RFC3339), archive.ArchivedAt)
+	assert.Equal(t, 1, archive.Players[0].CardsLeft)
+
+	// The game was never dealt, so it has no transcript to keep
+	assert.Empty(t, archive.Transcript)
+}
+
+func TestJanitorDeletesOrphanedPlayers(t *testing.T) {
+	j, database, clock := setupJanitor(janitorPolicy{Interval: time.Minute, OrphanTTL: time.Hour})
+
+	creator, _ := database.CreatePlayer("Creator")
+	joiner, _ := database.CreatePlayer("Joiner")
+	orphan, _ := database.CreatePlayer("Orphan")
+	game, _ := database.CreateGame("Game 1", creator.ID)
+	database.JoinGame(game.ID, joiner.ID)
+
+	// A player who was just created may still be on their way into a game
+	stats, err := j.sweep()
+	assert.Nil(t, err)
+	assert.Equal(t, janitorStats{}, stats)
+
+	clock.Advance(2 * time.Hour)
+
+	stats, err = j.sweep()
+	assert.Nil(t, err)
+	assert.Equal(t, janitorStats{DeletedPlayers: 1}, stats)
+
+	_, err = database.LookupPlayer(orphan.ID)
+	assert.True(t, errors.Is(err, db.ErrPlayerNotFound))
+	_, err = database.LookupPlayer(creator.ID)
+	assert.Nil(t, err)
+	_, err = database.LookupPlayer(joiner.ID)
+	assert.Nil(t, err)
+}
+
+func TestJanitorCleansUpAfterCleanedGames(t *testing.T) {
+	policy := janitorPolicy{Interval: time.Minute, LobbyTTL: 30 * time.Minute, OrphanTTL: time.Hour}
+	j, database, clock := setupJanitor(policy)
+
+	creator, _ := database.CreatePlayer("Creator")
+	database.CreateGame("Abandoned", creator.ID)
+
+	// Once the lobby is gone, so is the player who only existed to create it
+	clock.Advance(2 * time.Hour)
+	stats, err := j.sweep()
+	assert.Nil(t, err)
+	assert.Equal(t, janitorStats{ExpiredLobbies: 1, DeletedPlayers: 1}, stats)
+}
+
+func TestJanitorDisabledPolicies(t *testing.T) {
+	j, database, clock := setupJanitor(janitorPolicy{Interval: time.Minute})
+
+	creator, _ := database.CreatePlayer("Creator")
+	game, _ := database.CreateGame("Game 1", creator.ID)
+	database.CreatePlayer("Orphan")
+
+	clock.Advance(24 * time.Hour)
+	stats, err := j.sweep()
+	assert.Nil(t, err)
+	assert.Equal(t, janitorStats{}, stats)
+	assert.True(t, database.HasGameByID(game.ID))
+}
+
+func TestJanitorMetrics(t *testing.T) {
+	j, database, clock := setupJanitor(janitorPolicy{Interval: time.Minute, LobbyTTL: time.Minute})
+
+	creator, _ := database.CreatePlayer("Creator")
+	database.CreateGame("Game 1", creator.ID)
+	before := expiredLobbies()
+
+	clock.Advance(time.Hour)
+	j.sweep()
+
+	assert.Equal(t, before+1, expiredLobbies())
+}
+
+// expiredLobbies reads the running total the janitor publishes
+func expiredLobbies() float64 {
+	return testutil.ToFloat64(janitorCleanups.WithLabelValues(cleanupExpiredLobby))
+}
+
+func TestJanitorRunStops(t *testing.T) {
+	j, _, _ := setupJanitor(janitorPolicy{Interval: time.Millisecond})
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		j.run(stop)
+		close(done)
+	}()
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("janitor did not stop")
+	}
+}
+
+func TestJanitorPolicyFromEnv(t *testing.T) {
+	policy, err := janitorPolicyFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, defaultJanitorPolicy, policy)
+
+	os.Setenv("JANITOR_LOBBY_TTL", "5m")
+	os.Setenv("JANITOR_ORPHAN_TTL", "0")
+	defer os.Unsetenv("JANITOR_LOBBY_TTL")
+	defer os.Unsetenv("JANITOR_ORPHAN_TTL")
+
+	policy, err = janitorPolicyFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Minute, policy.LobbyTTL)
+	assert.Equal(t, time.Duration(0), policy.OrphanTTL)
+	assert.Equal(t, defaultJanitorPolicy.FinishedTTL, policy.FinishedTTL)
+
+	os.Setenv("JANITOR_INTERVAL", "soon")
+	defer os.Unsetenv("JANITOR_INTERVAL")
+	_, err = janitorPolicyFromEnv()
+	assert.NotNil(t, err)
+}
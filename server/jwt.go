@@ -10,9 +10,6 @@ import (
 	//"github.com/google/uuid"
 )
 
-// we can have this signkey be whatever we want.
-const signKey = "^s@m&R@n&om,St)("
-
 // example usage. Change the name of the function to main and type "go run jwt.go" in this directory to see it working.
 // DO NOT commit after renaming this fucntion to main, as this will break the build.
 /*
@@ -38,7 +35,7 @@ func exampleUsage() {
 
 	// techincally, you can just parse the token, but token.Claims.(jwt.MapClaims) looks ugly and is long to type
 	// I think we will mostly use getValidClaims, but if for whatever reason you need the whole token, this is how you would do it and access the claims.
-	token, valid := parseJWT(createdToken, signKey)
+	token, valid := parseJWT(createdToken, tokenSecret)
 
 	if valid {
 		fmt.Println(token.Claims.(jwt.MapClaims)["name"])
@@ -48,7 +45,7 @@ func exampleUsage() {
 }
 */
 
-// function to create a new jwt based on a name, gameid, and a signKey
+// function to create a new jwt based on a name and gameid, signed with the configured tokenSecret
 // note, when this is merged with the db branch, we may want to combine "name" and "userid" into one "player" object
 func newJWT(name string, userid string) (string, error) {
 	// Create the token
@@ -65,7 +62,7 @@ func newJWT(name string, userid string) (string, error) {
 		"userid": userid,
 	}
 	// Sign and get the complete encoded token as a string
-	tokenString, err := token.SignedString( []byte(signKey) )
+	tokenString, err := token.SignedString( []byte(tokenSecret) )
 	return tokenString, err
 }
 
@@ -93,7 +90,7 @@ func parseJWT(myToken string, signKey string) (*jwt.Token, bool) {
 func getValidClaims(myToken string) (jwt.MapClaims, bool) {
 
 	// get the token, and see if it is valid
-	token, valid := parseJWT(myToken, signKey)
+	token, valid := parseJWT(myToken, tokenSecret)
 
 	// set up an empty claims
 	var claims jwt.MapClaims
@@ -138,16 +135,11 @@ func makeJWTPayload(EncodedJWT string) map[string]interface{} {
 	return payload
 }
 
-func getPlayerFromHeader(authHeader string) (*model.Player, bool, error){
-    database, err := db.GetDb()
-	if err != nil {
-		return nil, false, err
-	}
-    
+func getPlayerFromHeader(database db.UnoDB, authHeader string) (*model.Player, bool, error){
     claims, validUser := getValidClaimsFromHeader(authHeader)
     
     if !validUser {
-        return nil, false, err
+        return nil, false, nil
     }
     
     player, err := database.LookupPlayer(claims["userid"].(string))
@@ -28,7 +28,7 @@ func TestJWT (t *testing.T) {
 	assert.Equal(t, nil, err)
 	
 	// test the parse function!
-	validToken, tokenIsValid := parseJWT(encodedToken, signKey)
+	validToken, tokenIsValid := parseJWT(encodedToken, tokenSecret)
 	
 	// the token should be valid, as it wasn't changed by the user.
 	assert.Equal(t, true, tokenIsValid)
@@ -69,7 +69,7 @@ func TestJWT (t *testing.T) {
 	badEncodedToken := "modify" + encodedToken
 	
 	// try parsing JWT
-	invalidToken, tokenIsInvalid := parseJWT(badEncodedToken, signKey)
+	invalidToken, tokenIsInvalid := parseJWT(badEncodedToken, tokenSecret)
 	
 	// false means invalid
 	assert.Equal(t, false, tokenIsInvalid)
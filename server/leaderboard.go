@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Time windows the leaderboard can be limited to
+var leaderboardWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// Leaderboard sizes
+const (
+	defaultLeaderboardSize = 20
+	maxLeaderboardSize     = 100
+)
+
+// leaderboardQuery is what GET /api/leaderboard was asked for
+type leaderboardQuery struct {
+	// One of leaderboardWindows
+	Window string
+	// Rank accounts, everyone who played under the same name, or single players
+	ByPlayer bool
+	Limit    int
+}
+
+// leaderboardEntry is one ranked row of the leaderboard
+type leaderboardEntry struct {
+	Rank int `json:"rank"`
+	model.PlayerStats
+}
+
+// recordGameResults stores how everyone did once a game is over.
+// The move that ended the game has already been saved, so a failure is only logged.
+func recordGameResults(ctx context.Context, database db.UnoDB, game *model.Game, finishedAt time.Time) {
+	err := database.AddGameResults(model.GameToResults(*game, finishedAt.UTC().Format(time.RFC3339)))
+
+	if err != nil {
+		logFrom(ctx).Error("Could not record the results of a game", zap.String("game_id", game.ID), zap.Error(err))
+	}
+}
+
+// parseLeaderboardQuery reads ?window=&by=&limit= for the leaderboard
+func parseLeaderboardQuery(params url.Values) (leaderboardQuery, error) {
+	query := leaderboardQuery{Window: "all", Limit: defaultLeaderboardSize}
+
+	if window := params.Get("window"); window != "" {
+		query.Window = strings.ToLower(window)
+		if _, ok := leaderboardWindows[query.Window]; !ok {
+			return query, fmt.Errorf("Unknown window '%s', use day, week, month, year or all", window)
+		}
+	}
+
+	switch by := strings.ToLower(params.Get("by")); by {
+	case "", "account":
+	case "player":
+		query.ByPlayer = true
+	default:
+		return query, fmt.Errorf("Cannot rank by '%s', use account or player", params.Get("by"))
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxLeaderboardSize {
+			return query, fmt.Errorf("limit must be a number from 1 to %d", maxLeaderboardSize)
+		}
+		query.Limit = n
+	}
+
+	return query, nil
+}
+
+// since is when the window starts, or the zero time for all time
+func (q leaderboardQuery) since(now time.Time) time.Time {
+	if length := leaderboardWindows[q.Window]; length > 0 {
+		return now.Add(-length)
+	}
+	return time.Time{}
+}
+
+// addUpStats adds results up per account, or per player when byPlayer is set.
+// Names come from each account's most recent game.
+func addUpStats(results []model.PlayerResult, byPlayer bool) []model.PlayerStats {
+	sorted := append([]model.PlayerResult{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].FinishedAt < sorted[j].FinishedAt
+	})
+
+	totals := make(map[string]*model.PlayerStats)
+	handSizes := make(map[string]int)
+	order := make([]string, 0)
+
+	for _, result := range sorted {
+		key := result.Account
+		if byPlayer {
+			key = result.PlayerID
+		}
+
+		stats, ok := totals[key]
+		if !ok {
+			stats = &model.PlayerStats{Account: result.Account}
+			if byPlayer {
+				stats.PlayerID = result.PlayerID
+			}
+			totals[key] = stats
+			order = append(order, key)
+		}
+
+		stats.Name = result.Name
+		stats.GamesPlayed++
+		if result.Won {
+			stats.GamesWon++
+		}
+		stats.CardsPlayed += result.Tally.CardsPlayed
+		stats.DrawsTaken += result.Tally.DrawsTaken
+		stats.UnoCalls += result.Tally.UnoCalls
+		stats.Penalties += result.Tally.Penalties
+		handSizes[key] += result.FinalHandSize
+	}
+
+	all := make([]model.PlayerStats, 0, len(order))
+	for _, key := range order {
+		stats := totals[key]
+		stats.AverageHandSize = float64(handSizes[key]) / float64(stats.GamesPlayed)
+		all = append(all, *stats)
+	}
+
+	return all
+}
+
+// rankStats orders stats by wins, then win rate, then games played, then name
+func rankStats(stats []model.PlayerStats) []leaderboardEntry {
+	sort.SliceStable(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.GamesWon != b.GamesWon {
+			return a.GamesWon > b.GamesWon
+		}
+		// a won a larger share than b, compared without dividing
+		if a.GamesWon*b.GamesPlayed != b.GamesWon*a.GamesPlayed {
+			return a.GamesWon*b.GamesPlayed > b.GamesWon*a.GamesPlayed
+		}
+		if a.GamesPlayed != b.GamesPlayed {
+			return a.GamesPlayed > b.GamesPlayed
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Account+a.PlayerID < b.Account+b.PlayerID
+	})
+
+	entries := make([]leaderboardEntry, len(stats))
+	for i, s := range stats {
+		entries[i] = leaderboardEntry{Rank: i + 1, PlayerStats: s}
+	}
+	return entries
+}
+
+// getLeaderboard ranks everyone who finished a game in the window
+func (s *server) getLeaderboard(c echo.Context) error {
+	query, err := parseLeaderboardQuery(c.QueryParams())
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	database := s.games.databaseFor(c.Request().Context())
+
+	since := query.since(s.games.clock.Now())
+	results, err := database.GetGameResults(since)
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not build the leaderboard")
+	}
+
+	entries := rankStats(addUpStats(*results, query.ByPlayer))
+	if len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+
+	response := map[string]interface{}{
+		"window":      query.Window,
+		"leaderboard": entries,
+	}
+	if !since.IsZero() {
+		response["since"] = since.UTC().Format(time.RFC3339)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
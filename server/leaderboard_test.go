@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLeaderboardQuery(t *testing.T) {
+	query, err := parseLeaderboardQuery(url.Values{})
+	assert.Nil(t, err)
+	assert.Equal(t, leaderboardQuery{Window: "all", Limit: defaultLeaderboardSize}, query)
+	assert.True(t, query.since(time.Now()).IsZero())
+
+	query, err = parseLeaderboardQuery(url.Values{"window": {"Week"}, "by": {"player"}, "limit": {"5"}})
+	assert.Nil(t, err)
+	assert.Equal(t, leaderboardQuery{Window: "week", ByPlayer: true, Limit: 5}, query)
+
+	now := time.Date(2020, 12, 8, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 12, 1, 12, 0, 0, 0, time.UTC), query.since(now))
+
+	for _, bad := range []url.Values{
+		{"window": {"decade"}},
+		{"by": {"team"}},
+		{"limit": {"0"}},
+		{"limit": {"1000"}},
+	} {
+		_, err = parseLeaderboardQuery(bad)
+		assert.NotNil(t, err, "%v should be rejected", bad)
+	}
+}
+
+func TestAddUpStats(t *testing.T) {
+	results := []model.PlayerResult{
+		{GameID: "2", PlayerID: "p3", Account: "ann", Name: "ANN", FinishedAt: "2020-12-02T10:00:00Z", FinalHandSize: 4,
+			Tally: model.PlayerTally{CardsPlayed: 3, DrawsTaken: 2}},
+		{GameID: "1", PlayerID: "p1", Account: "ann", Name: "Ann", FinishedAt: "2020-12-01T10:00:00Z", Won: true,
+			Tally: model.PlayerTally{CardsPlayed: 7, UnoCalls: 1}},
+		{GameID: "1", PlayerID: "p2", Account: "bob", Name: "Bob", FinishedAt: "2020-12-01T10:00:00Z", FinalHandSize: 3,
+			Tally: model.PlayerTally{CardsPlayed: 6, DrawsTaken: 5, Penalties: 1}},
+	}
+
+	// Both of Ann's players count towards her account, which goes by her latest name
+	assert.Equal(t, []model.PlayerStats{
+		{Account: "ann", Name: "ANN", GamesPlayed: 2, GamesWon: 1, CardsPlayed: 10, DrawsTaken: 2, UnoCalls: 1, AverageHandSize: 2},
+		{Account: "bob", Name: "Bob", GamesPlayed: 1, CardsPlayed: 6, DrawsTaken: 5, Penalties: 1, AverageHandSize: 3},
+	}, addUpStats(results, false))
+
+	byPlayer := addUpStats(results, true)
+	assert.Len(t, byPlayer, 3)
+	assert.Equal(t, "p1", byPlayer[0].PlayerID)
+	assert.Equal(t, 1, byPlayer[0].GamesPlayed)
+}
+
+func TestRankStats(t *testing.T) {
+	entries := rankStats([]model.PlayerStats{
+		{Account: "casual", Name: "Casual", GamesPlayed: 1},
+		{Account: "grinder", Name: "Grinder", GamesPlayed: 10, GamesWon: 2},
+		{Account: "lucky", Name: "Lucky", GamesPlayed: 2, GamesWon: 2},
+		{Account: "steady", Name: "Steady", GamesPlayed: 4, GamesWon: 2},
+		{Account: "newbie", Name: "Newbie", GamesPlayed: 1},
+	})
+
+	names := []string{}
+	for i, entry := range entries {
+		assert.Equal(t, i+1, entry.Rank)
+		names = append(names, entry.Name)
+	}
+
+	// Most wins first, then the best win rate, then the most games, then by name
+	assert.Equal(t, []string{"Lucky", "Steady", "Grinder", "Casual", "Newbie"}, names)
+}
+
+func TestFinishingGameRecordsResults(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, winner := setupGameWithPlayer(database)
+	loser, _ := database.CreatePlayer(uuid.New().String())
+	game, _ = database.JoinGame(game.ID, loser.ID)
+
+	game.Status = model.Playing
+	game.CurrentPlayer = 0
+	game.DiscardPile = []model.Card{{Color: "red", Value: "2"}}
+	game.Players[0].Cards = []model.Card{{Color: "red", Value: "5"}}
+	game.Players[1].Cards = []model.Card{{Color: "blue", Value: "1"}, {Color: "blue", Value: "2"}}
+	game.Players[1].Tally.DrawsTaken = 3
+	database.SaveGame(*game)
+
+	game, err := games.playCard(context.Background(), game.ID, winner.ID, model.Card{Color: "red", Value: "5"})
+	assert.Nil(t, err)
+	assert.Equal(t, model.Finished, game.Status)
+
+	results, _ := database.GetGameResults(time.Now().Add(-time.Minute))
+	recorded := map[string]model.PlayerResult{}
+	for _, result := range *results {
+		if result.GameID == game.ID {
+			recorded[result.PlayerID] = result
+		}
+	}
+
+	assert.Len(t, recorded, 2)
+	assert.True(t, recorded[winner.ID].Won)
+	assert.Equal(t, 1, recorded[winner.ID].Tally.CardsPlayed)
+	assert.False(t, recorded[loser.ID].Won)
+	assert.Equal(t, 2, recorded[loser.ID].FinalHandSize)
+	assert.Equal(t, 3, recorded[loser.ID].Tally.DrawsTaken)
+
+	// The loser shows up on the leaderboard under their account
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/leaderboard?window=day&limit=100", nil)
+	rec := httptest.NewRecorder()
+	assert.Nil(t, newServer(games).getLeaderboard(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Window      string             `json:"window"`
+		Since       string             `json:"since"`
+		Leaderboard []leaderboardEntry `json:"leaderboard"`
+	}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "day", body.Window)
+	assert.NotEmpty(t, body.Since)
+
+	found := false
+	for _, entry := range body.Leaderboard {
+		if entry.Account == model.AccountKey(loser.Name) {
+			found = true
+			assert.Equal(t, 1, entry.GamesPlayed)
+			assert.Equal(t, 0, entry.GamesWon)
+		}
+	}
+	assert.True(t, found)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/jak103/uno/model"
+	"github.com/jak103/uno/ratelimit"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var errTooManyGames = errors.New("You already have as many open games as you can, finish or leave one first")
+
+// The route groups in setupRoutes, each limited on its own
+const (
+	// Reading games, decks, the leaderboard and matchmaking tickets
+	limitPublic = "public"
+	// Anything that creates a player: creating, joining or matching into a game, and queueing
+	limitCreate = "create"
+	// Everything a player does with their token
+	limitPlayer = "player"
+)
+
+var rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "uno_rate_limited_total",
+	Help: "Requests turned away for going over a rate limit, by route group and what was limited.",
+}, []string{"group", "by"})
+
+func init() {
+	metricsRegistry.MustRegister(rateLimited)
+}
+
+// limiter holds the buckets requests take from, and each route group's rates
+type limiter struct {
+	store ratelimit.Store
+	// Per IP, by route group
+	ipRates map[string]ratelimit.Rate
+	// Per player, for the routes that need a token
+	playerRate ratelimit.Rate
+}
+
+func newLimiter(store ratelimit.Store, cfg config) *limiter {
+	return &limiter{
+		store: store,
+		ipRates: map[string]ratelimit.Rate{
+			limitPublic: cfg.PublicRate,
+			limitCreate: cfg.CreateRate,
+			limitPlayer: cfg.PublicRate,
+		},
+		playerRate: cfg.PlayerRate,
+	}
+}
+
+// newLimiterStore is where the config says to keep the buckets
+func newLimiterStore(cfg config) (ratelimit.Store, error) {
+	switch cfg.RateLimitStore {
+	case limitInMemory:
+		return ratelimit.NewMemoryStore(), nil
+	case limitInRedis:
+		return ratelimit.NewRedisStore(cfg.Backend["REDIS_URL"])
+	}
+	return nil, fmt.Errorf("%q is not a rate limit store", cfg.RateLimitStore)
+}
+
+// The places buckets can be kept
+const (
+	limitInMemory = "memory"
+	limitInRedis  = "redis"
+)
+
+// limitByIP limits how often each address can call the group's routes
+func (s *server) limitByIP(group string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if s.limiter == nil {
+				return next(c)
+			}
+			return s.take(c, next, group, "ip", "ip:"+group+":"+c.RealIP(), s.limiter.ipRates[group])
+		}
+	}
+}
+
+// limitByPlayer limits how often each player can call the group's routes. It goes after the JWT middleware.
+func (s *server) limitByPlayer(group string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			playerID, err := getPlayerFromContext(c)
+			if s.limiter == nil || err != nil {
+				return next(c)
+			}
+			return s.take(c, next, group, "player", "player:"+group+":"+playerID, s.limiter.playerRate)
+		}
+	}
+}
+
+// take lets the request through when its bucket has a token, and turns it away with when to try again
+// when it does not. The limits are there to protect the server, so when the store cannot be reached
+// requests are let through rather than turned away.
+func (s *server) take(c echo.Context, next echo.HandlerFunc, group string, by string, key string, rate ratelimit.Rate) error {
+	wait, err := s.limiter.store.Take(key, rate, s.games.clock.Now())
+	if err != nil {
+		logFrom(c.Request().Context()).Warn("Could not check a rate limit", zap.String("group", group), zap.Error(err))
+		return next(c)
+	}
+
+	if wait > 0 {
+		rateLimited.WithLabelValues(group, by).Inc()
+
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+		return c.JSON(http.StatusTooManyRequests, fmt.Sprintf("Too many requests, try again in %d seconds", seconds))
+	}
+
+	return next(c)
+}
+
+// checkOpenGames turns away a creator who already has as many unfinished games as the server allows
+func (s *GameService) checkOpenGames(ctx context.Context, creatorName string) error {
+	if s.maxOpenGames <= 0 {
+		return nil
+	}
+
+	ctx, span := startSpan(ctx, "checkOpenGames", "", "")
+	defer span.End()
+
+	games, err := s.databaseFor(ctx).GetAllGames()
+	if err != nil {
+		return err
+	}
+
+	account := model.AccountKey(creatorName)
+	open := 0
+	for _, game := range *games {
+		if game.Status != model.Finished && model.AccountKey(game.Creator.Name) == account {
+			open++
+		}
+	}
+
+	if open >= s.maxOpenGames {
+		return errTooManyGames
+	}
+	return nil
+}
+
+// checkSeat makes sure the game exists and has a seat, before a player is made to sit in it
+func (s *GameService) checkSeat(ctx context.Context, gameID string) error {
+	ctx, span := startSpan(ctx, "checkSeat", gameID, "")
+	defer span.End()
+
+	game, err := s.databaseFor(ctx).LookupGameByID(gameID)
+	if err != nil {
+		return errGameNotFound
+	}
+
+	if len(game.Players) >= model.MaxPlayers {
+		return errGameFull
+	}
+	return nil
+}
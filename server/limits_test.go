@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jak103/uno/model"
+	"github.com/jak103/uno/ratelimit"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// limitedServer is a server whose limits are low enough to hit in a test
+func limitedServer(games *GameService) *echo.Echo {
+	cfg := defaultConfig()
+	cfg.PublicRate = ratelimit.Rate{Limit: 5, Per: time.Minute}
+	cfg.CreateRate = ratelimit.Rate{Limit: 2, Per: time.Minute}
+	cfg.PlayerRate = ratelimit.Rate{Limit: 3, Per: time.Minute}
+
+	api := newServer(games)
+	api.limiter = newLimiter(ratelimit.NewMemoryStore(), cfg)
+
+	e := echo.New()
+	e.Use(middleware.BodyLimit("1K"))
+	api.setupRoutes(e)
+	return e
+}
+
+func call(e *echo.Echo, method string, path string, ip string, token string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Token "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCreatingIsLimitedPerAddress(t *testing.T) {
+	games := newTestService()
+	e := limitedServer(games)
+	create := func(ip string) *httptest.ResponseRecorder {
+		return call(e, http.MethodPost, "/api/games", ip, "", `{"name": "Game", "creator": "Player `+ip+`"}`)
+	}
+
+	assert.Equal(t, http.StatusOK, create("192.0.2.1").Code)
+	assert.Equal(t, http.StatusOK, create("192.0.2.1").Code)
+
+	rec := create("192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+
+	// Nothing was made for the request that was turned away
+	players, err := games.database.GetAllPlayers()
+	require.Nil(t, err)
+	assert.Len(t, *players, 2)
+
+	// Other addresses and other route groups have buckets of their own
+	assert.Equal(t, http.StatusOK, create("192.0.2.2").Code)
+	assert.Equal(t, http.StatusOK, call(e, http.MethodGet, "/api/games", "192.0.2.1", "", "").Code)
+
+	// A token comes back every 30 seconds
+	games.clock.(*fakeClock).Advance(30 * time.Second)
+	assert.Equal(t, http.StatusOK, create("192.0.2.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, create("192.0.2.1").Code)
+}
+
+func TestPlayersAreLimited(t *testing.T) {
+	games := newTestService()
+	e := limitedServer(games)
+	game, player := setupGameWithPlayer(games.database)
+	token := generateToken(player)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, call(e, http.MethodGet, "/api/games/"+game.ID, "192.0.2.1", token, "").Code)
+	}
+
+	// Moving to another address does not get the player more requests
+	rec := call(e, http.MethodGet, "/api/games/"+game.ID, "192.0.2.2", token, "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "20", rec.Header().Get("Retry-After"))
+}
+
+func TestJoiningAFullGameMakesNoPlayer(t *testing.T) {
+	games := newTestService()
+	e := echo.New()
+	newServer(games).setupRoutes(e)
+
+	game := seatPlayers(t, games, model.GameRules{}, playerNames(model.MaxPlayers)...)
+
+	before, _ := games.database.GetAllPlayers()
+
+	rec := call(e, http.MethodPost, "/api/games/"+game.ID+"/join", "192.0.2.1", "", `{"playerName": "Late"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = call(e, http.MethodPost, "/api/games/missing/join", "192.0.2.1", "", `{"playerName": "Lost"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	after, _ := games.database.GetAllPlayers()
+	assert.Len(t, *after, len(*before))
+}
+
+func TestOpenGamesPerCreatorAreCapped(t *testing.T) {
+	games := newTestService()
+	games.maxOpenGames = 2
+	e := echo.New()
+	newServer(games).setupRoutes(e)
+
+	create := func(creator string) int {
+		return call(e, http.MethodPost, "/api/games", "192.0.2.1", "", `{"name": "Game", "creator": "`+creator+`"}`).Code
+	}
+
+	assert.Equal(t, http.StatusOK, create("Alice"))
+	assert.Equal(t, http.StatusOK, create("alice"))
+	assert.Equal(t, http.StatusTooManyRequests, create("Alice"))
+	assert.Equal(t, http.StatusOK, create("Bob"))
+
+	// Finishing a game frees up a slot
+	all, err := games.database.GetAllGames()
+	require.Nil(t, err)
+	for _, game := range *all {
+		if game.Creator.Name == "Alice" {
+			game.Status = model.Finished
+			require.Nil(t, games.database.SaveGame(game))
+		}
+	}
+	assert.Equal(t, http.StatusOK, create("Alice"))
+}
+
+func TestLargeBodiesAreRefused(t *testing.T) {
+	e := limitedServer(newTestService())
+
+	body := `{"name": "` + strings.Repeat("x", 2048) + `", "creator": "Player"}`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, call(e, http.MethodPost, "/api/games", "192.0.2.1", "", body).Code)
+}
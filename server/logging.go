@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logLevel is the least severe level logged. It can be changed while the server runs
+// by PUTting {"level": "debug"} to /api/admin/log-level with an admin token.
+var logLevel = zap.NewAtomicLevelAt(zap.InfoLevel)
+
+// logger writes JSON lines to stdout. Code handling a request should log with logFrom instead,
+// so its lines carry the request, game and player they are about.
+var logger = newLogger()
+
+func newLogger() *zap.Logger {
+	config := zap.NewProductionConfig()
+	config.Level = logLevel
+	config.EncoderConfig.TimeKey = "time"
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	built, err := config.Build()
+	if err != nil {
+		panic(err)
+	}
+	return built
+}
+
+type loggerKey struct{}
+
+// withLogFields gives the context a logger that adds the fields to every line it writes
+func withLogFields(ctx context.Context, fields ...zap.Field) context.Context {
+	return context.WithValue(ctx, loggerKey{}, contextLogger(ctx).With(fields...))
+}
+
+func contextLogger(ctx context.Context) *zap.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return l
+	}
+	return logger
+}
+
+// logFrom is the logger for whatever the context is doing, with the trace it is part of
+func logFrom(ctx context.Context) *zap.Logger {
+	l := contextLogger(ctx)
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		l = l.With(zap.String("trace_id", span.TraceID().String()), zap.String("span_id", span.SpanID().String()))
+	}
+	return l
+}
+
+// logRequests gives each request a logger carrying its request ID, route and the game or ticket
+// it is about, and logs a line when it is done
+func logRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		req := c.Request()
+
+		fields := []zap.Field{
+			zap.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
+			zap.String("method", req.Method),
+			zap.String("route", c.Path()),
+		}
+		if id := c.Param("id"); id != "" {
+			fields = append(fields, zap.String(routeIDField(c.Path()), id))
+		}
+		c.SetRequest(req.WithContext(withLogFields(req.Context(), fields...)))
+
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		// The logger now also has the player, when the request had a token
+		log := logFrom(c.Request().Context())
+		status := c.Response().Status
+		if status >= http.StatusInternalServerError {
+			log.Error("Request failed", zap.Int("status", status), zap.Duration("took", time.Since(start)))
+		} else {
+			log.Info("Request", zap.Int("status", status), zap.Duration("took", time.Since(start)))
+		}
+		return nil
+	}
+}
+
+// tagPlayer adds the player a request's token was issued to to its logger and span.
+// It goes after the JWT middleware.
+func tagPlayer(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if playerID, err := getPlayerFromContext(c); err == nil {
+			ctx := withLogFields(c.Request().Context(), zap.String("player_id", playerID))
+			trace.SpanFromContext(ctx).SetAttributes(playerIDKey.String(playerID))
+			c.SetRequest(c.Request().WithContext(ctx))
+		}
+		return next(c)
+	}
+}
+
+// routeIDField is what the :id in a route names
+func routeIDField(route string) string {
+	if strings.HasPrefix(route, "/api/matchmaking/") {
+		return "ticket_id"
+	}
+	return "game_id"
+}
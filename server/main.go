@@ -2,49 +2,207 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
 )
 
 func main() {
 	fmt.Println("USU - UNO v0.0.0")
 
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	// New Echo server
 	e := echo.New()
 
+	if err := cfg.applyBackend(); err != nil {
+		e.Logger.Fatal(err)
+	}
+	tokenSecret = cfg.TokenSecret
+
 	// Setup middleware
 	//e.File("/", "/client/dist/index.html")
 
-	//e.Use(middleware.Logger())
+	logLevel.SetLevel(cfg.LogLevel)
+	defer logger.Sync()
+
+	// Every request gets an ID, a span and a logger that carries both
+	e.Use(middleware.RequestID())
+	e.Use(traceRequests)
+	e.Use(logRequests)
+	e.Use(recordRequests)
+	e.Use(middleware.BodyLimit(cfg.BodyLimit))
+
+	// Rate limits are per address, so only trust the address headers set by a load balancer
+	e.IPExtractor = echo.ExtractIPDirect()
+	if cfg.ProxyHeaders {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	}
 	e.Use(middleware.Gzip())
 	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: cfg.CORSOrigins,
+	}))
 
 	e.Use(middleware.StaticWithConfig(middleware.StaticConfig{
-		Root:  "/client/dist/",
+		Root:  cfg.StaticDir,
 		HTML5: true,
 	}))
 
+	backend, err := db.GetDb()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	database := observeDatabase(backend, strings.ToLower(backend.Name()))
+
+	bus, err := events.GetBus()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	games := newGameService(database, systemClock{}, newLockedRand(time.Now().UnixNano()), bus)
+	games.maxOpenGames = cfg.MaxOpenGames
+
+	limits, err := newLimiterStore(cfg)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
 	// Setup routes
-	setupRoutes(e)
+	api := newServer(games)
+	api.limiter = newLimiter(limits, cfg)
+	api.adminKey = cfg.AdminKey
+	api.setupRoutes(e)
+
+	// Games can be dealt with the decks in the decks directory as well as the standard deck
+	if err := games.loadDecks(cfg.DecksDir); err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	// Clean up stale games in the background
+	policy, err := janitorPolicyFromEnv()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	// The database is only disconnected once the background workers are done with it
+	var workers sync.WaitGroup
+
+	stopJanitor := make(chan struct{})
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		newJanitor(database, games.clock, policy).run(stopJanitor)
+	}()
+
+	// Match queued players into games in the background
+	matchmaking, err := matcherPolicyFromEnv()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	stopMatcher := make(chan struct{})
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		newMatcher(games, matchmaking).run(stopMatcher)
+	}()
+
+	// Stop on Ctrl-C, or when the container is stopped
+	ctx, stop := context.WithCancel(context.Background())
+
+	stopTracing, err := setupTracing(ctx, cfg)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-quit
+		stop()
+	}()
 
 	// Start server
-	e.Logger.Fatal(e.Start(":8080"))
+	err = api.serve(ctx, e, cfg, func() {
+		close(stopJanitor)
+		close(stopMatcher)
+		workers.Wait()
+		events.Close()
+		limits.Close()
+		db.Disconnect()
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	defer db.Disconnect()
+		flush, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := stopTracing(flush); err != nil {
+			logger.Error("Could not send the last spans", zap.Error(err))
+		}
+	})
 
-	if err := e.Shutdown(ctx); err != nil {
+	if err != nil {
 		e.Logger.Fatal(err)
 	}
 }
+
+// serve runs the API until ctx is done, then shuts down: the server stops taking connections,
+// event streams are ended so their clients reconnect elsewhere, and requests in flight
+// get until the shutdown timeout to finish. Then the cleanups are run in order.
+// It serves HTTPS when the config has a certificate and key.
+func (s *server) serve(ctx context.Context, e *echo.Echo, cfg config, cleanups ...func()) error {
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
+
+	stopped := make(chan error, 1)
+	go func() {
+		if cfg.TLSCert != "" {
+			stopped <- e.StartTLS(cfg.Listen, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			stopped <- e.Start(cfg.Listen)
+		}
+	}()
+
+	select {
+	case err := <-stopped:
+		// The server could not start
+		return err
+	case <-ctx.Done():
+	}
+
+	e.Logger.Info("Shutting down")
+
+	timeout, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	close(s.closing)
+
+	if err := e.Shutdown(timeout); err != nil {
+		return err
+	}
+
+	if err := <-stopped; err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
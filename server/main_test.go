@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownDrainsRequestsThenDisconnects(t *testing.T) {
+	games := newTestService()
+	api := newServer(games)
+
+	e := echo.New()
+	e.HideBanner, e.HidePort = true, true
+	api.setupRoutes(e)
+
+	var mutex sync.Mutex
+	happened := []string{}
+	record := func(what string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		happened = append(happened, what)
+	}
+
+	// A request that is still being answered when the server is told to stop
+	started := make(chan struct{})
+	e.GET("/slow", func(c echo.Context) error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		record("request finished")
+		return c.String(http.StatusOK, "done")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	e.Listener = listener
+	url := "http://" + listener.Addr().String()
+
+	cfg := defaultConfig()
+	cfg.ShutdownTimeout = 5 * time.Second
+
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- api.serve(ctx, e, cfg, func() { record("disconnected") })
+	}()
+
+	// A client watching a game keeps its connection open until the server ends the stream
+	game, player := setupGameWithPlayer(games.database)
+	stream, err := http.Get(url + "/api/games/" + game.ID + "/events?token=" + generateToken(player))
+	require.Nil(t, err)
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+
+	slow := make(chan string, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		slow <- string(body)
+	}()
+
+	<-started
+	stop()
+
+	assert.Equal(t, "done", <-slow)
+
+	_, err = ioutil.ReadAll(stream.Body)
+	assert.Nil(t, err)
+
+	assert.Nil(t, <-served)
+	assert.Equal(t, []string{"request finished", "disconnected"}, happened)
+
+	// Nothing new is let in
+	_, err = http.Get(url + "/slow")
+	assert.NotNil(t, err)
+}
+
+func TestServeFailsWhenItCannotListen(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+
+	e := echo.New()
+	e.HideBanner, e.HidePort = true, true
+
+	cfg := defaultConfig()
+	cfg.Listen = listener.Addr().String()
+
+	cleanedUp := false
+	err = newServer(newTestService()).serve(context.Background(), e, cfg, func() { cleanedUp = true })
+	assert.NotNil(t, err)
+	assert.True(t, cleanedUp)
+}
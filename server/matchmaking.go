@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/mattwhite180/go-away"
+	"go.uber.org/zap"
+)
+
+// matcherPolicy says how often the matcher runs and how long tickets are kept
+type matcherPolicy struct {
+	// How often the queue is matched
+	Interval time.Duration
+	// Tickets still waiting after this long expire
+	TicketTTL time.Duration
+	// A claim older than this belongs to a matcher that died while setting up the game, the players go back to waiting
+	ClaimTTL time.Duration
+	// Matched, cancelled and expired tickets are deleted this long after they were settled,
+	// which gives players time to pick up their token
+	Retention time.Duration
+}
+
+var defaultMatcherPolicy = matcherPolicy{
+	Interval:  2 * time.Second,
+	TicketTTL: 10 * time.Minute,
+	ClaimTTL:  time.Minute,
+	Retention: time.Hour,
+}
+
+// matcherPolicyFromEnv reads the policy from MATCHMAKING_INTERVAL, MATCHMAKING_TICKET_TTL,
+// MATCHMAKING_CLAIM_TTL and MATCHMAKING_RETENTION. Anything unset keeps its default.
+func matcherPolicyFromEnv() (matcherPolicy, error) {
+	policy := defaultMatcherPolicy
+
+	err := durationsFromEnv(map[string]*time.Duration{
+		"MATCHMAKING_INTERVAL":   &policy.Interval,
+		"MATCHMAKING_TICKET_TTL": &policy.TicketTTL,
+		"MATCHMAKING_CLAIM_TTL":  &policy.ClaimTTL,
+		"MATCHMAKING_RETENTION":  &policy.Retention,
+	})
+	if err != nil {
+		return policy, err
+	}
+
+	if policy.Interval == 0 {
+		return policy, fmt.Errorf("MATCHMAKING_INTERVAL must be more than 0")
+	}
+
+	return policy, nil
+}
+
+// matcher groups queued players with the same preferences into games.
+// The queue lives in UnoDB and every ticket is claimed before it is used,
+// so the queue survives restarts and each replica can run its own matcher.
+type matcher struct {
+	games  *GameService
+	clock  clock
+	policy matcherPolicy
+}
+
+// newMatcher makes a matcher that starts its games with games
+func newMatcher(games *GameService, policy matcherPolicy) *matcher {
+	return &matcher{games: games, clock: games.clock, policy: policy}
+}
+
+// run matches the queue every policy interval until stop is closed
+func (m *matcher) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, span := tracer.Start(context.Background(), "match")
+			games, err := m.match(ctx)
+			if err != nil {
+				logFrom(ctx).Error("Matchmaking failed", zap.Error(err))
+			}
+			if games > 0 {
+				logFrom(ctx).Info("Matchmaking started games", zap.Int("games", games))
+			}
+			span.End()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// match starts a game for every full group of compatible waiting players, oldest tickets first,
+// and tidies up old tickets. It returns how many games it started and the first error.
+func (m *matcher) match(ctx context.Context) (int, error) {
+	entries, err := m.games.databaseFor(ctx).GetQueueEntries()
+	if err != nil {
+		return 0, err
+	}
+
+	now := m.clock.Now()
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	groups := make(map[model.MatchPreferences][]model.QueueEntry)
+	order := make([]model.MatchPreferences, 0)
+
+	for _, entry := range *entries {
+		switch entry.Status {
+		case model.QueueWaiting:
+			if now.Sub(parseTimestamp(entry.EnqueuedAt)) > m.policy.TicketTTL {
+				fail(m.settle(ctx, entry, model.QueueWaiting, model.QueueExpired, now))
+				continue
+			}
+			if groups[entry.Preferences] == nil {
+				order = append(order, entry.Preferences)
+			}
+			groups[entry.Preferences] = append(groups[entry.Preferences], entry)
+
+		case model.QueueMatching:
+			if now.Sub(parseTimestamp(entry.UpdatedAt)) > m.policy.ClaimTTL {
+				fail(m.settle(ctx, entry, model.QueueMatching, model.QueueWaiting, now))
+			}
+
+		default:
+			if now.Sub(parseTimestamp(entry.UpdatedAt)) > m.policy.Retention {
+				if err := m.games.databaseFor(ctx).DeleteQueueEntry(entry.ID); err != nil && !errors.Is(err, db.ErrQueueEntryNotFound) {
+					fail(err)
+				}
+			}
+		}
+	}
+
+	started := 0
+	for _, preferences := range order {
+		group := groups[preferences]
+		for len(group) >= preferences.PlayerCount {
+			batch := group[:preferences.PlayerCount]
+			group = group[preferences.PlayerCount:]
+
+			ok, err := m.startGame(ctx, batch, now)
+			if err != nil {
+				fail(err)
+			}
+			if ok {
+				started++
+			}
+		}
+	}
+
+	return started, firstErr
+}
+
+// settle moves a ticket from one status to another and tells its player.
+// Losing the race to another matcher or to the player cancelling is not an error.
+func (m *matcher) settle(ctx context.Context, entry model.QueueEntry, from model.QueueStatus, to model.QueueStatus, now time.Time) error {
+	entry.Status = to
+	entry.UpdatedAt = now.UTC().Format(time.RFC3339)
+
+	err := m.games.databaseFor(ctx).UpdateQueueEntry(entry, from)
+	if errors.Is(err, db.ErrQueueEntryChanged) || errors.Is(err, db.ErrQueueEntryNotFound) {
+		return nil
+	}
+
+	if err == nil {
+		m.games.notifyTicket(ctx, entry.ID)
+	}
+
+	return err
+}
+
+// startGame claims every ticket in the batch and seats their players in a new game.
+// When any ticket was taken first, the claimed ones go back to waiting and no game is started.
+func (m *matcher) startGame(ctx context.Context, batch []model.QueueEntry, now time.Time) (bool, error) {
+	claimedAt := now.UTC().Format(time.RFC3339)
+	claimed := make([]model.QueueEntry, 0, len(batch))
+
+	for _, entry := range batch {
+		entry.Status = model.QueueMatching
+		entry.UpdatedAt = claimedAt
+
+		err := m.games.databaseFor(ctx).UpdateQueueEntry(entry, model.QueueWaiting)
+		if errors.Is(err, db.ErrQueueEntryChanged) || errors.Is(err, db.ErrQueueEntryNotFound) {
+			m.requeue(ctx, claimed, model.QueueMatching)
+			return false, nil
+		}
+		if err != nil {
+			m.requeue(ctx, claimed, model.QueueMatching)
+			return false, err
+		}
+
+		claimed = append(claimed, entry)
+	}
+
+	game, players, err := m.games.seatMatchedPlayers(ctx, claimed)
+	if err != nil {
+		m.requeue(ctx, claimed, model.QueueMatching)
+		return false, err
+	}
+
+	// Matching a ticket is what gives the game its player. A setup slow enough for the claim to time out
+	// may find a ticket back in the queue, or claimed again, and then the game is taken down
+	// rather than seat anyone twice.
+	matched := make([]model.QueueEntry, 0, len(claimed))
+	for i, entry := range claimed {
+		entry.Status = model.QueueMatched
+		entry.GameID = game.ID
+		entry.PlayerID = players[i].ID
+
+		err := m.games.databaseFor(ctx).UpdateQueueEntry(entry, model.QueueMatching)
+		if err != nil {
+			m.requeue(ctx, matched, model.QueueMatched)
+			m.requeue(ctx, claimed[i:], model.QueueMatching)
+			m.games.discardMatch(ctx, game, players)
+
+			if errors.Is(err, db.ErrQueueEntryChanged) || errors.Is(err, db.ErrQueueEntryNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		matched = append(matched, entry)
+	}
+
+	for _, entry := range matched {
+		m.games.notifyTicket(ctx, entry.ID)
+	}
+
+	return true, nil
+}
+
+// requeue puts tickets that are still in the from status back to waiting.
+// Tickets that have moved on belong to someone else and are left alone.
+func (m *matcher) requeue(ctx context.Context, entries []model.QueueEntry, from model.QueueStatus) {
+	for _, entry := range entries {
+		entry.Status = model.QueueWaiting
+		entry.GameID = ""
+		entry.PlayerID = ""
+
+		err := m.games.databaseFor(ctx).UpdateQueueEntry(entry, from)
+		if err != nil && !errors.Is(err, db.ErrQueueEntryChanged) && !errors.Is(err, db.ErrQueueEntryNotFound) {
+			logFrom(ctx).Error("Could not put a ticket back in the queue", zap.String("ticket_id", entry.ID), zap.Error(err))
+		}
+	}
+}
+
+// seatMatchedPlayers creates a game for the claimed tickets, seats everyone and deals.
+// The first ticket's player is the creator. Players are returned in the order of the tickets.
+// When any step fails, the players and the game made so far are deleted again.
+func (s *GameService) seatMatchedPlayers(ctx context.Context, entries []model.QueueEntry) (*model.Game, []*model.Player, error) {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.PlayerName
+	}
+
+	game, creator, err := s.createNewGame(ctx, "Match: "+strings.Join(names, ", "), entries[0].PlayerName, entries[0].Account, entries[0].Preferences.Rules)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	players := []*model.Player{creator}
+	for _, entry := range entries[1:] {
+		player, err := s.createPlayer(ctx, entry.PlayerName, entry.Account)
+		if err != nil {
+			s.discardMatch(ctx, game, players)
+			return nil, nil, err
+		}
+		players = append(players, player)
+
+		joined, err := s.joinGame(ctx, game.ID, player)
+		if err != nil {
+			s.discardMatch(ctx, game, players)
+			return nil, nil, err
+		}
+		game = joined
+	}
+
+	dealt, err := s.dealCards(ctx, game)
+	if err != nil {
+		s.discardMatch(ctx, game, players)
+		return nil, nil, err
+	}
+
+	return dealt, players, nil
+}
+
+// discardMatch deletes a game the matcher could not finish setting up, along with its players.
+// Its tickets were never all matched, so nobody is playing in it.
+func (s *GameService) discardMatch(ctx context.Context, game *model.Game, players []*model.Player) {
+	database := s.databaseFor(ctx)
+
+	if err := database.DeleteGame(game.ID); err != nil {
+		logFrom(ctx).Error("Could not delete an unfinished match", zap.String("game_id", game.ID), zap.Error(err))
+	}
+
+	for _, player := range players {
+		if err := database.DeletePlayer(player.ID); err != nil {
+			logFrom(ctx).Error("Could not delete a player of an unfinished match", zap.String("player_id", player.ID), zap.Error(err))
+		}
+	}
+}
+
+// notifyTicket tells a queued player that their ticket changed
+func (s *GameService) notifyTicket(ctx context.Context, ticketID string) {
+	s.notifyGame(ctx, ticketID, events.MatchUpdated)
+}
+
+// enqueueRequest is what POST /api/matchmaking/enqueue accepts
+type enqueueRequest struct {
+	PlayerName        string `json:"playerName"`
+	PlayerCount       int    `json:"player_count"`
+	DrawUntilPlayable bool   `json:"draw_until_playable"`
+	Deck              string `json:"deck"`
+	Teams             int    `json:"teams"`
+	ShareHands        bool   `json:"share_hands"`
+	// Games are rated unless this is false
+	Rated *bool `json:"rated"`
+}
+
+// enqueuePlayer puts a player in the matchmaking queue and returns their ticket.
+// The ticket ID is only given to the player, it is how they pick up their token once they are matched.
+func (s *server) enqueuePlayer(c echo.Context) error {
+	request := enqueueRequest{PlayerCount: 2}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, "Could not bind to input")
+	}
+
+	if request.PlayerName == "" {
+		return c.JSON(http.StatusBadRequest, "Missing player name")
+	}
+
+	if goaway.IsProfane(request.PlayerName) {
+		return c.JSON(http.StatusBadRequest, "Profane player name")
+	}
+
+	if request.PlayerCount < 2 || request.PlayerCount > model.MaxPlayers {
+		return c.JSON(http.StatusBadRequest, fmt.Sprintf("player_count must be from 2 to %d", model.MaxPlayers))
+	}
+
+	if err := validTeams(request.Teams, request.PlayerCount); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	deckName, err := s.games.ruleDeck(request.Deck)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	account, err := accountFromRequest(c)
+
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, err.Error())
+	}
+
+	database := s.games.databaseFor(c.Request().Context())
+
+	entry, err := database.EnqueuePlayer(model.QueueEntry{
+		PlayerName: request.PlayerName,
+		Account:    account,
+		Preferences: model.MatchPreferences{
+			PlayerCount: request.PlayerCount,
+			Rules: model.GameRules{
+				DrawUntilPlayable: request.DrawUntilPlayable,
+				Unrated:           request.Rated != nil && !*request.Rated,
+				Deck:              deckName,
+				Teams:             request.Teams,
+				ShareHands:        request.ShareHands,
+			},
+		},
+	})
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not join the queue")
+	}
+
+	return c.JSON(http.StatusAccepted, entry)
+}
+
+// ticket is a queue entry as its player sees it, with a token for their seat once they are matched
+type ticket struct {
+	model.QueueEntry
+	Token string `json:"token,omitempty"`
+}
+
+// ticketFor makes the token of a matched entry's player. Tokens are only made when the ticket is picked up,
+// so the queue never holds anything that would let someone who can read it sit in a game.
+func ticketFor(entry model.QueueEntry) ticket {
+	if entry.Status != model.QueueMatched {
+		return ticket{QueueEntry: entry}
+	}
+
+	return ticket{QueueEntry: entry, Token: generateToken(&model.Player{ID: entry.PlayerID, Name: entry.PlayerName})}
+}
+
+// getTicket reports where a ticket is in the queue, with the token and game ID once it is matched
+func (s *server) getTicket(c echo.Context) error {
+	database := s.games.databaseFor(c.Request().Context())
+
+	entry, err := database.LookupQueueEntry(c.Param("id"))
+
+	if errors.Is(err, db.ErrQueueEntryNotFound) {
+		return c.JSON(http.StatusNotFound, "Ticket not found")
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not look up ticket")
+	}
+
+	return c.JSON(http.StatusOK, ticketFor(*entry))
+}
+
+// cancelTicket takes a player out of the queue. A ticket that is already being matched cannot be cancelled.
+func (s *server) cancelTicket(c echo.Context) error {
+	database := s.games.databaseFor(c.Request().Context())
+
+	entry, err := database.LookupQueueEntry(c.Param("id"))
+
+	if errors.Is(err, db.ErrQueueEntryNotFound) {
+		return c.JSON(http.StatusNotFound, "Ticket not found")
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not look up ticket")
+	}
+
+	entry.Status = model.QueueCancelled
+	entry.UpdatedAt = s.games.clock.Now().UTC().Format(time.RFC3339)
+	err = database.UpdateQueueEntry(*entry, model.QueueWaiting)
+
+	if errors.Is(err, db.ErrQueueEntryChanged) {
+		return c.JSON(http.StatusConflict, "This ticket is no longer waiting")
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not cancel ticket")
+	}
+
+	s.games.notifyTicket(c.Request().Context(), entry.ID)
+
+	return c.JSON(http.StatusOK, entry)
+}
+
+// streamTicketEvents sends a "match" event whenever the ticket changes, so players do not have to poll.
+// A ticket that has already been settled gets one event straight away.
+func (s *server) streamTicketEvents(c echo.Context) error {
+	ticketID := c.Param("id")
+
+	bus := s.games.bus
+
+	// Subscribe before looking the ticket up, so a match in between is not missed
+	subscription := bus.Subscribe(ticketID)
+	defer subscription.Close()
+
+	database := s.games.databaseFor(c.Request().Context())
+
+	entry, err := database.LookupQueueEntry(ticketID)
+
+	if errors.Is(err, db.ErrQueueEntryNotFound) {
+		return c.JSON(http.StatusNotFound, "Ticket not found")
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not look up ticket")
+	}
+
+	pending := []events.Event{}
+	if entry.Status != model.QueueWaiting && entry.Status != model.QueueMatching {
+		pending = append(pending, events.Event{GameID: ticketID, Kind: events.MatchUpdated})
+	}
+
+	return s.streamEvents(c, subscription, pending...)
+}
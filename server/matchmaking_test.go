@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupMatcher makes a matcher with a service of its own, so the queue starts out empty
+func setupMatcher(t *testing.T) (*matcher, db.UnoDB, *fakeClock) {
+	games := newTestService()
+	return newMatcher(games, defaultMatcherPolicy), games.database, games.clock.(*fakeClock)
+}
+
+func enqueue(database db.UnoDB, name string, preferences model.MatchPreferences) *model.QueueEntry {
+	entry, _ := database.EnqueuePlayer(model.QueueEntry{PlayerName: name, Preferences: preferences})
+	return entry
+}
+
+func TestMatcherPolicyFromEnv(t *testing.T) {
+	policy, err := matcherPolicyFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, defaultMatcherPolicy, policy)
+
+	os.Setenv("MATCHMAKING_TICKET_TTL", "90s")
+	defer os.Unsetenv("MATCHMAKING_TICKET_TTL")
+	policy, err = matcherPolicyFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, 90*time.Second, policy.TicketTTL)
+
+	os.Setenv("MATCHMAKING_INTERVAL", "0s")
+	defer os.Unsetenv("MATCHMAKING_INTERVAL")
+	_, err = matcherPolicyFromEnv()
+	assert.NotNil(t, err)
+}
+
+func TestMatcherGroupsCompatiblePlayers(t *testing.T) {
+	matcher, database, _ := setupMatcher(t)
+
+	pairs := model.MatchPreferences{PlayerCount: 2}
+	houseRules := model.MatchPreferences{PlayerCount: 2, Rules: model.GameRules{DrawUntilPlayable: true}}
+
+	ann := enqueue(database, "Ann", pairs)
+	loner := enqueue(database, "Loner", houseRules)
+	bob := enqueue(database, "Bob", pairs)
+	cat := enqueue(database, "Cat", pairs)
+
+	subscription := matcher.games.bus.Subscribe(ann.ID)
+	defer subscription.Close()
+
+	started, err := matcher.match(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, started)
+
+	// The two oldest compatible tickets are seated together
+	ann, _ = database.LookupQueueEntry(ann.ID)
+	bob, _ = database.LookupQueueEntry(bob.ID)
+	assert.Equal(t, model.QueueMatched, ann.Status)
+	assert.Equal(t, model.QueueMatched, bob.Status)
+	assert.Equal(t, ann.GameID, bob.GameID)
+	assert.NotEqual(t, ann.PlayerID, bob.PlayerID)
+	assert.Equal(t, events.Event{GameID: ann.ID, Kind: events.MatchUpdated}, <-subscription.C)
+
+	// The queue only holds who was seated, the token is made when the ticket is picked up
+	token, valid := parseJWT(ticketFor(*ann).Token, tokenSecret)
+	assert.True(t, valid)
+	assert.Equal(t, ann.PlayerID, token.Claims.(jwt.MapClaims)["playerId"])
+
+	game, err := database.LookupGameByID(ann.GameID)
+	assert.Nil(t, err)
+	assert.Equal(t, model.Playing, game.Status)
+	assert.Len(t, game.Players, 2)
+	assert.Len(t, game.Players[0].Cards, 7)
+	assert.Equal(t, ann.PlayerID, game.Creator.ID)
+
+	// Nobody has the same preferences as the others yet
+	for _, entry := range []*model.QueueEntry{cat, loner} {
+		entry, _ = database.LookupQueueEntry(entry.ID)
+		assert.Equal(t, model.QueueWaiting, entry.Status)
+	}
+
+	// Once someone with the house rule turns up, the game is dealt with it
+	dan := enqueue(database, "Dan", houseRules)
+	started, err = matcher.match(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, started)
+
+	dan, _ = database.LookupQueueEntry(dan.ID)
+	game, _ = database.LookupGameByID(dan.GameID)
+	assert.True(t, game.Rules.DrawUntilPlayable)
+}
+
+func TestMatcherSkipsClaimedTickets(t *testing.T) {
+	matcher, database, clock := setupMatcher(t)
+	pairs := model.MatchPreferences{PlayerCount: 2}
+
+	ann := enqueue(database, "Ann", pairs)
+	bob := enqueue(database, "Bob", pairs)
+
+	// Another replica is in the middle of matching Ann
+	claimed := *ann
+	claimed.Status = model.QueueMatching
+	claimed.UpdatedAt = clock.Now().UTC().Format(time.RFC3339)
+	database.UpdateQueueEntry(claimed, model.QueueWaiting)
+
+	started, err := matcher.match(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, started)
+
+	// If that replica never finishes, Ann goes back in the queue and is matched on a later pass
+	clock.Advance(defaultMatcherPolicy.ClaimTTL + time.Second)
+	started, _ = matcher.match(context.Background())
+	assert.Equal(t, 0, started)
+	started, _ = matcher.match(context.Background())
+	assert.Equal(t, 1, started)
+
+	bob, _ = database.LookupQueueEntry(bob.ID)
+	assert.Equal(t, model.QueueMatched, bob.Status)
+}
+
+func TestMatcherTakesDownUnfinishedGames(t *testing.T) {
+	matcher, database, _ := setupMatcher(t)
+	pairs := model.MatchPreferences{PlayerCount: 2}
+
+	ann := enqueue(database, "Ann", pairs)
+	bob := enqueue(database, "Bob", pairs)
+
+	nothingLeft := func() {
+		games, _ := database.GetAllGames()
+		assert.Empty(t, *games)
+		players, _ := database.GetAllPlayers()
+		assert.Empty(t, *players)
+
+		for _, entry := range []*model.QueueEntry{ann, bob} {
+			entry, _ = database.LookupQueueEntry(entry.ID)
+			assert.Equal(t, model.QueueWaiting, entry.Status)
+			assert.Empty(t, entry.GameID)
+		}
+	}
+
+	// Bob cannot be seated, so the game Ann was already sitting in goes too
+	matcher.games.database = failingJoins{database}
+	started, err := matcher.match(context.Background())
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, started)
+	nothingLeft()
+
+	// Bob's claim timed out and another matcher has him, so Ann is not left in a game of one
+	matcher.games.database = lostClaim{database, bob.ID}
+	started, err = matcher.match(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, started)
+	nothingLeft()
+}
+
+// failingJoins is a database that cannot seat anyone
+type failingJoins struct {
+	db.UnoDB
+}
+
+func (failingJoins) JoinGame(string, string) (*model.Game, error) {
+	return nil, errors.New("Database is unavailable")
+}
+
+// lostClaim is a database where one ticket is taken by another matcher just before it is matched
+type lostClaim struct {
+	db.UnoDB
+	ticketID string
+}
+
+func (l lostClaim) UpdateQueueEntry(entry model.QueueEntry, from model.QueueStatus) error {
+	if entry.ID == l.ticketID && entry.Status == model.QueueMatched {
+		return fmt.Errorf("test: %w", db.ErrQueueEntryChanged)
+	}
+	return l.UnoDB.UpdateQueueEntry(entry, from)
+}
+
+func TestMatcherExpiresAndCleansUpTickets(t *testing.T) {
+	matcher, database, clock := setupMatcher(t)
+	ann := enqueue(database, "Ann", model.MatchPreferences{PlayerCount: 3})
+
+	clock.Advance(defaultMatcherPolicy.TicketTTL + time.Minute)
+	_, err := matcher.match(context.Background())
+	assert.Nil(t, err)
+
+	ann, _ = database.LookupQueueEntry(ann.ID)
+	assert.Equal(t, model.QueueExpired, ann.Status)
+
+	clock.Advance(defaultMatcherPolicy.Retention + time.Minute)
+	_, err = matcher.match(context.Background())
+	assert.Nil(t, err)
+
+	_, err = database.LookupQueueEntry(ann.ID)
+	assert.NotNil(t, err)
+}
+
+func TestEnqueueAndCancel(t *testing.T) {
+	matcher, database, _ := setupMatcher(t)
+	server := newServer(matcher.games)
+	e := echo.New()
+
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	e.POST("/api/matchmaking/enqueue", server.enqueuePlayer)
+	e.DELETE("/api/matchmaking/:id", server.cancelTicket)
+
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/matchmaking/enqueue", `{"player_count": 3}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/matchmaking/enqueue", `{"playerName": "Ann", "player_count": 11}`).Code)
+
+	rec := request(http.MethodPost, "/api/matchmaking/enqueue", `{"playerName": "Ann", "player_count": 3, "rated": false}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	entries, _ := database.GetQueueEntries()
+	assert.Len(t, *entries, 1)
+	ticket := (*entries)[0]
+	assert.Equal(t, model.MatchPreferences{PlayerCount: 3, Rules: model.GameRules{Unrated: true}}, ticket.Preferences)
+
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/api/matchmaking/"+ticket.ID, "").Code)
+	assert.Equal(t, http.StatusConflict, request(http.MethodDelete, "/api/matchmaking/"+ticket.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/api/matchmaking/missing", "").Code)
+}
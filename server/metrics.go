@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The metrics served at /metrics for Prometheus to scrape.
+// Rates such as moves per second come from the counters, e.g. rate(uno_moves_total[1m]).
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "uno_http_request_duration_seconds",
+		Help: "How long requests took, by route.",
+	}, []string{"method", "route", "status"})
+
+	movesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uno_moves_total",
+		Help: "Moves made in games, by kind.",
+	}, []string{"kind"})
+
+	dbOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "uno_db_operation_duration_seconds",
+		Help: "How long database calls took, by backend and method.",
+	}, []string{"backend", "method", "result"})
+
+	realtimeClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "uno_realtime_clients",
+		Help: "Clients connected to an event stream.",
+	})
+
+	janitorSweeps = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "uno_janitor_sweeps_total",
+		Help: "Sweeps the janitor made.",
+	})
+
+	janitorErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "uno_janitor_errors_total",
+		Help: "Cleanups the janitor could not make.",
+	})
+
+	janitorCleanups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uno_janitor_cleanups_total",
+		Help: "Lobbies, games and players the janitor cleaned up, by kind.",
+	}, []string{"kind"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		requestDuration,
+		movesTotal,
+		dbOperationDuration,
+		realtimeClients,
+		janitorSweeps,
+		janitorErrors,
+		janitorCleanups,
+	)
+}
+
+// The kinds of moves counted
+const (
+	movePlay    = "play"
+	moveDraw    = "draw"
+	moveCallUno = "call_uno"
+	// Moves taken back, and made again, once the players agreed
+	moveUndo = "undo"
+	moveRedo = "redo"
+)
+
+// recordRequests times every request by the route it matched rather than its path,
+// so games do not each get their own series
+func recordRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		status := strconv.Itoa(c.Response().Status)
+		requestDuration.WithLabelValues(c.Request().Method, route, status).Observe(time.Since(start).Seconds())
+		return nil
+	}
+}
+
+// observeDatabase times every call to the database
+func observeDatabase(database db.UnoDB, backend string) db.UnoDB {
+	return db.Observe(database, func(method string) func(error) {
+		start := time.Now()
+		return func(err error) {
+			result := "ok"
+			if err != nil {
+				result = "error"
+			}
+			dbOperationDuration.WithLabelValues(backend, method, result).Observe(time.Since(start).Seconds())
+		}
+	})
+}
+
+// gamesCollector counts the games in each status whenever metrics are scraped
+type gamesCollector struct {
+	database db.UnoDB
+}
+
+var activeGames = prometheus.NewDesc("uno_games", "Games in the database, by status.", []string{"status"}, nil)
+
+func (g gamesCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- activeGames
+}
+
+func (g gamesCollector) Collect(metrics chan<- prometheus.Metric) {
+	for _, status := range []model.GameStatus{model.WaitingForPlayers, model.Playing, model.Finished} {
+		page, err := g.database.QueryGames(db.GameQuery{Status: status, Limit: 1})
+		if err != nil {
+			metrics <- prometheus.NewInvalidMetric(activeGames, err)
+			continue
+		}
+		metrics <- prometheus.MustNewConstMetric(activeGames, prometheus.GaugeValue, float64(page.Total), string(status))
+	}
+}
+
+// metricsHandler serves the process metrics along with this server's games
+func (s *server) metricsHandler() echo.HandlerFunc {
+	games := prometheus.NewRegistry()
+	games.MustRegister(gamesCollector{s.games.database})
+
+	return echo.WrapHandler(promhttp.HandlerFor(prometheus.Gatherers{metricsRegistry, games}, promhttp.HandlerOpts{}))
+}
+
+// healthz says the process is up and answering
+func (s *server) healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, "ok")
+}
+
+// readyz says the server can take traffic, which it cannot while its database is unreachable
+func (s *server) readyz(c echo.Context) error {
+	if err := s.games.database.Ping(); err != nil {
+		return c.JSON(http.StatusServiceUnavailable, "Database unavailable: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, "ready")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachable is a database that cannot be reached
+type unreachable struct {
+	db.UnoDB
+}
+
+func (unreachable) Ping() error {
+	return errors.New("connection refused")
+}
+
+func probe(e *echo.Echo, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestProbes(t *testing.T) {
+	games := newTestService()
+	e := echo.New()
+	newServer(games).setupRoutes(e)
+
+	assert.Equal(t, http.StatusOK, probe(e, "/healthz").Code)
+	assert.Equal(t, http.StatusOK, probe(e, "/readyz").Code)
+
+	// A server whose database is down is alive but not ready
+	games.database = unreachable{games.database}
+	e = echo.New()
+	newServer(games).setupRoutes(e)
+
+	assert.Equal(t, http.StatusOK, probe(e, "/healthz").Code)
+	rec := probe(e, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "connection refused")
+}
+
+func TestMetrics(t *testing.T) {
+	games := newGameService(observeDatabase(db.NewMockDB(), "mock"), &fakeClock{now: time.Now()}, testRand(), events.NewLocalBus())
+	e := echo.New()
+	e.Use(recordRequests)
+	newServer(games).setupRoutes(e)
+
+	game, player := setupGameWithPlayer(games.database)
+	_, err := games.drawCard(context.Background(), game.ID, player.ID)
+	require.Nil(t, err)
+	probe(e, "/healthz")
+
+	rec := probe(e, "/metrics")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `uno_http_request_duration_seconds_count{method="GET",route="/healthz",status="200"}`)
+	assert.Contains(t, body, `uno_moves_total{kind="draw"}`)
+	assert.Contains(t, body, `uno_db_operation_duration_seconds_count{backend="mock",method="SaveGame",result="ok"}`)
+	assert.Contains(t, body, `uno_games{status="Playing"}`)
+	assert.Contains(t, body, "uno_realtime_clients")
+	assert.Contains(t, body, "go_goroutines")
+}
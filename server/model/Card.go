@@ -4,6 +4,37 @@ package model
 // Uses Value instead of Number
 // to more accurately represent non-numerical cards
 type Card struct {
-	Color string `bson:"color,omitempty" json:"color"`
-	Value string `bson:"value,omitempty" json:"value"`
+	Color string `bson:"color" json:"color"`
+	Value string `bson:"value" json:"value"`
+	// The dark face of a two-sided Flip card. Cards from one-sided decks have none.
+	DarkColor string `bson:"dark_color,omitempty" json:"dark_color,omitempty"`
+	DarkValue string `bson:"dark_value,omitempty" json:"dark_value,omitempty"`
+}
+
+// Face is the side of the card that is showing, the dark face when dark is set.
+// One-sided cards always show their only face.
+func (c Card) Face(dark bool) Card {
+	if dark && c.DarkValue != "" {
+		return Card{Color: c.DarkColor, Value: c.DarkValue}
+	}
+	return Card{Color: c.Color, Value: c.Value}
+}
+
+// Faces is the side of each card that is showing
+func Faces(cards []Card, dark bool) []Card {
+	faces := make([]Card, len(cards))
+	for i, card := range cards {
+		faces[i] = card.Face(dark)
+	}
+	return faces
+}
+
+// WithFace is the card with the showing side replaced, such as a wild card with the color its player picked
+func (c Card) WithFace(dark bool, face Card) Card {
+	if dark && c.DarkValue != "" {
+		c.DarkColor, c.DarkValue = face.Color, face.Value
+	} else {
+		c.Color, c.Value = face.Color, face.Value
+	}
+	return c
 }
@@ -0,0 +1,298 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EffectKind is something that happens when a card is played
+type EffectKind string
+
+// Possible card effects
+const (
+	// The next player loses their turn
+	EffectSkip EffectKind = "skip"
+	// Everyone else loses their turn, so the player goes again
+	EffectSkipAll EffectKind = "skip-all"
+	// Play changes direction. With two players this is a skip.
+	EffectReverse EffectKind = "reverse"
+	// The next player draws Amount cards and loses their turn
+	EffectDraw EffectKind = "draw"
+	// Every hand is gathered, shuffled and dealt back out starting with the next player
+	EffectShuffleHands EffectKind = "shuffle-hands"
+	// Every card on the table turns over to its other side. Only decks with a dark side have it.
+	EffectFlip EffectKind = "flip"
+	// The next player draws until they draw a card of the color the wild was played as, and loses their turn
+	EffectDrawColor EffectKind = "draw-color"
+)
+
+// Effect is one thing a card does when it is played
+type Effect struct {
+	Kind EffectKind `bson:"kind" json:"kind" yaml:"kind"`
+	// How many cards EffectDraw draws
+	Amount int `bson:"amount,omitempty" json:"amount,omitempty" yaml:"amount,omitempty"`
+}
+
+// CardType is one kind of card in a deck, such as the Skip or the blue 7s
+type CardType struct {
+	Value string `bson:"value" json:"value" yaml:"value"`
+	// What clients without artwork for the card show on it
+	Label string `bson:"label,omitempty" json:"label,omitempty" yaml:"label,omitempty"`
+	// How many of the card there are in each color, or in total for wild cards
+	Count int `bson:"count" json:"count" yaml:"count"`
+	// Wild cards can be played on anything, and whoever plays one picks the color to follow
+	Wild bool `bson:"wild,omitempty" json:"wild,omitempty" yaml:"wild,omitempty"`
+	// What the card is worth left in a hand. Number cards default to their face value.
+	Points int `bson:"points,omitempty" json:"points,omitempty" yaml:"points,omitempty"`
+	// What happens, in order, when the card is played. A wild card without effects is a blank wild.
+	Effects []Effect `bson:"effects,omitempty" json:"effects,omitempty" yaml:"effects,omitempty"`
+}
+
+// IsNumber reports whether the card is a plain card a game can start on
+func (t CardType) IsNumber() bool {
+	return !t.Wild && len(t.Effects) == 0
+}
+
+// DeckDefinition says which cards a game is played with
+type DeckDefinition struct {
+	Name        string `bson:"name" json:"name" yaml:"name"`
+	Description string `bson:"description,omitempty" json:"description,omitempty" yaml:"description,omitempty"`
+	// The colors every card that is not wild comes in
+	Colors []string `bson:"colors" json:"colors" yaml:"colors"`
+	// The color wild cards are dealt in, black unless set
+	WildColor string     `bson:"wild_color,omitempty" json:"wild_color,omitempty" yaml:"wild_color,omitempty"`
+	Cards     []CardType `bson:"cards" json:"cards" yaml:"cards"`
+	// Another copy of the deck is shuffled in for every this many players, 5 unless set
+	PlayersPerDeck int `bson:"players_per_deck,omitempty" json:"players_per_deck,omitempty" yaml:"players_per_deck,omitempty"`
+	// The other side of a two-sided Flip deck. Only its colors, wild color and cards are used,
+	// and it must have as many cards as the light side so every card gets one of each.
+	Dark *DeckDefinition `bson:"dark,omitempty" json:"dark,omitempty" yaml:"dark,omitempty"`
+}
+
+// MaxCardCount is the most of one card a deck can have in each color
+const MaxCardCount = 50
+
+// MaxDeckSize is the most cards a deck can lay out for a table of MaxPlayers
+const MaxDeckSize = 2000
+
+// StandardDeckName is the deck games are played with unless they pick another
+const StandardDeckName = "standard"
+
+// StandardDeck is the classic 108 card deck
+func StandardDeck() DeckDefinition {
+	deck := DeckDefinition{
+		Name:        StandardDeckName,
+		Description: "The classic 108 card deck",
+		Colors:      []string{"red", "blue", "green", "yellow"},
+		Cards:       []CardType{{Value: "0", Count: 1}},
+	}
+
+	for _, value := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"} {
+		deck.Cards = append(deck.Cards, CardType{Value: value, Count: 2})
+	}
+
+	deck.Cards = append(deck.Cards,
+		CardType{Value: "S", Label: "skip", Count: 2, Points: 20, Effects: []Effect{{Kind: EffectSkip}}},
+		CardType{Value: "D2", Label: "draw 2", Count: 2, Points: 20, Effects: []Effect{{Kind: EffectDraw, Amount: 2}}},
+		CardType{Value: "R", Label: "reverse", Count: 2, Points: 20, Effects: []Effect{{Kind: EffectReverse}}},
+		CardType{Value: "W", Label: "wild", Count: 4, Wild: true, Points: 50},
+		CardType{Value: "W4", Label: "draw 4", Count: 4, Wild: true, Points: 50, Effects: []Effect{{Kind: EffectDraw, Amount: 4}}},
+	)
+
+	return deck
+}
+
+// Copies is how many copies of the deck are shuffled together for a game with numPlayers players
+func (d DeckDefinition) Copies(numPlayers int) int {
+	perDeck := d.PlayersPerDeck
+	if perDeck == 0 {
+		perDeck = 5
+	}
+	return numPlayers/perDeck + 1
+}
+
+// Wilds is the color wild cards are dealt in
+func (d DeckDefinition) Wilds() string {
+	if d.WildColor == "" {
+		return "black"
+	}
+	return d.WildColor
+}
+
+// Type is the card type of a value, or nil when the deck has no such card
+func (d DeckDefinition) Type(value string) *CardType {
+	for i := range d.Cards {
+		if d.Cards[i].Value == value {
+			return &d.Cards[i]
+		}
+	}
+	return nil
+}
+
+// IsWild reports whether cards with this value are wild
+func (d DeckDefinition) IsWild(value string) bool {
+	cardType := d.Type(value)
+	return cardType != nil && cardType.Wild
+}
+
+// Points is what a card left in a hand is worth
+func (d DeckDefinition) Points(card Card) int {
+	cardType := d.Type(card.Value)
+	if cardType == nil {
+		return 0
+	}
+
+	if cardType.Points == 0 && len(card.Value) == 1 && card.Value[0] >= '0' && card.Value[0] <= '9' {
+		return int(card.Value[0] - '0')
+	}
+
+	return cardType.Points
+}
+
+// HandPoints is what the cards left in a hand are worth
+func (d DeckDefinition) HandPoints(cards []Card) int {
+	points := 0
+	for _, card := range cards {
+		points += d.Points(card)
+	}
+	return points
+}
+
+// Build lays out every card for a game with numPlayers players, unshuffled.
+// The dark faces of a Flip deck are paired with the light faces in order, they need shuffling to pair them at random.
+func (d DeckDefinition) Build(numPlayers int) []Card {
+	cards := d.buildSide(d.Copies(numPlayers))
+
+	if d.Dark != nil {
+		dark := d.Dark.buildSide(d.Copies(numPlayers))
+		for i := range cards {
+			if i < len(dark) {
+				cards[i].DarkColor, cards[i].DarkValue = dark[i].Color, dark[i].Value
+			}
+		}
+	}
+
+	return cards
+}
+
+// buildSide lays out one side of the cards
+func (d DeckDefinition) buildSide(copies int) []Card {
+	cards := []Card{}
+
+	for _, cardType := range d.Cards {
+		for i := 0; i < cardType.Count*copies; i++ {
+			if cardType.Wild {
+				cards = append(cards, Card{Color: d.Wilds(), Value: cardType.Value})
+				continue
+			}
+
+			for _, color := range d.Colors {
+				cards = append(cards, Card{Color: color, Value: cardType.Value})
+			}
+		}
+	}
+
+	return cards
+}
+
+// Validate checks that a game can be played with the deck
+func (d DeckDefinition) Validate() error {
+	if d.Name == "" {
+		return errors.New("the deck has no name")
+	}
+
+	if d.PlayersPerDeck < 0 {
+		return fmt.Errorf("deck %s has a negative players_per_deck", d.Name)
+	}
+
+	// The first card turned over is on the light side, so it has to have a number card
+	if err := d.validateSide(d.Name, true, d.Dark != nil); err != nil {
+		return err
+	}
+
+	if d.Dark == nil {
+		return nil
+	}
+
+	if d.Dark.Dark != nil {
+		return fmt.Errorf("the dark side of deck %s has a dark side", d.Name)
+	}
+
+	if err := d.Dark.validateSide(d.Name+" (dark side)", false, true); err != nil {
+		return err
+	}
+
+	if len(d.buildSide(1)) != len(d.Dark.buildSide(1)) {
+		return fmt.Errorf("the light and dark sides of deck %s have different numbers of cards", d.Name)
+	}
+
+	return nil
+}
+
+// validateSide checks the colors and cards of one side of a deck
+func (d DeckDefinition) validateSide(name string, needsNumber bool, canFlip bool) error {
+	if len(d.Colors) == 0 {
+		return fmt.Errorf("deck %s has no colors", name)
+	}
+
+	hasNumber := false
+	seen := map[string]bool{}
+	for _, cardType := range d.Cards {
+		// Blank is how other players' cards are hidden
+		if cardType.Value == "" || cardType.Value == "Blank" {
+			return fmt.Errorf("deck %s has a card with the value %q", name, cardType.Value)
+		}
+
+		if seen[cardType.Value] {
+			return fmt.Errorf("deck %s has more than one %s card", name, cardType.Value)
+		}
+		seen[cardType.Value] = true
+
+		if cardType.Count < 0 || cardType.Points < 0 {
+			return fmt.Errorf("deck %s has a negative count or points for %s", name, cardType.Value)
+		}
+
+		if cardType.Count > MaxCardCount {
+			return fmt.Errorf("deck %s has %d of %s, the most is %d", name, cardType.Count, cardType.Value, MaxCardCount)
+		}
+
+		for _, effect := range cardType.Effects {
+			switch effect.Kind {
+			case EffectSkip, EffectSkipAll, EffectReverse, EffectShuffleHands, EffectDrawColor:
+			case EffectFlip:
+				if !canFlip {
+					return fmt.Errorf("deck %s: %s flips a deck with no dark side", name, cardType.Value)
+				}
+			case EffectDraw:
+				if effect.Amount < 1 {
+					return fmt.Errorf("deck %s: %s draws %d cards", name, cardType.Value, effect.Amount)
+				}
+			default:
+				return fmt.Errorf("deck %s: %s has an unknown effect %q", name, cardType.Value, effect.Kind)
+			}
+		}
+
+		if cardType.IsNumber() && cardType.Count > 0 {
+			hasNumber = true
+		}
+	}
+
+	if needsNumber && !hasNumber {
+		return fmt.Errorf("deck %s has no number cards", name)
+	}
+
+	// Counted rather than built, so a deck too big to build is turned away without building it
+	size := 0
+	for _, cardType := range d.Cards {
+		if cardType.Wild {
+			size += cardType.Count
+		} else {
+			size += cardType.Count * len(d.Colors)
+		}
+		if size*d.Copies(MaxPlayers) > MaxDeckSize {
+			return fmt.Errorf("deck %s has more than %d cards for %d players", name, MaxDeckSize, MaxPlayers)
+		}
+	}
+
+	return nil
+}
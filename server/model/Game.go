@@ -13,27 +13,83 @@ const (
 // Game Provies full game state
 type Game struct {
 	ID            string     `bson:"_id,omitempty" json:"id"`
-	Name          string     `bson:"name,omitempty" json:"name"`
-	Creator       Player     `bson:"creator,omitempty" json:"creator"`
-	Password      string     `bson:"password,omitempty" json:"password"`
-	DrawPile      []Card     `bson:"draw_pile,omitempty" json:"draw_pile"`
-	DiscardPile   []Card     `bson:"discard_pile,omitempty" json:"discard_pile"`
-	Players       []Player   `bson:"players,omitempty" json:"players"`
-	CurrentPlayer int        `bson:"current_player,omitempty" json:"current_player"`
-	Status        GameStatus `bson:"status,omitempty" json:"status"`
-	Direction     bool       `bson:"direction,omitempty" json:"direction"`
-	Messages      []Message  `bson:"messeges,omitempty" json:"messages"`
-	GameOver      string     `bson:"winner,omitempty" json:"game_over"`
+	Name          string     `bson:"name" json:"name"`
+	Creator       Player     `bson:"creator" json:"creator"`
+	Password      string     `bson:"password" json:"password"`
+	DrawPile      []Card     `bson:"draw_pile" json:"draw_pile"`
+	DiscardPile   []Card     `bson:"discard_pile" json:"discard_pile"`
+	Players       []Player   `bson:"players" json:"players"`
+	CurrentPlayer int        `bson:"current_player" json:"current_player"`
+	Status        GameStatus `bson:"status" json:"status"`
+	Direction     bool       `bson:"direction" json:"direction"`
+	// Flip games are played on the dark side of the cards while this is set
+	DarkSide  bool      `bson:"dark_side" json:"dark_side"`
+	Messages  []Message `bson:"messeges" json:"messages"`
+	GameOver  string    `bson:"winner" json:"game_over"`
+	CreatedAt string    `bson:"created_at" json:"created_at"`
+	Rules     GameRules `bson:"rules" json:"rules"`
+	// The deck the game was dealt with. Games dealt before decks could be picked have none and use the standard deck.
+	Deck *DeckDefinition `bson:"deck,omitempty" json:"deck,omitempty"`
+	// The game as it was before each of its last few moves, newest last, so moves can be undone
+	History []GameSnapshot `bson:"history,omitempty" json:"history,omitempty"`
+	// The game as it was after each move that was undone, newest last, until the next move is made
+	Redo []GameSnapshot `bson:"redo,omitempty" json:"redo,omitempty"`
+	// A player asking to undo or redo a move, while it waits for the others to agree
+	UndoRequest *UndoRequest `bson:"undo_request,omitempty" json:"undo_request,omitempty"`
+	// The game as it was dealt, and everything that has happened since, so it can be exported and replayed.
+	// Games dealt before transcripts were kept have no opening.
+	Opening *GameSnapshot `bson:"opening,omitempty" json:"opening,omitempty"`
+	Actions []Action      `bson:"actions,omitempty" json:"actions,omitempty"`
+	// The seats of a resumed import nobody has claimed yet, by player ID. Joining the game claims one.
+	UnclaimedSeats []string `bson:"unclaimed_seats,omitempty" json:"unclaimed_seats,omitempty"`
 }
 
+// CardDeck is the deck the game is played with
+func (g Game) CardDeck() DeckDefinition {
+	if g.Deck == nil {
+		return StandardDeck()
+	}
+	return *g.Deck
+}
+
+// ActiveDeck is the side of the deck the game is being played on
+func (g Game) ActiveDeck() DeckDefinition {
+	deck := g.CardDeck()
+	if g.DarkSide && deck.Dark != nil {
+		return *deck.Dark
+	}
+	return deck
+}
+
+// GameRules are the options a game is played with. The zero value is the standard rated game.
+type GameRules struct {
+	// House rule: drawing keeps going until the player draws a card they can play
+	DrawUntilPlayable bool `bson:"draw_until_playable" json:"draw_until_playable"`
+	// Unrated games leave everyone's rating alone
+	Unrated bool `bson:"unrated" json:"unrated"`
+	// The name of the deck to deal, the standard deck when empty
+	Deck string `bson:"deck,omitempty" json:"deck,omitempty"`
+	// How many teams the players are split into, partners sit opposite each other. 0 is every player for themselves.
+	Teams int `bson:"teams,omitempty" json:"teams,omitempty"`
+	// Partners can see each other's hands
+	ShareHands bool `bson:"share_hands,omitempty" json:"share_hands,omitempty"`
+	// Who has to agree before a move in an unrated game is undone, every other player when empty
+	UndoApproval UndoApproval `bson:"undo_approval,omitempty" json:"undo_approval,omitempty"`
+}
+
+// MaxPlayers is how many players can sit at one game
+const MaxPlayers = 10
+
 // GameSummary Provides summary information for the lobby
 type GameSummary struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Creator string `json:"creator"`
-	// Players []string `json:"players"`
-	Players []Player   `json:"players"`
-	Status  GameStatus `json:"status"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Creator     string     `json:"creator"`
+	Players     []string   `json:"players"`
+	PlayerCount int        `json:"player_count"`
+	OpenSeats   int        `json:"open_seats"`
+	Status      GameStatus `json:"status"`
+	CreatedAt   string     `json:"created_at"`
 }
 
 // GameToSummary Converts a Game to a GameSummary
@@ -42,7 +98,21 @@ func GameToSummary(g Game) (summary GameSummary) {
 	summary.Name = g.Name
 	summary.Creator = g.Creator.Name
 	summary.Status = g.Status
-	summary.Players = g.Players
+	summary.CreatedAt = g.CreatedAt
+
+	summary.Players = make([]string, 0, len(g.Players))
+	for _, player := range g.Players {
+		summary.Players = append(summary.Players, player.Name)
+	}
+	summary.PlayerCount = len(g.Players)
+
+	// Only a game that is still waiting has seats anyone can take, apart from the unclaimed seats of a resumed import
+	if g.Status == WaitingForPlayers && summary.PlayerCount < MaxPlayers {
+		summary.OpenSeats = MaxPlayers - summary.PlayerCount
+	}
+	if g.Status == Playing {
+		summary.OpenSeats = len(g.UnclaimedSeats)
+	}
 
 	return summary
 }
@@ -0,0 +1,38 @@
+package model
+
+// GameArchive is the compact record kept of a finished game once the game itself is cleaned up
+type GameArchive struct {
+	ID         string           `bson:"_id,omitempty" json:"id"`
+	Name       string           `bson:"name" json:"name"`
+	Creator    string           `bson:"creator" json:"creator"`
+	Players    []ArchivedPlayer `bson:"players" json:"players"`
+	Winner     string           `bson:"winner" json:"winner"`
+	CreatedAt  string           `bson:"created_at" json:"created_at"`
+	ArchivedAt string           `bson:"archived_at" json:"archived_at"`
+	// The game's transcript as JSON, so the game can still be exported. Empty when the game kept none.
+	Transcript []byte `bson:"transcript,omitempty" json:"transcript,omitempty"`
+}
+
+// ArchivedPlayer is a player as they finished an archived game
+type ArchivedPlayer struct {
+	ID        string `bson:"id" json:"id"`
+	Name      string `bson:"name" json:"name"`
+	CardsLeft int    `bson:"cards_left" json:"cards_left"`
+}
+
+// GameToArchive Converts a finished Game to a GameArchive
+func GameToArchive(g Game, archivedAt string) (archive GameArchive) {
+	archive.ID = g.ID
+	archive.Name = g.Name
+	archive.Creator = g.Creator.Name
+	archive.Winner = g.GameOver
+	archive.CreatedAt = g.CreatedAt
+	archive.ArchivedAt = archivedAt
+
+	archive.Players = make([]ArchivedPlayer, 0, len(g.Players))
+	for _, player := range g.Players {
+		archive.Players = append(archive.Players, ArchivedPlayer{ID: player.ID, Name: player.Name, CardsLeft: len(player.Cards)})
+	}
+
+	return archive
+}
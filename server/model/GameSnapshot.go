@@ -0,0 +1,44 @@
+package model
+
+// MaxHistory is how many moves back a game can be undone, and how many undone moves can be redone
+const MaxHistory = 10
+
+// GameSnapshot is everything a move can change in a game, as it was just before the move
+type GameSnapshot struct {
+	// The kind of move that was made: play, draw or call_uno, or deal for a game's opening
+	Move string `bson:"move" json:"move"`
+	// The player who made it
+	PlayerID string `bson:"player_id" json:"player_id"`
+	// When it was made, in RFC 3339
+	At string `bson:"at" json:"at"`
+
+	DrawPile      []Card     `bson:"draw_pile" json:"draw_pile"`
+	DiscardPile   []Card     `bson:"discard_pile" json:"discard_pile"`
+	Players       []Player   `bson:"players" json:"players"`
+	CurrentPlayer int        `bson:"current_player" json:"current_player"`
+	Status        GameStatus `bson:"status" json:"status"`
+	Direction     bool       `bson:"direction" json:"direction"`
+	DarkSide      bool       `bson:"dark_side" json:"dark_side"`
+	GameOver      string     `bson:"winner" json:"game_over"`
+}
+
+// UndoApproval is who has to agree before a move is undone or redone
+type UndoApproval string
+
+// Who can agree to undo a move
+const (
+	// Every player at the table but the one asking
+	UndoByPlayers UndoApproval = "players"
+	// Only the player who created the game
+	UndoByCreator UndoApproval = "creator"
+)
+
+// UndoRequest is a player asking to undo the last move, or to make the last undone move again
+type UndoRequest struct {
+	Redo        bool   `bson:"redo" json:"redo"`
+	RequestedBy string `bson:"requested_by" json:"requested_by"`
+	// When it was asked for, in RFC 3339
+	At string `bson:"at" json:"at"`
+	// The players who have agreed so far
+	Approvals []string `bson:"approvals" json:"approvals"`
+}
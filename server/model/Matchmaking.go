@@ -0,0 +1,41 @@
+package model
+
+// QueueStatus is where a matchmaking ticket is in the queue
+type QueueStatus string
+
+// Possible queue status
+const (
+	// Waiting for enough compatible players
+	QueueWaiting QueueStatus = "waiting"
+	// Claimed by a matcher that is setting up the game
+	QueueMatching QueueStatus = "matching"
+	// Seated in a game, the ticket has the player and game ID
+	QueueMatched QueueStatus = "matched"
+	// The player left the queue
+	QueueCancelled QueueStatus = "cancelled"
+	// Nobody compatible turned up in time
+	QueueExpired QueueStatus = "expired"
+)
+
+// MatchPreferences are the games a queued player is willing to play.
+// Players are only matched with players that have exactly the same preferences.
+type MatchPreferences struct {
+	PlayerCount int       `bson:"player_count" json:"player_count"`
+	Rules       GameRules `bson:"rules" json:"rules"`
+}
+
+// QueueEntry is one player's matchmaking ticket. Its ID is only given to that player.
+type QueueEntry struct {
+	ID          string           `bson:"_id,omitempty" json:"id"`
+	PlayerName  string           `bson:"player_name" json:"player_name"`
+	Preferences MatchPreferences `bson:"preferences" json:"preferences"`
+	Status      QueueStatus      `bson:"status" json:"status"`
+	EnqueuedAt  string           `bson:"enqueued_at" json:"enqueued_at"`
+	// When the status last changed
+	UpdatedAt string `bson:"updated_at" json:"updated_at"`
+	// Set once the player is matched. Their token is made when they pick the ticket up, it is never stored.
+	GameID   string `bson:"game_id" json:"game_id,omitempty"`
+	PlayerID string `bson:"player_id" json:"player_id,omitempty"`
+	// The account the player is rated under, empty for guests
+	Account string `bson:"account,omitempty" json:"account,omitempty"`
+}
@@ -1,7 +1,28 @@
 package model
 
+// MessageChannel decides who can see a chat message
+type MessageChannel string
+
+// Possible message channels
+const (
+	// Seen by everyone at the table, players and spectators alike
+	TableChannel MessageChannel = "table"
+	// Seen only by the sender and the recipient
+	WhisperChannel MessageChannel = "whisper"
+	// Seen only by people watching the game who are not playing in it
+	SpectatorChannel MessageChannel = "spectator"
+	// Seen by everyone, sent by the server's operators rather than a player
+	SystemChannel MessageChannel = "system"
+)
+
 // Represents a Message in the Chat
+// ID and Timestamp are assigned by the server when the message is added
+// Recipient is the ID of the player a whisper is sent to
 type Message struct {
-	Player Player `bson:"player,omitempty" json:"player"`
-	Value  string `bson:"message,omitempty" json:"message"`
+	ID        string         `bson:"id" json:"id"`
+	Player    Player         `bson:"player" json:"player"`
+	Value     string         `bson:"message" json:"message"`
+	Timestamp string         `bson:"timestamp" json:"timestamp"`
+	Channel   MessageChannel `bson:"channel" json:"channel"`
+	Recipient string         `bson:"recipient" json:"recipient,omitempty"`
 }
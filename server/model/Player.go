@@ -2,10 +2,20 @@ package model
 
 // Player Model that represents a Player and their hand
 type Player struct {
-	ID          string `bson:"_id,omitempty" json:"id"`
-	Name        string `bson:"name,omitempty" json:"name"`
-	Cards       []Card `bson:"cards,omitempty" json:"cards"`
-	LastUpdated string `bson:"lastUpdated,omitempty" json:"lastUpdated"`
-	IsActive    bool   `bson:"isActive,omitempty" json:"isActive"`
-	Protection  bool   `bson:"protection,omitempty" json:"protection"`
+	ID          string      `bson:"_id,omitempty" json:"id"`
+	Name        string      `bson:"name" json:"name"`
+	Cards       []Card      `bson:"cards" json:"cards"`
+	LastUpdated string      `bson:"lastUpdated" json:"lastUpdated"`
+	IsActive    bool        `bson:"isActive" json:"isActive"`
+	Protection  bool        `bson:"protection" json:"protection"`
+	CreatedAt   string      `bson:"created_at" json:"created_at"`
+	Tally       PlayerTally `bson:"tally" json:"tally"`
+	// The player's rating when the game started, and where they finished and how that moved their rating
+	Rating      float64 `bson:"rating" json:"rating"`
+	Rank        int     `bson:"rank" json:"rank"`
+	RatingDelta float64 `bson:"rating_delta" json:"rating_delta"`
+	// The team the player is on in team games, numbered from 1. 0 when the game is not played in teams.
+	Team int `bson:"team" json:"team"`
+	// The account the player's rating is kept under, see POST /api/accounts. Empty for guests, who are not rated.
+	Account string `bson:"account,omitempty" json:"account,omitempty"`
 }
@@ -0,0 +1,51 @@
+package model
+
+// DefaultRating is the rating of an account that has never finished a rated game
+const DefaultRating = 1500.0
+
+// Rating is the skill rating of an account, keyed on the account ID a player signs in with
+type Rating struct {
+	Account    string  `bson:"_id" json:"account"`
+	Name       string  `bson:"name" json:"name"`
+	Rating     float64 `bson:"rating" json:"rating"`
+	GamesRated int     `bson:"games_rated" json:"games_rated"`
+	UpdatedAt  string  `bson:"updated_at" json:"updated_at"`
+}
+
+// RatingChange is how one finished game moved an account's rating
+type RatingChange struct {
+	Account string `bson:"account" json:"account"`
+	Name    string `bson:"name" json:"name"`
+	GameID  string `bson:"game_id" json:"game_id"`
+	// Where the player finished, 1 is the winner. Players tied on hand points share a rank.
+	Rank  int     `bson:"rank" json:"rank"`
+	Delta float64 `bson:"delta" json:"delta"`
+	// The rating after the change, filled in when the change is applied
+	Rating float64 `bson:"rating" json:"rating"`
+	At     string  `bson:"at" json:"at"`
+}
+
+// HandPoints is what the cards left in a hand are worth in the standard deck: the face value of number cards,
+// 20 for Skip, Reverse and Draw Two, and 50 for wild cards
+func HandPoints(cards []Card) int {
+	return StandardDeck().HandPoints(cards)
+}
+
+// GameToRatingChanges Converts the rating deltas of a finished Game into one RatingChange per seated player with an account
+func GameToRatingChanges(g Game, at string) []RatingChange {
+	changes := make([]RatingChange, 0, len(g.Players))
+	for _, player := range g.Players {
+		if player.Rank == 0 || player.Account == "" {
+			continue
+		}
+		changes = append(changes, RatingChange{
+			Account: player.Account,
+			Name:    player.Name,
+			GameID:  g.ID,
+			Rank:    player.Rank,
+			Delta:   player.RatingDelta,
+			At:      at,
+		})
+	}
+	return changes
+}
@@ -0,0 +1,62 @@
+package model
+
+import "strings"
+
+// PlayerTally counts what a player did during one game
+type PlayerTally struct {
+	CardsPlayed int `bson:"cards_played" json:"cards_played"`
+	DrawsTaken  int `bson:"draws_taken" json:"draws_taken"`
+	UnoCalls    int `bson:"uno_calls" json:"uno_calls"`
+	Penalties   int `bson:"penalties" json:"penalties"`
+}
+
+// PlayerResult is how one player did in one finished game. Results are kept after the game and
+// the player are cleaned up, so stats can be added up over any window of time.
+type PlayerResult struct {
+	GameID        string      `bson:"game_id" json:"game_id"`
+	PlayerID      string      `bson:"player_id" json:"player_id"`
+	Account       string      `bson:"account" json:"account"`
+	Name          string      `bson:"name" json:"name"`
+	FinishedAt    string      `bson:"finished_at" json:"finished_at"`
+	Won           bool        `bson:"won" json:"won"`
+	FinalHandSize int         `bson:"final_hand_size" json:"final_hand_size"`
+	Tally         PlayerTally `bson:"tally" json:"tally"`
+}
+
+// PlayerStats adds up the results of a player or an account
+type PlayerStats struct {
+	Account         string  `json:"account"`
+	PlayerID        string  `json:"player_id,omitempty"`
+	Name            string  `json:"name"`
+	GamesPlayed     int     `json:"games_played"`
+	GamesWon        int     `json:"games_won"`
+	CardsPlayed     int     `json:"cards_played"`
+	DrawsTaken      int     `json:"draws_taken"`
+	UnoCalls        int     `json:"uno_calls"`
+	Penalties       int     `json:"penalties"`
+	AverageHandSize float64 `json:"average_hand_size"`
+}
+
+// AccountKey is the account a player's results are added up under. There are no logins,
+// so everyone who plays under the same name, ignoring case and spacing, shares an account.
+func AccountKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// GameToResults Converts a finished Game into one PlayerResult per seated player
+func GameToResults(g Game, finishedAt string) []PlayerResult {
+	results := make([]PlayerResult, 0, len(g.Players))
+	for _, player := range g.Players {
+		results = append(results, PlayerResult{
+			GameID:        g.ID,
+			PlayerID:      player.ID,
+			Account:       AccountKey(player.Name),
+			Name:          player.Name,
+			FinishedAt:    finishedAt,
+			Won:           player.Rank == 1,
+			FinalHandSize: len(player.Cards),
+			Tally:         player.Tally,
+		})
+	}
+	return results
+}
@@ -0,0 +1,60 @@
+package model
+
+// TeamStanding is how one team of a team game is doing
+type TeamStanding struct {
+	Team    int      `json:"team"`
+	Players []string `json:"players"`
+	// What the cards left in the team's hands are worth
+	HandPoints int `json:"hand_points"`
+	// Where the team finished, set once the game is over
+	Rank int `json:"rank"`
+	// The winning team scores the points left in every other team's hands
+	Score int `json:"score"`
+}
+
+// IsTeammate reports whether two players are partners in a team game
+func IsTeammate(a Player, b Player) bool {
+	return a.Team != 0 && a.Team == b.Team
+}
+
+// GameToTeamStandings Converts a team Game to one TeamStanding per team, in team order
+func GameToTeamStandings(g Game) []TeamStanding {
+	standings := make([]TeamStanding, g.Rules.Teams)
+	for i := range standings {
+		standings[i] = TeamStanding{Team: i + 1, Players: []string{}}
+	}
+
+	deck := g.ActiveDeck()
+	for _, player := range g.Players {
+		if player.Team < 1 || player.Team > len(standings) {
+			continue
+		}
+
+		standing := &standings[player.Team-1]
+		standing.Players = append(standing.Players, player.Name)
+		standing.HandPoints += deck.HandPoints(Faces(player.Cards, g.DarkSide))
+
+		// Partners share their team's rank
+		if player.Rank != 0 {
+			standing.Rank = player.Rank
+		}
+	}
+
+	if g.Status != Finished {
+		return standings
+	}
+
+	for i := range standings {
+		if standings[i].Rank != 1 {
+			continue
+		}
+
+		for _, other := range standings {
+			if other.Team != standings[i].Team {
+				standings[i].Score += other.HandPoints
+			}
+		}
+	}
+
+	return standings
+}
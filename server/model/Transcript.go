@@ -0,0 +1,82 @@
+package model
+
+// The kinds of action a game's transcript records
+const (
+	ActionPlay    = "play"
+	ActionDraw    = "draw"
+	ActionCallUno = "call_uno"
+	// Taking the last move back, and making it again, once the players agreed or an operator decided
+	ActionUndo = "undo"
+	ActionRedo = "redo"
+	// An operator taking a player out of the game, or ending it without a winner
+	ActionRemovePlayer = "remove_player"
+	ActionEnd          = "end"
+)
+
+// Action is one thing that happened in a game after it was dealt
+type Action struct {
+	Kind string `bson:"kind" json:"kind"`
+	// Who did it, empty for what an operator did
+	PlayerID string `bson:"player_id,omitempty" json:"player_id,omitempty"`
+	// The card played, a wild card has the color it was played as
+	Card *Card `bson:"card,omitempty" json:"card,omitempty"`
+	// Who Uno was called on, or who was removed
+	TargetID string `bson:"target_id,omitempty" json:"target_id,omitempty"`
+	// The random numbers the action used, such as to reshuffle the discard pile into the draw pile,
+	// so replaying it comes out the same
+	Random []int `bson:"random,omitempty" json:"random,omitempty"`
+	// When it happened, in RFC 3339
+	At string `bson:"at,omitempty" json:"at,omitempty"`
+}
+
+// MaxActions is how many actions a game keeps for its transcript. A game that goes on longer
+// stops keeping one, and a transcript with more is turned away.
+const MaxActions = 1000
+
+// TranscriptFormat names the transcript format, so other JSON is not mistaken for a transcript
+const TranscriptFormat = "uno-transcript"
+
+// TranscriptVersion is the version of the transcript format this server writes.
+// It goes up whenever a change would stop an older server replaying a transcript correctly.
+const TranscriptVersion = 1
+
+// Transcript is a whole game from the deal, portable between servers and databases.
+// Replaying its actions from the deal under its rules brings the game to where it was exported.
+type Transcript struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+
+	Name       string         `json:"name"`
+	CreatedAt  string         `json:"created_at,omitempty"`
+	ExportedAt string         `json:"exported_at,omitempty"`
+	Rules      GameRules      `json:"rules"`
+	Deck       DeckDefinition `json:"deck"`
+	// Everyone dealt in, in seat order
+	Players []TranscriptPlayer `json:"players"`
+	// The ID of the player who created the game
+	Creator string         `json:"creator"`
+	Deal    TranscriptDeal `json:"deal"`
+	Actions []Action       `json:"actions"`
+	// How the game stood when it was exported. A replay that ends anywhere else has been tampered with.
+	Status GameStatus `json:"status"`
+	Winner string     `json:"winner,omitempty"`
+}
+
+// TranscriptPlayer is a player at the table. IDs only have to be unique within the transcript.
+type TranscriptPlayer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Team int    `json:"team,omitempty"`
+}
+
+// TranscriptDeal is the order of every card once the game was dealt, and who went first
+type TranscriptDeal struct {
+	DealtAt string `json:"dealt_at,omitempty"`
+	// The top of each pile is its last card
+	DrawPile    []Card `json:"draw_pile"`
+	DiscardPile []Card `json:"discard_pile"`
+	// Each player's hand, by seat
+	Hands         [][]Card `json:"hands"`
+	CurrentPlayer int      `json:"current_player"`
+	Direction     bool     `json:"direction"`
+}
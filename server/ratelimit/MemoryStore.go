@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the buckets in this process, so each replica limits on its own
+type MemoryStore struct {
+	mutex   sync.Mutex
+	buckets map[string]*memoryBucket
+	pruned  time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	rate Rate
+}
+
+// pruneEvery is how often buckets that have filled back up are forgotten
+const pruneEvery = time.Minute
+
+// NewMemoryStore makes a store with no buckets
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take takes a token from the key's bucket
+func (s *MemoryStore) Take(key string, rate Rate, now time.Time) (time.Duration, error) {
+	if rate.Off() {
+		return 0, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{bucket: bucket{tokens: float64(rate.Limit), updated: now}}
+		s.buckets[key] = b
+	}
+	b.rate = rate
+
+	return b.take(rate, now), nil
+}
+
+// prune forgets the buckets that would be full by now, they are the same as no bucket
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.pruned) < pruneEvery {
+		return
+	}
+	s.pruned = now
+
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= b.rate.Per {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Close does nothing, the buckets go with the process
+func (s *MemoryStore) Close() error {
+	return nil
+}
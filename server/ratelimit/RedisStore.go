@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// redisPrefix is put before every key, so the buckets can share a database with games
+const redisPrefix = "uno:ratelimit:"
+
+// takeScript is bucket.take run inside Redis, so replicas taking from the same bucket cannot both
+// take its last token. Times are in milliseconds. It returns how many to wait, 0 when a token was taken.
+var takeScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local per = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local perToken = per / limit
+
+local saved = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(saved[1]) or limit
+local updated = tonumber(saved[2]) or now
+
+if now > updated then
+	tokens = tokens + (now - updated) / perToken
+	updated = now
+end
+if tokens > limit then
+	tokens = limit
+end
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * perToken)
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(updated))
+redis.call("PEXPIRE", KEYS[1], per)
+return wait
+`)
+
+// RedisStore keeps the buckets in Redis, so every replica takes from the same buckets
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore connects to the Redis server at url, which looks like redis://localhost:6379/0
+func NewRedisStore(url string) (*RedisStore, error) {
+	if url == "" {
+		url = "redis://localhost:6379/0"
+	}
+
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &RedisStore{client: client}, nil
+}
+
+// Take takes a token from the key's bucket
+func (s *RedisStore) Take(key string, rate Rate, now time.Time) (time.Duration, error) {
+	if rate.Off() {
+		return 0, nil
+	}
+
+	wait, err := takeScript.Run(s.client, []string{redisPrefix + key},
+		rate.Limit, rate.Per.Milliseconds(), now.UnixNano()/int64(time.Millisecond)).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// Close disconnects from Redis
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
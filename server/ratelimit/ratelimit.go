@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate lets Limit requests through per Per. Each key has a bucket of Limit tokens that refills
+// evenly over Per, so a quiet client can burst up to Limit requests at once.
+// The zero Rate lets everything through.
+type Rate struct {
+	Limit int
+	Per   time.Duration
+}
+
+// Off is whether the rate lets everything through
+func (r Rate) Off() bool {
+	return r.Limit <= 0 || r.Per <= 0
+}
+
+func (r Rate) String() string {
+	if r.Off() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", r.Limit, r.Per)
+}
+
+// ParseRate reads a rate written like 20/1m, or off
+func ParseRate(text string) (Rate, error) {
+	if text == "off" || text == "0" {
+		return Rate{}, nil
+	}
+
+	parts := strings.Split(text, "/")
+	if len(parts) != 2 {
+		return Rate{}, fmt.Errorf("a rate looks like 20/1m, got %q", text)
+	}
+
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit < 1 {
+		return Rate{}, fmt.Errorf("a rate needs at least 1 request, got %q", text)
+	}
+
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return Rate{}, fmt.Errorf("a rate needs a duration like 1m, got %q", text)
+	}
+
+	return Rate{Limit: limit, Per: per}, nil
+}
+
+// Store keeps the buckets
+type Store interface {
+	// Take takes a token from the key's bucket. When the bucket is empty nothing is taken
+	// and it says how long until there is a token again.
+	Take(key string, rate Rate, now time.Time) (retryAfter time.Duration, err error)
+	// Close lets go of whatever the store holds
+	Close() error
+}
+
+// bucket is how full a key's bucket was when it was last used
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket for the time since it was last used, then takes a token if there is one
+func (b *bucket) take(rate Rate, now time.Time) time.Duration {
+	perToken := float64(rate.Per) / float64(rate.Limit)
+
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens += float64(elapsed) / perToken
+		b.updated = now
+	}
+	if b.tokens > float64(rate.Limit) {
+		b.tokens = float64(rate.Limit)
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) * perToken)
+}
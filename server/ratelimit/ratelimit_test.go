@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redisTestURL returns REDIS_TEST_URL for a local redis-server, or starts a miniredis
+func redisTestURL(t *testing.T) string {
+	if url := os.Getenv("REDIS_TEST_URL"); url != "" {
+		return url
+	}
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	return "redis://" + server.Addr()
+}
+
+func TestParseRate(t *testing.T) {
+	rate, err := ParseRate("20/1m")
+	assert.Nil(t, err)
+	assert.Equal(t, Rate{Limit: 20, Per: time.Minute}, rate)
+	assert.Equal(t, "20/1m0s", rate.String())
+
+	rate, err = ParseRate("off")
+	assert.Nil(t, err)
+	assert.True(t, rate.Off())
+
+	for _, bad := range []string{"", "20", "0/1m", "-1/1m", "20/soon", "20/0s", "a/1m"} {
+		_, err := ParseRate(bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"redis": func(t *testing.T) Store {
+			store, err := NewRedisStore(redisTestURL(t))
+			require.Nil(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		newStore := newStore
+		t.Run(name, func(t *testing.T) {
+			t.Run("Bursts", func(t *testing.T) { storeBursts(t, newStore(t)) })
+			t.Run("Refills", func(t *testing.T) { storeRefills(t, newStore(t)) })
+			t.Run("KeysAreSeparate", func(t *testing.T) { storeKeysAreSeparate(t, newStore(t)) })
+			t.Run("Off", func(t *testing.T) { storeOff(t, newStore(t)) })
+		})
+	}
+}
+
+var threePerMinute = Rate{Limit: 3, Per: time.Minute}
+
+func storeBursts(t *testing.T, store Store) {
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		wait, err := store.Take("ip:1", threePerMinute, now)
+		require.Nil(t, err)
+		assert.Zero(t, wait, "request %d", i)
+	}
+
+	// A token comes back every 20 seconds
+	wait, err := store.Take("ip:1", threePerMinute, now)
+	require.Nil(t, err)
+	assert.Equal(t, 20*time.Second, wait)
+
+	wait, _ = store.Take("ip:1", threePerMinute, now.Add(5*time.Second))
+	assert.Equal(t, 15*time.Second, wait)
+}
+
+func storeRefills(t *testing.T, store Store) {
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		store.Take("ip:1", threePerMinute, now)
+	}
+
+	wait, _ := store.Take("ip:1", threePerMinute, now.Add(20*time.Second))
+	assert.Zero(t, wait)
+	wait, _ = store.Take("ip:1", threePerMinute, now.Add(20*time.Second))
+	assert.NotZero(t, wait)
+
+	// Waiting longer than the whole period only fills the bucket
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		wait, _ = store.Take("ip:1", threePerMinute, later)
+		assert.Zero(t, wait)
+	}
+	wait, _ = store.Take("ip:1", threePerMinute, later)
+	assert.NotZero(t, wait)
+}
+
+func storeKeysAreSeparate(t *testing.T, store Store) {
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		store.Take("ip:1", threePerMinute, now)
+	}
+
+	wait, _ := store.Take("ip:2", threePerMinute, now)
+	assert.Zero(t, wait)
+	wait, _ = store.Take("ip:1", threePerMinute, now)
+	assert.NotZero(t, wait)
+}
+
+func storeOff(t *testing.T, store Store) {
+	for i := 0; i < 100; i++ {
+		wait, err := store.Take("ip:1", Rate{}, time.Now())
+		require.Nil(t, err)
+		assert.Zero(t, wait)
+	}
+}
+
+func TestMemoryStoreForgetsFullBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+
+	store.Take("ip:1", threePerMinute, now)
+	store.Take("ip:2", threePerMinute, now.Add(59*time.Second))
+	assert.Len(t, store.buckets, 2)
+
+	// ip:1 has filled back up by the time ip:3 comes along, ip:2 has not
+	store.Take("ip:3", threePerMinute, now.Add(61*time.Second))
+	assert.Len(t, store.buckets, 2)
+	assert.NotContains(t, store.buckets, "ip:1")
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/mattwhite180/go-away"
+	"go.uber.org/zap"
+)
+
+// ratingK is the most one game can move a rating. Each opponent is worth an equal share of it.
+const ratingK = 32.0
+
+var errNoOpenGame = errors.New("There is no open game to join")
+
+// rankPlayers sets where everyone finished: the current player won,
+// and everyone else is ranked by the points left in their hand, fewest first.
+// In team games partners finish together: the current player's team won,
+// and the other teams are ranked by the points left in all their hands.
+func rankPlayers(game *model.Game) {
+	// Flip games are scored on the side that is showing when the game ends
+	deck := game.ActiveDeck()
+	points := make([]int, len(game.Players))
+	for i, player := range game.Players {
+		points[i] = deck.HandPoints(model.Faces(player.Cards, game.DarkSide))
+	}
+
+	// Everyone is their own team when the game is not played in teams
+	side := func(i int) int {
+		if game.Rules.Teams == 0 {
+			return -i - 1
+		}
+		return game.Players[i].Team
+	}
+
+	sidePoints := map[int]int{}
+	for i := range game.Players {
+		sidePoints[side(i)] += points[i]
+	}
+
+	winner := side(game.CurrentPlayer)
+	for i := range game.Players {
+		if side(i) == winner {
+			game.Players[i].Rank = 1
+			continue
+		}
+
+		// Sides tied on points share the best rank among them
+		game.Players[i].Rank = 2
+		for other, total := range sidePoints {
+			if other != side(i) && other != winner && total < sidePoints[side(i)] {
+				game.Players[i].Rank++
+			}
+		}
+	}
+}
+
+// rateGame ranks the players of a game that just ended and works out how their ratings move.
+// Every pair of opponents is scored as an Elo match, a win counts 1, a tie ½ and a loss 0,
+// against the chance the ratings they started with gave them.
+func rateGame(game *model.Game) {
+	rankPlayers(game)
+
+	if !isRated(game) {
+		return
+	}
+
+	for i := range game.Players {
+		// Partners are not rated against each other
+		var total, opponents float64
+		for j := range game.Players {
+			if i == j || model.IsTeammate(game.Players[i], game.Players[j]) {
+				continue
+			}
+			opponents++
+
+			expected := 1 / (1 + math.Pow(10, (startingRating(game.Players[j])-startingRating(game.Players[i]))/400))
+
+			actual := 0.5
+			if game.Players[i].Rank < game.Players[j].Rank {
+				actual = 1
+			} else if game.Players[i].Rank > game.Players[j].Rank {
+				actual = 0
+			}
+
+			total += actual - expected
+		}
+
+		if opponents > 0 {
+			game.Players[i].RatingDelta = ratingK / opponents * total
+		}
+	}
+}
+
+// isRated says whether a game moves ratings. Unrated games and games with one player are not rated,
+// and neither are games with a guest at the table, who has no account to rate.
+func isRated(game *model.Game) bool {
+	if len(game.Players) < 2 || game.Rules.Unrated {
+		return false
+	}
+
+	for _, player := range game.Players {
+		if player.Account == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// startingRating is the rating a player started the game with.
+// Games dealt before ratings were kept rate everyone from the default.
+func startingRating(player model.Player) float64 {
+	if player.Rating == 0 {
+		return model.DefaultRating
+	}
+	return player.Rating
+}
+
+// lookupRating is the current rating of an account, or the default for accounts that were never rated
+func lookupRating(database db.UnoDB, account string) (float64, error) {
+	rating, err := database.LookupRating(account)
+
+	if errors.Is(err, db.ErrRatingNotFound) {
+		return model.DefaultRating, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return rating.Rating, nil
+}
+
+// seatRatings remembers everyone's rating as the game starts, so the result is rated against them.
+// Guests are seated at the default rating.
+func seatRatings(database db.UnoDB, game *model.Game) error {
+	accounts := make([]string, 0, len(game.Players))
+	for _, player := range game.Players {
+		if player.Account != "" {
+			accounts = append(accounts, player.Account)
+		}
+	}
+
+	ratings, err := database.LookupRatings(accounts)
+	if err != nil {
+		return err
+	}
+
+	for i, player := range game.Players {
+		game.Players[i].Rating = accountRating(ratings, player.Account)
+	}
+	return nil
+}
+
+// accountRating is an account's rating out of a batch lookup, or the default for guests and accounts that were never rated
+func accountRating(ratings map[string]model.Rating, account string) float64 {
+	if rated, ok := ratings[account]; ok && account != "" {
+		return rated.Rating
+	}
+	return model.DefaultRating
+}
+
+// recordRatings applies the rating changes of a game that just ended, when it is rated.
+// The game is already saved, so a failure is only logged.
+func recordRatings(ctx context.Context, database db.UnoDB, game *model.Game, at time.Time) {
+	if !isRated(game) {
+		return
+	}
+
+	err := database.ApplyRatingChanges(model.GameToRatingChanges(*game, at.UTC().Format(time.RFC3339)))
+
+	if err != nil {
+		logFrom(ctx).Error("Could not record the ratings of a game", zap.String("game_id", game.ID), zap.Error(err))
+	}
+}
+
+// findBalancedGame picks the open lobby whose players' average rating is closest to rating.
+// Ties go to the lobby with more players, then the older one, so lobbies fill up before new ones start.
+// Only the oldest page of open lobbies is considered, and their players' ratings are read in one batch.
+func findBalancedGame(database db.UnoDB, rating float64) (*model.Game, error) {
+	page, err := database.QueryGames(db.GameQuery{Status: model.WaitingForPlayers, Sort: db.SortOldest, Limit: db.MaxPageSize})
+
+	if err != nil {
+		return nil, err
+	}
+
+	open := make([]*model.Game, 0, len(page.Games))
+	accounts := make([]string, 0)
+	for i := range page.Games {
+		game := &page.Games[i]
+		if len(game.Players) == 0 || len(game.Players) >= model.MaxPlayers {
+			continue
+		}
+
+		open = append(open, game)
+		for _, player := range game.Players {
+			if player.Account != "" {
+				accounts = append(accounts, player.Account)
+			}
+		}
+	}
+
+	ratings, err := database.LookupRatings(accounts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		game *model.Game
+		gap  float64
+	}
+	candidates := make([]candidate, 0, len(open))
+
+	for _, game := range open {
+		var total float64
+		for _, player := range game.Players {
+			total += accountRating(ratings, player.Account)
+		}
+
+		candidates = append(candidates, candidate{game: game, gap: math.Abs(total/float64(len(game.Players)) - rating)})
+	}
+
+	if len(candidates) == 0 {
+		return nil, errNoOpenGame
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.gap != b.gap {
+			return a.gap < b.gap
+		}
+		if len(a.game.Players) != len(b.game.Players) {
+			return len(a.game.Players) > len(b.game.Players)
+		}
+		if a.game.CreatedAt != b.game.CreatedAt {
+			return a.game.CreatedAt < b.game.CreatedAt
+		}
+		return a.game.ID < b.game.ID
+	})
+
+	return candidates[0].game, nil
+}
+
+// matchGame joins the player to the open lobby that best fits their rating
+func (s *server) matchGame(c echo.Context) error {
+	m := echo.Map{}
+	err := c.Bind(&m)
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not bind to input")
+	}
+
+	playerName, _ := m["playerName"].(string)
+
+	if playerName == "" {
+		return c.JSON(http.StatusBadRequest, "Missing player name")
+	}
+
+	if goaway.IsProfane(playerName) {
+		return c.JSON(http.StatusBadRequest, "Profane player name")
+	}
+
+	account, err := accountFromRequest(c)
+
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, err.Error())
+	}
+
+	database := s.games.databaseFor(c.Request().Context())
+
+	// Guests are matched as if they had the default rating
+	rating := model.DefaultRating
+
+	if account != "" {
+		rating, err = lookupRating(database, account)
+
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Could not look up your rating")
+		}
+	}
+
+	game, err := findBalancedGame(database, rating)
+
+	if err == errNoOpenGame {
+		return c.JSON(http.StatusNotFound, err.Error())
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not find a game")
+	}
+
+	player, err := s.games.createPlayer(c.Request().Context(), playerName, account)
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not create player")
+	}
+
+	game, err = s.games.joinGame(c.Request().Context(), game.ID, player)
+
+	if err == errGameFull {
+		return c.JSON(http.StatusConflict, err.Error())
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not join game")
+	}
+
+	token := generateToken(player)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"token": token, "game": buildGameState(game, player.ID)})
+}
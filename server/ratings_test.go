@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandPoints(t *testing.T) {
+	assert.Equal(t, 0, model.HandPoints(nil))
+	assert.Equal(t, 7+20+20+20+50+50, model.HandPoints([]model.Card{
+		{Color: "red", Value: "7"},
+		{Color: "red", Value: "S"},
+		{Color: "blue", Value: "R"},
+		{Color: "green", Value: "D2"},
+		{Color: "black", Value: "W"},
+		{Color: "black", Value: "W4"},
+	}))
+}
+
+func TestRankPlayers(t *testing.T) {
+	game := &model.Game{CurrentPlayer: 1, Players: []model.Player{
+		{Cards: []model.Card{{Color: "red", Value: "9"}}},
+		{},
+		{Cards: []model.Card{{Color: "red", Value: "1"}, {Color: "blue", Value: "2"}}},
+		{Cards: []model.Card{{Color: "black", Value: "W"}}},
+		{Cards: []model.Card{{Color: "green", Value: "3"}}},
+	}}
+
+	rankPlayers(game)
+
+	// Fewest points first, and the two players on 3 points share second place
+	ranks := []int{}
+	for _, player := range game.Players {
+		ranks = append(ranks, player.Rank)
+	}
+	assert.Equal(t, []int{4, 1, 2, 5, 2}, ranks)
+}
+
+func TestRateGame(t *testing.T) {
+	// Evenly rated players swap half of K
+	game := &model.Game{CurrentPlayer: 0, Players: []model.Player{
+		{Account: "a", Rating: 1500},
+		{Account: "b", Rating: 1500, Cards: []model.Card{{Color: "red", Value: "1"}}},
+	}}
+	rateGame(game)
+	assert.InDelta(t, 16, game.Players[0].RatingDelta, 0.001)
+	assert.InDelta(t, -16, game.Players[1].RatingDelta, 0.001)
+
+	// A favourite gains less for winning than an underdog would
+	favourite := &model.Game{CurrentPlayer: 0, Players: []model.Player{
+		{Account: "c", Rating: 1800},
+		{Account: "d", Rating: 1400, Cards: []model.Card{{Color: "red", Value: "1"}}},
+		{Account: "e", Rating: 1400, Cards: []model.Card{{Color: "red", Value: "5"}}},
+	}}
+	rateGame(favourite)
+	underdog := &model.Game{CurrentPlayer: 1, Players: append([]model.Player{}, favourite.Players...)}
+	rateGame(underdog)
+	assert.True(t, favourite.Players[0].RatingDelta > 0)
+	assert.True(t, underdog.Players[1].RatingDelta > favourite.Players[0].RatingDelta)
+
+	// Ratings only move between the players
+	var total float64
+	for _, player := range underdog.Players {
+		total += player.RatingDelta
+	}
+	assert.InDelta(t, 0, total, 0.001)
+
+	// A guest has no account to rate, so the game is ranked but not rated
+	withGuest := &model.Game{CurrentPlayer: 0, Players: []model.Player{
+		{Account: "a", Rating: 1500},
+		{Rating: 1500, Cards: []model.Card{{Color: "red", Value: "1"}}},
+	}}
+	rateGame(withGuest)
+	assert.Equal(t, 1, withGuest.Players[0].Rank)
+	assert.Equal(t, 0.0, withGuest.Players[0].RatingDelta)
+}
+
+func TestFinishingGameUpdatesRatings(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	winnerAccount, loserAccount := uuid.New().String(), uuid.New().String()
+
+	winner, _ := games.createPlayer(context.Background(), "Winner", winnerAccount)
+	loser, _ := games.createPlayer(context.Background(), "Loser", loserAccount)
+	game, _ := database.CreateGame("Rated", winner.ID)
+	game, _ = database.JoinGame(game.ID, winner.ID)
+	game, _ = database.JoinGame(game.ID, loser.ID)
+
+	game, err := games.dealCards(context.Background(), game)
+	assert.Nil(t, err)
+	assert.Equal(t, model.DefaultRating, game.Players[0].Rating)
+
+	game.CurrentPlayer = 0
+	game.DiscardPile = []model.Card{{Color: "red", Value: "2"}}
+	game.Players[0].Cards = []model.Card{{Color: "red", Value: "5"}}
+	database.SaveGame(*game)
+
+	game, err = games.playCard(context.Background(), game.ID, winner.ID, model.Card{Color: "red", Value: "5"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, game.Players[0].Rank)
+	assert.Equal(t, 2, game.Players[1].Rank)
+
+	rating, err := database.LookupRating(winnerAccount)
+	assert.Nil(t, err)
+	assert.InDelta(t, model.DefaultRating+16, rating.Rating, 0.001)
+	assert.Equal(t, 1, rating.GamesRated)
+
+	history, _ := database.GetRatingHistory(loserAccount)
+	assert.Len(t, *history, 1)
+	assert.Equal(t, game.ID, (*history)[0].GameID)
+	assert.InDelta(t, model.DefaultRating-16, (*history)[0].Rating, 0.001)
+}
+
+func TestFindBalancedGame(t *testing.T) {
+	database := db.NewMockDB()
+
+	_, err := findBalancedGame(database, model.DefaultRating)
+	assert.Equal(t, errNoOpenGame, err)
+
+	// Everyone plays under an account named after them
+	lobby := func(names ...string) *model.Game {
+		creator, _ := newPlayer(database, names[0], strings.ToLower(names[0]))
+		game, _ := database.CreateGame(names[0]+"'s game", creator.ID)
+		for _, name := range names {
+			player, _ := newPlayer(database, name, strings.ToLower(name))
+			game, _ = database.JoinGame(game.ID, player.ID)
+		}
+		return game
+	}
+
+	database.ApplyRatingChanges([]model.RatingChange{
+		{Account: "pro", Name: "Pro", GameID: "1", Rank: 1, Delta: 400},
+		{Account: "ace", Name: "Ace", GameID: "1", Rank: 1, Delta: 300},
+	})
+
+	beginners := lobby("New", "Newer")
+	experts := lobby("Pro", "Ace")
+
+	started := lobby("Pro")
+	started.Status = model.Playing
+	database.SaveGame(*started)
+
+	match, err := findBalancedGame(database, model.DefaultRating)
+	assert.Nil(t, err)
+	assert.Equal(t, beginners.ID, match.ID)
+
+	match, err = findBalancedGame(database, 1900)
+	assert.Nil(t, err)
+	assert.Equal(t, experts.ID, match.ID)
+
+	// Only the open lobbies are read, with all their ratings in one lookup
+	match, err = findBalancedGame(queriesOnly{database}, 1900)
+	assert.Nil(t, err)
+	assert.Equal(t, experts.ID, match.ID)
+}
+
+// queriesOnly is a database that refuses to scan every game or look ratings up one at a time
+type queriesOnly struct {
+	db.UnoDB
+}
+
+func (queriesOnly) GetAllGames() (*[]model.Game, error) {
+	return nil, errors.New("Scanned every game")
+}
+
+func (queriesOnly) LookupRating(string) (*model.Rating, error) {
+	return nil, errors.New("Looked up one rating")
+}
@@ -1,80 +1,187 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mattwhite180/go-away"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
 	"github.com/jak103/uno/model"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
 )
 
-var tokenSecret string = "usudevops"
+// tokenSecret signs the tokens players are given, main sets it from the config
+var tokenSecret string = defaultConfig().TokenSecret
+
+// server handles the API. Everything it does to games goes through its GameService.
+type server struct {
+	games *GameService
+	// Closed when the server shuts down, which ends every event stream
+	closing chan struct{}
+	// Turns away clients making too many requests, nothing is limited when it is nil
+	limiter *limiter
+	// Traded for a token to the admin API, which is not served when it is empty
+	adminKey string
+}
+
+func newServer(games *GameService) *server {
+	return &server{games: games, closing: make(chan struct{})}
+}
+
+func (s *server) setupRoutes(e *echo.Echo) {
+	// Each group of routes has its own rate limits
+	public := s.limitByIP(limitPublic)
+	create := s.limitByIP(limitCreate)
 
-func setupRoutes(e *echo.Echo) {
 	// Routes that don't require a valid JWT
-	e.GET("/api/games", getGames)
-    e.GET("/api/games/summary/:id", getGame) 
-	e.POST("/api/games", newGame)
-	e.POST("/api/games/:id/join", joinExistingGame)
+	e.GET("/api/games", s.getGames, public)
+	e.GET("/api/games/summary/:id", s.getGame, public)
+	e.POST("/api/accounts", s.newAccount, create)
+	e.POST("/api/games", s.newGame, create)
+	e.POST("/api/games/:id/join", s.joinExistingGame, create)
+	e.POST("/api/games/match", s.matchGame, create)
+	e.GET("/api/games/:id/export", s.exportFinishedGame, public)
+	e.POST("/api/games/import", s.importGame, create)
+
+	// Matchmaking tickets are only known to the player who queued, so they need no JWT
+	e.POST("/api/matchmaking/enqueue", s.enqueuePlayer, create)
+	e.GET("/api/matchmaking/:id", s.getTicket, public)
+	e.DELETE("/api/matchmaking/:id", s.cancelTicket, public)
+	e.GET("/api/matchmaking/:id/events", s.streamTicketEvents, public)
+	e.GET("/api/leaderboard", s.getLeaderboard, public)
+	e.GET("/api/decks", s.getDecks, public)
+
+	// Probes and metrics for whatever runs the server
+	e.GET("/healthz", s.healthz)
+	e.GET("/readyz", s.readyz)
+	e.GET("/metrics", s.metricsHandler())
 
 	// Create a group that requires a valid JWT
 	group := e.Group("/api")
 
-	group.Use(middleware.JWTWithConfig(middleware.JWTConfig{
+	group.Use(s.limitByIP(limitPlayer), middleware.JWTWithConfig(middleware.JWTConfig{
 		SigningKey: []byte(tokenSecret),
 		AuthScheme: "Token",
-	}))
+	}), tagPlayer, s.limitByPlayer(limitPlayer))
 
 	// Add Message to the Chat
-	group.POST("/chat/:id/add", addNewMessage) // Andrew McMullin
+	group.POST("/chat/:id/add", s.addNewMessage) // Andrew McMullin
+	group.GET("/chat/:id", s.getChatMessages)
+	group.DELETE("/chat/:id/:messageId", s.deleteChatMessage)
+
+	group.POST("/games/:id/start", s.startGame)
+	group.POST("/games/:id/team", s.pickTeam)
+	group.POST("/games/:id/play", s.play) // Ryan Johnson
+	group.POST("/games/:id/draw", s.draw) // Brady Svedin
 
-	group.POST("/games/:id/start", startGame)
-	group.POST("/games/:id/play", play) // Ryan Johnson
-	group.POST("/games/:id/draw", draw) // Brady Svedin
+	group.POST("/games/:id/call", s.callUno) // Zach Ellis
 
-	group.POST("/games/:id/call", callUno) // Zach Ellis
+	// Unrated games can take moves back once the other players, or the creator, agree
+	group.POST("/games/:id/undo", s.askUndo)
+	group.POST("/games/:id/redo", s.askRedo)
+	group.POST("/games/:id/undo/approve", s.approveUndo)
+	group.POST("/games/:id/undo/reject", s.rejectUndo)
 
-	group.GET("/games/:id", getGameState)
-	group.GET("/players/token/:token", getPlayerFromToken)
+	group.GET("/games/:id", s.getGameState)
+	group.GET("/players/token/:token", s.getPlayerFromToken)
 
+	// EventSource cannot send an Authorization header, so the event stream reads its token from the query
+	e.GET("/api/games/:id/events", s.streamGameEvents, s.limitByIP(limitPlayer), middleware.JWTWithConfig(middleware.JWTConfig{
+		SigningKey:  []byte(tokenSecret),
+		TokenLookup: "query:token",
+	}), tagPlayer, s.limitByPlayer(limitPlayer))
+
+	s.setupAdminRoutes(e)
 }
 
-func getGames(c echo.Context) error {
+func (s *server) getGames(c echo.Context) error {
 	//log.Println("Running getGames")
-	database, err := db.GetDb()
+	database := s.games.databaseFor(c.Request().Context())
+
+	query, err := parseGameQuery(c.QueryParams())
 
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Could not find games: Failed to connect to db")
+		return c.JSON(http.StatusBadRequest, err.Error())
 	}
 
-	games, err := database.GetAllGames()
+	page, err := database.QueryGames(query)
 
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, "Could not find games")
 	}
 
 	gameSummaries := make([]model.GameSummary, 0)
-	for _, g := range *games {
+	for _, g := range page.Games {
 		summary := model.GameToSummary(g)
 		gameSummaries = append(gameSummaries, summary)
 	}
 
-	return c.JSON(http.StatusOK, gameSummaries)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"games": gameSummaries,
+		"total": page.Total,
+		"page":  query.Page,
+		"limit": query.Limit,
+	})
 }
 
-func getGame(c echo.Context) error {
-	//log.Println("Running getGames")
-	database, err := db.GetDb()
+// lobbyStatuses are the values the lobby accepts for ?status=
+var lobbyStatuses = map[string]model.GameStatus{
+	"waiting":             model.WaitingForPlayers,
+	"playing":             model.Playing,
+	"finished":            model.Finished,
+	"waiting for players": model.WaitingForPlayers,
+}
 
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Could not find game: Failed to connect to db")
+// parseGameQuery reads ?status=&q=&page=&limit=&sort= for the lobby listing
+func parseGameQuery(params url.Values) (db.GameQuery, error) {
+	query := db.GameQuery{Search: strings.TrimSpace(params.Get("q")), Sort: db.SortNewest, Page: 1, Limit: db.DefaultPageSize}
+
+	if status := params.Get("status"); status != "" {
+		var ok bool
+		if query.Status, ok = lobbyStatuses[strings.ToLower(status)]; !ok {
+			return query, fmt.Errorf("Unknown status '%s'", status)
+		}
+	}
+
+	if sort := params.Get("sort"); sort != "" {
+		var ok bool
+		if query.Sort, ok = db.ParseGameSort(sort); !ok {
+			return query, fmt.Errorf("Cannot sort games by '%s'", sort)
+		}
 	}
+
+	if page := params.Get("page"); page != "" {
+		n, err := strconv.Atoi(page)
+		if err != nil || n < 1 {
+			return query, fmt.Errorf("page must be a number from 1 up")
+		}
+		query.Page = n
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > db.MaxPageSize {
+			return query, fmt.Errorf("limit must be a number from 1 to %d", db.MaxPageSize)
+		}
+		query.Limit = n
+	}
+
+	return query, nil
+}
+
+func (s *server) getGame(c echo.Context) error {
+	//log.Println("Running getGames")
+	database := s.games.databaseFor(c.Request().Context())
     
     gameID := c.Param("id")
     
@@ -89,7 +196,7 @@ func getGame(c echo.Context) error {
 	return c.JSON(http.StatusOK, summary)
 }
 
-func newGame(c echo.Context) error {
+func (s *server) newGame(c echo.Context) error {
 	m := echo.Map{}
 
 	err := c.Bind(&m)
@@ -113,7 +220,45 @@ func newGame(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, "Profane game name or creator")
 	}
 
-	game, creator, gameErr := createNewGame(gameName, creatorName)
+	// Games are dealt with the standard deck unless they pick another
+	deckName, _ := m["deck"].(string)
+	deckName, err = s.games.ruleDeck(deckName)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	// Team games are split into teams whose partners sit opposite each other
+	teams, _ := m["teams"].(float64)
+	shareHands, _ := m["share_hands"].(bool)
+
+	if err := validTeams(int(teams), 0); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	// Unrated games can undo moves, with the agreement of every other player or only the creator
+	unrated, _ := m["unrated"].(bool)
+	undoApproval, _ := m["undo_approval"].(string)
+
+	if err := validUndoApproval(model.UndoApproval(undoApproval)); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	account, err := accountFromRequest(c)
+
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, err.Error())
+	}
+
+	rules := model.GameRules{Deck: deckName, Teams: int(teams), ShareHands: shareHands, Unrated: unrated, UndoApproval: model.UndoApproval(undoApproval)}
+
+	if err := s.games.checkOpenGames(c.Request().Context(), creatorName); err == errTooManyGames {
+		return c.JSON(http.StatusTooManyRequests, err.Error())
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not create game")
+	}
+
+	game, creator, gameErr := s.games.createNewGame(c.Request().Context(), gameName, creatorName, account, rules)
 
 	if gameErr != nil {
 		return gameErr
@@ -125,7 +270,7 @@ func newGame(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]interface{}{"token": token, "game": buildGameState(game, creator.ID)})
 }
 
-func joinExistingGame(c echo.Context) error {
+func (s *server) joinExistingGame(c echo.Context) error {
 	gameID := c.Param("id")
 	m := echo.Map{}
 	err := c.Bind(&m)
@@ -148,36 +293,123 @@ func joinExistingGame(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, "Profane player name")
 	}
 
-	player, _ := createPlayer(playerName)
+	account, err := accountFromRequest(c)
 
-	gameExists, err := checkGameExists(gameID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, err.Error())
+	}
+
+	// The seats of a resumed import are claimed, nobody new is made for them
+	game, player, err := s.games.claimSeat(c.Request().Context(), gameID, playerName)
+
+	if err == nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{"token": generateToken(player), "game": buildGameState(game, player.ID)})
+	}
 
-	if err != nil || !gameExists {
+	if err != errNoUnclaimedSeat && err != errGameNotFound {
+		return c.JSON(http.StatusInternalServerError, "Could not join game")
+	}
+
+	// Players are only made for games they can sit at
+	err = s.games.checkSeat(c.Request().Context(), gameID)
+
+	if err == errGameFull {
+		return c.JSON(http.StatusConflict, err.Error())
+	}
+
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, "Game with ID '"+gameID+"' does not exist")
 	}
 
-	game, _ := joinGame(gameID, player)
+	player, err = s.games.createPlayer(c.Request().Context(), playerName, account)
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not create player")
+	}
+
+	game, err = s.games.joinGame(c.Request().Context(), gameID, player)
+
+	if err == errGameFull {
+		return c.JSON(http.StatusConflict, err.Error())
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Could not join game")
+	}
 
 	token := generateToken(player)
 
-	return c.JSON(http.StatusOK, map[string]interface{}{"token": token, "game": buildGameState(game, player.Name)})
+	return c.JSON(http.StatusOK, map[string]interface{}{"token": token, "game": buildGameState(game, player.ID)})
 }
 
-func addNewMessage(c echo.Context) error {
+func (s *server) addNewMessage(c echo.Context) error {
 	playerID, err := getPlayerFromContext(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
+	}
+
 	var message model.Message
 	c.Bind(&message)
 	gameID := c.Param("id")
 
-	game, err := addMessage(gameID, playerID, message)
+	game, err := s.games.addMessage(c.Request().Context(), gameID, playerID, message)
 
 	if err != nil {
-		return err
+		return c.JSON(chatErrorStatus(err), err.Error())
 	}
 
 	return c.JSON(http.StatusOK, buildGameState(game, playerID))
 }
 
+func (s *server) getChatMessages(c echo.Context) error {
+	playerID, err := getPlayerFromContext(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
+	}
+	gameID := c.Param("id")
+
+	messages, err := s.games.getMessages(c.Request().Context(), gameID, playerID, c.QueryParam("after"))
+
+	if err != nil {
+		return c.JSON(chatErrorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, messages)
+}
+
+func (s *server) deleteChatMessage(c echo.Context) error {
+	playerID, err := getPlayerFromContext(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
+	}
+
+	game, err := s.games.deleteMessage(c.Request().Context(), c.Param("id"), playerID, c.Param("messageId"))
+
+	if err != nil {
+		return c.JSON(chatErrorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, buildGameState(game, playerID))
+}
+
+// chatErrorStatus picks the response code for an error returned by the chat functions
+func chatErrorStatus(err error) int {
+	switch err {
+	case errEmptyMessage, errMessageTooLong, errProfaneMessage, errUnknownChannel, errBadRecipient:
+		return http.StatusBadRequest
+	case errNotSpectator, errNotSeated:
+		return http.StatusForbidden
+	case errChatRateLimited:
+		return http.StatusTooManyRequests
+	case errNotGameCreator:
+		return http.StatusUnauthorized
+	case errGameNotFound, errMessageNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func generateToken(p *model.Player) string {
 	token := jwt.New(jwt.SigningMethodHS256)
 
@@ -195,11 +427,11 @@ func generateToken(p *model.Player) string {
 	return t
 }
 
-func getGameState(c echo.Context) error {
+func (s *server) getGameState(c echo.Context) error {
 	playerID, err := getPlayerFromContext(c)
 	gameID := c.Param("id")
 
-	game, err := getGameUpdate(gameID, playerID)
+	game, err := s.games.getGameUpdate(c.Request().Context(), gameID, playerID)
 
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, "Invalid game ID")
@@ -210,19 +442,95 @@ func getGameState(c echo.Context) error {
 	//return c.JSON(http.StatusOK, map[string]interface{}{"game": buildGameState(game, playerID)}) //buildGameState(game, playerID))
 }
 
-func getPlayerFromToken(c echo.Context) error {
+// eventKeepAlive is how often an idle event stream sends a comment so proxies do not close it
+const eventKeepAlive = 25 * time.Second
 
-	playerID, err := getPlayerFromContext(c)
-	if err != nil {
+// streamGameEvents sends a server-sent event whenever the game changes, whichever replica changed it.
+// Events only say what changed, clients then fetch the game state they are allowed to see.
+func (s *server) streamGameEvents(c echo.Context) error {
+	if _, err := getPlayerFromContext(c); err != nil {
 		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
 	}
 
-	database, err := db.GetDb()
+	gameID := c.Param("id")
+
+	if exists, _ := s.games.checkGameExists(c.Request().Context(), gameID); !exists {
+		return c.JSON(http.StatusNotFound, "Game with ID '"+gameID+"' does not exist")
+	}
+
+	bus := s.games.bus
+
+	subscription := bus.Subscribe(gameID)
+	defer subscription.Close()
+
+	return s.streamEvents(c, subscription)
+}
+
+// streamEvents writes the pending events and then everything the subscription receives
+// as server-sent events, until the client goes away or the server shuts down
+func (s *server) streamEvents(c echo.Context, subscription *events.Subscription, pending ...events.Event) error {
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.WriteHeader(http.StatusOK)
+	response.Flush()
+
+	realtimeClients.Inc()
+	defer realtimeClients.Dec()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	send := func(event events.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(response, "event: %s\ndata: %s\n\n", event.Kind, data)
+		response.Flush()
+		return nil
+	}
+
+	for _, event := range pending {
+		if err := send(event); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-subscription.C:
+			if !ok {
+				return nil
+			}
 
+			if err := send(event); err != nil {
+				return err
+			}
+
+		case <-keepAlive.C:
+			fmt.Fprint(response, ": keep-alive\n\n")
+			response.Flush()
+
+		case <-c.Request().Context().Done():
+			return nil
+
+		case <-s.closing:
+			return nil
+		}
+	}
+}
+
+func (s *server) getPlayerFromToken(c echo.Context) error {
+
+	playerID, err := getPlayerFromContext(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Could not connect to database.")
+		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
 	}
 
+	database := s.games.databaseFor(c.Request().Context())
+
 	player, err := database.LookupPlayer(playerID)
 
 	if err != nil {
@@ -233,20 +541,33 @@ func getPlayerFromToken(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "Unexpected ID returned on lookup. You can only look up player data for yourself.")
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{"name": player.Name, "id": player.ID})
+	// Guests have no account, so they play at the default rating and have no history
+	rating, history := model.DefaultRating, &[]model.RatingChange{}
+
+	if player.Account != "" {
+		rating, err = lookupRating(database, player.Account)
+
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Could not lookup player rating.")
+		}
+
+		history, err = database.GetRatingHistory(player.Account)
+
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Could not lookup player rating.")
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"name": player.Name, "id": player.ID, "rating": rating, "rating_history": history})
 }
 
-func startGame(c echo.Context) error {
+func (s *server) startGame(c echo.Context) error {
 	playerID, err := getPlayerFromContext(c)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
 	}
 
-	database, err := db.GetDb()
-
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Could not connect to database.")
-	}
+	database := s.games.databaseFor(c.Request().Context())
 
 	gameID := c.Param("id")
 
@@ -261,7 +582,11 @@ func startGame(c echo.Context) error {
 	}
 
 	// get the game state back after dealing cards, etc.
-	game, saveErr := dealCards(game)
+	game, saveErr := s.games.dealCards(c.Request().Context(), game)
+
+	if saveErr == errUnevenTeams || saveErr == errDeckTooSmall || saveErr == errNoStartingCard {
+		return c.JSON(http.StatusConflict, saveErr.Error())
+	}
 
 	if saveErr != nil {
 		return c.JSON(http.StatusInternalServerError, "Could not save game state.")
@@ -272,7 +597,7 @@ func startGame(c echo.Context) error {
 	return c.JSON(http.StatusOK, gameState)
 }
 
-func play(c echo.Context) error {
+func (s *server) play(c echo.Context) error {
 	playerID, err := getPlayerFromContext(c)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
@@ -281,35 +606,34 @@ func play(c echo.Context) error {
 	var card model.Card
 	c.Bind(&card)
 
-	log.Println("Player card", card)
+	logFrom(c.Request().Context()).Debug("Playing a card", zap.String("color", card.Color), zap.String("value", card.Value))
 
-	game, err := playCard(c.Param("id"), playerID, card)
+	game, err := s.games.playCard(c.Request().Context(), c.Param("id"), playerID, card)
 
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, "Error playing the game card")
+		return moveError(c, err, "Error playing the game card")
 	}
 
 	return c.JSON(http.StatusOK, buildGameState(game, playerID))
 }
 
-func draw(c echo.Context) error {
+func (s *server) draw(c echo.Context) error {
 	playerID, err := getPlayerFromContext(c)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
 	}
 	gameID := c.Param("id")
 
-	game, err := drawCard(gameID, playerID)
+	game, err := s.games.drawCard(c.Request().Context(), gameID, playerID)
 
 	if err != nil {
-		return err
+		return moveError(c, err, "Error drawing a card")
 	}
 
 	return c.JSON(http.StatusOK, buildGameState(game, playerID))
 }
 
-func callUno(c echo.Context) error {
-	log.Println("Handling callUno post")
+func (s *server) callUno(c echo.Context) error {
 	playerID, err := getPlayerFromContext(c)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
@@ -319,10 +643,10 @@ func callUno(c echo.Context) error {
 
 	gameID := c.Param("id")
 
-	game, err := logicCallUno(gameID, playerID, calledOnPlayer.ID)
+	game, err := s.games.logicCallUno(c.Request().Context(), gameID, playerID, calledOnPlayer.ID)
 
 	if err != nil {
-		return err
+		return moveError(c, err, "Error calling Uno")
 	}
 
 	return c.JSON(http.StatusOK, buildGameState(game, playerID))
@@ -335,33 +659,68 @@ func buildGameState(game *model.Game, playerID string) map[string]interface{} {
 	gameState["direction"] = game.Direction
 	gameState["draw_pile"] = game.DrawPile
 	gameState["discard_pile"] = game.DiscardPile
+	gameState["dark_side"] = game.DarkSide
 	gameState["game_id"] = game.ID
 	gameState["status"] = game.Status
 	gameState["name"] = game.Name
 	gameState["player_id"] = playerID
-	gameState["messages"] = game.Messages
 	gameState["gameOver"] = game.GameOver
+	gameState["deck"] = game.ActiveDeck()
+
+	// Flip games show the side of every card that is facing the player:
+	// the discard pile and their own hand show the active side, the backs of
+	// everyone else's cards and of the draw pile show the inactive side
+	twoSided := game.CardDeck().Dark != nil
+	if twoSided {
+		gameState["draw_pile"] = model.Faces(game.DrawPile, !game.DarkSide)
+		gameState["discard_pile"] = model.Faces(game.DiscardPile, game.DarkSide)
+	}
 
 	if game.DiscardPile != nil {
-		gameState["current_card"] = game.DiscardPile[len(game.DiscardPile)-1]
+		gameState["current_card"] = game.DiscardPile[len(game.DiscardPile)-1].Face(game.DarkSide)
 	} else {
 		gameState["current_card"] = model.Card{}
 	}
 
-	for _, player := range game.Players {
-		if player.ID != playerID {
-			for i := range player.Cards {
-				player.Cards[i].Color = "Blank"
-				player.Cards[i].Value = "Blank"
+	var viewer *model.Player
+	if index := findPlayer(game, playerID); index >= 0 {
+		viewer = &game.Players[index]
+	}
+
+	players := make([]model.Player, len(game.Players))
+	for index, player := range game.Players {
+		if !canSeeHand(game, viewer, player) {
+			if twoSided {
+				player.Cards = model.Faces(player.Cards, !game.DarkSide)
+			} else {
+				player.Cards = make([]model.Card, len(player.Cards))
+				for i := range player.Cards {
+					player.Cards[i].Color = "Blank"
+					player.Cards[i].Value = "Blank"
+				}
 			}
 		} else {
-			gameState["player_cards"] = player.Cards
+			// Partners who share hands see each other's cards as well as their own
+			player.Cards = model.Faces(player.Cards, game.DarkSide)
+			if player.ID == playerID {
+				gameState["player_cards"] = player.Cards
+			}
 		}
+		players[index] = player
 	}
 
-	gameState["all_players"] = game.Players
-	gameState["current_player"] = game.Players[game.CurrentPlayer]
+	gameState["all_players"] = players
+	gameState["current_player"] = players[game.CurrentPlayer]
 	gameState["creator"] = game.Creator
+
+	if game.Rules.Teams != 0 {
+		gameState["rules"] = game.Rules
+		gameState["teams"] = model.GameToTeamStandings(*game)
+	}
+
+	if game.Rules.Unrated {
+		gameState["undo"] = buildUndoState(game)
+	}
 	return gameState
 }
 
@@ -372,7 +731,12 @@ func getPlayerFromContext(c echo.Context) (string, error) {
 	}
 	user := c.Get("user").(*jwt.Token)
 	claims := user.Claims.(jwt.MapClaims)
-	playerID := claims["playerId"].(string)
+
+	// Admin tokens belong to no player
+	playerID, ok := claims["playerId"].(string)
+	if !ok {
+		return "", errors.New("The token does not belong to a player")
+	}
 
 	return playerID, nil
 }
@@ -4,189 +4,84 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
+	"github.com/google/uuid"
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/model"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
 
-// TODO: It will be far easier and cleaner to write these tests when we support a MockDB and JWT authentication in unit tests
-// For now, I will not run tests that will polute users local databases or require authentication,
-// Tests will also not deeply check data that is returned from users local databases.
-// TLDR: These tests aren't great, but at least they aren't breaking the build :^)
-
-var unitTestUserName = "UNIT_TEST_USER"
-var unitTestGameName = "UNIT_TEST_GAME_NAME"
-
-func TestGetGames(t *testing.T) {
-	// Setup
-	e := echo.New()
-	setupRoutes(e)
-	req := httptest.NewRequest(http.MethodGet, "/api/games", nil)
-	rec := httptest.NewRecorder()
-	c := e.NewContext(req, rec)
-
-	// Assertions
-	if assert.NoError(t, getGames(c)) {
-		assert.Equal(t, http.StatusOK, rec.Code)
-
-		var recData map[string]interface{}
-		json.Unmarshal([]byte(rec.Body.String()), &recData)
-		// TODO: Once we actually support a MockDB for unit tests, check the validity of our response
-		assert.True(t, true)
+func TestParseGameQuery(t *testing.T) {
+	query, err := parseGameQuery(url.Values{})
+	assert.Nil(t, err)
+	assert.Equal(t, db.GameQuery{Sort: db.SortNewest, Page: 1, Limit: db.DefaultPageSize}, query)
+
+	query, err = parseGameQuery(url.Values{
+		"status": {"Waiting"},
+		"q":      {"  friday  "},
+		"page":   {"2"},
+		"limit":  {"5"},
+		"sort":   {"players"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, db.GameQuery{Status: model.WaitingForPlayers, Search: "friday", Sort: db.SortPlayers, Page: 2, Limit: 5}, query)
+
+	for _, bad := range []url.Values{
+		{"status": {"lost"}},
+		{"sort": {"password"}},
+		{"page": {"0"}},
+		{"page": {"two"}},
+		{"limit": {"1000"}},
+	} {
+		_, err = parseGameQuery(bad)
+		assert.NotNil(t, err, "%v should be rejected", bad)
 	}
 }
 
-func TestNewGame(t *testing.T) {
-	/*
-		assert.True(t, true)
-		// Setup
-		e := echo.New()
-		setupRoutes(e)
-		var postPayload = `{ "name" : "` + unitTestGameName + `", "creator" : "` + unitTestUserName + `" }`
-		req := httptest.NewRequest(http.MethodPost, "/api/games", strings.NewReader(postPayload))
-		req.Header.Set("Content-Type", "application/json")
-		rec := httptest.NewRecorder()
-		c := e.NewContext(req, rec)
-
-		// Assertions
-		if assert.NoError(t, newGame(c)) {
-			assert.Equal(t, http.StatusOK, rec.Code)
-
-			var recData map[string]interface{}
-			json.Unmarshal([]byte(rec.Body.String()), &recData)
-			// TODO: Once we actually support a MockDB for unit tests, check the validity of our response
-			assert.True(t, true)
-		}
-	*/
-	assert.True(t, true)
-}
-
-func TestJoinExistingGame(t *testing.T) {
-	/*
-		// Setup
-		e := echo.New()
-		setupRoutes(e)
-		var postPayload = `{ "playerName" : "` + unitTestUserName + `" }`
-		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(postPayload))
-		req.Header.Set("Content-Type", "application/json")
-		rec := httptest.NewRecorder()
-		c := e.NewContext(req, rec)
-		c.SetPath("/api/games/:id/join")
-
-		// This test will create a game and then join the game
-		game, _, gameErr := createNewGame(unitTestGameName, unitTestUserName)
-		if assert.NoError(t, gameErr) {
-			c.SetParamNames("id")
-			c.SetParamValues(game.ID)
-		}
-
-		// Assertions
-		if assert.NoError(t, joinExistingGame(c)) {
-			assert.Equal(t, http.StatusOK, rec.Code)
-
-			var recData map[string]interface{}
-			json.Unmarshal([]byte(rec.Body.String()), &recData)
-			// TODO: Once we actually support a MockDB for unit tests, check the validity of our response
-			assert.True(t, true)
-		}
-	*/
-	assert.True(t, true)
-}
-
-func TestStartGame(t *testing.T) {
-	/*
-		// TODO: We need to find a solution to add jwt to context for this to work
-		// Setup
-		e := echo.New()
-		setupRoutes(e)
-		var postPayload = `{ "playerName" : "` + unitTestUserName + `" }`
-		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(postPayload))
-		req.Header.Set("Content-Type", "application/json")
-		rec := httptest.NewRecorder()
-		c := e.NewContext(req, rec)
-		c.SetPath("/games/:id/start")
-		game, creator, gameErr := createNewGame(unitTestGameName, unitTestUserName)
-		if assert.NoError(t, gameErr) {
-			token := generateToken(creator)
-			c.SetParamNames("id")
-			c.SetParamValues(game.ID)
-			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
-			if assert.NoError(t, startGame(c)) {
-				assert.Equal(t, http.StatusOK, rec.Code)
-
-				var recData map[string]interface{}
-				json.Unmarshal([]byte(rec.Body.String()), &recData)
-				// TODO: Once we actually support a MockDB for unit tests, check the validity of our response
-				assert.True(t, true)
-			}
-		}
-	*/
-	assert.True(t, true)
-}
-
-func TestPlay(t *testing.T) {
-	assert.True(t, true)
-}
-
-func TestDraw(t *testing.T) {
-	assert.True(t, true)
-}
-
-func TestGetGameState(t *testing.T) {
-	assert.True(t, true)
-}
-
-func TestGetGames_error(t *testing.T) {
-	assert.True(t, true)
-}
-
-func TestNewGame_error(t *testing.T) {
-	assert.True(t, true)
-}
-
-func TestJoinExistingGame_error(t *testing.T) {
-	assert.True(t, true)
-}
-
-func TestStartGame_error(t *testing.T) {
-	// Setup
-	e := echo.New()
-	setupRoutes(e)
-	req := httptest.NewRequest(http.MethodGet, "/startgame", nil)
-	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	//rec := httptest.NewRecorder()
-	//c := e.NewContext(req, rec)
-
-	// Assertions
-	//assert.Error(t, login(c)) // This should error because no game was created
-}
+func TestGetGames(t *testing.T) {
+	games := newTestService()
+	database := games.database
+
+	// A name no other test uses, so only these games match
+	tag := uuid.New().String()
+	creator, _ := database.CreatePlayer("Creator")
+	for i := 0; i < 3; i++ {
+		game, _ := database.CreateGame(tag, creator.ID)
+		player, _ := database.CreatePlayer("Joiner")
+		database.JoinGame(game.ID, player.ID)
+	}
 
-func TestPlay_error(t *testing.T) {
-	// Setup
 	e := echo.New()
-	setupRoutes(e)
-	req := httptest.NewRequest(http.MethodGet, "/play", nil)
-	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	// TODO: finish mocking up an auth header here, and in other tests
-	/*isHost := true
-	  encodedJWT, err := newJWT("Thomas", "userid", "gameid", isHost, []byte(signKey))
-
-	  assert.Equal(t, nil, err)
-
-	  req.Header.Set(echo.HeaderAuthorization, "bearer" + encodedJWT)*/
-
-	//rec := httptest.NewRecorder()
-	//c := e.NewContext(req, rec)
-
-	// Assertions
-	//assert.Error(t, login(c)) // This should error because no game was created
-}
-
-func TestDraw_error(t *testing.T) {
-	assert.True(t, true)
-}
-
-func TestGetGameState_error(t *testing.T) {
-	assert.True(t, true)
+	req := httptest.NewRequest(http.MethodGet, "/api/games?q="+tag+"&limit=2", nil)
+	rec := httptest.NewRecorder()
+	assert.Nil(t, newServer(games).getGames(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response struct {
+		Games []model.GameSummary `json:"games"`
+		Total int                 `json:"total"`
+		Page  int                 `json:"page"`
+		Limit int                 `json:"limit"`
+	}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, 3, response.Total)
+	assert.Equal(t, 1, response.Page)
+	assert.Equal(t, 2, response.Limit)
+	assert.Equal(t, 2, len(response.Games))
+
+	// The lobby only sees names, never anyone's cards
+	summary := response.Games[0]
+	assert.Equal(t, []string{"Creator", "Joiner"}, summary.Players)
+	assert.Equal(t, 2, summary.PlayerCount)
+	assert.Equal(t, model.MaxPlayers-2, summary.OpenSeats)
+	assert.NotEqual(t, "", summary.CreatedAt)
+	assert.NotContains(t, rec.Body.String(), "cards")
+
+	req = httptest.NewRequest(http.MethodGet, "/api/games?sort=password", nil)
+	rec = httptest.NewRecorder()
+	assert.Nil(t, newServer(games).getGames(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	red3 = model.Card{Color: "red", Value: "3"}
+	red5 = model.Card{Color: "red", Value: "5"}
+)
+
+// tableGame seats players around a table playing the standard deck, each holding a pair of red 3s,
+// with a red 5 on the discard pile and seat to play
+func tableGame(players int, seat int, forward bool) *model.Game {
+	game := &model.Game{Status: model.Playing, CurrentPlayer: seat, Direction: forward, Rules: model.GameRules{Unrated: true}}
+	for i, name := range playerNames(players) {
+		game.Players = append(game.Players, model.Player{
+			ID:    fmt.Sprint("player-", i),
+			Name:  name,
+			Cards: []model.Card{red3, red3},
+		})
+	}
+
+	game.DiscardPile = []model.Card{red5}
+	game.DrawPile = generateShuffledDeck(testRand(), model.StandardDeck(), players)
+	return game
+}
+
+// seatAfter counts steps seats on from seat, up the seats when play goes forward
+func seatAfter(players int, seat int, steps int, forward bool) int {
+	if !forward {
+		steps = -steps
+	}
+	return ((seat+steps)%players + players) % players
+}
+
+func playTurn(game *model.Game, seat int, card model.Card) error {
+	return resolveTurn(testRand(), game, turnAction{kind: actionPlay, playerID: game.Players[seat].ID, card: card})
+}
+
+// ruleCase is a card and what the official rules say happens when it is played
+type ruleCase struct {
+	name string
+	// The card in the player's hand, and how it is played
+	held   model.Card
+	played model.Card
+	// How many seats on, in the direction of play before the card, play goes with this many players
+	steps func(players int) int
+	// Whether the card turns play around
+	reverses bool
+	// How many cards the next player draws
+	draws int
+}
+
+func steps(n int) func(int) int {
+	return func(int) int { return n }
+}
+
+var ruleCases = []ruleCase{
+	{name: "number", held: model.Card{Color: "red", Value: "7"}, played: model.Card{Color: "red", Value: "7"}, steps: steps(1)},
+	{name: "matching number", held: model.Card{Color: "blue", Value: "5"}, played: model.Card{Color: "blue", Value: "5"}, steps: steps(1)},
+	{name: "skip", held: model.Card{Color: "red", Value: "S"}, played: model.Card{Color: "red", Value: "S"}, steps: steps(2)},
+	// With two players a reverse is a skip, so the player goes again
+	{name: "reverse", held: model.Card{Color: "red", Value: "R"}, played: model.Card{Color: "red", Value: "R"}, reverses: true, steps: func(players int) int {
+		if players == 2 {
+			return 0
+		}
+		return -1
+	}},
+	{name: "draw two", held: model.Card{Color: "red", Value: "D2"}, played: model.Card{Color: "red", Value: "D2"}, steps: steps(2), draws: 2},
+	{name: "wild", held: model.Card{Color: "black", Value: "W"}, played: model.Card{Color: "blue", Value: "W"}, steps: steps(1)},
+	{name: "wild draw four", held: model.Card{Color: "black", Value: "W4"}, played: model.Card{Color: "green", Value: "W4"}, steps: steps(2), draws: 4},
+}
+
+func TestTurnOrderRules(t *testing.T) {
+	for _, rule := range ruleCases {
+		for players := 2; players <= model.MaxPlayers; players++ {
+			for _, forward := range []bool{true, false} {
+				// The first and last seats check play wraps around the table
+				for _, seat := range []int{0, players - 1} {
+					name := fmt.Sprintf("%s, %d players, seat %d, forward %v", rule.name, players, seat, forward)
+
+					game := tableGame(players, seat, forward)
+					game.Players[seat].Cards = []model.Card{rule.held, red3, red3}
+
+					assert.Nil(t, playTurn(game, seat, rule.played), name)
+					assert.Equal(t, model.Playing, game.Status, name)
+					assert.Equal(t, seatAfter(players, seat, rule.steps(players), forward), game.CurrentPlayer, name)
+					assert.Equal(t, forward != rule.reverses, game.Direction, name)
+					assert.Equal(t, rule.played, game.DiscardPile[len(game.DiscardPile)-1], name)
+
+					// Only the player who was next draws
+					victim := seatAfter(players, seat, 1, forward)
+					for i, player := range game.Players {
+						switch i {
+						case seat:
+							assert.Len(t, player.Cards, 2, name)
+							assert.Equal(t, 1, player.Tally.CardsPlayed, name)
+						case victim:
+							assert.Len(t, player.Cards, 2+rule.draws, name)
+							assert.Equal(t, rule.draws, player.Tally.DrawsTaken, name)
+						default:
+							assert.Len(t, player.Cards, 2, name)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLastCardEndsTheGame(t *testing.T) {
+	for _, rule := range ruleCases {
+		for players := 2; players <= model.MaxPlayers; players++ {
+			name := fmt.Sprintf("%s, %d players", rule.name, players)
+
+			seat := players / 2
+			game := tableGame(players, seat, true)
+			game.Players[seat].Cards = []model.Card{rule.held}
+
+			assert.Nil(t, playTurn(game, seat, rule.played), name)
+
+			// The game ends on the winner whatever the card does to the order of play
+			assert.Equal(t, model.Finished, game.Status, name)
+			assert.Equal(t, seat, game.CurrentPlayer, name)
+			assert.Equal(t, game.Players[seat].Name, game.GameOver, name)
+			assert.Equal(t, 1, game.Players[seat].Rank, name)
+			assert.Empty(t, game.Players[seat].Cards, name)
+
+			// A last draw card still makes the next player draw, and those cards count against them
+			victim := seatAfter(players, seat, 1, true)
+			assert.Len(t, game.Players[victim].Cards, 2+rule.draws, name)
+
+			// Nobody can move once the game is over
+			next := seatAfter(players, seat, 1, true)
+			assert.Equal(t, errGameNotPlaying, playTurn(game, seat, red3), name)
+			assert.Equal(t, errGameNotPlaying, playTurn(game, next, red3), name)
+			assert.Equal(t, errGameNotPlaying, resolveTurn(testRand(), game, turnAction{kind: actionDraw, playerID: game.Players[seat].ID}), name)
+			assert.Equal(t, seat, game.CurrentPlayer, name)
+		}
+	}
+}
+
+func TestTeamWinEndsTheGameOnTheWinner(t *testing.T) {
+	game := tableGame(4, 1, true)
+	game.Rules.Teams = 2
+	for i := range game.Players {
+		game.Players[i].Team = i%2 + 1
+	}
+	game.Players[1].Cards = []model.Card{{Color: "red", Value: "D2"}}
+
+	assert.Nil(t, playTurn(game, 1, model.Card{Color: "red", Value: "D2"}))
+	assert.Equal(t, model.Finished, game.Status)
+	assert.Equal(t, 1, game.CurrentPlayer)
+	assert.Len(t, game.Players[2].Cards, 4)
+	assert.Equal(t, []int{2, 1, 2, 1}, []int{game.Players[0].Rank, game.Players[1].Rank, game.Players[2].Rank, game.Players[3].Rank})
+}
+
+func TestEmptyPilesAreRefilled(t *testing.T) {
+	// The discard pile is shuffled back in when the draw pile runs out, all but its top card
+	game := tableGame(3, 0, true)
+	game.Players[0].Cards = []model.Card{{Color: "red", Value: "D2"}, red3}
+	game.DrawPile = nil
+	game.DiscardPile = []model.Card{{Color: "blue", Value: "1"}, {Color: "green", Value: "2"}, {Color: "yellow", Value: "4"}, red5}
+
+	assert.Nil(t, playTurn(game, 0, model.Card{Color: "red", Value: "D2"}))
+	assert.Len(t, game.Players[1].Cards, 4)
+	assert.Equal(t, []model.Card{{Color: "red", Value: "D2"}}, game.DiscardPile)
+	assert.Len(t, game.DrawPile, 2)
+	assert.Equal(t, 2, game.CurrentPlayer)
+
+	// With every other card in someone's hand there is nothing to draw, and no cards are made up
+	for players := 2; players <= model.MaxPlayers; players++ {
+		name := fmt.Sprintf("%d players", players)
+
+		game = tableGame(players, 0, true)
+		game.Players[0].Cards = []model.Card{{Color: "black", Value: "W4"}, red3}
+		game.DrawPile = nil
+
+		// Only the red 5 under the draw 4 is there to draw
+		assert.Nil(t, playTurn(game, 0, model.Card{Color: "red", Value: "W4"}), name)
+		assert.Len(t, game.Players[1].Cards, 3, name)
+		assert.Equal(t, []model.Card{{Color: "red", Value: "W4"}}, game.DiscardPile, name)
+		assert.Empty(t, game.DrawPile, name)
+
+		next := game.CurrentPlayer
+		held := len(game.Players[next].Cards)
+		assert.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionDraw, playerID: game.Players[next].ID}), name)
+		assert.Len(t, game.Players[next].Cards, held, name)
+		assert.Equal(t, seatAfter(players, next, 1, true), game.CurrentPlayer, name)
+	}
+
+	// Uno penalties draw what there is
+	game = tableGame(3, 0, true)
+	game.Players[1].Cards = []model.Card{red3}
+	game.DrawPile = []model.Card{red5}
+	assert.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionCallUno, playerID: game.Players[0].ID, targetID: game.Players[1].ID}))
+	assert.Len(t, game.Players[1].Cards, 2)
+	assert.Equal(t, 1, game.Players[1].Tally.Penalties)
+
+	assert.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionCallUno, playerID: game.Players[2].ID, targetID: game.Players[0].ID}))
+	assert.Len(t, game.Players[2].Cards, 2)
+	assert.Equal(t, 1, game.Players[2].Tally.Penalties)
+}
+
+func TestDrawingPassesOrKeepsTheTurn(t *testing.T) {
+	for players := 2; players <= model.MaxPlayers; players++ {
+		for _, forward := range []bool{true, false} {
+			name := fmt.Sprintf("%d players, forward %v", players, forward)
+
+			// A card that can be played can be played straight away
+			game := tableGame(players, 0, forward)
+			game.DrawPile = []model.Card{{Color: "red", Value: "9"}}
+			assert.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionDraw, playerID: game.Players[0].ID}), name)
+			assert.Equal(t, 0, game.CurrentPlayer, name)
+			assert.Nil(t, playTurn(game, 0, model.Card{Color: "red", Value: "9"}), name)
+
+			// Otherwise play passes on
+			game = tableGame(players, 0, forward)
+			game.DrawPile = []model.Card{{Color: "blue", Value: "9"}}
+			assert.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionDraw, playerID: game.Players[0].ID}), name)
+			assert.Equal(t, seatAfter(players, 0, 1, forward), game.CurrentPlayer, name)
+			assert.Len(t, game.Players[0].Cards, 3, name)
+		}
+	}
+}
+
+func TestInvalidMovesChangeNothing(t *testing.T) {
+	for players := 2; players <= model.MaxPlayers; players++ {
+		name := fmt.Sprintf("%d players", players)
+
+		game := tableGame(players, 0, true)
+		game.Players[0].Cards = []model.Card{{Color: "blue", Value: "7"}, {Color: "black", Value: "W"}, red3}
+		game.Players[1].Cards = []model.Card{red3, red3}
+		before := fmt.Sprint(*game)
+
+		assert.Equal(t, errNotYourTurn, playTurn(game, 1, red3), name)
+		assert.Equal(t, errNotYourTurn, resolveTurn(testRand(), game, turnAction{kind: actionDraw, playerID: game.Players[1].ID}), name)
+		assert.Equal(t, errNotInGame, resolveTurn(testRand(), game, turnAction{kind: actionDraw, playerID: "stranger"}), name)
+		assert.Equal(t, errCardNotInHand, playTurn(game, 0, model.Card{Color: "red", Value: "8"}), name)
+		assert.Equal(t, errCardNotPlayable, playTurn(game, 0, model.Card{Color: "blue", Value: "7"}), name)
+		assert.Equal(t, errNoColorChosen, playTurn(game, 0, model.Card{Color: "black", Value: "W"}), name)
+		assert.Equal(t, errNoSuchPlayer, resolveTurn(testRand(), game, turnAction{kind: actionCallUno, playerID: game.Players[0].ID, targetID: "stranger"}), name)
+		assert.Equal(t, before, fmt.Sprint(*game), name)
+
+		// Nothing can be played before the game starts
+		game.Status = model.WaitingForPlayers
+		assert.Equal(t, errGameNotPlaying, playTurn(game, 0, red3), name)
+	}
+}
+
+func TestCallingUno(t *testing.T) {
+	game := tableGame(3, 2, true)
+	game.Players[0].Cards = []model.Card{red3}
+
+	// Calling it on yourself keeps you safe from being caught
+	assert.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionCallUno, playerID: game.Players[0].ID, targetID: game.Players[0].ID}))
+	assert.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionCallUno, playerID: game.Players[1].ID, targetID: game.Players[0].ID}))
+	assert.Len(t, game.Players[0].Cards, 1)
+	assert.Equal(t, 1, game.Players[0].Tally.UnoCalls)
+
+	// Until you next draw
+	game.CurrentPlayer = 0
+	game.DrawPile = []model.Card{{Color: "blue", Value: "9"}}
+	assert.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionDraw, playerID: game.Players[0].ID}))
+	game.Players[0].Cards = game.Players[0].Cards[:1]
+	game.DrawPile = generateShuffledDeck(testRand(), model.StandardDeck(), 3)
+
+	assert.Nil(t, resolveTurn(testRand(), game, turnAction{kind: actionCallUno, playerID: game.Players[2].ID, targetID: game.Players[0].ID}))
+	assert.Len(t, game.Players[0].Cards, 1+unoPenaltyCards)
+	assert.Equal(t, 1, game.Players[0].Tally.Penalties)
+
+	// Calls never change whose turn it is
+	assert.Equal(t, 1, game.CurrentPlayer)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
+	"github.com/jak103/uno/model"
+	"go.uber.org/zap"
+)
+
+// GameService plays games. It holds everything a game needs from outside the rules,
+// so tests can run games side by side, each with its own database, clock, random numbers and events.
+type GameService struct {
+	database db.UnoDB
+	clock    clock
+	rng      random
+	bus      events.Bus
+	// The decks games can be dealt with
+	decks *deckRegistry
+	chat  *chatRateLimiter
+	// How many unfinished games one creator can have, 0 for any number
+	maxOpenGames int
+}
+
+// newGameService makes a service whose games can be dealt with the standard deck, loadDecks adds more
+func newGameService(database db.UnoDB, clock clock, rng random, bus events.Bus) *GameService {
+	return &GameService{
+		database: database,
+		clock:    clock,
+		rng:      rng,
+		bus:      bus,
+		decks:    newDeckRegistry(),
+		chat:     newChatRateLimiter(messageRateLimit, messageRateWindow),
+	}
+}
+
+// random is where cards are shuffled and starting players picked, so tests can seed it
+type random interface {
+	Intn(n int) int
+	Shuffle(n int, swap func(i, j int))
+}
+
+// lockedRand is a random source that every request can share
+type lockedRand struct {
+	mutex sync.Mutex
+	rng   *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rng.Intn(n)
+}
+
+func (r *lockedRand) Shuffle(n int, swap func(i, j int)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rng.Shuffle(n, swap)
+}
+
+// notifyGame tells every client watching the game, on any replica, that it changed.
+// A lost event only delays a client until its next refresh, so a failed publish does not fail the move.
+func (s *GameService) notifyGame(ctx context.Context, gameID string, kind string) {
+	s.publish(ctx, events.Event{GameID: gameID, Kind: kind})
+}
+
+// publish sends an event to every client watching its game, a failed publish is only logged
+func (s *GameService) publish(ctx context.Context, event events.Event) {
+	if err := s.bus.Publish(event); err != nil {
+		logFrom(ctx).Warn("Could not publish an event", zap.String("kind", event.Kind), zap.String("game_id", event.GameID), zap.Error(err))
+	}
+}
+
+// databaseFor is the database as seen by whatever the context is doing, with every call traced
+func (s *GameService) databaseFor(ctx context.Context) db.UnoDB {
+	return tracedDatabase(ctx, s.database)
+}
+
+// now is the service's time as it is written into games
+func (s *GameService) now() string {
+	return s.clock.Now().UTC().Format(time.RFC3339)
+}
+
+// updateGame changes a game in one read-modify-write, so moves made at the same time on different replicas
+// are applied one after the other instead of overwriting each other. update can run more than once when
+// another change got in first, so it must not do anything besides changing the game.
+func updateGame(database db.UnoDB, gameID string, update func(game *model.Game) error) (*model.Game, error) {
+	game, err := database.UpdateGame(gameID, update)
+	if errors.Is(err, db.ErrGameNotFound) {
+		return nil, errGameNotFound
+	}
+	return game, err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestService is a GameService of its own for one test, with an empty mock database,
+// a clock that only moves when the test moves it, seeded random numbers and a local event bus
+func newTestService() *GameService {
+	return newGameService(db.NewMockDB(), &fakeClock{now: time.Now()}, testRand(), events.NewLocalBus())
+}
+
+// testRand is a seeded random source, so shuffles come out the same on every run
+func testRand() random {
+	return rand.New(rand.NewSource(1))
+}
+
+// failingSaves is a database that loses every game it is asked to save
+type failingSaves struct {
+	db.UnoDB
+}
+
+func (failingSaves) SaveGame(model.Game) error {
+	return errors.New("Database is unavailable")
+}
+
+func (failingSaves) UpdateGame(string, func(*model.Game) error) (*model.Game, error) {
+	return nil, errors.New("Database is unavailable")
+}
+
+// renamedAfterRead is a database where the game is renamed by someone else each time it is read
+type renamedAfterRead struct {
+	db.UnoDB
+	renames *int
+}
+
+func (r renamedAfterRead) rename(game *model.Game, err error) (*model.Game, error) {
+	if err == nil {
+		*r.renames++
+		r.UnoDB.UpdateGame(game.ID, func(game *model.Game) error {
+			game.Name = fmt.Sprint("Renamed ", *r.renames)
+			return nil
+		})
+	}
+	return game, err
+}
+
+func (r renamedAfterRead) LookupGameByID(id string) (*model.Game, error) {
+	return r.rename(r.UnoDB.LookupGameByID(id))
+}
+
+func (r renamedAfterRead) JoinGame(id string, playerID string) (*model.Game, error) {
+	return r.rename(r.UnoDB.JoinGame(id, playerID))
+}
+
+func TestChangesMadeSinceTheReadAreKept(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	database := games.database
+
+	// The last rename is the one that has to be kept
+	renames := 0
+	renamed := func(gameID string) {
+		saved, err := database.LookupGameByID(gameID)
+		require.Nil(t, err)
+		assert.Equal(t, fmt.Sprint("Renamed ", renames), saved.Name)
+	}
+
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+	lobby := seatPlayers(t, games, model.GameRules{Teams: 2}, "Alice")
+	player, err := games.createPlayer(ctx, "Bob", "")
+	require.Nil(t, err)
+
+	games.database = renamedAfterRead{database, &renames}
+
+	_, err = games.getGameUpdate(ctx, game.ID, game.Players[0].ID)
+	require.Nil(t, err)
+	renamed(game.ID)
+
+	_, err = games.joinGame(ctx, lobby.ID, player)
+	require.Nil(t, err)
+	renamed(lobby.ID)
+
+	_, err = games.chooseTeam(ctx, lobby.ID, player.ID, 1)
+	require.Nil(t, err)
+	saved, err := database.LookupGameByID(lobby.ID)
+	require.Nil(t, err)
+	assert.Equal(t, 1, saved.Players[1].Team)
+}
+
+func TestServicesDoNotShareGames(t *testing.T) {
+	first := newTestService()
+	second := newTestService()
+
+	game, _ := setupGameWithPlayer(first.database)
+
+	_, err := first.checkGameExists(context.Background(), game.ID)
+	assert.Nil(t, err)
+	_, err = second.checkGameExists(context.Background(), game.ID)
+	assert.NotNil(t, err)
+}
+
+func TestSeededServicesDealTheSameCards(t *testing.T) {
+	deal := func() []model.Card {
+		games := newTestService()
+		return generateShuffledDeck(games.rng, model.StandardDeck(), 1)
+	}
+
+	assert.Equal(t, deal(), deal())
+}
+
+func TestFailedSaveRejectsTheMove(t *testing.T) {
+	games := newTestService()
+	game, player := setupGameWithPlayer(games.database)
+	game.DiscardPile = []model.Card{{Color: "red", Value: "2"}}
+	game.Players[0].Cards = []model.Card{{Color: "red", Value: "5"}, {Color: "blue", Value: "7"}}
+	games.database.SaveGame(*game)
+
+	games.database = failingSaves{games.database}
+
+	_, err := games.playCard(context.Background(), game.ID, player.ID, model.Card{Color: "red", Value: "5"})
+	assert.NotNil(t, err)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/games/"+game.ID+"/play", strings.NewReader(`{"color":"red","value":"5"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(game.ID)
+	c.Set("user", &jwt.Token{Claims: jwt.MapClaims{"playerId": player.ID}})
+
+	assert.Nil(t, newServer(games).play(c))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jak103/uno/events"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errUnevenTeams = errors.New("Every team needs the same number of players")
+	errNotTeamGame = errors.New("This game is not played in teams")
+	errUnknownTeam = errors.New("There is no team with that number")
+	errGameStarted = errors.New("The game has already started")
+	errNotInGame   = errors.New("You cannot participate in a game you do not belong")
+)
+
+// validTeams checks the number of teams a game is created with. Games of playerCount players
+// must split evenly, a playerCount of 0 is a lobby that does not know how many players it will get.
+func validTeams(teams int, playerCount int) error {
+	if teams == 0 {
+		return nil
+	}
+
+	if teams < 2 || teams > model.MaxPlayers/2 {
+		return fmt.Errorf("teams must be 0, or from 2 to %d", model.MaxPlayers/2)
+	}
+
+	if playerCount != 0 && (playerCount%teams != 0 || playerCount/teams < 2) {
+		return fmt.Errorf("%d players cannot be split into %d teams of at least 2", playerCount, teams)
+	}
+
+	return nil
+}
+
+// assignTeam puts a player who has no team yet on the team with the fewest players, the lowest numbered one on a tie
+func assignTeam(game *model.Game, index int) {
+	if game.Rules.Teams == 0 || game.Players[index].Team != 0 {
+		return
+	}
+
+	sizes := make([]int, game.Rules.Teams+1)
+	for _, player := range game.Players {
+		if player.Team > 0 && player.Team <= game.Rules.Teams {
+			sizes[player.Team]++
+		}
+	}
+
+	team := 1
+	for t := 2; t <= game.Rules.Teams; t++ {
+		if sizes[t] < sizes[team] {
+			team = t
+		}
+	}
+
+	game.Players[index].Team = team
+}
+
+// seatTeams seats a team game so the teams take turns and partners sit opposite each other.
+// Every team must have the same number of players.
+func seatTeams(game *model.Game) error {
+	if game.Rules.Teams == 0 {
+		return nil
+	}
+
+	teams := make([][]model.Player, game.Rules.Teams)
+	for _, player := range game.Players {
+		if player.Team < 1 || player.Team > game.Rules.Teams {
+			return errUnevenTeams
+		}
+		teams[player.Team-1] = append(teams[player.Team-1], player)
+	}
+
+	size := len(teams[0])
+	for _, team := range teams {
+		if size == 0 || len(team) != size {
+			return errUnevenTeams
+		}
+	}
+
+	seats := make([]model.Player, 0, len(game.Players))
+	for i := 0; i < size; i++ {
+		for _, team := range teams {
+			seats = append(seats, team[i])
+		}
+	}
+	game.Players = seats
+
+	return nil
+}
+
+// findWinner is the seat of the player who went out, or -1 while everyone still has cards.
+// The current player is checked first. In team games a partner can also be left with no cards,
+// such as after hands are shuffled, which wins the game for their team.
+func findWinner(game *model.Game) int {
+	if len(game.Players[game.CurrentPlayer].Cards) == 0 {
+		return game.CurrentPlayer
+	}
+
+	if game.Rules.Teams == 0 {
+		return -1
+	}
+
+	for i, player := range game.Players {
+		if len(player.Cards) == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// canSeeHand reports whether a player can see another player's cards
+func canSeeHand(game *model.Game, viewer *model.Player, player model.Player) bool {
+	if viewer == nil {
+		return false
+	}
+
+	return viewer.ID == player.ID || (game.Rules.ShareHands && model.IsTeammate(*viewer, player))
+}
+
+// chooseTeam moves a player to another team while the game is still waiting for players
+func (s *GameService) chooseTeam(ctx context.Context, gameID string, playerID string, team int) (*model.Game, error) {
+	database := s.databaseFor(ctx)
+
+	game, err := updateGame(database, gameID, func(game *model.Game) error {
+		if game.Rules.Teams == 0 {
+			return errNotTeamGame
+		}
+
+		if game.Status != model.WaitingForPlayers {
+			return errGameStarted
+		}
+
+		if team < 1 || team > game.Rules.Teams {
+			return errUnknownTeam
+		}
+
+		index := findPlayer(game, playerID)
+		if index < 0 {
+			return errNotInGame
+		}
+
+		game.Players[index].Team = team
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyGame(ctx, game.ID, events.GameUpdated)
+
+	return game, nil
+}
+
+// findPlayer is the seat of a player in a game, or -1 when they are not in it
+func findPlayer(game *model.Game, playerID string) int {
+	for i, player := range game.Players {
+		if player.ID == playerID {
+			return i
+		}
+	}
+	return -1
+}
+
+// pickTeam handles a player choosing their team in the lobby
+func (s *server) pickTeam(c echo.Context) error {
+	playerID, err := getPlayerFromContext(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
+	}
+
+	var request struct {
+		Team int `json:"team"`
+	}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, "Could not bind to input")
+	}
+
+	game, err := s.games.chooseTeam(c.Request().Context(), c.Param("id"), playerID, request.Team)
+
+	switch err {
+	case nil:
+		return c.JSON(http.StatusOK, buildGameState(game, playerID))
+	case errGameNotFound:
+		return c.JSON(http.StatusNotFound, err.Error())
+	case errNotInGame:
+		return c.JSON(http.StatusForbidden, err.Error())
+	case errNotTeamGame, errUnknownTeam:
+		return c.JSON(http.StatusBadRequest, err.Error())
+	case errGameStarted:
+		return c.JSON(http.StatusConflict, err.Error())
+	default:
+		return c.JSON(http.StatusInternalServerError, "Could not change team")
+	}
+}
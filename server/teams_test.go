@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jak103/uno/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// teamLobby creates a lobby for a team game with a player for every name, the first one creates it
+func teamsOf(game *model.Game) []int {
+	teams := []int{}
+	for _, player := range game.Players {
+		teams = append(teams, player.Team)
+	}
+	return teams
+}
+
+func TestValidTeams(t *testing.T) {
+	assert.Nil(t, validTeams(0, 3))
+	assert.Nil(t, validTeams(2, 4))
+	assert.Nil(t, validTeams(3, 6))
+	assert.Nil(t, validTeams(2, 0))
+	assert.NotNil(t, validTeams(1, 4))
+	assert.NotNil(t, validTeams(2, 5))
+	assert.NotNil(t, validTeams(3, 3))
+	assert.NotNil(t, validTeams(6, 0))
+}
+
+func TestTeamsAreAssignedAndSeated(t *testing.T) {
+	games := newTestService()
+
+	game := seatPlayers(t, games, model.GameRules{Teams: 2}, "Ann", "Bob", "Cat")
+	assert.Equal(t, []int{1, 2, 1}, teamsOf(game))
+
+	// Ann switches sides, leaving team 1 short
+	game, err := games.chooseTeam(context.Background(), game.ID, game.Players[0].ID, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 2, 1}, teamsOf(game))
+
+	_, err = games.chooseTeam(context.Background(), game.ID, game.Players[0].ID, 3)
+	assert.Equal(t, errUnknownTeam, err)
+	_, err = games.chooseTeam(context.Background(), game.ID, "stranger", 1)
+	assert.Equal(t, errNotInGame, err)
+
+	player, _ := games.createPlayer(context.Background(), "Dan", "")
+	game, _ = games.joinGame(context.Background(), game.ID, player)
+	assert.Equal(t, 1, game.Players[3].Team)
+
+	dave, _ := games.createPlayer(context.Background(), "Dave", "")
+	game, _ = games.joinGame(context.Background(), game.ID, dave)
+	_, err = games.dealCards(context.Background(), game)
+	assert.Equal(t, errUnevenTeams, err)
+
+	database := games.database
+	game, _ = database.LookupGameByID(game.ID)
+	game.Players = game.Players[:4]
+	database.SaveGame(*game)
+
+	// The teams take turns, so partners sit opposite each other
+	game, err = games.dealCards(context.Background(), game)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2, 1, 2}, teamsOf(game))
+
+	_, err = games.chooseTeam(context.Background(), game.ID, game.Players[0].ID, 2)
+	assert.Equal(t, errGameStarted, err)
+}
+
+func TestTeamWinsWhenAPartnerGoesOut(t *testing.T) {
+	games := newTestService()
+
+	game := seatPlayers(t, games, model.GameRules{Teams: 2, Unrated: true}, "Ann", "Bob", "Cat", "Dan")
+	game, err := games.dealCards(context.Background(), game)
+	assert.Nil(t, err)
+
+	// Cat goes out, so Ann wins with her even though Ann has the most points left
+	game.CurrentPlayer = 2
+	game.DiscardPile = []model.Card{{Color: "red", Value: "2"}}
+	game.Players[0].Cards = []model.Card{{Color: "black", Value: "W"}, {Color: "black", Value: "W4"}}
+	game.Players[1].Cards = []model.Card{{Color: "red", Value: "9"}}
+	game.Players[2].Cards = []model.Card{{Color: "red", Value: "5"}}
+	game.Players[3].Cards = []model.Card{{Color: "blue", Value: "S"}}
+	database := games.database
+	database.SaveGame(*game)
+
+	game, err = games.playCard(context.Background(), game.ID, game.Players[2].ID, model.Card{Color: "red", Value: "5"})
+	assert.Nil(t, err)
+	assert.Equal(t, model.Finished, game.Status)
+	assert.Equal(t, "Cat", game.GameOver)
+
+	ranks := []int{}
+	for _, player := range game.Players {
+		ranks = append(ranks, player.Rank)
+	}
+	assert.Equal(t, []int{1, 2, 1, 2}, ranks)
+
+	// The winners score what is left in the other team's hands
+	standings := model.GameToTeamStandings(*game)
+	assert.Equal(t, []string{"Ann", "Cat"}, standings[0].Players)
+	assert.Equal(t, 100, standings[0].HandPoints)
+	assert.Equal(t, 9+20, standings[0].Score)
+	assert.Equal(t, 2, standings[1].Rank)
+	assert.Equal(t, 0, standings[1].Score)
+
+	for _, result := range model.GameToResults(*game, "") {
+		assert.Equal(t, result.Name == "Ann" || result.Name == "Cat", result.Won, result.Name)
+	}
+}
+
+func TestPartnersShareHands(t *testing.T) {
+	game := &model.Game{Rules: model.GameRules{Teams: 2}, Players: []model.Player{
+		{ID: "ann", Team: 1, Cards: []model.Card{{Color: "red", Value: "1"}}},
+		{ID: "bob", Team: 2, Cards: []model.Card{{Color: "red", Value: "2"}}},
+		{ID: "cat", Team: 1, Cards: []model.Card{{Color: "red", Value: "3"}}},
+		{ID: "dan", Team: 2, Cards: []model.Card{{Color: "red", Value: "4"}}},
+	}, DiscardPile: []model.Card{{Color: "red", Value: "5"}}}
+
+	visible := func() []string {
+		players := buildGameState(game, "ann")["all_players"].([]model.Player)
+		values := []string{}
+		for _, player := range players {
+			values = append(values, player.Cards[0].Value)
+		}
+		return values
+	}
+
+	assert.Equal(t, []string{"1", "Blank", "Blank", "Blank"}, visible())
+
+	game.Rules.ShareHands = true
+	assert.Equal(t, []string{"1", "Blank", "3", "Blank"}, visible())
+	assert.Equal(t, []model.Card{{Color: "red", Value: "1"}}, buildGameState(game, "ann")["player_cards"])
+}
+
+func TestPartnersAreNotRatedAgainstEachOther(t *testing.T) {
+	game := &model.Game{CurrentPlayer: 0, Rules: model.GameRules{Teams: 2}, Players: []model.Player{
+		{Account: "a", Rating: 1500, Team: 1},
+		{Account: "b", Rating: 1500, Team: 2, Cards: []model.Card{{Color: "red", Value: "1"}}},
+		{Account: "c", Rating: 1500, Team: 1, Cards: []model.Card{{Color: "red", Value: "9"}}},
+		{Account: "d", Rating: 1500, Team: 2, Cards: []model.Card{{Color: "red", Value: "2"}}},
+	}}
+	rateGame(game)
+
+	// Evenly rated teams swap half of K, whoever on the team went out
+	assert.InDelta(t, 16, game.Players[0].RatingDelta, 0.001)
+	assert.InDelta(t, 16, game.Players[2].RatingDelta, 0.001)
+	assert.InDelta(t, -16, game.Players[1].RatingDelta, 0.001)
+	assert.InDelta(t, -16, game.Players[3].RatingDelta, 0.001)
+}
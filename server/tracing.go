@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jak103/uno/db"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagation"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans for requests, game functions and database calls.
+// Until setupTracing installs an exporter the spans go nowhere.
+var tracer = otel.Tracer("github.com/jak103/uno")
+
+var (
+	gameIDKey   = attribute.Key("uno.game.id")
+	playerIDKey = attribute.Key("uno.player.id")
+)
+
+// The places traces can be sent
+const (
+	traceToNowhere  = "none"
+	traceToStdout   = "stdout"
+	traceToOTLP     = "otlp"
+	defaultOTLPHost = "localhost:4317"
+)
+
+// setupTracing sends spans where the config says. The returned function flushes the spans
+// still waiting to be sent, and should be called before the server exits.
+func setupTracing(ctx context.Context, cfg config) (func(context.Context) error, error) {
+	var exporter exporttrace.SpanExporter
+	var err error
+
+	switch cfg.TraceExporter {
+	case traceToNowhere:
+		return func(context.Context) error { return nil }, nil
+	case traceToStdout:
+		exporter, err = stdout.NewExporter(stdout.WithoutMetricExport())
+	case traceToOTLP:
+		exporter, err = otlp.NewExporter(ctx, otlpgrpc.NewDriver(otlpgrpc.WithInsecure(), otlpgrpc.WithEndpoint(cfg.OTLPEndpoint)))
+	default:
+		return nil, fmt.Errorf("%q is not a trace exporter", cfg.TraceExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String("uno"))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// traceRequests puts each request in a span named for its route, continuing the caller's trace
+// when the request carries one
+func traceRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := propagation.TraceContext{}.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		name := req.Method
+		if c.Path() != "" {
+			name += " " + c.Path()
+		}
+
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethodKey.String(req.Method), semconv.HTTPRouteKey.String(c.Path())),
+		)
+		defer span.End()
+
+		if id := c.Param("id"); id != "" && routeIDField(c.Path()) == "game_id" {
+			span.SetAttributes(gameIDKey.String(id))
+		}
+
+		c.SetRequest(req.WithContext(ctx))
+
+		if err := next(c); err != nil {
+			span.RecordError(err)
+			c.Error(err)
+		}
+
+		status := c.Response().Status
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(status))
+		return nil
+	}
+}
+
+// startSpan starts the span for a game function, with the game and player it was called for
+func startSpan(ctx context.Context, name string, gameID string, playerID string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{}
+	if gameID != "" {
+		attributes = append(attributes, gameIDKey.String(gameID))
+	}
+	if playerID != "" {
+		attributes = append(attributes, playerIDKey.String(playerID))
+	}
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// tracedDatabase puts each call to the database in a span of the context's trace
+func tracedDatabase(ctx context.Context, database db.UnoDB) db.UnoDB {
+	return db.Observe(database, func(method string) func(error) {
+		_, span := tracer.Start(ctx, "db."+method, trace.WithSpanKind(trace.SpanKindClient))
+		return func(err error) {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}
+	})
+}
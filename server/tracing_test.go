@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// spanRecorder keeps every span that ends
+type spanRecorder struct {
+	mutex sync.Mutex
+	spans []*exporttrace.SpanSnapshot
+}
+
+func (r *spanRecorder) ExportSpans(ctx context.Context, spans []*exporttrace.SpanSnapshot) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (r *spanRecorder) named(name string) *exporttrace.SpanSnapshot {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, span := range r.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+// recordTelemetry sends the spans and log lines of the test to the returned recorder and buffer
+func recordTelemetry(t *testing.T) (*spanRecorder, *bytes.Buffer) {
+	recorder := &spanRecorder{}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorder))
+
+	lines := &bytes.Buffer{}
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(lines), logLevel)
+
+	oldTracer, oldLogger, oldLevel := tracer, logger, logLevel.Level()
+	tracer, logger = provider.Tracer("test"), zap.New(core)
+	t.Cleanup(func() {
+		tracer, logger = oldTracer, oldLogger
+		logLevel.SetLevel(oldLevel)
+	})
+
+	return recorder, lines
+}
+
+// logLines decodes the JSON log lines
+func logLines(t *testing.T, lines *bytes.Buffer) []map[string]interface{} {
+	decoded := []map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(lines.Bytes()))
+	for scanner.Scan() {
+		line := map[string]interface{}{}
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &line), scanner.Text())
+		decoded = append(decoded, line)
+	}
+	return decoded
+}
+
+func tracedServer(games *GameService) *echo.Echo {
+	e := echo.New()
+	e.Use(middleware.RequestID())
+	e.Use(traceRequests)
+	e.Use(logRequests)
+	newServer(games).setupRoutes(e)
+	return e
+}
+
+func TestRequestsAreTracedAndLogged(t *testing.T) {
+	spans, lines := recordTelemetry(t)
+
+	games := newTestService()
+	e := tracedServer(games)
+	game, player := setupGameWithPlayer(games.database)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/games/"+game.ID+"/draw", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Token "+generateToken(player))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// The handler, the game function and its database calls are one trace
+	request := spans.named("POST /api/games/:id/draw")
+	draw := spans.named("drawCard")
+	lookup := spans.named("db.UpdateGame")
+	require.NotNil(t, request)
+	require.NotNil(t, draw)
+	require.NotNil(t, lookup)
+
+	assert.Equal(t, request.SpanContext.TraceID(), lookup.SpanContext.TraceID())
+	assert.Equal(t, request.SpanContext.SpanID(), draw.ParentSpanID)
+	assert.Equal(t, draw.SpanContext.SpanID(), lookup.ParentSpanID)
+	assert.Contains(t, draw.Attributes, gameIDKey.String(game.ID))
+	assert.Contains(t, request.Attributes, playerIDKey.String(player.ID))
+
+	// The request's line says who asked about which game, and which trace to look at
+	logged := logLines(t, lines)
+	require.Len(t, logged, 1)
+	assert.Equal(t, "Request", logged[0]["msg"])
+	assert.Equal(t, rec.Header().Get(echo.HeaderXRequestID), logged[0]["request_id"])
+	assert.Equal(t, game.ID, logged[0]["game_id"])
+	assert.Equal(t, player.ID, logged[0]["player_id"])
+	assert.Equal(t, request.SpanContext.TraceID().String(), logged[0]["trace_id"])
+	assert.Equal(t, float64(http.StatusOK), logged[0]["status"])
+}
+
+func TestCallersTraceIsContinued(t *testing.T) {
+	spans, _ := recordTelemetry(t)
+	e := tracedServer(newTestService())
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("traceparent", traceparent)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	request := spans.named("GET /healthz")
+	require.NotNil(t, request)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext.TraceID().String())
+}
+
+func TestLogLevelCanChangeAtRuntime(t *testing.T) {
+	_, lines := recordTelemetry(t)
+	e, token := adminServer(t, newTestService())
+
+	logFrom(context.Background()).Debug("hidden")
+
+	// Only operators can change it
+	rec := call(e, http.MethodPut, "/api/admin/log-level", "192.0.2.1", "", `{"level": "debug"}`)
+	require.NotEqual(t, http.StatusOK, rec.Code)
+	rec = call(e, http.MethodPut, "/debug/log-level", "192.0.2.1", "", `{"level": "debug"}`)
+	require.NotEqual(t, http.StatusOK, rec.Code)
+
+	rec = call(e, http.MethodPut, "/api/admin/log-level", "192.0.2.1", token, `{"level": "debug"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	logFrom(context.Background()).Debug("shown")
+
+	messages := []interface{}{}
+	for _, line := range logLines(t, lines) {
+		messages = append(messages, line["msg"])
+	}
+	assert.NotContains(t, messages, "hidden")
+	assert.Contains(t, messages, "shown")
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// A game's transcript is its deal and every action since, see model.Transcript.
+// Transcripts are checked by replaying them under the rules, so a tampered one is turned away
+// before anything is saved.
+
+var (
+	errNoTranscript     = errors.New("The game has not been dealt, was dealt before transcripts were kept, or ran too long to keep one")
+	errStillPlaying     = errors.New("The game can be exported once it is over")
+	errBadTranscript    = errors.New("The transcript is not a game that could have been played")
+	errBadImportMode    = errors.New("Games are imported to replay or to resume")
+	errNothingToResume  = errors.New("Only a game that is still being played can be resumed")
+	errNothingToReplay  = errors.New("Only a finished game can be replayed")
+	errTranscriptResult = errors.New("The actions do not end the game the way the transcript says")
+	errNotReplayable    = errors.New("Only plays, draws and Uno calls by the players can be imported")
+	errNoUnclaimedSeat  = errors.New("The game has no seats left to claim")
+)
+
+// The ways a transcript can be imported
+const (
+	// A finished game to look back over, nobody's results or ratings are recorded
+	importReplay = "replay"
+	// A game that carries on where it was exported, unrated and with new players,
+	// whoever imported it in the creator's seat and the other seats claimed by joining
+	importResume = "resume"
+)
+
+// exportGame writes out a game as a transcript
+func exportGame(game *model.Game, exportedAt string) (*model.Transcript, error) {
+	if game.Opening == nil {
+		return nil, errNoTranscript
+	}
+
+	transcript := &model.Transcript{
+		Format:     model.TranscriptFormat,
+		Version:    model.TranscriptVersion,
+		Name:       game.Name,
+		CreatedAt:  game.CreatedAt,
+		ExportedAt: exportedAt,
+		Rules:      game.Rules,
+		Deck:       game.CardDeck(),
+		Deal: model.TranscriptDeal{
+			DealtAt:       game.Opening.At,
+			DrawPile:      copyCards(game.Opening.DrawPile),
+			DiscardPile:   copyCards(game.Opening.DiscardPile),
+			CurrentPlayer: game.Opening.CurrentPlayer,
+			Direction:     game.Opening.Direction,
+		},
+		Actions: append([]model.Action{}, game.Actions...),
+		Status:  game.Status,
+		Winner:  game.GameOver,
+	}
+
+	for _, player := range game.Opening.Players {
+		transcript.Players = append(transcript.Players, model.TranscriptPlayer{ID: player.ID, Name: player.Name, Team: player.Team})
+		transcript.Deal.Hands = append(transcript.Deal.Hands, copyCards(player.Cards))
+	}
+
+	// A creator who left before the deal hands the game to the first seat, as removing them would
+	transcript.Creator = game.Creator.ID
+	if !dealtIn(transcript, transcript.Creator) {
+		transcript.Creator = transcript.Players[0].ID
+	}
+
+	return transcript, nil
+}
+
+// replayTranscript deals a transcript's game and replays every action in it, failing on the first
+// thing that could not have happened under the rules, or that the transcript cannot vouch for,
+// such as an undo or an operator's repair. The game it returns has no ID yet.
+func replayTranscript(transcript *model.Transcript) (*model.Game, error) {
+	game, err := dealTranscript(transcript)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadTranscript, err)
+	}
+
+	for i, action := range transcript.Actions {
+		// Actions are read from the transcript, the game keeps its own copies
+		if action.Card != nil {
+			card := *action.Card
+			action.Card = &card
+		}
+		action.Random = append([]int{}, action.Random...)
+
+		// Whether an undo was agreed to, or who made an operator's repair, is not in the transcript,
+		// so nothing shows they were allowed
+		var err error
+		switch action.Kind {
+		case model.ActionPlay, model.ActionDraw, model.ActionCallUno:
+			if findPlayer(game, action.PlayerID) < 0 {
+				err = errNoSuchPlayer
+			}
+		default:
+			err = errNotReplayable
+		}
+
+		rng := &replayRand{values: action.Random}
+		if err == nil {
+			err = applyAction(rng, game, action)
+		}
+		if err == nil {
+			err = rng.finish()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: action %d: %v", errBadTranscript, i+1, err)
+		}
+	}
+
+	if game.Status != transcript.Status || game.GameOver != transcript.Winner {
+		return nil, fmt.Errorf("%w: %v", errBadTranscript, errTranscriptResult)
+	}
+
+	return game, nil
+}
+
+// dealTranscript checks a transcript's players, rules and deal, and sets up the game as it was dealt
+func dealTranscript(transcript *model.Transcript) (*model.Game, error) {
+	if transcript.Format != model.TranscriptFormat {
+		return nil, fmt.Errorf("the format must be %q", model.TranscriptFormat)
+	}
+
+	if transcript.Version < 1 || transcript.Version > model.TranscriptVersion {
+		return nil, fmt.Errorf("version %d transcripts cannot be read, up to version %d can", transcript.Version, model.TranscriptVersion)
+	}
+
+	if len(transcript.Actions) > model.MaxActions {
+		return nil, fmt.Errorf("a game keeps at most %d actions, not %d", model.MaxActions, len(transcript.Actions))
+	}
+
+	players := len(transcript.Players)
+	if players < 2 || players > model.MaxPlayers {
+		return nil, fmt.Errorf("a game has from 2 to %d players, not %d", model.MaxPlayers, players)
+	}
+
+	seen := make(map[string]bool)
+	for _, player := range transcript.Players {
+		if player.ID == "" || player.Name == "" {
+			return nil, errors.New("every player needs an ID and a name")
+		}
+		if seen[player.ID] {
+			return nil, fmt.Errorf("player %s is seated twice", player.ID)
+		}
+		seen[player.ID] = true
+	}
+
+	if !dealtIn(transcript, transcript.Creator) {
+		return nil, errors.New("the creator has to be one of the players")
+	}
+
+	rules := transcript.Rules
+	if err := validTeams(rules.Teams, players); err != nil {
+		return nil, err
+	}
+
+	// Partners sit opposite each other
+	for seat, player := range transcript.Players {
+		team := 0
+		if rules.Teams != 0 {
+			team = seat%rules.Teams + 1
+		}
+		if player.Team != team {
+			return nil, fmt.Errorf("player %s is on team %d, their seat is on team %d", player.ID, player.Team, team)
+		}
+	}
+
+	if err := validUndoApproval(rules.UndoApproval); err != nil {
+		return nil, err
+	}
+
+	deck := transcript.Deck
+	if err := deck.Validate(); err != nil {
+		return nil, err
+	}
+
+	deal := transcript.Deal
+	if len(deal.Hands) != players {
+		return nil, fmt.Errorf("%d hands were dealt to %d players", len(deal.Hands), players)
+	}
+
+	dealt := append(copyCards(deal.DrawPile), deal.DiscardPile...)
+	for seat, hand := range deal.Hands {
+		if len(hand) != 7 {
+			return nil, fmt.Errorf("player %s was dealt %d cards instead of 7", transcript.Players[seat].ID, len(hand))
+		}
+		dealt = append(dealt, hand...)
+	}
+
+	if len(deal.DiscardPile) != 1 || !isNumberCard(deck, deal.DiscardPile[0]) {
+		return nil, errors.New("the discard pile has to start with one number card")
+	}
+
+	if deal.CurrentPlayer < 0 || deal.CurrentPlayer >= players || !deal.Direction {
+		return nil, errors.New("play has to start with one of the players, going forward")
+	}
+
+	if !sameCards(dealt, deck.Build(players)) {
+		return nil, errors.New("the cards dealt are not the deck")
+	}
+
+	game := &model.Game{
+		Name:          transcript.Name,
+		CreatedAt:     transcript.CreatedAt,
+		Rules:         rules,
+		Deck:          &deck,
+		DrawPile:      copyCards(deal.DrawPile),
+		DiscardPile:   copyCards(deal.DiscardPile),
+		CurrentPlayer: deal.CurrentPlayer,
+		Direction:     deal.Direction,
+		Status:        model.Playing,
+	}
+
+	for seat, player := range transcript.Players {
+		seated := model.Player{ID: player.ID, Name: player.Name, Team: player.Team, Cards: copyCards(deal.Hands[seat])}
+		game.Players = append(game.Players, seated)
+		if player.ID == transcript.Creator {
+			game.Creator = creatorFrom(seated)
+		}
+	}
+
+	opening := takeSnapshot(game, dealMove, "", deal.DealtAt)
+	game.Opening = &opening
+
+	return game, nil
+}
+
+// sameCards says whether two piles hold the same cards. The light and dark faces of a Flip deck are
+// compared on their own, as a deal pairs them at random.
+func sameCards(a []model.Card, b []model.Card) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	faces := func(cards []model.Card) []string {
+		keys := make([]string, 0, 2*len(cards))
+		for _, card := range cards {
+			keys = append(keys, "light "+card.Color+" "+card.Value, "dark "+card.DarkColor+" "+card.DarkValue)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	keysA, keysB := faces(a), faces(b)
+	for i := range keysA {
+		if keysA[i] != keysB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// dealtIn says whether a player was dealt into a transcript's game
+func dealtIn(transcript *model.Transcript, playerID string) bool {
+	for _, player := range transcript.Players {
+		if player.ID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// exportTranscript reads a game and writes it out as a transcript, or reads the transcript kept with its archive
+func (s *GameService) exportTranscript(ctx context.Context, gameID string, finishedOnly bool) (*model.Transcript, error) {
+	ctx, span := startSpan(ctx, "exportTranscript", gameID, "")
+	defer span.End()
+
+	database := s.databaseFor(ctx)
+
+	game, err := database.LookupGameByID(gameID)
+	if err != nil {
+		return s.exportArchive(database, gameID)
+	}
+
+	if finishedOnly && game.Status != model.Finished {
+		return nil, errStillPlaying
+	}
+
+	return exportGame(game, s.now())
+}
+
+// exportArchive reads the transcript kept with a finished game once the janitor has archived it
+func (s *GameService) exportArchive(database db.UnoDB, gameID string) (*model.Transcript, error) {
+	archive, err := database.LookupArchive(gameID)
+	if err != nil {
+		return nil, errGameNotFound
+	}
+
+	if len(archive.Transcript) == 0 {
+		return nil, errNoTranscript
+	}
+
+	var transcript model.Transcript
+	if err := json.Unmarshal(archive.Transcript, &transcript); err != nil {
+		return nil, err
+	}
+	transcript.ExportedAt = s.now()
+
+	return &transcript, nil
+}
+
+// importTranscript checks a transcript and saves its game as a new one, with a new player for every seat,
+// and returns the player in the creator's seat, who is whoever imported it. The other seats of a resumed game
+// are left for others to claim. Resumed games are unrated, as nobody can vouch for how the game got where it is.
+func (s *GameService) importTranscript(ctx context.Context, transcript *model.Transcript, mode string) (*model.Game, *model.Player, error) {
+	ctx, span := startSpan(ctx, "importTranscript", "", "")
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	if mode != importReplay && mode != importResume {
+		return nil, nil, errBadImportMode
+	}
+
+	if _, err := replayTranscript(transcript); err != nil {
+		return nil, nil, err
+	}
+
+	if mode == importResume && transcript.Status != model.Playing {
+		return nil, nil, errNothingToResume
+	}
+
+	if mode == importReplay && transcript.Status != model.Finished {
+		return nil, nil, errNothingToReplay
+	}
+
+	creator := transcript.Players[0].Name
+	for _, player := range transcript.Players {
+		if player.ID == transcript.Creator {
+			creator = player.Name
+		}
+	}
+	if err := s.checkOpenGames(ctx, creator); err != nil {
+		return nil, nil, err
+	}
+
+	// The transcript's player IDs are swapped for the new players', then it is replayed once more
+	// so the game's history and actions are in terms of the new players
+	imported := *transcript
+	imported.Players = nil
+	imported.Actions = nil
+
+	ids := make(map[string]string)
+	players := make([]*model.Player, 0, len(transcript.Players))
+	for _, seated := range transcript.Players {
+		player, err := database.CreatePlayer(seated.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids[seated.ID] = player.ID
+		players = append(players, player)
+
+		seated.ID = player.ID
+		imported.Players = append(imported.Players, seated)
+	}
+
+	imported.Creator = ids[transcript.Creator]
+	for _, action := range transcript.Actions {
+		action.PlayerID, action.TargetID = ids[action.PlayerID], ids[action.TargetID]
+		imported.Actions = append(imported.Actions, action)
+	}
+
+	replayed, err := replayTranscript(&imported)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	game, err := database.CreateGame(transcript.Name, imported.Creator)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The game is as new as the import, so it is not cleaned up as idle straight away
+	replayed.ID = game.ID
+	replayed.Password = game.Password
+	replayed.CreatedAt = game.CreatedAt
+
+	var importer *model.Player
+	for _, player := range players {
+		if seat := findPlayer(replayed, player.ID); seat >= 0 {
+			replayed.Players[seat].CreatedAt = player.CreatedAt
+		}
+		if player.ID == replayed.Creator.ID {
+			replayed.Creator = creatorFrom(*player)
+			importer = player
+		} else if mode == importResume {
+			replayed.UnclaimedSeats = append(replayed.UnclaimedSeats, player.ID)
+		}
+	}
+	if mode == importResume {
+		replayed.Rules.Unrated = true
+	}
+
+	if err := database.SaveGame(*replayed); err != nil {
+		return nil, nil, err
+	}
+
+	logFrom(ctx).Info("Imported a game", zap.String("game_id", replayed.ID), zap.String("mode", mode), zap.Int("actions", len(transcript.Actions)))
+	s.notifyGame(ctx, replayed.ID, events.GameUpdated)
+
+	return replayed, importer, nil
+}
+
+// claimSeat hands a player one of a resumed import's unclaimed seats, the one with their name if there is one
+// and otherwise the first, and returns the player in that seat
+func (s *GameService) claimSeat(ctx context.Context, gameID string, name string) (*model.Game, *model.Player, error) {
+	ctx, span := startSpan(ctx, "claimSeat", gameID, "")
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	var claimed string
+	game, err := updateGame(database, gameID, func(game *model.Game) error {
+		if game.Status != model.Playing || len(game.UnclaimedSeats) == 0 {
+			return errNoUnclaimedSeat
+		}
+
+		pick := 0
+		for i, id := range game.UnclaimedSeats {
+			if seat := findPlayer(game, id); seat >= 0 && strings.EqualFold(game.Players[seat].Name, name) {
+				pick = i
+				break
+			}
+		}
+
+		claimed = game.UnclaimedSeats[pick]
+		game.UnclaimedSeats = append(append([]string{}, game.UnclaimedSeats[:pick]...), game.UnclaimedSeats[pick+1:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	player, err := database.LookupPlayer(claimed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.notifyGame(ctx, game.ID, events.GameUpdated)
+
+	return game, player, nil
+}
+
+// exportFinishedGame serves the transcript of a finished game to anyone
+func (s *server) exportFinishedGame(c echo.Context) error {
+	transcript, err := s.games.exportTranscript(c.Request().Context(), c.Param("id"), true)
+	if err != nil {
+		return transcriptError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, transcript)
+}
+
+// adminExportGame serves the transcript of a game in any state, such as one that has to be moved to another server
+func (s *server) adminExportGame(c echo.Context) error {
+	transcript, err := s.games.exportTranscript(c.Request().Context(), c.Param("id"), false)
+	if err != nil {
+		return transcriptError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, transcript)
+}
+
+// importGame saves a transcript's game as a new game, and hands back a token for the creator's seat.
+// The other seats of a resumed game are claimed by joining it.
+func (s *server) importGame(c echo.Context) error {
+	var body struct {
+		Transcript *model.Transcript `json:"transcript"`
+		// replay or resume, replay when empty
+		Mode string `json:"mode"`
+	}
+
+	if err := c.Bind(&body); err != nil || body.Transcript == nil {
+		return c.JSON(http.StatusBadRequest, "Missing transcript")
+	}
+
+	if body.Mode == "" {
+		body.Mode = importReplay
+	}
+
+	game, importer, err := s.games.importTranscript(c.Request().Context(), body.Transcript, body.Mode)
+	if err != nil {
+		return transcriptError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"token": generateToken(importer), "game": buildGameState(game, importer.ID)})
+}
+
+// transcriptError responds to a transcript that could not be exported or imported
+func transcriptError(c echo.Context, err error) error {
+	switch {
+	case err == errGameNotFound:
+		return c.JSON(http.StatusNotFound, err.Error())
+	case errors.Is(err, errBadTranscript), err == errBadImportMode:
+		return c.JSON(http.StatusBadRequest, err.Error())
+	case err == errNoTranscript, err == errStillPlaying, err == errNothingToResume, err == errNothingToReplay:
+		return c.JSON(http.StatusConflict, err.Error())
+	case err == errTooManyGames:
+		return c.JSON(http.StatusTooManyRequests, err.Error())
+	default:
+		logFrom(c.Request().Context()).Error("Transcript request failed", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "Could not export or import the game")
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copyTranscript is a transcript as it comes back after being saved as JSON
+func copyTranscript(t *testing.T, transcript *model.Transcript) *model.Transcript {
+	encoded, err := json.Marshal(transcript)
+	require.Nil(t, err)
+
+	var copied model.Transcript
+	require.Nil(t, json.Unmarshal(encoded, &copied))
+	return &copied
+}
+
+func TestTranscriptsReplayTheGame(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob", "Carol")
+
+	_, err := games.exportTranscript(ctx, game.ID, true)
+	assert.Equal(t, errStillPlaying, err)
+
+	// Everyone draws until the draw pile runs out and the discard pile is shuffled into it,
+	// which has to come out the same when the game is replayed
+	game = playOut(t, games, game.ID, 20)
+	reshuffled := false
+	for i := 0; i < 200 && !reshuffled; i++ {
+		game, err = games.drawCard(ctx, game.ID, game.Players[game.CurrentPlayer].ID)
+		require.Nil(t, err)
+		reshuffled = len(game.Actions[len(game.Actions)-1].Random) > 0
+	}
+	require.True(t, reshuffled)
+
+	game = playOut(t, games, game.ID, 2000)
+	require.Equal(t, model.Finished, game.Status)
+
+	transcript, err := games.exportTranscript(ctx, game.ID, true)
+	require.Nil(t, err)
+	assert.Equal(t, model.TranscriptFormat, transcript.Format)
+	assert.Equal(t, game.GameOver, transcript.Winner)
+	assert.Len(t, transcript.Actions, len(game.Actions))
+
+	replayed, err := replayTranscript(copyTranscript(t, transcript))
+	require.Nil(t, err)
+	assert.Equal(t, game.DrawPile, replayed.DrawPile)
+	assert.Equal(t, game.DiscardPile, replayed.DiscardPile)
+	require.Len(t, replayed.Players, len(game.Players))
+	for i, player := range game.Players {
+		assert.Equal(t, player.ID, replayed.Players[i].ID)
+		assert.Equal(t, player.Cards, replayed.Players[i].Cards)
+		assert.Equal(t, player.Tally, replayed.Players[i].Tally)
+	}
+	assert.Equal(t, game.GameOver, replayed.GameOver)
+	assert.Equal(t, game.Actions, replayed.Actions)
+}
+
+func TestUndosAndRepairsAreNotImported(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	game := dealGame(t, games, casual, "Alice", "Bob", "Carol")
+	alice, bob, carol := game.Players[0].ID, game.Players[1].ID, game.Players[2].ID
+
+	playOut(t, games, game.ID, 5)
+	_, err := games.requestUndo(ctx, game.ID, alice, false)
+	require.Nil(t, err)
+	games.answerUndo(ctx, game.ID, bob, true)
+	_, err = games.answerUndo(ctx, game.ID, carol, true)
+	require.Nil(t, err)
+
+	_, err = games.undoMove(ctx, game.ID)
+	require.Nil(t, err)
+	_, err = games.removePlayer(ctx, game.ID, carol)
+	require.Nil(t, err)
+	game, err = games.forceEndGame(ctx, game.ID)
+	require.Nil(t, err)
+
+	transcript, err := games.exportTranscript(ctx, game.ID, true)
+	require.Nil(t, err)
+
+	var kinds []string
+	for _, action := range transcript.Actions[len(transcript.Actions)-4:] {
+		kinds = append(kinds, action.Kind)
+	}
+	assert.Equal(t, []string{model.ActionUndo, model.ActionUndo, model.ActionRemovePlayer, model.ActionEnd}, kinds)
+
+	// Nothing in the transcript shows the undos were agreed to or the repairs made by an operator,
+	// so each of them turns the transcript away
+	played := len(transcript.Actions) - 4
+	for _, action := range transcript.Actions[played:] {
+		tampered := copyTranscript(t, transcript)
+		tampered.Actions = append(tampered.Actions[:played], action)
+
+		_, err := replayTranscript(tampered)
+		assert.True(t, errors.Is(err, errBadTranscript), "%v", err)
+		assert.Contains(t, err.Error(), errNotReplayable.Error())
+	}
+
+	_, err = replayTranscript(copyTranscript(t, transcript))
+	assert.True(t, errors.Is(err, errBadTranscript), "%v", err)
+}
+
+func TestTamperedTranscriptsAreRejected(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+	playOut(t, games, game.ID, 30)
+
+	exported, err := games.exportTranscript(ctx, game.ID, false)
+	require.Nil(t, err)
+	_, err = replayTranscript(copyTranscript(t, exported))
+	require.Nil(t, err)
+
+	firstPlay := -1
+	for i, action := range exported.Actions {
+		if action.Kind == model.ActionPlay {
+			firstPlay = i
+			break
+		}
+	}
+	require.True(t, firstPlay >= 0)
+
+	tampered := map[string]func(transcript *model.Transcript){
+		"another format":  func(transcript *model.Transcript) { transcript.Format = "chess" },
+		"a newer version": func(transcript *model.Transcript) { transcript.Version = model.TranscriptVersion + 1 },
+		"a player seated twice": func(transcript *model.Transcript) {
+			transcript.Players[1].ID = transcript.Players[0].ID
+		},
+		"a short hand": func(transcript *model.Transcript) {
+			transcript.Deal.DrawPile = append(transcript.Deal.DrawPile, transcript.Deal.Hands[0][0])
+			transcript.Deal.Hands[0] = transcript.Deal.Hands[0][1:]
+		},
+		"a card that is not in the deck": func(transcript *model.Transcript) {
+			transcript.Deal.Hands[0][0] = model.Card{Color: "red", Value: "W4"}
+		},
+		"a card played out of turn": func(transcript *model.Transcript) {
+			action := &transcript.Actions[firstPlay]
+			for _, player := range transcript.Players {
+				if player.ID != action.PlayerID {
+					action.PlayerID = player.ID
+					break
+				}
+			}
+		},
+		"a card nobody had": func(transcript *model.Transcript) {
+			transcript.Actions[firstPlay].Card = &model.Card{Color: "orange", Value: "7"}
+		},
+		"a random number left over": func(transcript *model.Transcript) {
+			transcript.Actions[0].Random = append(transcript.Actions[0].Random, 0)
+		},
+		"a draw by someone who is not playing": func(transcript *model.Transcript) {
+			transcript.Actions[0].PlayerID = "stranger"
+		},
+		"an undo in a rated game": func(transcript *model.Transcript) {
+			undo := model.Action{Kind: model.ActionUndo, PlayerID: transcript.Players[0].ID}
+			transcript.Actions = append(transcript.Actions[:1:1], append([]model.Action{undo}, transcript.Actions[1:]...)...)
+		},
+		"more actions than a game keeps": func(transcript *model.Transcript) {
+			for len(transcript.Actions) <= model.MaxActions {
+				transcript.Actions = append(transcript.Actions, transcript.Actions[0])
+			}
+		},
+		"a different winner": func(transcript *model.Transcript) {
+			transcript.Status, transcript.Winner = model.Finished, transcript.Players[0].Name
+		},
+	}
+
+	for name, tamper := range tampered {
+		t.Run(name, func(t *testing.T) {
+			transcript := copyTranscript(t, exported)
+			tamper(transcript)
+
+			_, err := replayTranscript(transcript)
+			assert.True(t, errors.Is(err, errBadTranscript), "%v", err)
+		})
+	}
+}
+
+func TestLongGamesStopKeepingTheirTranscript(t *testing.T) {
+	game := dealGame(t, newTestService(), model.GameRules{}, "Alice", "Bob")
+	game.Actions = make([]model.Action, model.MaxActions-1)
+
+	require.Nil(t, applyAction(testRand(), game, model.Action{Kind: model.ActionDraw, PlayerID: game.Players[game.CurrentPlayer].ID}))
+	assert.Len(t, game.Actions, model.MaxActions)
+
+	require.Nil(t, applyAction(testRand(), game, model.Action{Kind: model.ActionDraw, PlayerID: game.Players[game.CurrentPlayer].ID}))
+	assert.Nil(t, game.Opening)
+	assert.Empty(t, game.Actions)
+
+	_, err := exportGame(game, "")
+	assert.Equal(t, errNoTranscript, err)
+}
+
+func TestClaimingSeats(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob", "Carol")
+	game.UnclaimedSeats = []string{game.Players[1].ID, game.Players[2].ID}
+	require.Nil(t, games.database.SaveGame(*game))
+
+	// A name that matches a seat takes it, any other name takes the first seat left
+	_, carol, err := games.claimSeat(ctx, game.ID, "CAROL")
+	require.Nil(t, err)
+	assert.Equal(t, game.Players[2].ID, carol.ID)
+
+	_, dan, err := games.claimSeat(ctx, game.ID, "Dan")
+	require.Nil(t, err)
+	assert.Equal(t, game.Players[1].ID, dan.ID)
+
+	_, _, err = games.claimSeat(ctx, game.ID, "Erin")
+	assert.Equal(t, errNoUnclaimedSeat, err)
+	_, _, err = games.claimSeat(ctx, "missing", "Erin")
+	assert.Equal(t, errGameNotFound, err)
+}
+
+func TestArchivedGamesCanBeExported(t *testing.T) {
+	games := newTestService()
+	e := echo.New()
+	newServer(games).setupRoutes(e)
+
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+	game = playOut(t, games, game.ID, 2000)
+	require.Equal(t, model.Finished, game.Status)
+
+	clock := games.clock.(*fakeClock)
+	clock.Advance(11 * time.Minute)
+	stats, err := newJanitor(games.database, clock, janitorPolicy{Interval: time.Minute, FinishedTTL: 10 * time.Minute}).sweep()
+	require.Nil(t, err)
+	require.Equal(t, 1, stats.ArchivedGames)
+
+	rec := call(e, http.MethodGet, "/api/games/"+game.ID+"/export", "192.0.2.1", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var transcript model.Transcript
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &transcript))
+	assert.Equal(t, game.Actions, transcript.Actions)
+	assert.Equal(t, clock.Now().UTC().Format(time.RFC3339), transcript.ExportedAt)
+
+	replayed, err := replayTranscript(&transcript)
+	require.Nil(t, err)
+	assert.Equal(t, game.GameOver, replayed.GameOver)
+
+	assert.Equal(t, http.StatusNotFound, call(e, http.MethodGet, "/api/games/missing/export", "192.0.2.1", "", "").Code)
+}
+
+func TestImportingGames(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	e := echo.New()
+	newServer(games).setupRoutes(e)
+
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+	game = playOut(t, games, game.ID, 20)
+	require.Equal(t, model.Playing, game.Status)
+
+	assert.Equal(t, http.StatusConflict, call(e, http.MethodGet, "/api/games/"+game.ID+"/export", "192.0.2.1", "", "").Code)
+	midGame, err := games.exportTranscript(ctx, game.ID, false)
+	require.Nil(t, err)
+
+	importBody := func(transcript *model.Transcript, mode string) string {
+		body, err := json.Marshal(map[string]interface{}{"transcript": transcript, "mode": mode})
+		require.Nil(t, err)
+		return string(body)
+	}
+
+	var imported struct {
+		Game struct {
+			ID string `json:"game_id"`
+		} `json:"game"`
+		Token string `json:"token"`
+	}
+
+	assert.Equal(t, http.StatusBadRequest, call(e, http.MethodPost, "/api/games/import", "192.0.2.1", "", importBody(midGame, "rewind")).Code)
+
+	// A game still being played cannot be replayed, it would be left waiting for players who have no seats
+	all, err := games.database.GetAllGames()
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, call(e, http.MethodPost, "/api/games/import", "192.0.2.1", "", importBody(midGame, importReplay)).Code)
+	unchanged, err := games.database.GetAllGames()
+	require.Nil(t, err)
+	assert.Len(t, *unchanged, len(*all))
+
+	// A resumed game carries on unrated, with new players in the same seats.
+	// Whoever imported it sits in the creator's seat, the others claim theirs by joining.
+	rec := call(e, http.MethodPost, "/api/games/import", "192.0.2.1", "", importBody(midGame, importResume))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &imported))
+
+	resumed, err := games.database.LookupGameByID(imported.Game.ID)
+	require.Nil(t, err)
+	assert.NotEqual(t, game.ID, resumed.ID)
+	assert.Equal(t, model.Playing, resumed.Status)
+	assert.True(t, resumed.Rules.Unrated)
+	assert.Equal(t, resumed.Creator.ID, resumed.Players[0].ID)
+	assert.NotEqual(t, game.Players[0].ID, resumed.Players[0].ID)
+	assert.Equal(t, []string{resumed.Players[1].ID}, resumed.UnclaimedSeats)
+	assert.Equal(t, 1, model.GameToSummary(*resumed).OpenSeats)
+	assert.Equal(t, game.DrawPile, resumed.DrawPile)
+	assert.Equal(t, game.CurrentPlayer, resumed.CurrentPlayer)
+	assert.Len(t, resumed.Actions, len(game.Actions))
+
+	token, valid := parseJWT(imported.Token, tokenSecret)
+	require.True(t, valid)
+	assert.Equal(t, resumed.Players[0].ID, token.Claims.(jwt.MapClaims)["playerId"])
+
+	rec = call(e, http.MethodPost, "/api/games/"+resumed.ID+"/join", "192.0.2.1", "", `{"playerName": "bob"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var joined struct {
+		Token string `json:"token"`
+	}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &joined))
+	token, valid = parseJWT(joined.Token, tokenSecret)
+	require.True(t, valid)
+	assert.Equal(t, resumed.Players[1].ID, token.Claims.(jwt.MapClaims)["playerId"])
+
+	resumed, err = games.database.LookupGameByID(resumed.ID)
+	require.Nil(t, err)
+	assert.Empty(t, resumed.UnclaimedSeats)
+	assert.Len(t, resumed.Players, 2)
+
+	_, err = games.drawCard(ctx, resumed.ID, resumed.Players[resumed.CurrentPlayer].ID)
+	assert.Nil(t, err)
+
+	// A finished game is imported to be looked back over
+	game = playOut(t, games, game.ID, 2000)
+	require.Equal(t, model.Finished, game.Status)
+
+	rec = call(e, http.MethodGet, "/api/games/"+game.ID+"/export", "192.0.2.1", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var finished model.Transcript
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &finished))
+
+	assert.Equal(t, http.StatusConflict, call(e, http.MethodPost, "/api/games/import", "192.0.2.1", "", importBody(&finished, importResume)).Code)
+	results, err := games.database.GetGameResults(time.Time{})
+	require.Nil(t, err)
+
+	rec = call(e, http.MethodPost, "/api/games/import", "192.0.2.1", "", importBody(&finished, importReplay))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &imported))
+
+	replayed, err := games.database.LookupGameByID(imported.Game.ID)
+	require.Nil(t, err)
+	assert.Equal(t, model.Finished, replayed.Status)
+	assert.Equal(t, game.GameOver, replayed.GameOver)
+	assert.Equal(t, game.DiscardPile, replayed.DiscardPile)
+
+	// Nobody's results are recorded for a game that was only imported
+	after, err := games.database.GetGameResults(time.Time{})
+	require.Nil(t, err)
+	assert.Len(t, *after, len(*results))
+
+	// Replays count against the creator's open games like any other import
+	games.maxOpenGames = 1
+	assert.Equal(t, http.StatusTooManyRequests, call(e, http.MethodPost, "/api/games/import", "192.0.2.1", "", importBody(&finished, importReplay)).Code)
+	games.maxOpenGames = 0
+
+	// Tampered transcripts are turned away before anything is saved
+	finished.Winner = "Mallory"
+	assert.Equal(t, http.StatusBadRequest, call(e, http.MethodPost, "/api/games/import", "192.0.2.1", "", importBody(&finished, importReplay)).Code)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+)
+
+// Every move a player makes goes through resolveTurn, a small state machine that steps through
+// the states below until the move is done:
+//
+//	validate -> play -> effects -> penalties -> pass
+//	validate -> draw -> pass, unless the drawn card can be played
+//	validate -> call Uno
+//
+// A move that fails validation changes nothing. pass is the only state that hands play on, and it
+// ends the game instead when someone went out, so nothing moves play on after the game is over.
+
+var (
+	errGameNotPlaying  = errors.New("The game is not being played")
+	errNotYourTurn     = errors.New("It is not your turn to play")
+	errCardNotInHand   = errors.New("You do not have that card")
+	errCardNotPlayable = errors.New("That card cannot be played on the discard pile")
+	errNoColorChosen   = errors.New("Wild cards have to be played as one of the deck's colors")
+	errNoSuchPlayer    = errors.New("That player is not in this game")
+)
+
+// unoPenaltyCards is how many cards a player caught with one card who did not call Uno draws
+const unoPenaltyCards = 2
+
+type actionKind int
+
+const (
+	actionPlay actionKind = iota
+	actionDraw
+	actionCallUno
+)
+
+// turnAction is one move by a player
+type turnAction struct {
+	kind     actionKind
+	playerID string
+	// The card played, a wild card has the color it is played as
+	card model.Card
+	// Who Uno is called on
+	targetID string
+}
+
+// turn is a move being resolved
+type turn struct {
+	game   *model.Game
+	action turnAction
+	// Where reshuffles get their randomness
+	rng random
+	// The seat of the player making the move
+	seat int
+	// What the played card does
+	effects []model.Effect
+	// How many players after the current one lose their turn
+	skipped int
+}
+
+// turnState is one step of resolving a move. It returns the next step, or nil once the move is done.
+type turnState func(t *turn) (turnState, error)
+
+// resolveTurn makes a move in a game
+func resolveTurn(rng random, game *model.Game, action turnAction) error {
+	t := &turn{game: game, action: action, rng: rng}
+
+	var err error
+	for state := turnState(validateState); state != nil; {
+		if state, err = state(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateState checks the move is allowed, without changing the game
+func validateState(t *turn) (turnState, error) {
+	game := t.game
+
+	t.seat = findPlayer(game, t.action.playerID)
+	if t.seat < 0 {
+		return nil, errNotInGame
+	}
+
+	if game.Status != model.Playing {
+		return nil, errGameNotPlaying
+	}
+
+	// Uno can be called at any time
+	if t.action.kind == actionCallUno {
+		if findPlayer(game, t.action.targetID) < 0 {
+			return nil, errNoSuchPlayer
+		}
+		return callUnoState, nil
+	}
+
+	if t.seat != game.CurrentPlayer {
+		return nil, errNotYourTurn
+	}
+
+	if t.action.kind == actionDraw {
+		return drawState, nil
+	}
+
+	deck := game.ActiveDeck()
+	card := t.action.card
+
+	if !checkForCardInHand(deck, card, model.Faces(game.Players[t.seat].Cards, game.DarkSide)) {
+		return nil, errCardNotInHand
+	}
+
+	if deck.IsWild(card.Value) && !hasColor(deck, card.Color) {
+		return nil, errNoColorChosen
+	}
+
+	if !isCardPlayable(game, card) {
+		return nil, errCardNotPlayable
+	}
+
+	return playState, nil
+}
+
+// playState moves the card from the player's hand to the discard pile
+func playState(t *turn) (turnState, error) {
+	game := t.game
+	card := t.action.card
+	deck := game.ActiveDeck()
+	dark := game.DarkSide
+
+	player := &game.Players[t.seat]
+	hand := player.Cards
+	for index, item := range model.Faces(hand, dark) {
+		if item == card || (item.Value == card.Value && deck.IsWild(card.Value)) {
+			// The card keeps its other side, and a wild card shows the color it was played as
+			game.DiscardPile = append(game.DiscardPile, hand[index].WithFace(dark, card))
+			player.Cards = append(hand[:index], hand[index+1:]...)
+			break
+		}
+	}
+
+	player.Tally.CardsPlayed++
+
+	// Reset Uno calling protection after every card is played
+	player.Protection = false
+
+	if cardType := deck.Type(card.Value); cardType != nil {
+		t.effects = cardType.Effects
+	}
+
+	return effectsState, nil
+}
+
+// effectsState applies what the card does to the order of play and to the table.
+// A player who just went out keeps their empty hand, but a Flip still turns the cards that are scored.
+func effectsState(t *turn) (turnState, error) {
+	game := t.game
+
+	for _, effect := range t.effects {
+		switch effect.Kind {
+		case model.EffectReverse:
+			game.Direction = !game.Direction
+			// With two players a reverse is a skip, so the player goes again
+			if len(game.Players) == 2 {
+				t.skipped++
+			}
+		case model.EffectSkip:
+			t.skipped++
+		case model.EffectSkipAll:
+			t.skipped = len(game.Players) - 1
+		case model.EffectShuffleHands:
+			game = shuffleHands(t.rng, game)
+		case model.EffectFlip:
+			game.DarkSide = !game.DarkSide
+		}
+	}
+
+	// Nobody loses more than one turn to a card
+	if t.skipped > len(game.Players)-1 {
+		t.skipped = len(game.Players) - 1
+	}
+
+	return penaltyState, nil
+}
+
+// penaltyState makes the next player draw whatever the card makes them draw, and they lose their turn.
+// They still draw when it was the player's last card, those cards count against them in the score.
+func penaltyState(t *turn) (turnState, error) {
+	game := t.game
+	victim := nextSeat(game, t.seat, 1)
+
+	for _, effect := range t.effects {
+		switch effect.Kind {
+		case model.EffectDraw:
+			game = drawNCards(t.rng, game, victim, uint(effect.Amount))
+		case model.EffectDrawColor:
+			game = drawUntilColor(t.rng, game, victim, t.action.card.Color)
+		default:
+			continue
+		}
+
+		if t.skipped == 0 {
+			t.skipped = 1
+		}
+	}
+
+	return passState, nil
+}
+
+// passState ends the game when someone went out, and otherwise hands play on to the next player
+// who has not lost their turn
+func passState(t *turn) (turnState, error) {
+	game := t.game
+
+	if winner := findWinner(game); winner >= 0 {
+		// the game ends on the player who went out, and in team games their whole team wins
+		game.CurrentPlayer = winner
+		game.GameOver = game.Players[winner].Name
+		game.Status = model.Finished
+		rateGame(game)
+		return nil, nil
+	}
+
+	game.CurrentPlayer = nextSeat(game, game.CurrentPlayer, t.skipped+1)
+
+	return nil, nil
+}
+
+// drawState draws a card, or with the draw until playable house rule keeps drawing until one can be played.
+// The player can play a card they drew, otherwise play passes on, as it does when there is nothing left to draw.
+func drawState(t *turn) (turnState, error) {
+	game := t.game
+	player := &game.Players[t.seat]
+
+	// Reset Uno calling protection after a card is drawn
+	player.Protection = false
+
+	for {
+		drawnCard, ok := drawFromPile(t.rng, game)
+		if !ok {
+			return passState, nil
+		}
+		player.Cards = append(player.Cards, drawnCard)
+		player.Tally.DrawsTaken++
+
+		if isCardPlayable(game, drawnCard.Face(game.DarkSide)) {
+			return nil, nil
+		}
+
+		if !game.Rules.DrawUntilPlayable {
+			return passState, nil
+		}
+	}
+}
+
+// callUnoState resolves a call of Uno, which does not change whose turn it is.
+// Calling it on yourself with one card left keeps you safe until you next play or draw,
+// catching someone else with one card who has not called it makes them draw,
+// and calling it on anyone with more than one card costs the caller a card.
+func callUnoState(t *turn) (turnState, error) {
+	game := t.game
+	caller := &game.Players[t.seat]
+	called := &game.Players[findPlayer(game, t.action.targetID)]
+
+	if len(called.Cards) != 1 {
+		caller.Tally.Penalties++
+		drawCards(t.rng, game, caller, 1)
+		return nil, nil
+	}
+
+	if called.Protection {
+		return nil, nil
+	}
+
+	if called.ID == caller.ID {
+		called.Protection = true
+		called.Tally.UnoCalls++
+		return nil, nil
+	}
+
+	called.Tally.Penalties++
+	drawCards(t.rng, game, called, unoPenaltyCards)
+
+	return nil, nil
+}
+
+// drawCards gives a player up to n cards from the draw pile, as many as there are
+func drawCards(rng random, game *model.Game, player *model.Player, n int) {
+	for i := 0; i < n; i++ {
+		drawnCard, ok := drawFromPile(rng, game)
+		if !ok {
+			return
+		}
+		player.Cards = append(player.Cards, drawnCard)
+	}
+}
+
+// nextSeat is the seat steps turns after seat in the direction of play
+func nextSeat(game *model.Game, seat int, steps int) int {
+	if !game.Direction {
+		steps = -steps
+	}
+
+	players := len(game.Players)
+	return ((seat+steps)%players + players) % players
+}
+
+// hasColor reports whether a color is one of the colors a deck's cards come in
+func hasColor(deck model.DeckDefinition, color string) bool {
+	for _, c := range deck.Colors {
+		if c == color {
+			return true
+		}
+	}
+	return false
+}
+
+// drawFromPile takes the top card off the draw pile, refilling an empty draw pile with the discard pile.
+// There is nothing to draw when every other card is in someone's hand, then ok is false and nobody draws.
+func drawFromPile(rng random, game *model.Game) (card model.Card, ok bool) {
+	if len(game.DrawPile) == 0 {
+		game = reshuffleDiscardPile(rng, game)
+	}
+
+	if len(game.DrawPile) == 0 {
+		return model.Card{}, false
+	}
+
+	_, drawnCard := drawTopCard(game)
+	return drawnCard, true
+}
+
+// moveError responds to a move that was turned down. message is shown when something went wrong on the server.
+func moveError(c echo.Context, err error, message string) error {
+	switch err {
+	case errGameNotFound, errNoSuchPlayer:
+		return c.JSON(http.StatusNotFound, err.Error())
+	case errNotInGame:
+		return c.JSON(http.StatusForbidden, err.Error())
+	case errGameNotPlaying, errNotYourTurn:
+		return c.JSON(http.StatusConflict, err.Error())
+	case errCardNotInHand, errCardNotPlayable, errNoColorChosen:
+		return c.JSON(http.StatusBadRequest, err.Error())
+	default:
+		return c.JSON(http.StatusInternalServerError, message)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jak103/uno/events"
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errRatedUndo       = errors.New("Moves in rated games cannot be undone")
+	errUndoPending     = errors.New("There is already an undo waiting for an answer")
+	errNoUndoPending   = errors.New("There is no undo waiting for an answer")
+	errNotApprover     = errors.New("You do not get a say in this undo")
+	errBadUndoApproval = errors.New("undo_approval must be players or creator")
+)
+
+// validUndoApproval checks who a game is created to need agreement from before undoing a move
+func validUndoApproval(approval model.UndoApproval) error {
+	switch approval {
+	case "", model.UndoByPlayers, model.UndoByCreator:
+		return nil
+	}
+	return errBadUndoApproval
+}
+
+// undoApprovers are the players who have to agree to the game's undo request.
+// Nobody does when the creator asks in a game where only the creator decides.
+func undoApprovers(game *model.Game) []string {
+	request := game.UndoRequest
+
+	if game.Rules.UndoApproval == model.UndoByCreator {
+		if request.RequestedBy == game.Creator.ID {
+			return nil
+		}
+		return []string{game.Creator.ID}
+	}
+
+	approvers := make([]string, 0, len(game.Players))
+	for _, player := range game.Players {
+		if player.ID != request.RequestedBy {
+			approvers = append(approvers, player.ID)
+		}
+	}
+	return approvers
+}
+
+// waitingOn are the players who still have to agree to the game's undo request
+func waitingOn(game *model.Game) []string {
+	waiting := make([]string, 0)
+	if game.UndoRequest == nil {
+		return waiting
+	}
+
+	for _, approver := range undoApprovers(game) {
+		if !containsID(game.UndoRequest.Approvals, approver) {
+			waiting = append(waiting, approver)
+		}
+	}
+	return waiting
+}
+
+func containsID(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// settleUndo undoes or redoes the move once everyone who has to has agreed, and says which it did
+func settleUndo(game *model.Game, at string) (string, error) {
+	if len(waitingOn(game)) > 0 {
+		return "", nil
+	}
+
+	action := model.Action{Kind: model.ActionUndo, PlayerID: game.UndoRequest.RequestedBy, At: at}
+	if game.UndoRequest.Redo {
+		action.Kind = model.ActionRedo
+	}
+
+	return action.Kind, applyAction(nil, game, action)
+}
+
+// requestUndo asks the other players to agree to undo the last move, or to make the last undone move again.
+// Only unrated games can take moves back, a rating has to come from the game as it was played.
+func (s *GameService) requestUndo(ctx context.Context, gameID string, playerID string, redo bool) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "requestUndo", gameID, playerID)
+	defer span.End()
+
+	requestedAt := s.now()
+
+	return s.saveUndo(ctx, gameID, func(game *model.Game) error {
+		if !isPlayerInGame(game, playerID) {
+			return errNotInGame
+		}
+
+		if !game.Rules.Unrated {
+			return errRatedUndo
+		}
+
+		if game.Status != model.Playing {
+			return errGameNotPlaying
+		}
+
+		if game.UndoRequest != nil {
+			return errUndoPending
+		}
+
+		if redo && len(game.Redo) == 0 {
+			return errNothingToRedo
+		}
+
+		if !redo && len(game.History) == 0 {
+			return errNothingToUndo
+		}
+
+		game.UndoRequest = &model.UndoRequest{Redo: redo, RequestedBy: playerID, At: requestedAt, Approvals: []string{}}
+		return nil
+	})
+}
+
+// answerUndo agrees to the game's undo request, or turns it down. Whoever asked can take it back by turning it down.
+func (s *GameService) answerUndo(ctx context.Context, gameID string, playerID string, approve bool) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "answerUndo", gameID, playerID)
+	defer span.End()
+
+	return s.saveUndo(ctx, gameID, func(game *model.Game) error {
+		if game.UndoRequest == nil {
+			return errNoUndoPending
+		}
+
+		approver := containsID(undoApprovers(game), playerID)
+
+		switch {
+		case !approve && (approver || playerID == game.UndoRequest.RequestedBy):
+			game.UndoRequest = nil
+		case approve && approver:
+			if !containsID(game.UndoRequest.Approvals, playerID) {
+				game.UndoRequest.Approvals = append(game.UndoRequest.Approvals, playerID)
+			}
+		default:
+			return errNotApprover
+		}
+
+		return nil
+	})
+}
+
+// saveUndo changes the game's undo request and takes the move back once everyone has agreed,
+// all in one update of the game, then tells everyone watching
+func (s *GameService) saveUndo(ctx context.Context, gameID string, change func(game *model.Game) error) (*model.Game, error) {
+	at := s.now()
+	move := ""
+
+	game, err := updateGame(s.databaseFor(ctx), gameID, func(game *model.Game) error {
+		if err := change(game); err != nil {
+			return err
+		}
+
+		move = ""
+		if game.UndoRequest == nil {
+			return nil
+		}
+
+		var err error
+		move, err = settleUndo(game, at)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if move != "" {
+		movesTotal.WithLabelValues(move).Inc()
+	}
+
+	s.notifyGame(ctx, game.ID, events.GameUpdated)
+
+	return game, nil
+}
+
+// askUndo handles a player asking to undo the last move
+func (s *server) askUndo(c echo.Context) error {
+	return s.askToTakeBack(c, false)
+}
+
+// askRedo handles a player asking to make the last undone move again
+func (s *server) askRedo(c echo.Context) error {
+	return s.askToTakeBack(c, true)
+}
+
+func (s *server) askToTakeBack(c echo.Context, redo bool) error {
+	playerID, err := getPlayerFromContext(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
+	}
+
+	game, err := s.games.requestUndo(c.Request().Context(), c.Param("id"), playerID, redo)
+	if err != nil {
+		return undoError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, buildGameState(game, playerID))
+}
+
+// approveUndo handles a player agreeing to the undo or redo they were asked about
+func (s *server) approveUndo(c echo.Context) error {
+	return s.answerTakeBack(c, true)
+}
+
+// rejectUndo handles a player turning down an undo or redo, or taking back their own
+func (s *server) rejectUndo(c echo.Context) error {
+	return s.answerTakeBack(c, false)
+}
+
+func (s *server) answerTakeBack(c echo.Context, approve bool) error {
+	playerID, err := getPlayerFromContext(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, "Failed to authenticate user")
+	}
+
+	game, err := s.games.answerUndo(c.Request().Context(), c.Param("id"), playerID, approve)
+	if err != nil {
+		return undoError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, buildGameState(game, playerID))
+}
+
+// buildUndoState is what players see of taking moves back in an unrated game
+func buildUndoState(game *model.Game) map[string]interface{} {
+	approval := game.Rules.UndoApproval
+	if approval == "" {
+		approval = model.UndoByPlayers
+	}
+
+	return map[string]interface{}{
+		"approval":   approval,
+		"can_undo":   len(game.History) > 0,
+		"can_redo":   len(game.Redo) > 0,
+		"request":    game.UndoRequest,
+		"waiting_on": waitingOn(game),
+	}
+}
+
+func undoError(c echo.Context, err error) error {
+	switch err {
+	case errGameNotFound:
+		return c.JSON(http.StatusNotFound, err.Error())
+	case errNotInGame, errNotApprover:
+		return c.JSON(http.StatusForbidden, err.Error())
+	case errRatedUndo, errGameNotPlaying, errGameFinished, errUndoPending, errNoUndoPending, errNothingToUndo, errNothingToRedo:
+		return c.JSON(http.StatusConflict, err.Error())
+	default:
+		return c.JSON(http.StatusInternalServerError, "Could not change the game")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jak103/uno/model"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var casual = model.GameRules{Unrated: true}
+
+// drawOnce has whoever's turn it is draw, and returns the game as it was before and after
+func drawOnce(t *testing.T, games *GameService, game *model.Game) (*model.Game, *model.Game) {
+	before, err := games.database.LookupGameByID(game.ID)
+	require.Nil(t, err)
+
+	after, err := games.drawCard(context.Background(), game.ID, before.Players[before.CurrentPlayer].ID)
+	require.Nil(t, err)
+	return before, after
+}
+
+func TestRatedGamesRefuseUndo(t *testing.T) {
+	games := newTestService()
+	game := dealGame(t, games, model.GameRules{}, "Alice", "Bob")
+	drawOnce(t, games, game)
+
+	_, err := games.requestUndo(context.Background(), game.ID, game.Players[0].ID, false)
+	assert.Equal(t, errRatedUndo, err)
+}
+
+func TestUndoNeedsEveryOtherPlayer(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	game := dealGame(t, games, casual, "Alice", "Bob", "Carol")
+	alice, bob, carol := game.Players[0].ID, game.Players[1].ID, game.Players[2].ID
+
+	// A player's Protection is part of what is taken back
+	game.Players[1].Protection = true
+	require.Nil(t, games.database.SaveGame(*game))
+	before, after := drawOnce(t, games, game)
+	after.Players[1].Protection = false
+	require.Nil(t, games.database.SaveGame(*after))
+
+	pending, err := games.requestUndo(ctx, game.ID, alice, false)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{bob, carol}, waitingOn(pending))
+	assert.Equal(t, games.now(), pending.UndoRequest.At)
+
+	_, err = games.requestUndo(ctx, game.ID, bob, false)
+	assert.Equal(t, errUndoPending, err)
+	_, err = games.answerUndo(ctx, game.ID, alice, true)
+	assert.Equal(t, errNotApprover, err)
+
+	pending, err = games.answerUndo(ctx, game.ID, bob, true)
+	require.Nil(t, err)
+	assert.Equal(t, []string{carol}, waitingOn(pending))
+	assert.Len(t, pending.History, 1)
+
+	undone, err := games.answerUndo(ctx, game.ID, carol, true)
+	require.Nil(t, err)
+	assert.Nil(t, undone.UndoRequest)
+	assert.Equal(t, before.DrawPile, undone.DrawPile)
+	assert.Equal(t, before.Players, undone.Players)
+	assert.Equal(t, before.CurrentPlayer, undone.CurrentPlayer)
+	assert.True(t, undone.Players[1].Protection)
+
+	// Everyone agreeing again makes the move again
+	_, err = games.requestUndo(ctx, game.ID, bob, true)
+	require.Nil(t, err)
+	games.answerUndo(ctx, game.ID, alice, true)
+	redone, err := games.answerUndo(ctx, game.ID, carol, true)
+	require.Nil(t, err)
+	assert.Equal(t, after.DrawPile, redone.DrawPile)
+	assert.Equal(t, after.Players, redone.Players)
+	assert.Equal(t, after.CurrentPlayer, redone.CurrentPlayer)
+	assert.Empty(t, redone.Redo)
+	assert.Len(t, redone.History, 1)
+
+	_, err = games.requestUndo(ctx, game.ID, bob, true)
+	assert.Equal(t, errNothingToRedo, err)
+}
+
+func TestCreatorDecidesUndo(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	game := dealGame(t, games, model.GameRules{Unrated: true, UndoApproval: model.UndoByCreator}, "Alice", "Bob", "Carol")
+	alice, bob, carol := game.Players[0].ID, game.Players[1].ID, game.Players[2].ID
+	before, _ := drawOnce(t, games, game)
+
+	_, err := games.requestUndo(ctx, game.ID, bob, false)
+	require.Nil(t, err)
+
+	_, err = games.answerUndo(ctx, game.ID, carol, true)
+	assert.Equal(t, errNotApprover, err)
+
+	undone, err := games.answerUndo(ctx, game.ID, alice, true)
+	require.Nil(t, err)
+	assert.Equal(t, before.DrawPile, undone.DrawPile)
+
+	// The creator does not have to wait for anyone
+	redone, err := games.requestUndo(ctx, game.ID, alice, true)
+	require.Nil(t, err)
+	assert.Nil(t, redone.UndoRequest)
+	assert.Len(t, redone.History, 1)
+}
+
+func TestUndoCanBeTurnedDown(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	game := dealGame(t, games, casual, "Alice", "Bob", "Carol")
+	alice, bob := game.Players[0].ID, game.Players[1].ID
+	_, after := drawOnce(t, games, game)
+
+	_, err := games.answerUndo(ctx, game.ID, bob, false)
+	assert.Equal(t, errNoUndoPending, err)
+
+	games.requestUndo(ctx, game.ID, alice, false)
+	rejected, err := games.answerUndo(ctx, game.ID, bob, false)
+	require.Nil(t, err)
+	assert.Nil(t, rejected.UndoRequest)
+	assert.Equal(t, after.DrawPile, rejected.DrawPile)
+
+	// Whoever asked can take it back
+	games.requestUndo(ctx, game.ID, alice, false)
+	withdrawn, err := games.answerUndo(ctx, game.ID, alice, false)
+	require.Nil(t, err)
+	assert.Nil(t, withdrawn.UndoRequest)
+	assert.Len(t, withdrawn.History, 1)
+}
+
+func TestAMoveReplacesTheUndo(t *testing.T) {
+	games := newTestService()
+	ctx := context.Background()
+	game := dealGame(t, games, model.GameRules{Unrated: true, UndoApproval: model.UndoByCreator}, "Alice", "Bob")
+	alice, bob := game.Players[0].ID, game.Players[1].ID
+
+	drawOnce(t, games, game)
+	drawOnce(t, games, game)
+	_, err := games.requestUndo(ctx, game.ID, alice, false)
+	require.Nil(t, err)
+
+	// There is an undone move to redo, and Bob asks for another undo, until someone moves
+	_, err = games.requestUndo(ctx, game.ID, bob, false)
+	require.Nil(t, err)
+	_, moved := drawOnce(t, games, game)
+	assert.Nil(t, moved.UndoRequest)
+	assert.Empty(t, moved.Redo)
+	assert.Len(t, moved.History, 2)
+}
+
+func TestUndoRoutes(t *testing.T) {
+	games := newTestService()
+	e := echo.New()
+	newServer(games).setupRoutes(e)
+
+	rec := call(e, http.MethodPost, "/api/games", "192.0.2.1", "", `{"name": "Casual", "creator": "Alice", "unrated": true, "undo_approval": "anyone"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = call(e, http.MethodPost, "/api/games", "192.0.2.1", "", `{"name": "Casual", "creator": "Alice", "unrated": true, "undo_approval": "creator"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var created struct {
+		Token string `json:"token"`
+		Game  struct {
+			ID string `json:"game_id"`
+		} `json:"game"`
+	}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	game, err := games.database.LookupGameByID(created.Game.ID)
+	require.Nil(t, err)
+	assert.Equal(t, model.GameRules{Unrated: true, UndoApproval: model.UndoByCreator}, game.Rules)
+
+	bob, _ := games.createPlayer(context.Background(), "Bob", "")
+	game, err = games.joinGame(context.Background(), game.ID, bob)
+	require.Nil(t, err)
+	game, err = games.dealCards(context.Background(), game)
+	require.Nil(t, err)
+
+	rec = call(e, http.MethodPost, "/api/games/"+game.ID+"/undo", "192.0.2.1", created.Token, "")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	drawOnce(t, games, game)
+	rec = call(e, http.MethodPost, "/api/games/"+game.ID+"/undo", "192.0.2.1", generateToken(bob), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var state struct {
+		Undo struct {
+			Approval  string   `json:"approval"`
+			CanUndo   bool     `json:"can_undo"`
+			WaitingOn []string `json:"waiting_on"`
+		} `json:"undo"`
+	}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	assert.Equal(t, "creator", state.Undo.Approval)
+	assert.True(t, state.Undo.CanUndo)
+	assert.Equal(t, []string{game.Creator.ID}, state.Undo.WaitingOn)
+
+	assert.Equal(t, http.StatusForbidden, call(e, http.MethodPost, "/api/games/"+game.ID+"/undo/approve", "192.0.2.1", generateToken(bob), "").Code)
+	assert.Equal(t, http.StatusOK, call(e, http.MethodPost, "/api/games/"+game.ID+"/undo/approve", "192.0.2.1", created.Token, "").Code)
+	assert.Equal(t, http.StatusConflict, call(e, http.MethodPost, "/api/games/"+game.ID+"/undo/reject", "192.0.2.1", created.Token, "").Code)
+	assert.Equal(t, http.StatusOK, call(e, http.MethodPost, "/api/games/"+game.ID+"/redo", "192.0.2.1", created.Token, "").Code)
+}
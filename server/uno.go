@@ -1,31 +1,55 @@
 package main
 
 import (
-	"fmt"
-	"math/rand"
+	"context"
+	"errors"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
 	"github.com/jak103/uno/model"
 )
 
+var errGameFull = errors.New("This game is full")
+var errDeckTooSmall = errors.New("The deck does not have enough cards to deal everyone in")
+var errNoStartingCard = errors.New("The deck has no number card left to start the discard pile")
+
+// maxStartingShuffles is how many times the draw pile is reshuffled looking for a number card to start the discard pile,
+// before the first one in the pile is taken instead
+const maxStartingShuffles = 20
+
 ////////////////////////////////////////////////////////////
 // These are all of the functions for the game -> essentially public functions
 ////////////////////////////////////////////////////////////
-func getGameUpdate(gameID string, playerID string) (*model.Game, error) {
-	database, err := db.GetDb()
-
-	if err != nil {
-		return nil, err
-	}
+func (s *GameService) getGameUpdate(ctx context.Context, gameID string, playerID string) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "getGameUpdate", gameID, playerID)
+	defer span.End()
+	database := s.databaseFor(ctx)
 
 	gameData, gameErr := database.LookupGameByID(gameID)
 	if gameErr != nil {
-		return nil, err
+		return nil, errGameNotFound
+	}
+
+	// Who is active is written through updateGame, so a move made since the game was read is not lost
+	now := s.clock.Now()
+	if markActive(gameData, playerID, now) {
+		gameData, gameErr = updateGame(database, gameID, func(game *model.Game) error {
+			markActive(game, playerID, now)
+			return nil
+		})
+		if gameErr != nil {
+			return nil, gameErr
+		}
 	}
 
-	// Determine if player is active
-	now := time.Now()
+	return gameData, nil
+}
+
+// markActive marks the player as active and anyone not heard from in 10 seconds as inactive,
+// and reports whether anything changed
+func markActive(gameData *model.Game, playerID string, now time.Time) bool {
 	changedData := false
 	for index, player := range gameData.Players {
 		if player.ID == playerID {
@@ -41,38 +65,42 @@ func getGameUpdate(gameID string, playerID string) (*model.Game, error) {
 			}
 		}
 	}
+	return changedData
+}
 
-	if changedData {
-		gameErr = database.SaveGame(*gameData)
-		if gameErr != nil {
-			return nil, err
-		}
-	}
+func (s *GameService) createPlayer(ctx context.Context, name string, account string) (*model.Player, error) {
+	ctx, span := startSpan(ctx, "createPlayer", "", "")
+	defer span.End()
+	database := s.databaseFor(ctx)
 
-	return gameData, nil
+	return newPlayer(database, name, account)
 }
 
-func createPlayer(name string) (*model.Player, error) {
-	database, err := db.GetDb()
+// newPlayer creates a player rated under account, or a guest when account is ""
+func newPlayer(database db.UnoDB, name string, account string) (*model.Player, error) {
+	player, err := database.CreatePlayer(name)
 	if err != nil {
 		return nil, err
 	}
 
-	player, err := database.CreatePlayer(name)
-	if err != nil {
+	if account == "" {
+		return player, nil
+	}
+
+	player.Account = account
+	if err := database.SavePlayer(*player); err != nil {
 		return nil, err
 	}
 
 	return player, nil
 }
 
-func createNewGame(gameName string, creatorName string) (*model.Game, *model.Player, error) {
-	database, err := db.GetDb()
-	if err != nil {
-		return nil, nil, err
-	}
+func (s *GameService) createNewGame(ctx context.Context, gameName string, creatorName string, account string, rules model.GameRules) (*model.Game, *model.Player, error) {
+	ctx, span := startSpan(ctx, "createNewGame", "", "")
+	defer span.End()
+	database := s.databaseFor(ctx)
 
-	creator, err := database.CreatePlayer(creatorName)
+	creator, err := newPlayer(database, creatorName, account)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -82,6 +110,9 @@ func createNewGame(gameName string, creatorName string) (*model.Game, *model.Pla
 		return nil, nil, err
 	}
 
+	game.Rules = rules
+	assignTeam(game, 0)
+
 	err = database.SaveGame(*game)
 	if err != nil {
 		return nil, nil, err
@@ -90,10 +121,19 @@ func createNewGame(gameName string, creatorName string) (*model.Game, *model.Pla
 	return game, creator, nil
 }
 
-func joinGame(game string, player *model.Player) (*model.Game, error) {
-	database, err := db.GetDb()
-	if err != nil {
-		return nil, err
+func (s *GameService) joinGame(ctx context.Context, game string, player *model.Player) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "joinGame", game, player.ID)
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	current, gameErr := database.LookupGameByID(game)
+
+	if gameErr != nil {
+		return nil, gameErr
+	}
+
+	if len(current.Players) >= model.MaxPlayers && !isPlayerInGame(current, player.ID) {
+		return nil, errGameFull
 	}
 
 	gameData, gameErr := database.JoinGame(game, player.ID)
@@ -102,219 +142,220 @@ func joinGame(game string, player *model.Player) (*model.Game, error) {
 		return nil, gameErr
 	}
 
+	// Players joining a team game go on the smallest team, they can switch before the game starts
+	if index := findPlayer(gameData, player.ID); index >= 0 && gameData.Rules.Teams != 0 && gameData.Players[index].Team == 0 {
+		gameData, gameErr = updateGame(database, game, func(game *model.Game) error {
+			if index := findPlayer(game, player.ID); index >= 0 {
+				assignTeam(game, index)
+			}
+			return nil
+		})
+
+		if gameErr != nil {
+			return nil, gameErr
+		}
+	}
+
+	s.notifyGame(ctx, gameData.ID, events.GameUpdated)
+
 	return gameData, nil
 }
 
-func addMessage(gameID string, playerID string, message model.Message) (*model.Game, error) { //*model.Player
-	database, err := db.GetDb()
+func (s *GameService) addMessage(ctx context.Context, gameID string, playerID string, message model.Message) (*model.Game, error) { //*model.Player
+	ctx, span := startSpan(ctx, "addMessage", gameID, playerID)
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	message, err := validateMessage(message)
 
 	if err != nil {
 		return nil, err
 	}
 
-	gameData, err := database.AddMessage(gameID, playerID, message)
+	gameData, err := database.LookupGameByID(gameID)
+
+	if err != nil {
+		return nil, errGameNotFound
+	}
+
+	message, err = validateChannel(gameData, playerID, message)
 
 	if err != nil {
 		return nil, err
 	}
 
-	err = database.SaveGame(*gameData)
+	now := s.clock.Now()
+	if !s.chat.allow(playerID, now) {
+		return nil, errChatRateLimited
+	}
+
+	// The server decides the identity and time of every message
+	message.ID = uuid.New().String()
+	message.Timestamp = now.Format(time.RFC3339)
+
+	gameData, err = database.AddMessage(gameID, playerID, message)
 
 	if err != nil {
 		return nil, err
 	}
 
+	s.notifyGame(ctx, gameID, events.ChatUpdated)
+
 	return gameData, nil
 }
 
-func playCard(game string, playerID string, card model.Card) (*model.Game, error) {
-	database, err := db.GetDb()
+func (s *GameService) getMessages(ctx context.Context, gameID string, playerID string, afterID string) ([]model.Message, error) {
+	ctx, span := startSpan(ctx, "getMessages", gameID, playerID)
+	defer span.End()
+	database := s.databaseFor(ctx)
+
+	gameData, err := database.LookupGameByID(gameID)
 
 	if err != nil {
-		return nil, err
+		return nil, errGameNotFound
 	}
 
-	gameData, gameErr := database.LookupGameByID(game)
+	messages, err := messagesAfter(gameData.Messages, afterID)
 
-	if gameErr != nil {
+	if err != nil {
 		return nil, err
 	}
 
-	if gameData.Players[gameData.CurrentPlayer].ID == playerID {
-		hand := gameData.Players[gameData.CurrentPlayer].Cards
-		if checkForCardInHand(card, hand) && isCardPlayable(card, gameData.DiscardPile) {
-			// Valid card can be played
-
-			gameData.DiscardPile = append(gameData.DiscardPile, card)
-
-			for index, item := range hand {
-				if item == card || (item.Value == "W" && card.Value == "W") || (item.Value == "W4" && card.Value == "W4") {
-					gameData.Players[gameData.CurrentPlayer].Cards = append(hand[:index], hand[index+1:]...)
-					break
-				}
-			}
-
-			// Reset Uno calling protection after every card is played
-			gameData.Players[gameData.CurrentPlayer].Protection = false
+	return visibleMessages(gameData, playerID, messages), nil
+}
 
-			// Update who plays next, taking into account reverse card and skip card
-			if card.Value == "R" {
-				gameData.Direction = !gameData.Direction
-				if len(gameData.Players) == 2 {
-					gameData = goToNextPlayer(gameData)
-				}
-			}
+func (s *GameService) deleteMessage(ctx context.Context, gameID string, playerID string, messageID string) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "deleteMessage", gameID, playerID)
+	defer span.End()
+	database := s.databaseFor(ctx)
 
-			if card.Value == "S" {
-				gameData = goToNextPlayer(gameData)
-			}
+	gameData, err := database.LookupGameByID(gameID)
 
-			gameData = goToNextPlayer(gameData)
+	if err != nil {
+		return nil, errGameNotFound
+	}
 
-			// take into account cards that force the next player to draw
-			if card.Value == "D2" {
-				gameData = drawNCards(gameData, 2)
-				gameData = goToNextPlayer(gameData)
-			}
+	if gameData.Creator.ID != playerID {
+		return nil, errNotGameCreator
+	}
 
-			if card.Value == "W4" {
-				gameData = drawNCards(gameData, 4)
-				gameData = goToNextPlayer(gameData)
-			}
-		}
+	if findMessage(gameData.Messages, messageID) < 0 {
+		return nil, errMessageNotFound
 	}
 
-	err = database.SaveGame(*gameData)
+	gameData, err = database.DeleteMessage(gameID, messageID)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// Clients only ever fetch messages newer than their last one, so they have to be told which one to remove
+	s.publish(ctx, events.Event{GameID: gameID, Kind: events.MessageDeleted, MessageID: messageID})
+
 	return gameData, nil
 }
 
-func logicCallUno(gameID string, callingPlayerID string, calledOnPlayerID string) (*model.Game, error) {
-	database, dbErr := db.GetDb()
+func (s *GameService) playCard(ctx context.Context, game string, playerID string, card model.Card) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "playCard", game, playerID)
+	defer span.End()
+	database := s.databaseFor(ctx)
 
-	if dbErr != nil {
-		return nil, dbErr
-	}
-
-	gameData, gameErr := database.LookupGameByID(gameID)
+	action := model.Action{Kind: model.ActionPlay, PlayerID: playerID, Card: &card, At: s.now()}
+	gameData, err := updateGame(database, game, func(gameData *model.Game) error {
+		return applyAction(s.rng, gameData, action)
+	})
 
-	if gameErr != nil {
-		return nil, gameErr
-	}
-
-	var callingPlayer, calledOnPlayer *model.Player
-	for i := range gameData.Players {
-		if gameData.Players[i].ID == callingPlayerID {
-			callingPlayer = &gameData.Players[i]
-		}
-
-		if gameData.Players[i].ID == calledOnPlayerID {
-			calledOnPlayer = &gameData.Players[i]
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	var drawnCard model.Card
-	if len(calledOnPlayer.Cards) == 1 {
-		if calledOnPlayer.Protection == false {
-			if calledOnPlayer.ID == callingPlayer.ID {
-				calledOnPlayer.Protection = true
-			} else {
-				for i := 0; i < 4; i++ {
-					gameData, drawnCard = drawTopCard(gameData)
-
-					calledOnPlayer.Cards = append(calledOnPlayer.Cards, drawnCard)
-				}
-			}
-		}
-	} else {
-		gameData, drawnCard = drawTopCard(gameData)
+	movesTotal.WithLabelValues(movePlay).Inc()
 
-		callingPlayer.Cards = append(callingPlayer.Cards, drawnCard)
+	if gameData.Status == model.Finished {
+		now := s.clock.Now()
+		recordGameResults(ctx, database, gameData, now)
+		recordRatings(ctx, database, gameData, now)
 	}
 
-	database.SaveGame(*gameData)
+	s.notifyGame(ctx, gameData.ID, events.GameUpdated)
 
 	return gameData, nil
 }
 
-func drawCard(gameID string, playerID string) (*model.Game, error) {
-	// These lines are simply getting the database and game and handling any error that could occur
-	database, dbErr := db.GetDb()
-
-	if dbErr != nil {
-		return nil, dbErr
-	}
+func (s *GameService) logicCallUno(ctx context.Context, gameID string, callingPlayerID string, calledOnPlayerID string) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "logicCallUno", gameID, callingPlayerID)
+	defer span.End()
+	database := s.databaseFor(ctx)
 
-	gameData, gameErr := database.LookupGameByID(gameID)
+	action := model.Action{Kind: model.ActionCallUno, PlayerID: callingPlayerID, TargetID: calledOnPlayerID, At: s.now()}
+	gameData, gameErr := updateGame(database, gameID, func(gameData *model.Game) error {
+		return applyAction(s.rng, gameData, action)
+	})
 
 	if gameErr != nil {
 		return nil, gameErr
 	}
 
-	// Reset Uno calling protection after a card is drawn
-	gameData.Players[gameData.CurrentPlayer].Protection = false
-
-	// We get the current player from the game
-	player := &gameData.Players[gameData.CurrentPlayer]
-	//We then check if the player attempting to play a card is the current player
-	if player.ID == playerID {
-		// We check if the draw pile has available cards
-		if len(gameData.DrawPile) == 0 {
-			// we check that the discard pile has cards to reshuffle
-			if len(gameData.DiscardPile) <= 1 {
-				// If there are not cards on the table add a new deck
-				// TODO in the future do more complicated logic such as skip the players turn or something like that.
-				gameData.DrawPile = generateShuffledDeck(1)
-			} else {
-				gameData = reshuffleDiscardPile(gameData)
-			}
-		}
-
-		// Draw a card off the drawpile
-		_, drawnCard := drawTopCard(gameData)
-
-		// append the card into the players cards from the draw pile
-		player.Cards = append(player.Cards, drawnCard)
+	movesTotal.WithLabelValues(moveCallUno).Inc()
+	s.notifyGame(ctx, gameID, events.GameUpdated)
 
-		// if the card cannot be played, advance to the next player
-		if !isCardPlayable(drawnCard, gameData.DiscardPile) {
-			gameData = goToNextPlayer(gameData)
-		}
+	return gameData, nil
+}
 
-		// Save the game into the database
-		database.SaveGame(*gameData)
+func (s *GameService) drawCard(ctx context.Context, gameID string, playerID string) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "drawCard", gameID, playerID)
+	defer span.End()
+	// These lines are simply getting the database and game and handling any error that could occur
+	database := s.databaseFor(ctx)
 
-		// Return a successfully updated game.
-		return gameData, nil
-	}
+	// Draw in the game as it is stored and save it in the same step
+	action := model.Action{Kind: model.ActionDraw, PlayerID: playerID, At: s.now()}
+	gameData, gameErr := updateGame(database, gameID, func(gameData *model.Game) error {
+		return applyAction(s.rng, gameData, action)
+	})
 
-	// Check why they couldn't draw, is it not their turn, or are they not part of this game?
-	for _, item := range gameData.Players {
-		if item.ID == playerID {
-			return nil, fmt.Errorf("It is not your turn to play")
-		}
+	if gameErr != nil {
+		return nil, gameErr
 	}
 
-	// TODO Make real error
-	return nil, fmt.Errorf("You cannot participate in a game you do not belong")
+	movesTotal.WithLabelValues(moveDraw).Inc()
+	s.notifyGame(ctx, gameID, events.GameUpdated)
 
+	// Return a successfully updated game.
+	return gameData, nil
 }
 
 /*This function will:
 Deal out 7 cards to each player
 Set the first card for the game to start from
 */
-func dealCards(game *model.Game) (*model.Game, error) {
+func (s *GameService) dealCards(ctx context.Context, game *model.Game) (*model.Game, error) {
+	ctx, span := startSpan(ctx, "dealCards", game.ID, "")
+	defer span.End()
+
+	// partners sit opposite each other
+	if err := seatTeams(game); err != nil {
+		return nil, err
+	}
 
 	// pick a starting player
-	game.CurrentPlayer = rand.Intn(len(game.Players))
+	game.CurrentPlayer = s.rng.Intn(len(game.Players))
 
-	// get a deck
-	game.DrawPile = generateShuffledDeck(len(game.Players))
+	// get the deck the game was set up with
+	deck, err := s.dealtDeck(game)
+
+	if err != nil {
+		return nil, err
+	}
+
+	game.Deck = deck
+	game.DarkSide = false
+	game.DrawPile = generateShuffledDeck(s.rng, *deck, len(game.Players))
+
+	// everyone gets 7 cards and one more starts the discard pile
+	if len(game.DrawPile) < 7*len(game.Players)+1 {
+		return nil, errDeckTooSmall
+	}
 
 	//For each player currently in the game, give everyone 7 cards
 	for k := range game.Players {
@@ -334,11 +375,23 @@ func dealCards(game *model.Game) (*model.Game, error) {
 	game, drawnCard = drawTopCard(game)
 
 	// ensure that this first card is a number card
-	for !isNumberCard(drawnCard) {
+	for shuffles := 0; !isNumberCard(*deck, drawnCard); shuffles++ {
 		// if not, add it back to the draw pile
 		game.DrawPile = append(game.DrawPile, drawnCard)
+
+		// after enough unlucky shuffles, take the first number card there is, if there is one at all
+		if shuffles == maxStartingShuffles {
+			seat := firstNumberCard(*deck, game.DrawPile)
+			if seat < 0 {
+				return nil, errNoStartingCard
+			}
+			drawnCard = game.DrawPile[seat]
+			game.DrawPile = append(game.DrawPile[:seat], game.DrawPile[seat+1:]...)
+			break
+		}
+
 		// reshuffle cards so the same card is not drawn again
-		game.DrawPile = shuffleCards(game.DrawPile)
+		game.DrawPile = shuffleCards(s.rng, game.DrawPile)
 		// draw a new card
 		game, drawnCard = drawTopCard(game)
 	}
@@ -347,7 +400,15 @@ func dealCards(game *model.Game) (*model.Game, error) {
 
 	game.Status = "Playing"
 
-	database, err := db.GetDb()
+	// the game's transcript starts from the deal
+	opening := takeSnapshot(game, dealMove, "", s.now())
+	game.Opening = &opening
+	game.Actions = nil
+
+	database := s.databaseFor(ctx)
+
+	// the game is rated against everyone's rating as it starts
+	err = seatRatings(database, game)
 
 	if err != nil {
 		return nil, err
@@ -356,6 +417,10 @@ func dealCards(game *model.Game) (*model.Game, error) {
 	// save the new game status
 	err = database.SaveGame(*game)
 
+	if err == nil {
+		s.notifyGame(ctx, game.ID, events.GameUpdated)
+	}
+
 	return game, err
 }
 
@@ -364,80 +429,131 @@ func dealCards(game *model.Game) (*model.Game, error) {
 ////////////////////////////////////////////////////////////
 
 // Checks if a card is playable
-// Card is playable if it is wild or matches the card on top of the discard pile
+// Card is playable if it is wild or matches the side of the card on top of the discard pile that is showing
+// A wild card that was turned face up by a Flip has no color yet, so anything can be played on it
 // Does not check that the card is in the player's hand
 // Use checkForCardInHand for that
-func isCardPlayable(card model.Card, discardPile []model.Card) bool {
-	isWild := card.Value == "W4" || card.Value == "W"
+func isCardPlayable(game *model.Game, card model.Card) bool {
+	deck := game.ActiveDeck()
+	isWild := deck.IsWild(card.Value)
+
+	// Anything can start an empty discard pile
+	if len(game.DiscardPile) == 0 {
+		return true
+	}
 
-	cardOnDiscardPile := discardPile[len(discardPile)-1]
+	cardOnDiscardPile := game.DiscardPile[len(game.DiscardPile)-1].Face(game.DarkSide)
+	isUncolored := deck.IsWild(cardOnDiscardPile.Value) && cardOnDiscardPile.Color == deck.Wilds()
 
-	if (card.Color == cardOnDiscardPile.Color || card.Value == cardOnDiscardPile.Value || isWild) {
+	if (card.Color == cardOnDiscardPile.Color || card.Value == cardOnDiscardPile.Value || isWild || isUncolored) {
 		return true
 	}
 
 	return false
 }
 
-func checkForCardInHand(card model.Card, hand []model.Card) bool {
+func checkForCardInHand(deck model.DeckDefinition, card model.Card, hand []model.Card) bool {
 	for _, c := range hand {
-		// the wild cards, W4 and W, don't need to match in color; not for the previous card, and not with the hand. The card itself can become any color.
-		if c.Value == card.Value && (c.Color == card.Color || card.Value == "W4" || card.Value == "W") {
+		// the wild cards don't need to match in color; not for the previous card, and not with the hand. The card itself can become any color.
+		if c.Value == card.Value && (c.Color == card.Color || deck.IsWild(card.Value)) {
 			return true
 		}
 	}
 	return false
 }
 
+// goToNextPlayer ends the game if someone went out, and otherwise passes play to the next player
 func goToNextPlayer(gameData *model.Game) *model.Game {
-	//check for winner
-	if len(gameData.Players[gameData.CurrentPlayer].Cards) == 0 {
-		gameData.GameOver = gameData.Players[gameData.CurrentPlayer].Name
-		gameData.Status = model.Finished
-	} else {
-		if gameData.Direction {
-			gameData.CurrentPlayer++
-			gameData.CurrentPlayer %= len(gameData.Players)
-		} else {
-			gameData.CurrentPlayer--
-			if gameData.CurrentPlayer < 0 {
-				gameData.CurrentPlayer = len(gameData.Players) - 1
-			}
-		}
-	}
-
+	passState(&turn{game: gameData, seat: gameData.CurrentPlayer})
 	return gameData
 }
 
-func reshuffleDiscardPile(gameData *model.Game) *model.Game {
+func reshuffleDiscardPile(rng random, gameData *model.Game) *model.Game {
 	//Reshuffle all discarded cards except the last one back into the draw pile.
+	if len(gameData.DiscardPile) < 2 {
+		return gameData
+	}
 	oldDiscard := gameData.DiscardPile[:len(gameData.DiscardPile)-1]
-	gameData.DrawPile = shuffleCards(oldDiscard)
+	gameData.DrawPile = shuffleCards(rng, oldDiscard)
 	gameData.DiscardPile = gameData.DiscardPile[len(gameData.DiscardPile)-1:]
 	return gameData
 }
 
-func drawNCards(gameData *model.Game, nCards uint) *model.Game {
+// shuffleHands gathers every hand, shuffles them together and deals them back out one at a time,
+// starting with the next player. A player who just played their last card has won and keeps their empty hand.
+func shuffleHands(rng random, gameData *model.Game) *model.Game {
+	if len(gameData.Players[gameData.CurrentPlayer].Cards) == 0 {
+		return gameData
+	}
+
+	cards := []model.Card{}
+	for i := range gameData.Players {
+		cards = append(cards, gameData.Players[i].Cards...)
+		gameData.Players[i].Cards = []model.Card{}
+	}
+	cards = shuffleCards(rng, cards)
+
+	step := 1
+	if !gameData.Direction {
+		step = len(gameData.Players) - 1
+	}
+
+	seat := gameData.CurrentPlayer
+	for _, card := range cards {
+		seat = (seat + step) % len(gameData.Players)
+		gameData.Players[seat].Cards = append(gameData.Players[seat].Cards, card)
+	}
+
+	return gameData
+}
+
+// drawUntilColor makes the player in seat draw until they draw a card showing the color,
+// or until there is nothing left to draw
+func drawUntilColor(rng random, gameData *model.Game, seat int, color string) *model.Game {
+	for {
+		drawnCard, ok := drawFromPile(rng, gameData)
+		if !ok {
+			break
+		}
+		gameData.Players[seat].Cards = append(gameData.Players[seat].Cards, drawnCard)
+		gameData.Players[seat].Tally.DrawsTaken++
+
+		if drawnCard.Face(gameData.DarkSide).Color == color {
+			break
+		}
+	}
+
+	return gameData
+}
+
+// drawNCards makes the player in seat draw nCards cards, or as many as there are to draw
+func drawNCards(rng random, gameData *model.Game, seat int, nCards uint) *model.Game {
 	for i := uint(0); i < nCards; i++ {
-		var drawnCard model.Card
-		gameData, drawnCard = drawTopCard(gameData)
-		gameData.Players[gameData.CurrentPlayer].Cards = append(gameData.Players[gameData.CurrentPlayer].Cards, drawnCard)
+		drawnCard, ok := drawFromPile(rng, gameData)
+		if !ok {
+			break
+		}
+		gameData.Players[seat].Cards = append(gameData.Players[seat].Cards, drawnCard)
+		gameData.Players[seat].Tally.DrawsTaken++
 	}
 	return gameData
 }
 
+// drawTopCard takes the top card off the draw pile, an empty draw pile gives no card
 func drawTopCard(game *model.Game) (*model.Game, model.Card) {
+	if len(game.DrawPile) == 0 {
+		return game, model.Card{}
+	}
+
 	drawnCard := game.DrawPile[len(game.DrawPile)-1]
 	game.DrawPile = game.DrawPile[:len(game.DrawPile)-1]
 	return game, drawnCard
 }
 
-func checkGameExists(gameID string) (bool, error) {
-	database, err := db.GetDb()
-
-	if err != nil {
-		return false, err
-	}
+func (s *GameService) checkGameExists(ctx context.Context, gameID string) (bool, error) {
+	ctx, span := startSpan(ctx, "checkGameExists", gameID, "")
+	defer span.End()
+	database := s.databaseFor(ctx)
 
 	_, gameErr := database.LookupGameByID(gameID)
 
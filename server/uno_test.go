@@ -1,49 +1,155 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+
 	"github.com/jak103/uno/db"
+	"github.com/jak103/uno/events"
 	"github.com/jak103/uno/model"
 	"github.com/stretchr/testify/assert"
-	"errors"
+	"github.com/stretchr/testify/require"
 )
 
 
 
 // This function is meant to get a game and a player into the data base in a usable state for testing.
-func setupGameWithPlayer(database *db.DB) (*model.Game, *model.Player) {
+func setupGameWithPlayer(database db.UnoDB) (*model.Game, *model.Player) {
 	player, _ := database.CreatePlayer("Player 1")
 
 	game, _ := database.CreateGame("Game 1", player.ID)
 
 	game, _ = database.JoinGame(game.ID, player.ID)
 
-	game.DrawPile = generateShuffledDeck(1)
+	game.DrawPile = generateShuffledDeck(testRand(), model.StandardDeck(), 1)
+
+	game.Status = model.Playing
 
 	database.SaveGame(*game)
 
 	return game, player
 }
 
+// seatPlayers creates a game with the named players seated in order, the first one as its creator
+func seatPlayers(t *testing.T, games *GameService, rules model.GameRules, names ...string) *model.Game {
+	ctx := context.Background()
+
+	game, _, err := games.createNewGame(ctx, t.Name(), names[0], "", rules)
+	require.Nil(t, err)
+
+	for _, name := range names[1:] {
+		player, err := games.createPlayer(ctx, name, "")
+		require.Nil(t, err)
+		game, err = games.joinGame(ctx, game.ID, player)
+		require.Nil(t, err)
+	}
+	return game
+}
+
+// dealGame seats the named players and deals them in
+func dealGame(t *testing.T, games *GameService, rules model.GameRules, names ...string) *model.Game {
+	game, err := games.dealCards(context.Background(), seatPlayers(t, games, rules, names...))
+	require.Nil(t, err)
+	return game
+}
+
+// playerNames names the given number of players "Player 0", "Player 1" and so on
+func playerNames(players int) []string {
+	names := make([]string, players)
+	for i := range names {
+		names[i] = fmt.Sprint("Player ", i)
+	}
+	return names
+}
+
+// pickCard is the first card the player whose turn it is can play, with wild cards played
+// as the color they hold most of. It is false when they have nothing to play.
+func pickCard(game *model.Game) (model.Card, bool) {
+	deck := game.ActiveDeck()
+	hand := model.Faces(game.Players[game.CurrentPlayer].Cards, game.DarkSide)
+
+	for _, card := range hand {
+		if deck.IsWild(card.Value) {
+			card.Color = favoriteColor(deck, hand)
+		}
+		if isCardPlayable(game, card) {
+			return card, true
+		}
+	}
+
+	return model.Card{}, false
+}
+
+// favoriteColor is the deck color a hand holds most of
+func favoriteColor(deck model.DeckDefinition, hand []model.Card) string {
+	best, most := deck.Colors[0], 0
+	for _, color := range deck.Colors {
+		count := 0
+		for _, card := range hand {
+			if card.Color == color {
+				count++
+			}
+		}
+		if count > most {
+			best, most = color, count
+		}
+	}
+	return best
+}
+
+// playOut has each player in turn play the first card they can, or draw, calling Uno on themselves
+// when they are down to one card. It stops after the given number of moves or once the game is over.
+func playOut(t *testing.T, games *GameService, gameID string, moves int) *model.Game {
+	ctx := context.Background()
+
+	game, err := games.database.LookupGameByID(gameID)
+	require.Nil(t, err)
+
+	for i := 0; i < moves && game.Status == model.Playing; i++ {
+		player := game.Players[game.CurrentPlayer]
+
+		card, ok := pickCard(game)
+		if !ok {
+			game, err = games.drawCard(ctx, gameID, player.ID)
+			require.Nil(t, err)
+			continue
+		}
+
+		game, err = games.playCard(ctx, gameID, player.ID, card)
+		require.Nil(t, err)
+
+		if seat := findPlayer(game, player.ID); game.Status == model.Playing && len(game.Players[seat].Cards) == 1 {
+			game, err = games.logicCallUno(ctx, gameID, player.ID, player.ID)
+			require.Nil(t, err)
+		}
+	}
+
+	return game
+}
+
 
 func TestDrawCard(t *testing.T) {
+	games := newTestService()
+
 	// Test passing in a bogus game id, we should get an error
-	game, err := drawCard("Bogus game id", "Bogus player id")
+	game, err := games.drawCard(context.Background(), "Bogus game id", "Bogus player id")
 
 	// Assert that we got an actual err
 	assert.NotNil(t, err, "We did not error on a bogus game id")
 
 	// Generate real game in database and real player
-	database, _ := db.GetDb()
+	database := games.database
 	game, player := setupGameWithPlayer(database)
 	
 	// Put a number card on the discard pile
 	// For the purposes of this test, it's ok that it's an extra card
-	game.DiscardPile = append(game.DiscardPile, model.Card{"red", "2"})
+	game.DiscardPile = append(game.DiscardPile, model.Card{Color: "red", Value: "2"})
 	database.SaveGame(*game)
 
 	// Test Drawing a card with a full deck and real player
-	game, err = drawCard(game.ID, player.ID)
+	game, err = games.drawCard(context.Background(), game.ID, player.ID)
 	game, _ = database.LookupGameByID(game.ID)
 	player = &game.Players[game.CurrentPlayer]
 
@@ -61,7 +167,7 @@ func TestDrawCard(t *testing.T) {
 
 	database.SaveGame(*game)
 
-	game, err = drawCard(game.ID, player.ID)
+	game, err = games.drawCard(context.Background(), game.ID, player.ID)
 	player = &game.Players[game.CurrentPlayer]
 
 	//Assert no error, player has 2 cards from both draw tests,
@@ -75,23 +181,23 @@ func TestDrawCard(t *testing.T) {
 	assert.Equal(t, lastCard.Color, game.DiscardPile[0].Color)
 	assert.Equal(t, lastCard.Value, game.DiscardPile[0].Value)
 
-	// Empty out both discard and draw piles, it should for now put a new deck on the draw pile.
+	// Empty out both discard and draw piles, there is nothing to draw so no card appears out of nowhere
 	game.DrawPile = game.DrawPile[:0]
 	game.DiscardPile = game.DiscardPile[:1]
 	lastCard = game.DiscardPile[len(game.DiscardPile)-1]
 
 	database.SaveGame(*game)
 
-	game, err = drawCard(game.ID, player.ID)
+	game, err = games.drawCard(context.Background(), game.ID, player.ID)
 	player = &game.Players[game.CurrentPlayer]
 
-	// Assert no errors, assert player now has 3 cards
-	// assert new draw pile with one missing
+	// Assert no errors, assert player still has 2 cards
+	// assert the draw pile is still empty
 	// assert discard still has one card
 	// Assert last card in discard is actually to proper last card
 	assert.Nil(t, err, "Failed to draw card.")
-	assert.Equal(t, 3, len(player.Cards))
-	assert.Equal(t, 107, len(game.DrawPile))
+	assert.Equal(t, 2, len(player.Cards))
+	assert.Equal(t, 0, len(game.DrawPile))
 	assert.Equal(t, 1, len(game.DiscardPile))
 	assert.Equal(t, lastCard.Color, game.DiscardPile[0].Color)
 	assert.Equal(t, lastCard.Value, game.DiscardPile[0].Value)
@@ -100,7 +206,7 @@ func TestDrawCard(t *testing.T) {
 	otherPlayer := model.Player{ID: " id 2 ", Name: "Name 2", Cards: []model.Card{}}
 
 	// Simulate a someone trying to participate in a game they are not a part of.
-	_, err = drawCard(game.ID, otherPlayer.ID)
+	_, err = games.drawCard(context.Background(), game.ID, otherPlayer.ID)
 
 	// Assert that we got an error from the draw card function as we should have.
 	// Assert that the player didn't get any cards
@@ -108,7 +214,7 @@ func TestDrawCard(t *testing.T) {
 	assert.NotNil(t, err, "Player not in the game drew a card. Please make sure only players in the game can draw")
 	assert.Equal(t, "You cannot participate in a game you do not belong", err.Error())
 	assert.Equal(t, 0, len(otherPlayer.Cards))
-	assert.Equal(t, 107, len(game.DrawPile))
+	assert.Equal(t, 0, len(game.DrawPile))
 
 	// Create a real player and add them to the game so there is more than one player.
 	player2, _ := database.CreatePlayer("Player 2")
@@ -118,7 +224,7 @@ func TestDrawCard(t *testing.T) {
 	database.SaveGame(*game)
 
 	//Simulate drawing out of turn
-	_, err = drawCard(game.ID, player2.ID)
+	_, err = games.drawCard(context.Background(), game.ID, player2.ID)
 
 	// Assert that we got an error from the draw card function as we should have.
 	// Assert that the player didn't get any cards
@@ -126,16 +232,18 @@ func TestDrawCard(t *testing.T) {
 	assert.NotNil(t, err, "Player drew out of turn. Please make sure only the player who's turn it is can play.")
 	assert.Equal(t, "It is not your turn to play", err.Error())
 	assert.Equal(t, 0, len(player2.Cards))
-	assert.Equal(t, 107, len(game.DrawPile))
+	assert.Equal(t, 0, len(game.DrawPile))
 }
 
 func TestDealCards(t *testing.T) {
 	// Generate real game in database and real player
-	database, err := db.GetDb()
+	games := newTestService()
+	database := games.database
+	var err error
 	game, player := setupGameWithPlayer(database)
 
 	// Test Drawing a card with a full deck and real player
-	game, err = dealCards(game)
+	game, err = games.dealCards(context.Background(), game)
 	player = &game.Players[game.CurrentPlayer] //getting from the game who the current player is
 
 	// Assert that no error occured, the player has a new card and the draw pile
@@ -166,7 +274,7 @@ func TestDealCards(t *testing.T) {
 	game.DiscardPile = []model.Card{}
 
 	// Test Drawing a card with a full deck and multiple players
-	game, err = dealCards(game)
+	game, err = games.dealCards(context.Background(), game)
 	// Assert that no error occured, the player has a new card and the draw pile
 	// has one less card
 	assert.Nil(t, err, "Failed to deal multiple players cards.")
@@ -179,23 +287,23 @@ func TestDealCards(t *testing.T) {
 
 func TestCheckForCardInHand(t *testing.T){
 	//Created two cards One will be in the hand and the other won't
-	validCard := model.Card{"red", "1"}
-	falseCard := model.Card{"blue", "4"}
+	validCard := model.Card{Color: "red", Value: "1"}
+	falseCard := model.Card{Color: "blue", Value: "4"}
 	//Created a hand with the valid card in it
 	hand := []model.Card{validCard}
 	
 	//Testing to see if the function returns True for a card that is 
 	//present and False for a card that isn't present
-	assert.True(t, checkForCardInHand(validCard, hand))
-	assert.False(t, checkForCardInHand(falseCard, hand))
+	assert.True(t, checkForCardInHand(model.StandardDeck(), validCard, hand))
+	assert.False(t, checkForCardInHand(model.StandardDeck(), falseCard, hand))
 }
 
 func TestCreatePlayer(t *testing.T){
 	// get the database
-	database, err := db.GetDb()
-	assert.Nil(t, err, "could not find database")
+	games := newTestService()
+	database := games.database
 	// use the createPlayer function
-	player, err := createPlayer("test") 
+	player, err := games.createPlayer(context.Background(), "test", "") 
  	assert.Nil(t, err, "could not create player")
 	// Lookup the player in the database to see if it is there
 	databasePlayer, err := database.LookupPlayer(player.ID)
@@ -206,8 +314,8 @@ func TestCreatePlayer(t *testing.T){
 
 func TestJoinGame(t *testing.T){
 	// Get database
-	database, err := db.GetDb()
-	assert.Nil(t, err, "could not find database")
+	games := newTestService()
+	database := games.database
 	// Create a new game with one player
 	player, err := database.CreatePlayer("testPlayer")
 	assert.Nil(t, err, "could not create new player")
@@ -217,7 +325,7 @@ func TestJoinGame(t *testing.T){
 	newPlayer, err := database.CreatePlayer("joinGamePlayer")
 	assert.Nil(t, err, "could not create new player")
 	// Attempt to join game
-	game, err = joinGame(game.ID, newPlayer)
+	game, err = games.joinGame(context.Background(), game.ID, newPlayer)
 	database.SaveGame(*game)
 	assert.Nil(t, err, "could not join game with new player")
 	// Lookup game from database 
@@ -227,15 +335,15 @@ func TestJoinGame(t *testing.T){
 	assert.Contains(t, game.Players, *newPlayer)
 	// attempt to join an errored game
 	err = errors.New("MockDB: Error!")
-	game, err = joinGame("Bad ID", newPlayer)
+	game, err = games.joinGame(context.Background(), "Bad ID", newPlayer)
 	assert.Nil(t, game, "Joined a valid game")
 
 }
 
 func TestDrawTopCard(t *testing.T) {
 	// Creating database and testing for errors
-	database, err := db.GetDb()
-	assert.Nil(t, err, "MockDB: Could not retrive database")
+	games := newTestService()
+	database := games.database
 	// Creating player and testing for errors
 	player , err := database.CreatePlayer("Test Player")
 	assert.Nil(t, err, "MockDB: Could not create player")
@@ -243,16 +351,16 @@ func TestDrawTopCard(t *testing.T) {
 	game, err := database.CreateGame("Test Game", player.ID)
 	assert.Nil(t, err, "MockDB: Could not create game")
 	// Setting game.DrawPile to a test deck
-	game.DrawPile = []model.Card{model.Card{"red", "1"}, model.Card{"blue", "2"}, model.Card{"green", "3"}}
+	game.DrawPile = []model.Card{model.Card{Color: "red", Value: "1"}, model.Card{Color: "blue", Value: "2"}, model.Card{Color: "green", Value: "3"}}
 	// Testing drawTopCard
 	game, cardReturned := drawTopCard(game) 
-	assert.Equal(t, model.Card{"green", "3"}, cardReturned)
+	assert.Equal(t, model.Card{Color: "green", Value: "3"}, cardReturned)
 }
 
 func TestGoToNextPlayer(t *testing.T) {
 	// Creating database and testing for errors
-	database, err := db.GetDb()
-	assert.Nil(t, err, "MockDB: Could not retrive database")
+	games := newTestService()
+	database := games.database
 	// Creating first player and testing for errors
 	player1 , err := database.CreatePlayer("Test 1")
 	assert.Nil(t, err, "MockDB: Could not create player")
@@ -263,9 +371,9 @@ func TestGoToNextPlayer(t *testing.T) {
 	game, err := database.CreateGame("Test Game 1", player1.ID)
 	assert.Nil(t, err, "MockDB: Could not create game")
 	// Adding players
-	game , err  = joinGame(game.ID, player1)
+	game , err  = games.joinGame(context.Background(), game.ID, player1)
 	database.SaveGame(*game)
-	game , err  = joinGame(game.ID, player2)
+	game , err  = games.joinGame(context.Background(), game.ID, player2)
 	database.SaveGame(*game)
 	// Testing a situation where the players have no cards to trigger winning condition if statement.  
 	game.CurrentPlayer = 0
@@ -273,7 +381,7 @@ func TestGoToNextPlayer(t *testing.T) {
 	// When winning condition is present goToNextPlayer will not change the current player
 	assert.Equal(t, 0, game.CurrentPlayer)
 	// Dealing cards to players
-	game, err = dealCards(game)
+	game, err = games.dealCards(context.Background(), game)
 	game.CurrentPlayer = 1
 	assert.Nil(t, err, "MockDB: Could not deal cards")
 	// Testing one direction
@@ -291,8 +399,8 @@ func TestGoToNextPlayer(t *testing.T) {
 func TestIsCardPlayable(t *testing.T){
 
 	// Generate real game in database and real player
-	database, err := db.GetDb()
-	assert.Nil(t, err, "could not find database")
+	games := newTestService()
+	database := games.database
 
 	game, _ := setupGameWithPlayer(database)
 
@@ -300,51 +408,52 @@ func TestIsCardPlayable(t *testing.T){
 	game.DiscardPile = append(game.DiscardPile, model.Card{Color: "red", Value: "2"})
 
 	// tests to see if a card of the same color is playable
-	test1 := isCardPlayable(model.Card{Color: "red", Value: "1"} , game.DiscardPile)
+	test1 := isCardPlayable(game, model.Card{Color: "red", Value: "1"})
 	assert.Equal(t, test1, true)
 
 	// tests to see if a card of the same number is playable
-	test2 := isCardPlayable(model.Card{Color: "blue", Value: "2"} , game.DiscardPile)
+	test2 := isCardPlayable(game, model.Card{Color: "blue", Value: "2"})
 	assert.Equal(t, test2, true)
 
 	// tests to see if a wild is playable
-	test3 := isCardPlayable(model.Card{Color: "black", Value: "W"} , game.DiscardPile)
+	test3 := isCardPlayable(game, model.Card{Color: "black", Value: "W"})
 	assert.Equal(t, test3, true)
 
 	// tests to see if a wild draw four is playable
-	test4 := isCardPlayable(model.Card{Color: "black", Value: "W4"} , game.DiscardPile)
+	test4 := isCardPlayable(game, model.Card{Color: "black", Value: "W4"})
 	assert.Equal(t, test4, true)
 }
 func TestReshuffleDiscardPile(t *testing.T){
 
 	// Generate real game in database and real player
-	database, err := db.GetDb()
-	assert.Nil(t, err, "could not find database")
+	games := newTestService()
+	database := games.database
 
 	game, _ := setupGameWithPlayer(database)
 
 	// puts the deck into the discard pile from the beginning
-	game.DiscardPile = generateShuffledDeck(1)
+	game.DiscardPile = generateShuffledDeck(testRand(), model.StandardDeck(), 1)
 
 	// shuffles the discard pile into the draw pile
-	game = reshuffleDiscardPile(game)
+	game = reshuffleDiscardPile(testRand(), game)
 
 	// checks to see if the discard pile is now empty
 	assert.Equal(t, len(game.DiscardPile), 1)
 
 }
 
-func TestcheckGameExists(t *testing.T) {
-	database, _ := db.GetDb()
-	game, _, _ := createNewGame("testGame", "testPlayer")
+func Test_checkGameExists(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, _, _ := games.createNewGame(context.Background(), "testGame", "testPlayer", "", model.GameRules{})
 	_, gameErr := database.LookupGameByID(game.ID)
 	assert.Nil(t, gameErr, "could not find existing game")
 }
 
 func TestCheckGameExists(t *testing.T){
 	// Get database
-	database, err := db.GetDb()
-	assert.Nil(t, err, "could not find database")
+	games := newTestService()
+	database := games.database
 	// Create Player
 	player, err := database.CreatePlayer("testPlayer")
 	assert.Nil(t, err, "could not create player")
@@ -352,17 +461,17 @@ func TestCheckGameExists(t *testing.T){
 	game, err := database.CreateGame("testGame", player.ID)
 	assert.Nil(t, err, "could not create game")
 	// Check to see if the function detects the created game
-	validGame, err := checkGameExists(game.ID)
+	validGame, err := games.checkGameExists(context.Background(), game.ID)
 	assert.True(t, validGame)
 	// Check to see if the function does not detect a game that does not exist
-	fakeGame, err := checkGameExists("fakeGame")
+	fakeGame, err := games.checkGameExists(context.Background(), "fakeGame")
 	assert.False(t, fakeGame)
 }
 
 func TestGetGameUpdate(t *testing.T){
 	// Get database
-	database, err := db.GetDb()
-	assert.Nil(t, err, "could not find database")
+	games := newTestService()
+	database := games.database
 	// Create Player
 	player, err := database.CreatePlayer("testPlayer")
 	assert.Nil(t, err, "could not create player")
@@ -370,7 +479,7 @@ func TestGetGameUpdate(t *testing.T){
 	game, err := database.CreateGame("testGame", player.ID)
 	assert.Nil(t, err, "could not create game")
 	// Get Game Update from function
-	gameUpdate, err := getGameUpdate(game.ID, player.ID)
+	gameUpdate, err := games.getGameUpdate(context.Background(), game.ID, player.ID)
 	assert.Nil(t, err, "could not get game update")
 	// Get game data from the database
 	gameData, err := database.LookupGameByID(game.ID)
@@ -378,7 +487,258 @@ func TestGetGameUpdate(t *testing.T){
 	// Check to see if the gameUpdate is equal to the game in the database
 	assert.Equal(t, gameData, gameUpdate)
 	// Check that the function returns Nil for non existant game
-	fakeGame, _ := getGameUpdate("fakeGame", "fakePlayer")
+	fakeGame, _ := games.getGameUpdate(context.Background(), "fakeGame", "fakePlayer")
 	assert.Nil(t, fakeGame, "Found game that does not exist")
 }
 
+
+func TestAddMessage(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, player := setupGameWithPlayer(database)
+
+	// A valid message is stored with a server assigned ID and timestamp
+	game, err := games.addMessage(context.Background(), game.ID, player.ID, model.Message{Value: "hello"})
+	assert.Nil(t, err, "could not add message")
+	assert.Equal(t, 1, len(game.Messages))
+	assert.Equal(t, "hello", game.Messages[0].Value)
+	assert.Equal(t, player.ID, game.Messages[0].Player.ID)
+	assert.NotEqual(t, "", game.Messages[0].ID)
+	assert.NotEqual(t, "", game.Messages[0].Timestamp)
+
+	// Invalid messages are rejected and not stored
+	_, err = games.addMessage(context.Background(), game.ID, player.ID, model.Message{Value: ""})
+	assert.Equal(t, errEmptyMessage, err)
+	_, err = games.addMessage(context.Background(), game.ID, player.ID, model.Message{Value: "fuck"})
+	assert.Equal(t, errProfaneMessage, err)
+
+	// Players are rate limited
+	for i := 1; i < messageRateLimit; i++ {
+		_, err = games.addMessage(context.Background(), game.ID, player.ID, model.Message{Value: "spam"})
+		assert.Nil(t, err)
+	}
+	_, err = games.addMessage(context.Background(), game.ID, player.ID, model.Message{Value: "spam"})
+	assert.Equal(t, errChatRateLimited, err)
+
+	game, _ = database.LookupGameByID(game.ID)
+	assert.Equal(t, messageRateLimit, len(game.Messages))
+}
+
+func TestGetMessages(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, player := setupGameWithPlayer(database)
+
+	game, _ = games.addMessage(context.Background(), game.ID, player.ID, model.Message{Value: "first"})
+	game, _ = games.addMessage(context.Background(), game.ID, player.ID, model.Message{Value: "second"})
+
+	messages, err := games.getMessages(context.Background(), game.ID, player.ID, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+
+	messages, err = games.getMessages(context.Background(), game.ID, player.ID, game.Messages[0].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "second", messages[0].Value)
+
+	_, err = games.getMessages(context.Background(), "fakeGame", player.ID, "")
+	assert.Equal(t, errGameNotFound, err)
+}
+
+func TestDeleteMessage(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, creator := setupGameWithPlayer(database)
+	other, _ := database.CreatePlayer("Player 2")
+	game, _ = database.JoinGame(game.ID, other.ID)
+	database.SaveGame(*game)
+
+	game, _ = games.addMessage(context.Background(), game.ID, other.ID, model.Message{Value: "delete me"})
+	messageID := game.Messages[0].ID
+
+	// Only the creator can delete messages
+	_, err := games.deleteMessage(context.Background(), game.ID, other.ID, messageID)
+	assert.Equal(t, errNotGameCreator, err)
+
+	_, err = games.deleteMessage(context.Background(), game.ID, creator.ID, "unknown")
+	assert.Equal(t, errMessageNotFound, err)
+
+	subscription := games.bus.Subscribe(game.ID)
+	defer subscription.Close()
+
+	game, err = games.deleteMessage(context.Background(), game.ID, creator.ID, messageID)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(game.Messages))
+
+	game, _ = database.LookupGameByID(game.ID)
+	assert.Equal(t, 0, len(game.Messages))
+
+	// Everyone watching is told which message to take down
+	assert.Equal(t, events.Event{GameID: game.ID, Kind: events.MessageDeleted, MessageID: messageID}, <-subscription.C)
+}
+
+func TestMessageChannels(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, player1 := setupGameWithPlayer(database)
+	player2, _ := database.CreatePlayer("Player 2")
+	player3, _ := database.CreatePlayer("Player 3")
+	spectator, _ := database.CreatePlayer("Spectator")
+	game, _ = database.JoinGame(game.ID, player2.ID)
+	database.SaveGame(*game)
+	game, _ = database.JoinGame(game.ID, player3.ID)
+	database.SaveGame(*game)
+
+	// Messages without a channel go to the whole table
+	game, err := games.addMessage(context.Background(), game.ID, player1.ID, model.Message{Value: "hello table"})
+	assert.Nil(t, err)
+	assert.Equal(t, model.TableChannel, game.Messages[0].Channel)
+
+	_, err = games.addMessage(context.Background(), game.ID, player1.ID, model.Message{Value: "psst", Channel: model.WhisperChannel, Recipient: player2.ID})
+	assert.Nil(t, err)
+	_, err = games.addMessage(context.Background(), game.ID, spectator.ID, model.Message{Value: "they have a wild", Channel: model.SpectatorChannel})
+	assert.Nil(t, err)
+
+	// Invalid channels, recipients and senders are rejected
+	_, err = games.addMessage(context.Background(), game.ID, player1.ID, model.Message{Value: "hi", Channel: "team"})
+	assert.Equal(t, errUnknownChannel, err)
+	_, err = games.addMessage(context.Background(), game.ID, player1.ID, model.Message{Value: "hi", Channel: model.WhisperChannel, Recipient: player1.ID})
+	assert.Equal(t, errBadRecipient, err)
+	_, err = games.addMessage(context.Background(), game.ID, player2.ID, model.Message{Value: "hi", Channel: model.WhisperChannel, Recipient: spectator.ID})
+	assert.Equal(t, errBadRecipient, err)
+	_, err = games.addMessage(context.Background(), game.ID, player2.ID, model.Message{Value: "hi", Channel: model.SpectatorChannel})
+	assert.Equal(t, errNotSpectator, err)
+
+	// Spectators cannot talk to the table or whisper to the players
+	_, err = games.addMessage(context.Background(), game.ID, spectator.ID, model.Message{Value: "play the wild", Channel: model.TableChannel})
+	assert.Equal(t, errNotSeated, err)
+	_, err = games.addMessage(context.Background(), game.ID, spectator.ID, model.Message{Value: "play the wild", Channel: model.WhisperChannel, Recipient: player1.ID})
+	assert.Equal(t, errNotSeated, err)
+
+	// What they send without a channel only reaches the other spectators
+	game, err = games.addMessage(context.Background(), game.ID, spectator.ID, model.Message{Value: "nice draw"})
+	assert.Nil(t, err)
+	assert.Equal(t, model.SpectatorChannel, game.Messages[len(game.Messages)-1].Channel)
+
+	values := func(playerID string) []string {
+		messages, err := games.getMessages(context.Background(), game.ID, playerID, "")
+		assert.Nil(t, err)
+		result := []string{}
+		for _, message := range messages {
+			result = append(result, message.Value)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"hello table", "psst"}, values(player1.ID))
+	assert.Equal(t, []string{"hello table", "psst"}, values(player2.ID))
+	assert.Equal(t, []string{"hello table"}, values(player3.ID))
+	assert.Equal(t, []string{"hello table", "they have a wild", "nice draw"}, values(spectator.ID))
+}
+
+func TestMovesNotifyWatchers(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, player := setupGameWithPlayer(database)
+	game.DiscardPile = []model.Card{{Color: "red", Value: "2"}}
+	database.SaveGame(*game)
+
+	subscription := games.bus.Subscribe(game.ID)
+	defer subscription.Close()
+
+	_, err := games.drawCard(context.Background(), game.ID, player.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, events.Event{GameID: game.ID, Kind: events.GameUpdated}, <-subscription.C)
+
+	_, err = games.addMessage(context.Background(), game.ID, player.ID, model.Message{Value: "hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, events.Event{GameID: game.ID, Kind: events.ChatUpdated}, <-subscription.C)
+
+	// Reading the game changes nothing other clients need to hear about
+	_, err = games.getGameUpdate(context.Background(), game.ID, player.ID)
+	assert.Nil(t, err)
+	select {
+	case event := <-subscription.C:
+		t.Errorf("unexpected event %v", event)
+	default:
+	}
+}
+
+func TestJoinGameFull(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, _ := setupGameWithPlayer(database)
+
+	for len(game.Players) < model.MaxPlayers {
+		player, _ := games.createPlayer(context.Background(), "Player", "")
+		var err error
+		game, err = games.joinGame(context.Background(), game.ID, player)
+		assert.Nil(t, err)
+	}
+
+	latecomer, _ := games.createPlayer(context.Background(), "Latecomer", "")
+	_, err := games.joinGame(context.Background(), game.ID, latecomer)
+	assert.Equal(t, errGameFull, err)
+
+	// Someone already seated can still rejoin a full game
+	_, err = games.joinGame(context.Background(), game.ID, &game.Players[1])
+	assert.Nil(t, err)
+}
+
+func TestMovesAreTallied(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, player1 := setupGameWithPlayer(database)
+	player2, _ := games.createPlayer(context.Background(), "Player 2", "")
+	game, _ = games.joinGame(context.Background(), game.ID, player2)
+
+	game.Status = model.Playing
+	game.CurrentPlayer = 0
+	game.Direction = true
+	game.DiscardPile = []model.Card{{Color: "red", Value: "2"}}
+	game.Players[0].Cards = []model.Card{{Color: "red", Value: "D2"}, {Color: "blue", Value: "7"}, {Color: "green", Value: "7"}}
+	game.Players[1].Cards = []model.Card{{Color: "yellow", Value: "1"}}
+	database.SaveGame(*game)
+
+	// Playing a draw two makes the next player take two cards
+	game, _ = games.playCard(context.Background(), game.ID, player1.ID, model.Card{Color: "red", Value: "D2"})
+	assert.Equal(t, model.PlayerTally{CardsPlayed: 1}, game.Players[0].Tally)
+	assert.Equal(t, model.PlayerTally{DrawsTaken: 2}, game.Players[1].Tally)
+
+	// Calling uno on someone with more than one card is a penalty for the caller
+	game, _ = games.logicCallUno(context.Background(), game.ID, player2.ID, player1.ID)
+	assert.Equal(t, 1, game.Players[1].Tally.Penalties)
+
+	game.Players[0].Cards = game.Players[0].Cards[:1]
+	game.Players[1].Cards = game.Players[1].Cards[:1]
+	database.SaveGame(*game)
+
+	// Calling uno for yourself counts as a call, being caught first counts as a penalty
+	game, _ = games.logicCallUno(context.Background(), game.ID, player1.ID, player1.ID)
+	game, _ = games.logicCallUno(context.Background(), game.ID, player1.ID, player2.ID)
+	assert.Equal(t, model.PlayerTally{CardsPlayed: 1, UnoCalls: 1}, game.Players[0].Tally)
+	assert.Equal(t, model.PlayerTally{DrawsTaken: 2, Penalties: 2}, game.Players[1].Tally)
+}
+
+func TestDrawUntilPlayable(t *testing.T) {
+	games := newTestService()
+	database := games.database
+	game, player := setupGameWithPlayer(database)
+
+	game.Rules.DrawUntilPlayable = true
+	game.DiscardPile = []model.Card{{Color: "red", Value: "2"}}
+	game.DrawPile = []model.Card{
+		{Color: "blue", Value: "9"},
+		{Color: "green", Value: "7"},
+		{Color: "red", Value: "5"},
+		{Color: "blue", Value: "3"},
+		{Color: "green", Value: "4"},
+	}
+	database.SaveGame(*game)
+
+	// Cards come off the end of the draw pile, the third one can be played
+	game, err := games.drawCard(context.Background(), game.ID, player.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Card{{Color: "green", Value: "4"}, {Color: "blue", Value: "3"}, {Color: "red", Value: "5"}}, game.Players[0].Cards)
+	assert.Equal(t, 3, game.Players[0].Tally.DrawsTaken)
+}